@@ -74,11 +74,11 @@ for target in "${!targets[@]}"; do
   echo "Building $BIN_NAME with GOARCH=$GOARCH GOARM=$GOARM CC=$CC (statically linked)"
 
   # Attempt static linking explicitly
-  if go build -a -ldflags '-extldflags "-static"' -o "$BUILD_DIR/$BIN_NAME" ./trmnl-display.go; then
+  if go build -a -ldflags '-extldflags "-static"' -o "$BUILD_DIR/$BIN_NAME" ./cmd/trmnl-display; then
     echo "Static build successful for $BIN_NAME"
   else
     echo "Static build failed, attempting fallback without static flags..."
-    if go build -o "$BUILD_DIR/$BIN_NAME" ./trmnl-display.go; then
+    if go build -o "$BUILD_DIR/$BIN_NAME" ./cmd/trmnl-display; then
       echo "Fallback build successful for $BIN_NAME (dynamic linking)"
     else
       echo "Failed to build for $target"
@@ -113,7 +113,7 @@ if [[ "$(uname -m)" == "x86_64" ]]; then
   export CGO_ENABLED=1
   unset CC
   echo "Using native compilation for x86_64"
-  if go build -o "$BUILD_DIR/$BIN_NAME" ./trmnl-display.go; then
+  if go build -o "$BUILD_DIR/$BIN_NAME" ./cmd/trmnl-display; then
     chmod +x "$BUILD_DIR/$BIN_NAME"
     echo "Uploading $BIN_NAME to S3 bucket: $S3_BUCKET"
     aws s3 cp "$BUILD_DIR/$BIN_NAME" "s3://$S3_BUCKET/$BIN_NAME"
@@ -121,7 +121,7 @@ if [[ "$(uname -m)" == "x86_64" ]]; then
   else
     echo "Failed to build for x86_64. Trying with CGO disabled..."
     export CGO_ENABLED=0
-    if go build -o "$BUILD_DIR/$BIN_NAME" ./trmnl-display.go; then
+    if go build -o "$BUILD_DIR/$BIN_NAME" ./cmd/trmnl-display; then
       chmod +x "$BUILD_DIR/$BIN_NAME"
       echo "Uploading $BIN_NAME to S3 bucket: $S3_BUCKET"
       aws s3 cp "$BUILD_DIR/$BIN_NAME" "s3://$S3_BUCKET/$BIN_NAME"
@@ -135,7 +135,7 @@ else
   echo "Non-x86_64 system detected, attempting cross-compilation for x86_64"
   echo "This may fail without the appropriate cross-compiler."
   export CGO_ENABLED=0  # Disable CGO for cross-compilation
-  if go build -o "$BUILD_DIR/$BIN_NAME" ./trmnl-display.go; then
+  if go build -o "$BUILD_DIR/$BIN_NAME" ./cmd/trmnl-display; then
     chmod +x "$BUILD_DIR/$BIN_NAME"
     echo "Uploading $BIN_NAME to S3 bucket: $S3_BUCKET"
     aws s3 cp "$BUILD_DIR/$BIN_NAME" "s3://$S3_BUCKET/$BIN_NAME"
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// Native TRMNL panel resolution, used for frames when no framebuffer is present
+const (
+	defaultFrameWidth  = 800
+	defaultFrameHeight = 480
+)
+
+// archiveFrame scales img to the native frame size exactly as it would be
+// drawn and saves it as a timestamped PNG in the archive directory
+func (d *Daemon) archiveFrame(img image.Image, options AppOptions) error {
+	frame := render.Scale(img, image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight))
+	drawingStages.Record("scale", frame, map[string]interface{}{
+		"from":   img.Bounds().String(),
+		"to":     frame.Bounds().String(),
+		"scaler": "nearest-neighbor",
+	})
+
+	if err := archiveImage(frame, options); err != nil {
+		return err
+	}
+	d.panelImage = frame
+	return nil
+}
+
+// archiveImage saves a frame to the archive directory and prunes the
+// archive to the retention limits in options
+func archiveImage(frame image.Image, options AppOptions) error {
+	framePath, err := saveFrame(options.ArchiveDir, frame, time.Now())
+	if err != nil {
+		return err
+	}
+	displayLog.Debug("Archived frame", "path", framePath)
+
+	maxBytes := int64(options.ArchiveMaxSize) * 1024 * 1024
+	if err := pruneFrames(options.ArchiveDir, options.ArchiveMaxFiles, options.ArchiveMaxAge, maxBytes); err != nil {
+		displayLog.Warn("Error pruning archive", "err", err)
+	}
+	return nil
+}
+
+// frameFileName matches the files saveFrame writes, and the names without
+// milliseconds that earlier versions wrote
+var frameFileName = regexp.MustCompile(`^\d{8}-\d{6}(\.\d{3})?\.png$`)
+
+// Layouts of frame file names, with milliseconds so frames saved within the
+// same second do not overwrite each other, and without as earlier versions
+// wrote them
+const (
+	frameNameLayout    = "20060102-150405.000.png"
+	oldFrameNameLayout = "20060102-150405.png"
+)
+
+// ArchivedFrame is a frame file written by saveFrame
+type ArchivedFrame struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// listFrames lists the frames saved in dir, newest first. Other files are
+// ignored.
+func listFrames(dir string) ([]ArchivedFrame, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	frames := []ArchivedFrame{}
+	for _, entry := range entries {
+		if !frameFileName.MatchString(entry.Name()) {
+			continue
+		}
+		t, err := time.ParseInLocation(frameNameLayout, entry.Name(), time.Local)
+		if err != nil {
+			t, err = time.ParseInLocation(oldFrameNameLayout, entry.Name(), time.Local)
+		}
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		frames = append(frames, ArchivedFrame{Name: entry.Name(), Time: t, Size: info.Size()})
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].Time.After(frames[j].Time) })
+	return frames, nil
+}
+
+// pruneFrames deletes the oldest frames in dir until at most maxFiles remain,
+// none is older than maxAge, and together they take at most maxBytes. Zero
+// limits are not applied.
+func pruneFrames(dir string, maxFiles int, maxAge time.Duration, maxBytes int64) error {
+	if maxFiles <= 0 && maxAge <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	frames, err := listFrames(dir)
+	if err != nil {
+		return err
+	}
+	var total int64
+	for i, frame := range frames {
+		total += frame.Size
+		keep := (maxFiles <= 0 || i < maxFiles) &&
+			(maxAge <= 0 || time.Since(frame.Time) <= maxAge) &&
+			(maxBytes <= 0 || total <= maxBytes)
+		if !keep {
+			if err := os.Remove(filepath.Join(dir, frame.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveFrame writes frame as a PNG named after the given timestamp and returns its path
+func saveFrame(dir string, frame image.Image, timestamp time.Time) (string, error) {
+	framePath := filepath.Join(dir, timestamp.Format(frameNameLayout))
+
+	out, err := os.Create(framePath)
+	if err != nil {
+		return "", fmt.Errorf("error creating archive file: %v", err)
+	}
+
+	if err := png.Encode(out, frame); err != nil {
+		out.Close()
+		os.Remove(framePath)
+		return "", fmt.Errorf("error encoding archive frame: %v", err)
+	}
+
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("error writing archive file: %v", err)
+	}
+	return framePath, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneFrames(t *testing.T) {
+	now := time.Now()
+	write := func(dir string, age time.Duration, size int) {
+		name := now.Add(-age).Format(frameNameLayout)
+		os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644)
+	}
+	setup := func() string {
+		dir := t.TempDir()
+		write(dir, 3*time.Hour, 100)
+		write(dir, 2*time.Hour, 100)
+		write(dir, time.Hour, 100)
+		os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0644)
+		return dir
+	}
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxAge   time.Duration
+		maxBytes int64
+		want     int
+	}{
+		{"no limits", 0, 0, 0, 3},
+		{"count", 2, 0, 0, 2},
+		{"age", 0, 150 * time.Minute, 0, 2},
+		{"size", 0, 0, 250, 2},
+		{"tightest wins", 2, 90 * time.Minute, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := setup()
+			if err := pruneFrames(dir, tt.maxFiles, tt.maxAge, tt.maxBytes); err != nil {
+				t.Fatal(err)
+			}
+			frames, err := listFrames(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frames) != tt.want {
+				t.Fatalf("%d frames left, want %d", len(frames), tt.want)
+			}
+			if tt.want > 0 && frames[0].Time.Before(now.Add(-time.Hour-time.Second)) {
+				t.Errorf("newest frame was removed: %v", frames)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+				t.Errorf("unrelated file removed: %v", err)
+			}
+		})
+	}
+}
+
+func TestSaveFrameSameSecond(t *testing.T) {
+	dir := t.TempDir()
+	// A frame archived by an earlier version, without milliseconds
+	os.WriteFile(filepath.Join(dir, "20250101-075959.png"), nil, 0644)
+
+	img := image.NewGray(image.Rect(0, 0, 8, 8))
+	at := time.Date(2025, 1, 1, 8, 0, 0, 0, time.Local)
+	for _, offset := range []time.Duration{100 * time.Millisecond, 900 * time.Millisecond} {
+		if _, err := saveFrame(dir, img, at.Add(offset)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	frames, err := listFrames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, frame := range frames {
+		names = append(names, frame.Name)
+	}
+	want := []string{"20250101-080000.900.png", "20250101-080000.100.png", "20250101-075959.png"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("frames = %v, want %v", names, want)
+	}
+}
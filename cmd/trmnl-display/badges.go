@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/compose"
+)
+
+// How long a badge stays up when it does not set a lifetime
+const defaultBadgeLifetime = 15 * time.Minute
+
+// Badges are meant to be transient; longer-lived messages belong on a screen
+const maxBadgeLifetime = 24 * time.Hour
+
+// Longest badge text, so a badge cannot cover the frame
+const maxBadgeText = 40
+
+// Corners a badge can be placed in
+var badgeCorners = []string{"top-left", "top-right", "bottom-left", "bottom-right"}
+
+// Badge is a short label, such as "door open" or "3 new mails", drawn over
+// whatever is on screen until it expires
+type Badge struct {
+	ID      string `json:",omitempty"`
+	Text    string
+	Corner  string    `json:",omitempty"` // one of badgeCorners, top-right by default
+	TTL     string    `json:",omitempty"` // how long it stays up, e.g. "10m"
+	Expires time.Time // filled in when the badge is set
+}
+
+// BadgeBoard holds the badges set through the control API
+type BadgeBoard struct {
+	mu      sync.Mutex
+	badges  []Badge
+	nextID  int
+	changed chan struct{}
+}
+
+// Global badge board
+var badges = &BadgeBoard{changed: make(chan struct{}, 1)}
+
+// Set adds a badge, or replaces the one with the same ID, and returns its ID
+func (b *BadgeBoard) Set(badge Badge, now time.Time) (string, error) {
+	badge.Text = strings.TrimSpace(badge.Text)
+	if badge.Text == "" {
+		return "", fmt.Errorf("badge has no text")
+	}
+	if len([]rune(badge.Text)) > maxBadgeText {
+		return "", fmt.Errorf("badge text is longer than %d characters", maxBadgeText)
+	}
+	if badge.Corner == "" {
+		badge.Corner = "top-right"
+	}
+	if !isBadgeCorner(badge.Corner) {
+		return "", fmt.Errorf("invalid corner %q, expected one of %s", badge.Corner, strings.Join(badgeCorners, ", "))
+	}
+	lifetime := defaultBadgeLifetime
+	if badge.TTL != "" {
+		d, err := time.ParseDuration(badge.TTL)
+		if err != nil || d <= 0 || d > maxBadgeLifetime {
+			return "", fmt.Errorf("invalid TTL %q, expected a duration up to %v", badge.TTL, maxBadgeLifetime)
+		}
+		lifetime = d
+	}
+	badge.Expires = now.Add(lifetime)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if badge.ID == "" {
+		b.nextID++
+		badge.ID = fmt.Sprintf("badge-%d", b.nextID)
+	}
+	replaced := false
+	for i, existing := range b.badges {
+		if existing.ID == badge.ID {
+			b.badges[i] = badge
+			replaced = true
+		}
+	}
+	if !replaced {
+		b.badges = append(b.badges, badge)
+	}
+	b.notify()
+	return badge.ID, nil
+}
+
+// Remove takes down a badge before it expires
+func (b *BadgeBoard) Remove(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, badge := range b.badges {
+		if badge.ID == id {
+			b.badges = append(b.badges[:i], b.badges[i+1:]...)
+			b.notify()
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns the badges that have not expired at now, dropping the rest
+func (b *BadgeBoard) Active(now time.Time) []Badge {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	active := []Badge{}
+	for _, badge := range b.badges {
+		if now.Before(badge.Expires) {
+			active = append(active, badge)
+		}
+	}
+	b.badges = active
+	return append([]Badge{}, active...)
+}
+
+// NextExpiry returns when the next badge expires, or the zero time if there
+// are none
+func (b *BadgeBoard) NextExpiry() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var next time.Time
+	for _, badge := range b.badges {
+		if next.IsZero() || badge.Expires.Before(next) {
+			next = badge.Expires
+		}
+	}
+	return next
+}
+
+// notify wakes the badge watcher; b.mu must be held
+func (b *BadgeBoard) notify() {
+	select {
+	case b.changed <- struct{}{}:
+	default:
+	}
+}
+
+// isBadgeCorner reports whether corner is a valid badge corner
+func isBadgeCorner(corner string) bool {
+	for _, c := range badgeCorners {
+		if c == corner {
+			return true
+		}
+	}
+	return false
+}
+
+// watchBadges redraws the badge area whenever a badge is set, removed, or
+// expires, until ctx is cancelled
+func (d *Daemon) watchBadges(ctx context.Context, options AppOptions) {
+	for {
+		var expiry <-chan time.Time
+		if next := badges.NextExpiry(); !next.IsZero() {
+			expiry = time.After(time.Until(next))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-badges.changed:
+		case <-expiry:
+		}
+		d.redrawBadges(options)
+	}
+}
+
+// redrawBadges redraws only the parts of the panel covered by the old and
+// new badges, leaving the rest of the frame untouched
+func (d *Daemon) redrawBadges(options AppOptions) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastFrame == nil || options.Headless || (menu != nil && menu.IsOpen()) {
+		return
+	}
+
+	img, rects := composeBadges(d.lastFrame, badges.Active(time.Now()))
+	var region image.Rectangle
+	for _, r := range append(d.badgeRects, rects...) {
+		region = region.Union(r)
+	}
+	d.badgeRects = rects
+	if region.Empty() {
+		return
+	}
+
+	// Leave the panel as it was found, so a badge does not keep it awake
+	if d.power != nil {
+		asleep := d.power.IsAsleep()
+		if err := d.power.Wake(); err != nil {
+			powerLog.Warn("Failed to wake panel", "err", err)
+		}
+		if asleep {
+			defer d.power.Sleep()
+		}
+	}
+	if err := d.drawFrameRegion(img, region, options); err != nil {
+		displayLog.Error("Error drawing badges", "err", err)
+	}
+}
+
+// composeBadges draws badges over a copy of img, stacking those that share
+// a corner, and returns the result and the area each badge covers
+func composeBadges(img image.Image, list []Badge) (image.Image, []image.Rectangle) {
+	if len(list) == 0 {
+		return img, nil
+	}
+	bounds := img.Bounds()
+	c, err := compose.New(bounds.Dx(), bounds.Dy())
+	if err != nil {
+		displayLog.Error("Error drawing badges", "err", err)
+		return img, nil
+	}
+	draw.Draw(c.Frame, c.Frame.Bounds(), img, bounds.Min, draw.Src)
+
+	size := float64(bounds.Dy()) / 20
+	face := c.Face(true, size)
+	defer face.Close()
+	padding := int(size / 2)
+	margin := int(size / 2)
+	height := face.Metrics().Height.Ceil() + 2*padding
+
+	var rects []image.Rectangle
+	stacked := make(map[string]int)
+	for _, badge := range list {
+		width := compose.MeasureText(face, badge.Text) + 2*padding
+		offset := stacked[badge.Corner]
+		x, y := margin, margin+offset
+		if strings.HasSuffix(badge.Corner, "right") {
+			x = bounds.Dx() - margin - width
+		}
+		if strings.HasPrefix(badge.Corner, "bottom") {
+			y = bounds.Dy() - margin - offset - height
+		}
+		rect := image.Rect(x, y, x+width, y+height)
+		if !rect.In(c.Frame.Bounds()) {
+			continue
+		}
+		stacked[badge.Corner] += height + margin/2
+
+		// A white border keeps the badge readable on dark screens
+		outline := rect.Inset(-2)
+		c.FillRect(outline, color.White)
+		c.FillRect(rect, color.Black)
+		c.DrawText(badge.Text, x+padding, y+padding+face.Metrics().Ascent.Ceil(), face, color.White)
+		rects = append(rects, outline)
+	}
+	return c.Frame, rects
+}
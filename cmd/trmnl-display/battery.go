@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"trmnl-display/pkg/api"
+	"trmnl-display/pkg/sensor"
+)
+
+// Battery providers -battery can name
+const (
+	batterySysfs   = "sysfs"
+	batteryPiSugar = "pisugar"
+	batteryINA219  = "ina219"
+	batteryNone    = "none"
+)
+
+// How often the battery is checked for the low battery badge and shutdown
+const batteryPollInterval = time.Minute
+
+// The low battery badge is set for a day, the longest a badge can last, and
+// set again after half of that while the battery stays low
+const lowBatteryBadgeRenew = maxBadgeLifetime / 2
+
+// lowBatteryBadgeID is the ID of the low battery badge on the badge board
+const lowBatteryBadgeID = "low-battery"
+
+// BatteryStatus is a reading of the battery
+type BatteryStatus struct {
+	Percent  int
+	Voltage  float64 // 0 if the provider does not report it
+	Charging bool
+}
+
+// batteryProvider reads the battery
+type batteryProvider interface {
+	Read() (BatteryStatus, error)
+}
+
+// Global battery provider, nil with -battery none
+var battery batteryProvider = sysfsBattery{}
+
+// batteryCritical is set when the program is shutting down for a critical
+// battery, to leave the battery low screen up rather than clearing the panel
+var batteryCritical atomic.Bool
+
+// validateBattery checks a -battery setting: sysfs, pisugar[:HOST:PORT],
+// ina219[:BUS[:ADDR]], or none
+func validateBattery(spec string) error {
+	name, rest, _ := strings.Cut(spec, ":")
+	switch name {
+	case batterySysfs, batteryNone:
+		if rest != "" {
+			return fmt.Errorf("battery provider %s takes no settings", name)
+		}
+		return nil
+	case batteryPiSugar:
+		if rest == "" {
+			return nil
+		}
+		_, _, err := net.SplitHostPort(rest)
+		return err
+	case batteryINA219:
+		_, _, err := sensor.ParseINA219(rest)
+		return err
+	}
+	return fmt.Errorf("unknown battery provider %q, expected %s, %s, %s, or %s", name, batterySysfs, batteryPiSugar, batteryINA219, batteryNone)
+}
+
+// openBattery opens the provider a valid -battery setting names
+func openBattery(spec string) (batteryProvider, error) {
+	name, rest, _ := strings.Cut(spec, ":")
+	switch name {
+	case batteryPiSugar:
+		return &piSugarBattery{sensor.PiSugar{Addr: rest}}, nil
+	case batteryINA219:
+		bus, addr, err := sensor.ParseINA219(rest)
+		if err != nil {
+			return nil, err
+		}
+		ina, err := sensor.OpenINA219(bus, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &ina219Battery{ina}, nil
+	case batteryNone:
+		return nil, nil
+	}
+	return sysfsBattery{}, nil
+}
+
+// readBattery reads the battery, and returns false if there is none or it
+// cannot be read
+func readBattery() (BatteryStatus, bool) {
+	if battery == nil {
+		return BatteryStatus{}, false
+	}
+	status, err := battery.Read()
+	if err != nil {
+		powerLog.Debug("Error reading battery", "err", err)
+		return BatteryStatus{}, false
+	}
+	return status, true
+}
+
+// readBatteryPercent returns the battery's charge, and false if there is no
+// battery
+func readBatteryPercent() (int, bool) {
+	status, ok := readBattery()
+	return status.Percent, ok
+}
+
+// deviceTelemetry is what is reported to the TRMNL server with each fetch
+func deviceTelemetry() api.Telemetry {
+	status, _ := readBattery()
+	return api.Telemetry{BatteryVoltage: status.Voltage}
+}
+
+// sysfsBattery is the first battery reported by the kernel's power supply
+// class
+type sysfsBattery struct{}
+
+// Read reads the battery's capacity, voltage, and status
+func (sysfsBattery) Read() (BatteryStatus, error) {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+
+	for _, supply := range supplies {
+		read := func(name string) string {
+			data, _ := os.ReadFile(filepath.Join(supply, name))
+			return strings.TrimSpace(string(data))
+		}
+		if read("type") != "Battery" {
+			continue
+		}
+		percent, err := strconv.Atoi(read("capacity"))
+		if err != nil {
+			continue
+		}
+		status := BatteryStatus{Percent: percent, Charging: read("status") == "Charging"}
+		if microvolts, err := strconv.Atoi(read("voltage_now")); err == nil {
+			status.Voltage = float64(microvolts) / 1e6
+		}
+		return status, nil
+	}
+	return BatteryStatus{}, fmt.Errorf("no battery in /sys/class/power_supply")
+}
+
+// piSugarBattery is a PiSugar battery read through pisugar-server
+type piSugarBattery struct {
+	pisugar sensor.PiSugar
+}
+
+// Read asks pisugar-server for the battery's state
+func (b *piSugarBattery) Read() (BatteryStatus, error) {
+	status, err := b.pisugar.Status()
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+	return BatteryStatus{Percent: int(status.Percent + 0.5), Voltage: status.Voltage, Charging: status.Charging}, nil
+}
+
+// ina219Battery is a single cell LiPo battery monitored by an INA219, whose
+// charge is estimated from its voltage
+type ina219Battery struct {
+	ina *sensor.INA219
+}
+
+// Read reads the battery's voltage, and counts current flowing into it as
+// charging
+func (b *ina219Battery) Read() (BatteryStatus, error) {
+	volts, err := b.ina.BusVoltage()
+	if err != nil {
+		return BatteryStatus{}, err
+	}
+	status := BatteryStatus{Percent: sensor.LiPoPercent(volts), Voltage: volts}
+	if shunt, err := b.ina.ShuntVoltage(); err == nil {
+		status.Charging = shunt > 0
+	}
+	return status, nil
+}
+
+// lowBattery reports whether status is a battery running down below percent
+func lowBattery(status BatteryStatus, percent int) bool {
+	return percent > 0 && !status.Charging && status.Percent < percent
+}
+
+// batteryDue returns when the refresh due next should happen given the
+// battery: as scheduled, or no sooner than -low-battery-refresh while the
+// battery is low
+func batteryDue(due, now time.Time, options AppOptions) time.Time {
+	if options.LowBatteryRefresh <= 0 {
+		return due
+	}
+	if status, ok := readBattery(); !ok || !lowBattery(status, options.LowBattery) {
+		return due
+	}
+	if earliest := now.Add(options.LowBatteryRefresh); due.Before(earliest) {
+		return earliest
+	}
+	return due
+}
+
+// watchBattery checks the battery every batteryPollInterval, showing a badge
+// while it is low and calling shutdown once it is critical
+func watchBattery(ctx context.Context, options AppOptions, shutdown context.CancelFunc) {
+	var badgeSet time.Time
+	ticker := time.NewTicker(batteryPollInterval)
+	defer ticker.Stop()
+	for {
+		if status, ok := readBattery(); ok {
+			now := time.Now()
+			switch {
+			case options.CriticalBattery > 0 && !status.Charging && status.Percent <= options.CriticalBattery:
+				powerLog.Error("Battery critical, shutting down", "percent", status.Percent)
+				batteryCritical.Store(true)
+				shutdown()
+				return
+			case lowBattery(status, options.LowBattery):
+				if now.Sub(badgeSet) > lowBatteryBadgeRenew {
+					powerLog.Warn("Battery low", "percent", status.Percent)
+					badges.Set(Badge{ID: lowBatteryBadgeID, Text: "Low battery", Corner: "bottom-right", TTL: maxBadgeLifetime.String()}, now)
+					badgeSet = now
+				}
+			case !badgeSet.IsZero():
+				powerLog.Info("Battery no longer low", "percent", status.Percent)
+				badges.Remove(lowBatteryBadgeID)
+				badgeSet = time.Time{}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdownLowBattery leaves a battery low screen on the panel, which keeps it
+// without power, puts the panel to sleep, flushes everything written to
+// disk, and runs -shutdown-command to power the frame off before the battery
+// gives out
+func (d *Daemon) shutdownLowBattery(options AppOptions) {
+	text := "Charge the battery to carry on"
+	if percent, ok := readBatteryPercent(); ok {
+		text = fmt.Sprintf("%d%% left\n%s", percent, text)
+	}
+	d.mu.Lock()
+	if d.power != nil {
+		d.power.Wake()
+	}
+	img, err := renderTextScreen("Battery low", text)
+	if err == nil {
+		err = d.drawFrame(img, options)
+	}
+	if err != nil {
+		displayLog.Error("Error showing battery low screen", "err", err)
+		d.clearFramebuffer()
+	}
+	restoreCursor()
+	if d.power != nil {
+		if err := d.power.Sleep(); err != nil {
+			powerLog.Warn("Failed to put panel to sleep", "err", err)
+		}
+	}
+	d.mu.Unlock()
+
+	syscall.Sync()
+	if options.ShutdownCommand == "" {
+		return
+	}
+	powerLog.Info("Running shutdown command", "command", options.ShutdownCommand)
+	if out, err := exec.Command("sh", "-c", options.ShutdownCommand).CombinedOutput(); err != nil {
+		powerLog.Error("Error running shutdown command", "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeBattery reports a fixed reading
+type fakeBattery struct {
+	status BatteryStatus
+}
+
+func (b *fakeBattery) Read() (BatteryStatus, error) {
+	return b.status, nil
+}
+
+// useBattery swaps in a fake battery for the length of the test
+func useBattery(t *testing.T, status BatteryStatus) *fakeBattery {
+	fake := &fakeBattery{status}
+	saved := battery
+	battery = fake
+	t.Cleanup(func() { battery = saved })
+	return fake
+}
+
+func TestBatteryDue(t *testing.T) {
+	now := time.Now()
+	due := now.Add(5 * time.Minute)
+	options := AppOptions{LowBattery: 20, LowBatteryRefresh: time.Hour}
+	fake := useBattery(t, BatteryStatus{Percent: 50})
+	if got := batteryDue(due, now, options); !got.Equal(due) {
+		t.Errorf("batteryDue with a charged battery = %v, want the schedule", got.Sub(now))
+	}
+	fake.status.Percent = 15
+	if got := batteryDue(due, now, options); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("batteryDue with a low battery = %v, want 1h", got.Sub(now))
+	}
+	fake.status.Charging = true
+	if got := batteryDue(due, now, options); !got.Equal(due) {
+		t.Errorf("batteryDue while charging = %v, want the schedule", got.Sub(now))
+	}
+	fake.status.Charging = false
+	options.LowBatteryRefresh = 0
+	if got := batteryDue(due, now, options); !got.Equal(due) {
+		t.Errorf("batteryDue with -low-battery-refresh 0 = %v, want the schedule", got.Sub(now))
+	}
+}
+
+func TestWatchBattery(t *testing.T) {
+	fake := useBattery(t, BatteryStatus{Percent: 15})
+	t.Cleanup(func() { badges.Remove(lowBatteryBadgeID) })
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := make(chan struct{})
+	go watchBattery(ctx, AppOptions{LowBattery: 20}, func() { close(shutdown) })
+	for deadline := time.Now().Add(time.Second); len(badges.Active(time.Now())) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("no badge shown for a low battery")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	fake.status.Percent = 4
+	t.Cleanup(func() { batteryCritical.Store(false) })
+	go watchBattery(context.Background(), AppOptions{LowBattery: 20, CriticalBattery: 5}, func() { close(shutdown) })
+	select {
+	case <-shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("no shutdown for a critical battery")
+	}
+	if !batteryCritical.Load() {
+		t.Errorf("shutdown for a critical battery not recorded")
+	}
+}
+
+func TestShutdownLowBattery(t *testing.T) {
+	useBattery(t, BatteryStatus{Percent: 4})
+	p := &fakePanel{bounds: image.Rect(0, 0, 800, 480)}
+	marker := filepath.Join(t.TempDir(), "off")
+	panelDaemon(p).shutdownLowBattery(AppOptions{ShutdownCommand: "touch " + marker})
+	if len(p.frames) != 1 {
+		t.Fatalf("panel showed %d frames, want the battery low screen", len(p.frames))
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("shutdown command not run: %v", err)
+	}
+}
+
+func TestValidateBattery(t *testing.T) {
+	for _, spec := range []string{"sysfs", "none", "pisugar", "pisugar:192.168.1.5:8423", "ina219", "ina219:1:0x42"} {
+		if err := validateBattery(spec); err != nil {
+			t.Errorf("validateBattery(%q) = %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"", "ups", "sysfs:1", "pisugar:8423", "ina219:x"} {
+		if err := validateBattery(spec); err == nil {
+			t.Errorf("validateBattery(%q) succeeded", spec)
+		}
+	}
+}
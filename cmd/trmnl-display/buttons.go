@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/panel"
+)
+
+// Press is how a button was pressed
+type Press string
+
+// Press types a button can bind actions to
+const (
+	PressShort  Press = "short"
+	PressLong   Press = "long"
+	PressDouble Press = "double"
+)
+
+// A long press is a button held this long; a double press is a second press
+// starting this soon after the first is released
+const (
+	longPressTime     = 800 * time.Millisecond
+	doublePressWindow = 400 * time.Millisecond
+)
+
+// inputAction is an action asked for by a button press or a touch
+type inputAction struct {
+	Source string // what asked for it, for the log
+	Action string
+}
+
+// inputActions carries actions from the button and touch watchers to the
+// goroutine that runs them one at a time
+var inputActions = make(chan inputAction, 8)
+
+// buttonActions are what a press or touch can do, by the name used in
+// -buttons and -touch-regions
+var buttonActions = map[string]func(d *Daemon, options AppOptions){
+	"refresh": func(d *Daemon, options AppOptions) {
+		closeMenu()
+		requestRefresh()
+	},
+	// The server moves on through the playlist on every fetch, so the next
+	// item is a refresh away, as with the button on TRMNL's own hardware
+	"next": func(d *Daemon, options AppOptions) {
+		closeMenu()
+		requestRefresh()
+	},
+	"dark-mode": func(d *Daemon, options AppOptions) {
+		closeMenu()
+		live.ToggleDarkMode(options.DarkMode)
+		requestRefresh()
+	},
+	"pause": func(d *Daemon, options AppOptions) {
+		if live.Paused() {
+			live.Resume(options.ResumeClear)
+		} else {
+			live.Pause()
+		}
+	},
+	"source": func(d *Daemon, options AppOptions) {
+		closeMenu()
+		menu.nextSource()
+	},
+	"info": func(d *Daemon, options AppOptions) {
+		menu.ShowPage(networkInfo())
+	},
+	"clear": func(d *Daemon, options AppOptions) {
+		closeMenu()
+		d.mu.Lock()
+		d.clearFramebuffer()
+		d.mu.Unlock()
+		if err := d.showCurrentFrame(options); err != nil {
+			menuLog.Error("Error restoring screen", "err", err)
+		}
+	},
+	"menu": func(d *Daemon, options AppOptions) {
+		if menu.IsOpen() {
+			menu.close()
+			menu.restore()
+		} else {
+			menu.navigate(0)
+		}
+	},
+	"menu-next":   func(d *Daemon, options AppOptions) { menu.Next() },
+	"menu-prev":   func(d *Daemon, options AppOptions) { menu.Prev() },
+	"menu-select": func(d *Daemon, options AppOptions) { menu.Select() },
+}
+
+// needsMenu reports whether options set up a rotary encoder, or a button
+// or touch region running an action that works through the settings menu,
+// which is then set up
+func needsMenu(options AppOptions) bool {
+	usesMenu := func(action string) bool {
+		return action == "info" || action == "menu" || action == "source" || strings.HasPrefix(action, "menu-")
+	}
+	for _, presses := range options.Buttons {
+		for _, action := range presses {
+			if usesMenu(action) {
+				return true
+			}
+		}
+	}
+	for _, region := range options.TouchRegions {
+		if usesMenu(region.Action) {
+			return true
+		}
+	}
+	return len(options.MenuEncoder) > 0
+}
+
+// validateAction checks an action name for a button or touch region
+func validateAction(action string) error {
+	if _, ok := buttonActions[action]; ok {
+		return nil
+	}
+	var names []string
+	for name := range buttonActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown action %q, expected %s", action, strings.Join(names, ", "))
+}
+
+// closeMenu takes the settings menu off screen, without redrawing, if it
+// is open
+func closeMenu() {
+	if menu != nil && menu.IsOpen() {
+		menu.close()
+	}
+}
+
+// parseButtons parses comma-separated GPIO[:PRESS]=ACTION bindings, where
+// PRESS is short (the default), long, or double
+func parseButtons(s string) (map[panel.GPIOPin]map[Press]string, error) {
+	buttons := make(map[panel.GPIOPin]map[Press]string)
+	for _, binding := range strings.Split(s, ",") {
+		binding = strings.TrimSpace(binding)
+		if binding == "" {
+			continue
+		}
+		button, action, found := strings.Cut(binding, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid button %q, expected GPIO[:PRESS]=ACTION", binding)
+		}
+		pin, press, _ := strings.Cut(button, ":")
+		if press == "" {
+			press = string(PressShort)
+		}
+		if err := bindButton(buttons, panel.GPIOPin(pin), Press(press), action); err != nil {
+			return nil, err
+		}
+	}
+	return buttons, nil
+}
+
+// bindButton binds action to a press of the button on pin, refusing
+// bindings that clash
+func bindButton(buttons map[panel.GPIOPin]map[Press]string, pin panel.GPIOPin, press Press, action string) error {
+	if !validPin(string(pin)) {
+		return fmt.Errorf("invalid button GPIO %q", pin)
+	}
+	switch press {
+	case PressShort, PressLong, PressDouble:
+	default:
+		return fmt.Errorf("unknown press %q for button %s, expected %s, %s, or %s", press, pin, PressShort, PressLong, PressDouble)
+	}
+	if err := validateAction(action); err != nil {
+		return err
+	}
+	if buttons[pin] == nil {
+		buttons[pin] = make(map[Press]string)
+	}
+	if existing, ok := buttons[pin][press]; ok && existing != action {
+		return fmt.Errorf("button %s %s press is bound to both %s and %s", pin, press, existing, action)
+	}
+	buttons[pin][press] = action
+	return nil
+}
+
+// startButtons watches the configured buttons and runs the actions bound
+// to their presses
+func startButtons(options AppOptions) error {
+	for pin, presses := range options.Buttons {
+		chipPath, offset, err := pin.Resolve(options.GPIOChip)
+		if err != nil {
+			return err
+		}
+		line, err := panel.OpenGPIOInput(chipPath, offset, true)
+		if err != nil {
+			return err
+		}
+		line.Debounce = options.ButtonDebounce
+		go watchPresses(line, pin, presses)
+	}
+	return nil
+}
+
+// watchPresses asks for the actions bound to the presses of the button on
+// line
+func watchPresses(line *panel.GPIOLine, pin panel.GPIOPin, presses map[Press]string) {
+	edges := make(chan panel.GPIOEdge)
+	go func() {
+		defer close(edges)
+		for {
+			edge, err := line.WaitForEdge()
+			if err != nil {
+				menuLog.Error("Error watching button", "button", pin, "err", err)
+				return
+			}
+			edges <- edge
+		}
+	}()
+	// Buttons pull the line low while held
+	held := func() bool {
+		high, err := line.Value()
+		return err == nil && !high
+	}
+	_, double := presses[PressDouble]
+	decoder := pressDecoder{Long: longPressTime, DoubleWindow: doublePressWindow, Double: double}
+	decoder.run(edges, held, func(press Press) {
+		if action, ok := presses[press]; ok {
+			inputActions <- inputAction{Source: fmt.Sprintf("button %s %s press", pin, press), Action: action}
+		}
+	})
+}
+
+// pressDecoder tells short, long, and double presses apart from a button's
+// edges
+type pressDecoder struct {
+	// Long is how long a button is held for a long press, which is reported
+	// as soon as it has been
+	Long time.Duration
+	// DoubleWindow is how soon a second press must start for a double press.
+	// Without Double, short presses are reported on release without waiting
+	// to see if another follows.
+	DoubleWindow time.Duration
+	Double       bool
+}
+
+// run reads edges until the channel is closed, calling emit for each press.
+// held reports whether the button is down, for when debouncing swallowed
+// a release.
+func (d pressDecoder) run(edges <-chan panel.GPIOEdge, held func() bool, emit func(Press)) {
+	var down, longDone, pending bool
+	var longTimer, doubleTimer <-chan time.Time
+	release := func() {
+		down, longTimer = false, nil
+		switch {
+		case longDone:
+			longDone = false
+		case !d.Double:
+			emit(PressShort)
+		case pending:
+			pending = false
+			emit(PressDouble)
+		default:
+			pending = true
+			doubleTimer = time.After(d.DoubleWindow)
+		}
+	}
+	for {
+		select {
+		case edge, ok := <-edges:
+			if !ok {
+				return
+			}
+			if edge.Rising {
+				if down {
+					release()
+				}
+				continue
+			}
+			down, longDone = true, false
+			longTimer, doubleTimer = time.After(d.Long), nil
+		case <-longTimer:
+			if !held() {
+				release()
+				continue
+			}
+			longTimer = nil
+			if pending {
+				pending = false
+				emit(PressShort)
+			}
+			longDone = true
+			emit(PressLong)
+		case <-doubleTimer:
+			doubleTimer = nil
+			if pending {
+				pending = false
+				emit(PressShort)
+			}
+		}
+	}
+}
+
+// runInputActions runs each action asked for by a button or touch, after
+// taking down a reminder if one is on screen
+func (d *Daemon) runInputActions(options AppOptions) {
+	for input := range inputActions {
+		menuLog.Info("Running input action", "from", input.Source, "action", input.Action)
+		if dismissReminder() {
+			continue
+		}
+		buttonActions[input.Action](d, options)
+	}
+}
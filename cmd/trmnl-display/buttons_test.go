@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"trmnl-display/pkg/panel"
+)
+
+func TestParseButtons(t *testing.T) {
+	buttons, err := parseButtons("26=next, 26:long=pause,26:double=dark-mode,GPIO19=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[panel.GPIOPin]map[Press]string{
+		"26":     {PressShort: "next", PressLong: "pause", PressDouble: "dark-mode"},
+		"GPIO19": {PressShort: "info"},
+	}
+	if !reflect.DeepEqual(buttons, want) {
+		t.Errorf("parseButtons = %v, want %v", buttons, want)
+	}
+	for _, bad := range []string{"26", "26=explode", "26:triple=next", "-1=next", "26=next,26:short=pause"} {
+		if _, err := parseButtons(bad); err == nil {
+			t.Errorf("parseButtons(%q) succeeded, want an error", bad)
+		}
+	}
+}
+
+// pressEdges feeds a button's edges to a pressDecoder and returns the
+// presses it reports
+func pressEdges(double bool, steps func(edge func(rising bool))) []Press {
+	edges := make(chan panel.GPIOEdge)
+	presses := make(chan Press, 10)
+	done := make(chan struct{})
+	decoder := pressDecoder{Long: 60 * time.Millisecond, DoubleWindow: 30 * time.Millisecond, Double: double}
+	go func() {
+		decoder.run(edges, func() bool { return true }, func(p Press) { presses <- p })
+		close(done)
+	}()
+	steps(func(rising bool) { edges <- panel.GPIOEdge{Rising: rising, Time: time.Now()} })
+	time.Sleep(100 * time.Millisecond)
+	close(edges)
+	<-done
+	close(presses)
+	var got []Press
+	for p := range presses {
+		got = append(got, p)
+	}
+	return got
+}
+
+func TestPressDecoder(t *testing.T) {
+	tap := func(edge func(bool)) {
+		edge(false)
+		edge(true)
+	}
+	for _, tt := range []struct {
+		name   string
+		double bool
+		steps  func(edge func(bool))
+		want   []Press
+	}{
+		{"short", false, tap, []Press{PressShort}},
+		{"short waiting for double", true, tap, []Press{PressShort}},
+		{"double", true, func(edge func(bool)) { tap(edge); tap(edge) }, []Press{PressDouble}},
+		{"two shorts without double", false, func(edge func(bool)) { tap(edge); tap(edge) }, []Press{PressShort, PressShort}},
+		{"long", true, func(edge func(bool)) {
+			edge(false)
+			time.Sleep(90 * time.Millisecond)
+			edge(true)
+		}, []Press{PressLong}},
+	} {
+		if got := pressEdges(tt.double, tt.steps); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: presses = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTouchRegions(t *testing.T) {
+	regions, err := parseTouchRegions(defaultTouchRegions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		p    panel.TouchPoint
+		want string
+	}{
+		{panel.TouchPoint{X: 0.5, Y: 0.5}, "menu"},
+		{panel.TouchPoint{X: 0.1, Y: 0.5}, "source"},
+		{panel.TouchPoint{X: 0.9, Y: 0.1}, "refresh"},
+	} {
+		region, ok := touchRegionAt(regions, tt.p)
+		if !ok || region.Action != tt.want {
+			t.Errorf("touch at %v runs %q, want %q", tt.p, region.Action, tt.want)
+		}
+	}
+	for _, bad := range []string{"middle=menu", "left=explode", "left"} {
+		if _, err := parseTouchRegions(bad); err == nil {
+			t.Errorf("parseTouchRegions(%q) succeeded, want an error", bad)
+		}
+	}
+}
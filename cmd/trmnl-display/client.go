@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"trmnl-display/pkg/api"
+	"trmnl-display/pkg/sources"
+)
+
+// httpClient is shared by the API request, image downloads, and local sources
+// so connections (and their TLS sessions) are reused between refreshes. It is
+// replaced by configureHTTPClient once the config file has been loaded.
+var httpClient api.HTTPClient = newHTTPClient(http.ProxyFromEnvironment, nil)
+
+// configureHTTPClient applies the config file's network settings to the
+// shared client
+func configureHTTPClient(config Config) error {
+	proxy, err := proxyFunc(config)
+	if err != nil {
+		return err
+	}
+	tlsConf, err := tlsConfig(config)
+	if err != nil {
+		return err
+	}
+	httpClient = newHTTPClient(proxy, tlsConf)
+	return nil
+}
+
+// newHTTPClient creates the shared client with keep-alive connection pooling
+func newHTTPClient(proxy func(*http.Request) (*url.URL, error), tlsConf *tls.Config) *http.Client {
+	transport := &http.Transport{
+		Proxy:               proxy,
+		TLSClientConfig:     tlsConf,
+		MaxIdleConns:        10,
+		MaxIdleConnsPerHost: 2,
+		// Keep connections around across typical refresh intervals; servers
+		// that close them sooner are simply reconnected to
+		IdleConnTimeout:       15 * time.Minute,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ForceAttemptHTTP2:     true,
+	}
+
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+}
+
+// apiClient returns a client for the TRMNL server config points at, using
+// the shared HTTP client
+func apiClient(config Config) *api.Client {
+	return &api.Client{
+		BaseURL:   config.BaseURL,
+		APIKey:    config.APIKey,
+		UserAgent: fmt.Sprintf("trmnl-display/%s", version),
+		HTTP:      httpClient,
+		Telemetry: deviceTelemetry,
+	}
+}
+
+// sourcesClient returns a client for the morning briefing's sources, using
+// the shared HTTP client
+func sourcesClient() *sources.Client {
+	return &sources.Client{
+		UserAgent: fmt.Sprintf("trmnl-display/%s", version),
+		HTTP:      httpClient,
+	}
+}
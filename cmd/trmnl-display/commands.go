@@ -0,0 +1,433 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/gonutz/framebuffer"
+
+	"trmnl-display/pkg/panel"
+)
+
+// command is a trmnl-display subcommand
+type command struct {
+	Name    string
+	Summary string
+	Run     func(args []string)
+}
+
+// commands lists the subcommands in the order they are shown in the usage
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "Show the TRMNL playlist until interrupted (the default)", runDaemon},
+		{"show", "Put an image file or URL through the pipeline, display it once, and exit", runShow},
+		{"clear", "Clear the panel and exit", runClear},
+		{"testpattern", "Cycle through test patterns for checking wiring and image quality", runTestPattern},
+		{"refresh", "Ask the running display to fetch a new screen now", func(args []string) { runSignalCommand("refresh", args) }},
+		{"pause", "Ask the running display to stop refreshing", func(args []string) { runSignalCommand("pause", args) }},
+		{"resume", "Ask the running display to start refreshing again", func(args []string) { runSignalCommand("resume", args) }},
+		{"ctl", "Control the running display over its local socket (refresh, pause, resume, status, show)", runCtl},
+		{"config", "Show or change the config file", runConfig},
+		{"doctor", "Check the config, network, and display for common problems", runDoctor},
+		{"probe", "Detect the board, GPIO, SPI, and HAT and suggest a config", runProbe},
+		{"install", "Install and start a systemd service", runInstall},
+		{"export", "Export the refresh history as CSV", runExport},
+		{"stats", "Show uptime, refresh counts, error rates, and timings", runStats},
+		{"diff", "Render a heatmap of the changes between two frames", runDiff},
+		{"timelapse", "Stitch archived frames into an animated GIF or MP4", runTimelapse},
+		{"version", "Show version information", func([]string) { printVersion() }},
+		{"help", "Show this help", func([]string) { printUsage() }},
+	}
+}
+
+func main() {
+	// Without a command, or when the first argument is a flag, behave as
+	// `run` so existing invocations keep working
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	switch name {
+	case "-h", "-help", "--help":
+		name = "help"
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			cmd.Run(args)
+			return
+		}
+	}
+	fmt.Printf("Unknown command %q\n\n", name)
+	printUsage()
+	os.Exit(2)
+}
+
+// printUsage lists the commands
+func printUsage() {
+	fmt.Println("Usage: trmnl-display [command] [flags]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Printf("  %-12s %s\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Println()
+	fmt.Println("Run `trmnl-display <command> -h` for a command's flags.")
+}
+
+// printVersion shows the version and build information
+func printVersion() {
+	fmt.Printf("trmnl-display version %s (commit: %s, built: %s)\n",
+		version, commit, buildDate)
+}
+
+// runClear implements the clear command, which blanks the panel once
+func runClear(args []string) {
+	fs := flag.NewFlagSet("clear", flag.ExitOnError)
+	fs.Parse(args)
+
+	checkRoot()
+	d := newDaemon()
+	d.lock = NewFramebufferLock(lockFilePath)
+	if err := d.lock.Acquire(); err != nil {
+		fmt.Printf("Error acquiring framebuffer lock: %v\n", err)
+		os.Exit(1)
+	}
+	defer d.lock.Release()
+	if err := d.openConfiguredPanel(); err != nil {
+		fmt.Printf("Error opening panel: %v\n", err)
+		os.Exit(1)
+	}
+	defer d.closePanel()
+	d.clearFramebuffer()
+}
+
+// runConfig implements the config command:
+//
+//	config [show]           print the config with the API key masked
+//	config path             print the config file's location
+//	config set NAME VALUE   change a setting
+//	config unset NAME       remove a setting
+func runConfig(args []string) {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	fs.StringVar(&configFileFlag, "config", "", "Config file to use (default ~/.config/trmnl/config.json)")
+	profile := fs.String("profile", "", "Show or change this profile's settings")
+	fs.Usage = func() {
+		fmt.Println("Usage: trmnl-display config [-config FILE] [-profile NAME] [show | path | set NAME VALUE | unset NAME]")
+	}
+	fs.Parse(args)
+	args = fs.Args()
+
+	configDir, err := configDirectory()
+	if err != nil {
+		fmt.Printf("Error setting up config directory: %v\n", err)
+		os.Exit(1)
+	}
+	configFile := configFilePath(configDir)
+
+	action := "show"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+	switch {
+	case action == "path" && len(args) == 0:
+		fmt.Println(configFile)
+	case action == "show" && len(args) == 0:
+		config, err := readConfigIfExists()
+		if err == nil {
+			config, err = selectProfile(config, *profile)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *profile != "" {
+			config.Profiles = nil
+		}
+		config.APIKey = maskSecret(config.APIKey)
+		for name, p := range config.Profiles {
+			p.APIKey = maskSecret(p.APIKey)
+			config.Profiles[name] = p
+		}
+		data, _ := json.MarshalIndent(config, "", "  ")
+		fmt.Println(string(data))
+	case action == "set" && len(args) == 2, action == "unset" && len(args) == 1:
+		config, err := readConfigIfExists()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		value, unset := "", action == "unset"
+		if action == "set" {
+			value = args[1]
+		}
+
+		// The API key goes to the keyring when one is in use, and any copy
+		// in the file, which would take precedence, is removed
+		effective, err := selectProfile(config, *profile)
+		if err == nil {
+			effective, err = applyConfigEnv(effective)
+		}
+		if err == nil && strings.EqualFold(args[0], "APIKey") && usesKeyring(effective.KeyStorage) {
+			if unset {
+				err = keyringDelete(effective.KeyStorage, apiKeyName(*profile))
+			} else {
+				err = keyringSet(effective.KeyStorage, apiKeyName(*profile), value)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("API key updated in the %s keyring\n", effective.KeyStorage)
+			value, unset = "", true
+		}
+
+		if *profile == "" {
+			config, err = setConfigField(config, args[0], value, unset)
+		} else {
+			var updated Config
+			updated, err = setConfigField(config.Profiles[*profile], args[0], value, unset)
+			if config.Profiles == nil {
+				config.Profiles = make(map[string]Config)
+			}
+			config.Profiles[*profile] = updated
+		}
+		if err == nil {
+			err = validateKeyStorage(config.KeyStorage)
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		saveConfig(configDir, config)
+		fmt.Println("Config updated; restart the display or send it SIGHUP to apply it")
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(secret string) string {
+	if len(secret) <= 4 {
+		return secret
+	}
+	return strings.Repeat("*", len(secret)-4) + secret[len(secret)-4:]
+}
+
+// setConfigField sets the named Config field (matched case-insensitively)
+// from its command line form: text for string fields, JSON for the rest
+func setConfigField(config Config, name, value string, unset bool) (Config, error) {
+	field, ok := reflect.TypeOf(config).FieldByNameFunc(func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+	if !ok {
+		return config, fmt.Errorf("unknown setting %q", name)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return config, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return config, err
+	}
+
+	if unset {
+		delete(fields, field.Name)
+	} else if field.Type.Kind() == reflect.String {
+		fields[field.Name] = value
+	} else {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+			return config, fmt.Errorf("invalid value for %s, expected JSON: %v", field.Name, err)
+		}
+		fields[field.Name] = parsed
+	}
+
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return config, err
+	}
+	updated := Config{}
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return config, fmt.Errorf("invalid value for %s: %v", field.Name, err)
+	}
+	return updated, nil
+}
+
+// runDoctor implements the doctor command, which checks the things that
+// most often stop a display from working and explains how to fix them
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "", "Config file to check (default ~/.config/trmnl/config.json)")
+	profile := fs.String("profile", "", "Check this profile from the config file")
+	fs.Parse(args)
+
+	failures := 0
+	ok := func(format string, a ...interface{}) {
+		fmt.Printf("✓ "+format+"\n", a...)
+	}
+	warn := func(format string, a ...interface{}) {
+		fmt.Printf("! "+format+"\n", a...)
+	}
+	fail := func(format string, a ...interface{}) {
+		fmt.Printf("✗ "+format+"\n", a...)
+		failures++
+	}
+
+	// Config file, checked the way the run command reads it
+	var runArgs []string
+	if *configPath != "" {
+		runArgs = append(runArgs, "-config", *configPath)
+	}
+	if *profile != "" {
+		runArgs = append(runArgs, "-profile", *profile)
+	}
+	configFileFlag = *configPath
+	options, config := AppOptions{}, Config{}
+	configDir, err := configDirectory()
+	if err != nil {
+		fail("Config directory: %v", err)
+	} else if _, err := os.Stat(configFilePath(configDir)); err != nil && *configPath == "" {
+		warn("No config file yet; it is created on the first run")
+	} else if parsedOptions, parsed, err := parseOptions(runArgs); err != nil {
+		fail("Config file: %v", err)
+	} else {
+		options, config = parsedOptions, parsed
+		ok("Config file %s", configFilePath(configDir))
+		if config.Profile != "" {
+			ok("Profile %s", config.Profile)
+		}
+		if usesKeyring(config.KeyStorage) {
+			if fileConfig, err := readConfigIfExists(); err == nil && fileConfig.APIKey != "" {
+				warn("KeyStorage is %s, but config.json still holds an API key; move it there with `config set APIKey KEY`", config.KeyStorage)
+			}
+		}
+		if err := reminders.SetConfigured(config.Reminders); err != nil {
+			fail("Config file: %v", err)
+		}
+	}
+
+	if names := configEnvNames(); len(names) > 0 {
+		ok("Settings from the environment: %s", strings.Join(names, ", "))
+	}
+
+	// API key and server
+	if err := configureHTTPClient(config); err != nil {
+		fail("Network settings: %v", err)
+	} else if config.APIKey == "" {
+		fail("No API key in the config file or TRMNL_API_KEY")
+	} else {
+		checkServer(config, ok, fail)
+	}
+
+	// Display
+	if path, isFile := strings.CutPrefix(options.Panel, panelFilePrefix); isFile {
+		if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+			fail("Panel %s: directory %s does not exist", options.Panel, filepath.Dir(path))
+		} else {
+			ok("Panel writes frames to %s", path)
+		}
+	} else if isPreviewPanel(options.Panel) {
+		ok("Panel is a browser preview (%s)", options.Panel)
+	} else if options.Panel == panelTerm || strings.HasPrefix(options.Panel, panelTermPrefix) {
+		ok("Panel draws frames in the terminal (%s)", options.Panel)
+	} else if isDRMPanel(options.Panel) {
+		card := strings.TrimPrefix(strings.TrimPrefix(options.Panel, panelDRM), ":")
+		if card == "" {
+			card = panel.DefaultDRMCard
+		}
+		if _, err := os.Stat(card); err != nil {
+			fail("Panel %s: %s not found (enable KMS with dtoverlay=vc4-kms-v3d)", options.Panel, card)
+		} else {
+			ok("Panel is the monitor on %s", card)
+		}
+	} else if options.Panel != "" && options.Panel != panelFramebuffer {
+		spi := options.SPI
+		if spi.BitBang {
+			if chip, err := panel.ResolveGPIOChip(spi.GPIOChip); err != nil {
+				fail("Panel %s: %v", options.Panel, err)
+			} else if _, err := os.Stat(chip); err != nil {
+				fail("Panel %s: %v", options.Panel, err)
+			} else {
+				ok("Panel %s on bit-banged SPI (SCLK %s, MOSI %s, MISO %s, CS %s, RST %s, DC %s, BUSY %s)", options.Panel, spi.SCLK, spi.MOSI, spi.MISO, spi.CS, spi.RST, spi.DC, spi.BUSY)
+			}
+		} else if _, err := os.Stat(spi.Device()); err != nil {
+			fail("Panel %s: %s not found (enable SPI with dtparam=spi=on, or set SPI.Bus and SPI.ChipSelect)", options.Panel, spi.Device())
+		} else if chip, err := panel.ResolveGPIOChip(spi.GPIOChip); err != nil {
+			fail("Panel %s: %v", options.Panel, err)
+		} else if _, err := os.Stat(chip); err != nil {
+			fail("Panel %s: %v", options.Panel, err)
+		} else {
+			ok("Panel %s on %s at %d Hz (RST %s, DC %s, BUSY %s)", options.Panel, spi.Device(), spi.SpeedHz, spi.RST, spi.DC, spi.BUSY)
+			if bufsiz := panel.SpidevBufsiz(); spi.ChunkSize > bufsiz {
+				fail("SPI.ChunkSize %d is larger than spidev's %d-byte buffer; lower it or raise spidev.bufsiz", spi.ChunkSize, bufsiz)
+			} else if spi.ChunkSize > 0 {
+				ok("SPI transfers of %d bytes (spidev buffer %d)", spi.ChunkSize, bufsiz)
+			} else {
+				ok("SPI transfers of %d bytes (spidev buffer)", bufsiz)
+			}
+		}
+	} else if os.Geteuid() != 0 {
+		warn("Not running as root, so the framebuffer was not checked (run with sudo, or use -headless)")
+	} else if fb, err := framebuffer.Open("/dev/fb0"); err != nil {
+		fail("Framebuffer /dev/fb0: %v", err)
+	} else {
+		ok("Framebuffer /dev/fb0 (%dx%d)", fb.Bounds().Dx(), fb.Bounds().Dy())
+		fb.Close()
+	}
+	lock := NewFramebufferLock(lockFilePath)
+	if pid, err := lock.readLockFile(); err == nil && lock.isProcessRunning(pid) {
+		ok("Display running as process %d", pid)
+	} else {
+		warn("The display is not running")
+	}
+
+	// Hardware used by optional features
+	if spi, _ := filepath.Glob("/dev/spidev*"); len(spi) > 0 {
+		ok("SPI devices: %s", strings.Join(spi, ", "))
+	} else {
+		warn("No SPI devices (only needed for SPI panels; enable with dtparam=spi=on)")
+	}
+	if chips, _ := filepath.Glob("/dev/gpiochip*"); len(chips) > 0 {
+		ok("GPIO chips: %s", strings.Join(chips, ", "))
+	}
+	if touch, err := panel.FindTouch(); err == nil {
+		ok("Touchscreen: %s", touch)
+	} else if options.Touch != "" {
+		fail("Touchscreen: %v", err)
+	}
+
+	if failures > 0 {
+		fmt.Printf("\n%d problem(s) found\n", failures)
+		os.Exit(1)
+	}
+	fmt.Println("\nNo problems found")
+}
+
+// checkServer asks the TRMNL server for the current screen to verify the
+// base URL, network settings, and API key
+func checkServer(config Config, ok, fail func(string, ...interface{})) {
+	baseURL := serverURL(config)
+	info, status, err := checkAPIKey(config)
+	switch {
+	case err != nil:
+		fail("Cannot reach %s: %v", baseURL, err)
+	case status == http.StatusOK:
+		ok("Server %s accepted the API key for %s", baseURL, describeDisplay(info))
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound:
+		fail("Server %s rejected the API key (status %d)", baseURL, status)
+	default:
+		fail("Server %s returned status %d", baseURL, status)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// configFlags maps Config settings to the run flags they give defaults for.
+// Every run flag that makes sense to keep should have an entry here.
+var configFlags = []struct {
+	Field string
+	Flag  string
+}{
+	{"DarkMode", "d"},
+	{"Verbose", "verbose"},
+	{"LogLevel", "log-level"},
+	{"LogFormat", "log-format"},
+	{"LogTarget", "log-target"},
+	{"LogFile", "log-file"},
+	{"LogMaxSize", "log-max-size"},
+	{"LogMaxAge", "log-max-age"},
+	{"LogMaxFiles", "log-max-files"},
+	{"Headless", "headless"},
+	{"HeadlessFallback", "headless-fallback"},
+	{"ArchiveDir", "archive-dir"},
+	{"ArchiveMaxFiles", "archive-max-files"},
+	{"ArchiveMaxAge", "archive-max-age"},
+	{"ArchiveMaxSize", "archive-max-size"},
+	{"MaxPixels", "max-pixels"},
+	{"PanelSleep", "panel-sleep"},
+	{"FramebufferSleep", "framebuffer-sleep"},
+	{"Prefetch", "prefetch"},
+	{"Refresh", "refresh"},
+	{"RefreshMin", "refresh-min"},
+	{"RefreshMax", "refresh-max"},
+	{"RefreshDefault", "refresh-default"},
+	{"RefreshJitter", "refresh-jitter"},
+	{"PushURL", "push-url"},
+	{"MQTTBroker", "mqtt-broker"},
+	{"MQTTTopic", "mqtt-topic"},
+	{"MQTTDiscovery", "mqtt-discovery"},
+	{"Source", "source"},
+	{"SlideshowInterval", "slideshow-interval"},
+	{"Shuffle", "shuffle"},
+	{"Screens", "screen"},
+	{"RulesFile", "rules-file"},
+	{"AutoInvert", "auto-invert"},
+	{"AutoInvertThreshold", "auto-invert-threshold"},
+	{"ResumeClear", "resume-clear"},
+	{"ClearEvery", "clear-every"},
+	{"RefreshMode", "refresh-mode"},
+	{"QualityEvery", "quality-every"},
+	{"PartialThreshold", "partial-threshold"},
+	{"Grayscale", "grayscale"},
+	{"ColorSaturation", "color-saturation"},
+	{"Dither", "dither"},
+	{"DitherSerpentine", "dither-serpentine"},
+	{"RedRule", "red-rule"},
+	{"Luma", "luma"},
+	{"LumaLinear", "luma-linear"},
+	{"Sharpen", "sharpen"},
+	{"AutoLevels", "auto-levels"},
+	{"DumpStages", "dump-stages"},
+	{"Morning", "morning"},
+	{"QuietHours", "quiet-hours"},
+	{"DarkSchedule", "dark-schedule"},
+	{"QuietMode", "quiet-mode"},
+	{"Schedule", "schedule"},
+	{"Location", "location"},
+	{"Agenda", "agenda"},
+	{"Headlines", "headlines"},
+	{"GPIOChip", "gpio-chip"},
+	{"MenuButtons", "menu-buttons"},
+	{"MenuEncoder", "menu-encoder"},
+	{"RefreshButton", "refresh-button"},
+	{"ButtonDebounce", "button-debounce"},
+	{"Buttons", "buttons"},
+	{"Touch", "touch"},
+	{"TouchRegions", "touch-regions"},
+	{"MotionSensor", "motion-sensor"},
+	{"MotionTimeout", "motion-timeout"},
+	{"MotionIdleRefresh", "motion-idle-refresh"},
+	{"LightSensor", "light-sensor"},
+	{"LightThreshold", "light-threshold"},
+	{"LightHysteresis", "light-hysteresis"},
+	{"LightDarkMode", "light-dark-mode"},
+	{"LightQuiet", "light-quiet"},
+	{"Battery", "battery"},
+	{"LowBattery", "low-battery"},
+	{"LowBatteryRefresh", "low-battery-refresh"},
+	{"CriticalBattery", "critical-battery"},
+	{"ShutdownCommand", "shutdown-command"},
+	{"ControlAddr", "control-addr"},
+	{"ControlSocket", "control-socket"},
+	{"WebUI", "web-ui"},
+	{"Gallery", "gallery"},
+	{"WebhookSecret", "webhook-secret"},
+	{"Pprof", "pprof"},
+	{"Panel", "panel"},
+	{"Mirrors", "mirror"},
+	{"Displays", "displays"},
+}
+
+// configFileFlag is the config file given with -config, if any
+var configFileFlag string
+
+// configFilePath returns the config file: the one given with -config, or
+// config.json in configDir
+func configFilePath(configDir string) string {
+	if configFileFlag != "" {
+		return configFileFlag
+	}
+	return filepath.Join(configDir, "config.json")
+}
+
+// readConfigIfExists reads the config file, treating a missing default file
+// as an empty config. A file given with -config must exist.
+func readConfigIfExists() (Config, error) {
+	configDir, err := configDirectory()
+	if err != nil {
+		return Config{}, err
+	}
+	if _, err := os.Stat(configFilePath(configDir)); os.IsNotExist(err) && configFileFlag == "" {
+		return Config{}, nil
+	}
+	return readConfig(configDir)
+}
+
+// configFlagValues returns the flag values the settings in config stand
+// for, keyed by flag name. Unset settings are left out.
+func configFlagValues(config Config) (map[string]string, error) {
+	values := make(map[string]string)
+	v := reflect.ValueOf(config)
+	for _, cf := range configFlags {
+		field := v.FieldByName(cf.Field)
+		if !field.IsValid() {
+			return nil, fmt.Errorf("config has no setting %s", cf.Field)
+		}
+		if value, ok := flagValue(field); ok {
+			values[cf.Flag] = value
+		}
+	}
+	return values, nil
+}
+
+// configFlagField returns the config setting for a flag name
+func configFlagField(flag string) string {
+	for _, cf := range configFlags {
+		if cf.Flag == flag {
+			return cf.Field
+		}
+	}
+	return flag
+}
+
+// flagValue formats a config value the way the matching flag takes it,
+// reporting false for values that are not set
+func flagValue(v reflect.Value) (string, bool) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return "", false
+		}
+		value, _ := flagValue(v.Elem())
+		return value, true
+	case reflect.String:
+		return v.String(), v.String() != ""
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10), v.Int() != 0
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Slice:
+		var items []string
+		for i := 0; i < v.Len(); i++ {
+			items = append(items, v.Index(i).String())
+		}
+		return strings.Join(items, ","), len(items) > 0
+	case reflect.Map:
+		var items []string
+		for _, key := range v.MapKeys() {
+			items = append(items, key.String()+"="+v.MapIndex(key).String())
+		}
+		sort.Strings(items)
+		return strings.Join(items, ","), len(items) > 0
+	}
+	return "", false
+}
+
+// Environment variables overriding config settings are named with this
+// prefix and the setting in upper snake case, e.g. TRMNL_DARK_MODE
+const configEnvPrefix = "TRMNL_"
+
+// configEnvSeparators gives list settings that are not comma-separated
+// their separator, matching the flag
+var configEnvSeparators = map[string]string{
+	"Rules": ";",
+}
+
+// configEnvName returns the environment variable for a config setting
+func configEnvName(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	b.WriteString(configEnvPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (nextLower && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// configEnvNames lists the config environment variables that are set
+func configEnvNames() []string {
+	var names []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name := configEnvName(t.Field(i).Name)
+		if _, ok := os.LookupEnv(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// applyConfigEnv overrides the settings in config with any TRMNL_*
+// environment variables. Values take the same form as the matching flag;
+// lists and maps may also be given as JSON.
+func applyConfigEnv(config Config) (Config, error) {
+	v := reflect.ValueOf(&config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := configEnvName(t.Field(i).Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || t.Field(i).Name == "Profiles" {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), value, configEnvSeparators[t.Field(i).Name]); err != nil {
+			return config, fmt.Errorf("error in environment: invalid value %q for %s: %v", value, name, err)
+		}
+	}
+	return config, nil
+}
+
+// setEnvValue parses an environment variable into a config field. An empty
+// separator means ",".
+func setEnvValue(field reflect.Value, value, separator string) error {
+	if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		return json.Unmarshal([]byte(trimmed), field.Addr().Interface())
+	}
+	if separator == "" {
+		separator = ","
+	}
+
+	switch field.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(field.Type().Elem())
+		if err := setEnvValue(elem.Elem(), value, separator); err != nil {
+			return err
+		}
+		field.Set(elem)
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("expected JSON")
+		}
+		var items []string
+		for _, item := range strings.Split(value, separator) {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("expected JSON")
+		}
+		items := make(map[string]string)
+		for _, pair := range strings.Split(value, separator) {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, item, found := strings.Cut(pair, "=")
+			if !found {
+				return fmt.Errorf("expected name=value pairs")
+			}
+			items[strings.TrimSpace(key)] = strings.TrimSpace(item)
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting")
+	}
+	return nil
+}
+
+// updateConfigFile applies change to the config file as it is on disk, so
+// that settings from the environment are not written back to it
+func updateConfigFile(configDir string, change func(*Config)) error {
+	config, err := readConfigIfExists()
+	if err != nil {
+		return err
+	}
+	change(&config)
+	saveConfig(configDir, config)
+	return nil
+}
+
+// selectProfile returns config with the named profile's settings laid over
+// it. Settings a profile leaves out keep their value from the top level.
+func selectProfile(config Config, name string) (Config, error) {
+	if name == "" {
+		return config, nil
+	}
+	profile, ok := config.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range config.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return config, fmt.Errorf("unknown profile %q: the config file has no profiles", name)
+		}
+		return config, fmt.Errorf("unknown profile %q (expected one of %s)", name, strings.Join(names, ", "))
+	}
+
+	base := reflect.ValueOf(&config).Elem()
+	overlay := reflect.ValueOf(profile)
+	for i := 0; i < base.NumField(); i++ {
+		switch base.Type().Field(i).Name {
+		case "Profiles", "Profile", "Displays":
+			continue
+		}
+		if field := overlay.Field(i); !field.IsZero() {
+			base.Field(i).Set(field)
+		}
+	}
+	config.Profile = name
+	return config, nil
+}
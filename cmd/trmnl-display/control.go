@@ -0,0 +1,511 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a pairing code stays valid; the pairing screen is redrawn with a
+// fresh one when it expires
+const pairingTokenLifetime = 10 * time.Minute
+
+// Largest JSON request body the control API reads; anything bigger is
+// rejected rather than buffered
+const maxRequestBytes = 64 * 1024
+
+// ControlServer is the HTTP API used by phones and other devices to control
+// the frame. Clients pair once with a one-time token from the pairing screen
+// and then authenticate with the bearer token they were issued.
+type ControlServer struct {
+	Addr string
+
+	daemon         *Daemon
+	mu             sync.Mutex
+	configDir      string
+	config         Config
+	options        AppOptions
+	pairingToken   string
+	pairingExpires time.Time
+}
+
+// NewControlServer creates the control API for d. Paired client tokens are
+// kept (hashed) in the config file.
+func NewControlServer(d *Daemon, addr, configDir string, config Config, options AppOptions) *ControlServer {
+	return &ControlServer{
+		Addr:      addr,
+		daemon:    d,
+		configDir: configDir,
+		config:    config,
+		options:   options,
+	}
+}
+
+// Start listens on Addr and serves the API until ctx is cancelled
+func (s *ControlServer) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("error starting control API: %v", err)
+	}
+	s.Addr = listener.Addr().String()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("POST /refresh", s.handleWebhook)
+	mux.HandleFunc("GET /pair", s.handlePairPage)
+	mux.HandleFunc("POST /api/pair", s.handlePair)
+	mux.HandleFunc("GET /frame.png", s.requireToken(s.handlePanelImage))
+	mux.HandleFunc("GET /frame/source", s.requireToken(s.handleSourceImage))
+	s.registerAPI(mux, s.requireToken)
+	if s.options.WebUI {
+		mux.HandleFunc("GET /{$}", s.handleWebUI)
+		mux.HandleFunc("GET /api/frame.png", s.requireToken(s.handleFrame))
+		mux.HandleFunc("GET /api/settings", s.requireToken(s.handleGetSettings))
+		mux.HandleFunc("PUT /api/settings", s.requireToken(s.handlePutSettings))
+	}
+	if gallery != nil {
+		mux.HandleFunc("GET /gallery", s.handleGalleryPage)
+		mux.HandleFunc("GET /api/gallery", s.requireToken(s.handleListGallery))
+		mux.HandleFunc("GET /api/gallery/{name}", s.requireToken(s.handleGalleryFrame))
+	}
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	return nil
+}
+
+// registerAPI adds the /api endpoints for controlling the frame, each
+// wrapped in auth
+func (s *ControlServer) registerAPI(mux *http.ServeMux, auth func(http.HandlerFunc) http.HandlerFunc) {
+	mux.HandleFunc("GET /api/status", auth(s.handleStatus))
+	mux.HandleFunc("POST /api/refresh", auth(s.handleRefresh))
+	mux.HandleFunc("POST /api/screen", auth(s.handleScreen))
+	mux.HandleFunc("POST /api/dark-mode", auth(s.handleDarkMode))
+	mux.HandleFunc("POST /api/pause", auth(s.handlePause))
+	mux.HandleFunc("POST /api/resume", auth(s.handleResume))
+	mux.HandleFunc("GET /api/reminders", auth(s.handleListReminders))
+	mux.HandleFunc("POST /api/reminders", auth(s.handleAddReminder))
+	mux.HandleFunc("DELETE /api/reminders/{id}", auth(s.handleRemoveReminder))
+	mux.HandleFunc("POST /api/reminders/dismiss", auth(s.handleDismissReminder))
+	mux.HandleFunc("GET /api/badges", auth(s.handleListBadges))
+	mux.HandleFunc("POST /api/badges", auth(s.handleSetBadge))
+	mux.HandleFunc("DELETE /api/badges/{id}", auth(s.handleRemoveBadge))
+	mux.HandleFunc("POST /api/display", auth(s.handlePushDisplay))
+	mux.HandleFunc("POST /api/text", auth(s.handlePushText))
+	mux.HandleFunc("DELETE /api/display", auth(s.handleClearPush))
+	mux.HandleFunc("GET /api/debug/diff.png", auth(s.handleDiff))
+	mux.HandleFunc("GET /api/metrics", auth(s.handleMetrics))
+}
+
+// UpdateConfig switches to reloaded settings
+func (s *ControlServer) UpdateConfig(config Config, options AppOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+	s.options = options
+}
+
+// currentOptions returns the options in effect
+func (s *ControlServer) currentOptions() AppOptions {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.options
+}
+
+// IssuePairingToken replaces the pairing token with a fresh one
+func (s *ControlServer) IssuePairingToken() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pairingToken = token
+	s.pairingExpires = time.Now().Add(pairingTokenLifetime)
+	return token, nil
+}
+
+// PairingURL is the link encoded in the pairing QR code. Opening it in a
+// browser pairs through the web page; apps can read the address and token
+// from it directly.
+func (s *ControlServer) PairingURL(token string) string {
+	return s.BaseURL() + "/pair?token=" + token
+}
+
+// BaseURL is the address other devices on the network use to reach the API
+func (s *ControlServer) BaseURL() string {
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		host, port = s.Addr, "80"
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+		if addrs := interfaceAddresses(); len(addrs) > 0 {
+			host = addrs[0].IP.String()
+		}
+	}
+	return "http://" + net.JoinHostPort(host, port)
+}
+
+// consumePairingToken checks token against the current pairing token, which
+// can only be used once
+func (s *ControlServer) consumePairingToken(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pairingToken == "" || time.Now().After(s.pairingExpires) {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.pairingToken)) != 1 {
+		return false
+	}
+	s.pairingToken = ""
+	return true
+}
+
+// addClient issues a client token and saves its hash to the config file
+func (s *ControlServer) addClient() (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := hashToken(token)
+	s.config.ControlTokens = append(s.config.ControlTokens, hash)
+	err = updateConfigFile(s.configDir, func(c *Config) {
+		c.ControlTokens = append(c.ControlTokens, hash)
+	})
+	return token, err
+}
+
+// requireToken rejects requests without a paired client's bearer token
+func (s *ControlServer) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.isClient(token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// isClient reports whether token was issued to a paired client
+func (s *ControlServer) isClient(token string) bool {
+	hash := hashToken(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, known := range s.config.ControlTokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(known)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// handlePair exchanges a pairing token for a client token
+func (s *ControlServer) handlePair(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if !s.consumePairingToken(req.Token) {
+		http.Error(w, "invalid or expired pairing token", http.StatusForbidden)
+		return
+	}
+
+	token, err := s.addClient()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	controlLog.Info("Paired new control client", "addr", r.RemoteAddr)
+
+	// Put the normal screen back once pairing is done
+	if live.Screen() == screenPair {
+		live.SetScreen("")
+		requestRefresh()
+	}
+	writeJSON(w, map[string]string{"token": token})
+}
+
+// handleStatus reports the frame's live settings; an empty screen means the
+// content rules are in charge
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := map[string]any{
+		"version":   version,
+		"screen":    live.Screen(),
+		"dark_mode": live.DarkMode(s.currentOptions().DarkMode),
+		"paused":    live.Paused(),
+		"errors":    health.RecentErrors(),
+	}
+	if next := health.NextRefresh(); !next.IsZero() {
+		status["next_refresh"] = next
+	}
+	writeJSON(w, status)
+}
+
+// handlePanelImage serves exactly what is on the panel: the last image drawn,
+// at the panel's resolution, with any badges or menu over it
+func (s *ControlServer) handlePanelImage(w http.ResponseWriter, r *http.Request) {
+	s.daemon.mu.Lock()
+	img := s.daemon.panelImage
+	s.daemon.mu.Unlock()
+	if img == nil {
+		http.Error(w, "nothing has been drawn yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, img)
+}
+
+// handleSourceImage serves the image file the current frame was decoded
+// from, as the server or slideshow provided it
+func (s *ControlServer) handleSourceImage(w http.ResponseWriter, r *http.Request) {
+	s.daemon.mu.Lock()
+	source := s.daemon.lastSource
+	s.daemon.mu.Unlock()
+	if source == nil {
+		http.Error(w, "the current screen was rendered on the frame and has no source image", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(source))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(source)
+}
+
+// handlePause stops refreshing, keeping the current screen
+func (s *ControlServer) handlePause(w http.ResponseWriter, r *http.Request) {
+	live.Pause()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResume restarts refreshing; {"clear": true} clears the panel first
+// regardless of -resume-clear
+func (s *ControlServer) handleResume(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Clear bool `json:"clear"`
+	}{Clear: s.currentOptions().ResumeClear}
+	if r.ContentLength != 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	live.Resume(req.Clear)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRefresh fetches a new screen immediately
+func (s *ControlServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleScreen pins the frame to a screen ("" follows the rules again)
+func (s *ControlServer) handleScreen(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Screen string `json:"screen"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if err := validateScreen(req.Screen, s.currentOptions().Screens); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	live.SetScreen(req.Screen)
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDarkMode turns dark mode on or off
+func (s *ControlServer) handleDarkMode(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	live.SetDarkMode(req.Enabled)
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListReminders lists the scheduled reminders
+func (s *ControlServer) handleListReminders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, reminders.List())
+}
+
+// handleAddReminder schedules a reminder; it lasts until the daemon restarts
+func (s *ControlServer) handleAddReminder(w http.ResponseWriter, r *http.Request) {
+	var reminder Reminder
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&reminder); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	id, err := reminders.Add(reminder)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Show it now if it is already due, otherwise wake up in time for it
+	requestRefresh()
+	writeJSON(w, map[string]string{"id": id})
+}
+
+// handleRemoveReminder deletes a reminder added through the API
+func (s *ControlServer) handleRemoveReminder(w http.ResponseWriter, r *http.Request) {
+	if !reminders.Remove(r.PathValue("id")) {
+		http.Error(w, "no such reminder", http.StatusNotFound)
+		return
+	}
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDismissReminder takes down the reminder on screen
+func (s *ControlServer) handleDismissReminder(w http.ResponseWriter, r *http.Request) {
+	if !dismissReminder() {
+		http.Error(w, "no reminder is showing", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListBadges lists the badges that have not expired
+func (s *ControlServer) handleListBadges(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, badges.Active(time.Now()))
+}
+
+// handleSetBadge adds or replaces a badge; setting one with an existing ID
+// updates it in place
+func (s *ControlServer) handleSetBadge(w http.ResponseWriter, r *http.Request) {
+	var badge Badge
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&badge); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	id, err := badges.Set(badge, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]string{"id": id})
+}
+
+// handleRemoveBadge takes a badge down before it expires
+func (s *ControlServer) handleRemoveBadge(w http.ResponseWriter, r *http.Request) {
+	if !badges.Remove(r.PathValue("id")) {
+		http.Error(w, "no such badge", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePairPage is the page the pairing QR code opens on a phone
+func (s *ControlServer) handlePairPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, pairPage)
+}
+
+// pairPage completes pairing in the browser and offers a refresh button
+const pairPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>TRMNL Display</title>
+</head>
+<body style="font-family: sans-serif; max-width: 30em; margin: 2em auto; padding: 0 1em">
+<h1>TRMNL Display</h1>
+<p id="status">Pairing…</p>
+<button id="refresh" hidden>Refresh now</button>
+<script>
+const status = document.getElementById("status");
+const refresh = document.getElementById("refresh");
+const token = new URLSearchParams(location.search).get("token");
+function ready() {
+  status.textContent = "Paired with this frame.";
+  refresh.hidden = false;
+}
+refresh.onclick = () => fetch("/api/refresh", {
+  method: "POST",
+  headers: {Authorization: "Bearer " + localStorage.getItem("trmnl-token")},
+}).then(r => status.textContent = r.ok ? "Refreshing…" : "Refresh failed (" + r.status + ")");
+fetch("/api/pair", {method: "POST", body: JSON.stringify({token})})
+  .then(r => r.ok ? r.json() : Promise.reject(r.status))
+  .then(body => { localStorage.setItem("trmnl-token", body.token); ready(); })
+  .catch(() => localStorage.getItem("trmnl-token") ? ready() : status.textContent = "This pairing code has expired. Show a new one on the frame and scan again.");
+</script>
+</body>
+</html>
+`
+
+// writeJSON sends v as a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// randomToken returns 128 random bits as hex
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken is how client tokens are stored in the config file
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleWebhook fetches a new screen now, for webhooks (for example from a
+// playlist change) that cannot pair. The shared secret can be sent as a
+// bearer token, an X-Webhook-Secret header, or a secret query parameter, as
+// the sender allows.
+func (s *ControlServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get("X-Webhook-Secret")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		secret = bearer
+	}
+	if q := r.URL.Query().Get("secret"); q != "" {
+		secret = q
+	}
+	want := s.currentOptions().WebhookSecret
+	if want == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(want)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	controlLog.Info("Webhook received, refreshing now", "addr", r.RemoteAddr)
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
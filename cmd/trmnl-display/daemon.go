@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"sync"
+
+	"trmnl-display/pkg/history"
+	"trmnl-display/pkg/panel"
+)
+
+// Daemon is a running display: the panel it draws on, what is shown there,
+// and the servers that change it. The display loop, the control API, and
+// the goroutines watching buttons, sensors, and signals share one, which
+// commands that draw once and tests set up for themselves.
+type Daemon struct {
+	panel   panel.Panel      // nil when drawing to the framebuffer
+	power   *PanelPower      // nil unless the panel sleeps between refreshes
+	lock    *FramebufferLock // nil until the display is taken
+	control *ControlServer   // nil when -control-addr is not set
+	pushed  *PushedContent
+	history *history.File // nil when cycles are not recorded
+
+	// mu serialises drawing between the display loop and input handlers,
+	// and guards the fields below
+	mu sync.Mutex
+
+	// lastFrame is the most recent frame handed to the display, so overlays
+	// such as the menu can restore it, and previousFrame the one before,
+	// for the diff heatmap
+	lastFrame     image.Image
+	previousFrame image.Image
+
+	// panelImage is what was last drawn on the panel, after badges,
+	// overlays, and scaling (or the frame last archived when headless), and
+	// lastSource the image file the current frame was decoded from, nil for
+	// screens rendered on the frame
+	panelImage image.Image
+	lastSource []byte
+
+	// badgeRects are where badges were last drawn, in frame coordinates, so
+	// the area can be redrawn when they change
+	badgeRects []image.Rectangle
+
+	// scaledFrames are the frames images are scaled into for the panel or
+	// framebuffer
+	scaledFrames frameBuffers
+}
+
+// newDaemon creates a display with nothing drawn yet, drawing to the
+// framebuffer until a panel is opened
+func newDaemon() *Daemon {
+	return &Daemon{pushed: &PushedContent{}}
+}
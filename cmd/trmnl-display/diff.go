@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Defaults for the diff heatmap: the cell size in pixels and the luma change
+// (0-255) below which a pixel counts as unchanged
+const (
+	defaultDiffCell      = 16
+	defaultDiffThreshold = 32
+)
+
+// DiffStats summarises the change between two frames
+type DiffStats struct {
+	ChangedPixels int
+	TotalPixels   int
+	Bounds        image.Rectangle // smallest rectangle containing every change
+}
+
+// diffHeatmap visualises which parts of next changed from prev. The new frame
+// is shown faded, each cell is tinted red by the fraction of its pixels that
+// changed, and the bounding box of all changes is outlined in blue.
+func diffHeatmap(prev, next image.Image, cell, threshold int) (*image.RGBA, DiffStats, error) {
+	bounds := next.Bounds()
+	if prev.Bounds().Size() != bounds.Size() {
+		return nil, DiffStats{}, fmt.Errorf("frames differ in size: %v and %v", prev.Bounds().Size(), bounds.Size())
+	}
+	if cell < 1 {
+		cell = 1
+	}
+
+	width, height := bounds.Dx(), bounds.Dy()
+	cols, rows := (width+cell-1)/cell, (height+cell-1)/cell
+	changed := make([]int, cols*rows)
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	stats := DiffStats{TotalPixels: width * height}
+	offset := prev.Bounds().Min.Sub(bounds.Min)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := bounds.Min.Add(image.Pt(x, y))
+			a := color.GrayModel.Convert(prev.At(p.X+offset.X, p.Y+offset.Y)).(color.Gray).Y
+			b := color.GrayModel.Convert(next.At(p.X, p.Y)).(color.Gray).Y
+			faded := 160 + b/3
+			out.SetRGBA(x, y, color.RGBA{faded, faded, faded, 255})
+
+			if abs(int(a)-int(b)) >= threshold {
+				changed[(y/cell)*cols+x/cell]++
+				stats.ChangedPixels++
+				stats.Bounds = stats.Bounds.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	// Tint cells by how much of them changed, with a floor so that single
+	// changed pixels are still visible
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			n := changed[row*cols+col]
+			if n == 0 {
+				continue
+			}
+			r := image.Rect(col*cell, row*cell, (col+1)*cell, (row+1)*cell).Intersect(out.Bounds())
+			fraction := float64(n) / float64(r.Dx()*r.Dy())
+			alpha := 0.25 + 0.75*fraction
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					c := out.RGBAAt(x, y)
+					c.R = uint8(float64(c.R)*(1-alpha) + 255*alpha)
+					c.G = uint8(float64(c.G) * (1 - alpha))
+					c.B = uint8(float64(c.B) * (1 - alpha))
+					out.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+
+	if !stats.Bounds.Empty() {
+		outlineRect(out, stats.Bounds, color.RGBA{0, 0, 255, 255})
+	}
+	return out, stats, nil
+}
+
+// outlineRect draws a two-pixel border just outside r
+func outlineRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	outer := r.Inset(-2).Intersect(img.Bounds())
+	for y := outer.Min.Y; y < outer.Max.Y; y++ {
+		for x := outer.Min.X; x < outer.Max.X; x++ {
+			if !(image.Pt(x, y).In(r)) {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// String describes the change in a log-friendly way
+func (s DiffStats) String() string {
+	if s.ChangedPixels == 0 {
+		return "no changes"
+	}
+	return fmt.Sprintf("%d of %d pixels changed (%.1f%%) within %v",
+		s.ChangedPixels, s.TotalPixels, 100*float64(s.ChangedPixels)/float64(s.TotalPixels), s.Bounds)
+}
+
+// handleDiff serves the heatmap of the last two frames shown. The cell size
+// and threshold can be tuned with the cell and threshold query parameters.
+func (s *ControlServer) handleDiff(w http.ResponseWriter, r *http.Request) {
+	s.daemon.mu.Lock()
+	prev, next := s.daemon.previousFrame, s.daemon.lastFrame
+	s.daemon.mu.Unlock()
+	if prev == nil || next == nil {
+		http.Error(w, "fewer than two frames have been shown", http.StatusNotFound)
+		return
+	}
+
+	cell, threshold := defaultDiffCell, defaultDiffThreshold
+	if v := r.URL.Query().Get("cell"); v != "" {
+		cell, _ = strconv.Atoi(v)
+	}
+	if v := r.URL.Query().Get("threshold"); v != "" {
+		threshold, _ = strconv.Atoi(v)
+	}
+
+	heatmap, stats, err := diffHeatmap(prev, next, cell, threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Changed-Pixels", strconv.Itoa(stats.ChangedPixels))
+	png.Encode(w, heatmap)
+}
+
+// runDiff implements the diff subcommand, which renders the heatmap for two
+// saved frames (for example from the headless archive)
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	cell := fs.Int("cell", defaultDiffCell, "Heatmap cell size in pixels")
+	threshold := fs.Int("threshold", defaultDiffThreshold, "Luma difference (0-255) at which a pixel counts as changed")
+	output := fs.String("o", "diff.png", "Write the heatmap to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: trmnl-display diff [flags] <previous.png> <next.png>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	prev, err := loadImageFile(fs.Arg(0))
+	if err != nil {
+		fmt.Printf("Error loading frame: %v\n", err)
+		os.Exit(1)
+	}
+	next, err := loadImageFile(fs.Arg(1))
+	if err != nil {
+		fmt.Printf("Error loading frame: %v\n", err)
+		os.Exit(1)
+	}
+
+	heatmap, stats, err := diffHeatmap(prev, next, *cell, *threshold)
+	if err != nil {
+		fmt.Printf("Error comparing frames: %v\n", err)
+		os.Exit(1)
+	}
+	out, err := os.Create(*output)
+	if err != nil {
+		fmt.Printf("Error creating %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	defer out.Close()
+	if err := png.Encode(out, heatmap); err != nil {
+		fmt.Printf("Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s; heatmap written to %s\n", stats, *output)
+}
+
+// loadImageFile decodes an image file in any registered format
+func loadImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding %s: %v", path, err)
+	}
+	return img, nil
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Files are kept in the XDG base directories: settings in
+// $XDG_CONFIG_HOME/trmnl (default ~/.config/trmnl), and history and archived
+// frames in $XDG_STATE_HOME/trmnl (default ~/.local/state/trmnl), under
+// displays/NAME for each of several displays. Older
+// versions kept everything in ~/.trmnl, which is migrated on first use.
+
+// legacyFiles maps files in ~/.trmnl to the XDG directory they now live in
+var legacyFiles = []struct {
+	Name string
+	Dir  func() (string, error)
+}{
+	{"config.json", xdgConfigDirectory},
+	{"history.jsonl", xdgStateDirectory},
+	{"archive", xdgStateDirectory},
+}
+
+var migrateOnce sync.Once
+
+// configDirectory returns the config directory, creating it if necessary
+func configDirectory() (string, error) {
+	migrateOnce.Do(migrateLegacyDirectory)
+	return ensureDirectory(xdgConfigDirectory())
+}
+
+// stateDirectory returns the directory for history and archived frames,
+// creating it if necessary; each display named by -instance has its own
+func stateDirectory(instance string) (string, error) {
+	migrateOnce.Do(migrateLegacyDirectory)
+	dir, err := xdgStateDirectory()
+	if err == nil && instance != "" {
+		dir = filepath.Join(dir, "displays", instance)
+	}
+	return ensureDirectory(dir, err)
+}
+
+// xdgConfigDirectory returns $XDG_CONFIG_HOME/trmnl
+func xdgConfigDirectory() (string, error) {
+	return xdgDirectory("XDG_CONFIG_HOME", ".config")
+}
+
+// xdgStateDirectory returns $XDG_STATE_HOME/trmnl
+func xdgStateDirectory() (string, error) {
+	return xdgDirectory("XDG_STATE_HOME", filepath.Join(".local", "state"))
+}
+
+// xdgDirectory returns the trmnl directory under the base directory named
+// by env, or under fallback in the home directory when env is unset. The
+// spec says relative paths are invalid and must be ignored.
+func xdgDirectory(env, fallback string) (string, error) {
+	if base := os.Getenv(env); filepath.IsAbs(base) {
+		return filepath.Join(base, "trmnl"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting home directory: %v", err)
+	}
+	return filepath.Join(home, fallback, "trmnl"), nil
+}
+
+// ensureDirectory creates dir if it does not exist
+func ensureDirectory(dir string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating %s: %v", dir, err)
+	}
+	return dir, nil
+}
+
+// migrateLegacyDirectory moves files from ~/.trmnl to the XDG directories,
+// leaving alone any that already exist in the new location, and removes
+// ~/.trmnl once it is empty
+func migrateLegacyDirectory() {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+	legacyDir := filepath.Join(home, ".trmnl")
+	if _, err := os.Stat(legacyDir); err != nil {
+		return
+	}
+
+	for _, file := range legacyFiles {
+		from := filepath.Join(legacyDir, file.Name)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		dir, err := ensureDirectory(file.Dir())
+		if err != nil {
+			configLog.Error("Error migrating file", "path", from, "err", err)
+			continue
+		}
+		to := filepath.Join(dir, file.Name)
+		if _, err := os.Stat(to); err == nil {
+			configLog.Warn("Not migrating file, destination already exists", "path", from, "dest", to)
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			configLog.Error("Error migrating file", "path", from, "err", err)
+			continue
+		}
+		configLog.Info("Moved file", "path", from, "dest", to)
+	}
+
+	// Only succeeds if nothing was left behind
+	os.Remove(legacyDir)
+}
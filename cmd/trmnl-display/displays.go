@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Several panels can be driven by one daemon, each a display set up by a
+// profile in the config file with its own panel, wiring, and source. With
+// Displays set, `run` supervises a child process per display, started with
+// -profile and -instance naming it, rather than drawing itself. Each display
+// then keeps its own state, and one that crashes is restarted without
+// disturbing the others.
+//
+// A process per display rather than a goroutine per display keeps what is
+// still process-wide once a Daemon holds the panel (logging, signal
+// handling, settings changed at run time, stats, the menu and mirrors, and
+// the reminders and badges) to one display each, and costs under 10MB a display, as the binary's pages are shared. Panels
+// on one SPI bus or GPIO chip need no single owner: spidev serialises
+// transfers on a bus between the processes using it, and GPIO lines are
+// requested one by one, so each display holds only its own.
+
+// How long to wait before restarting a display that exited, doubling on
+// each quick failure up to the maximum; one that ran for displayStableRun
+// starts again from the minimum
+const (
+	displayRestartMin = 10 * time.Second
+	displayRestartMax = 5 * time.Minute
+	displayStableRun  = 10 * time.Minute
+)
+
+// displayLockFile returns the lock file for the display named by -instance:
+// each of several displays has a lock file of its own, and a single display
+// (instance "") takes lockFilePath
+func displayLockFile(instance string) string {
+	if instance == "" {
+		return lockFilePath
+	}
+	return fmt.Sprintf("/var/lock/trmnl-display-%s.lock", instance)
+}
+
+// validateDisplayName checks a display name, which becomes part of file
+// names
+func validateDisplayName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid display name %q", name)
+	}
+	return nil
+}
+
+// instanceSocket returns the control socket for a display: path with the
+// display's name added before the extension
+func instanceSocket(path, name string) string {
+	if path == "" || name == "" {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// runDisplays starts a child process for each of the displays and restarts
+// any that exit until a signal stops them all. Reload, refresh, and pause
+// signals are passed on to every display.
+func runDisplays(displays []string, args []string) {
+	exe, err := os.Executable()
+	if err != nil {
+		mainLog.Error("Error finding the executable to start displays with", "err", err)
+		os.Exit(1)
+	}
+
+	// Taking the lock lets the refresh, pause, and resume commands find the
+	// supervisor, which passes their signals on
+	if os.Geteuid() == 0 {
+		lock := NewFramebufferLock(lockFilePath)
+		if err := lock.Acquire(); err != nil {
+			mainLog.Error("Error acquiring lock", "err", err)
+			os.Exit(1)
+		}
+		defer lock.Release()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		children = make(map[string]*os.Process)
+	)
+	signals := make(chan os.Signal, 4)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGTSTP, syscall.SIGCONT)
+	go func() {
+		for sig := range signals {
+			if sig == os.Interrupt || sig == syscall.SIGTERM {
+				mainLog.Info("Received termination signal, stopping displays")
+				cancel()
+				sig = syscall.SIGTERM
+			}
+			mu.Lock()
+			for _, p := range children {
+				p.Signal(sig)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for _, name := range displays {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			childArgs := append([]string{"run"}, args...)
+			childArgs = append(childArgs, "-profile", name, "-instance", name)
+			backoff := displayRestartMin
+			for ctx.Err() == nil {
+				cmd := exec.Command(exe, childArgs...)
+				cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+				// In a process group of its own, so a Ctrl-C reaches it
+				// only once, passed on from here
+				cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+				started := time.Now()
+				if err := cmd.Start(); err != nil {
+					mainLog.Error("Error starting display", "display", name, "err", err)
+				} else {
+					mainLog.Info("Started display", "display", name, "pid", cmd.Process.Pid)
+					mu.Lock()
+					children[name] = cmd.Process
+					mu.Unlock()
+					err := cmd.Wait()
+					mu.Lock()
+					delete(children, name)
+					mu.Unlock()
+					if ctx.Err() != nil {
+						mainLog.Info("Display stopped", "display", name)
+						return
+					}
+					mainLog.Warn("Display exited, restarting", "display", name, "err", err, "after", backoff)
+				}
+				if time.Since(started) > displayStableRun {
+					backoff = displayRestartMin
+				}
+				select {
+				case <-time.After(backoff):
+				case <-ctx.Done():
+					return
+				}
+				backoff = min(backoff*2, displayRestartMax)
+			}
+		}()
+	}
+	wg.Wait()
+}
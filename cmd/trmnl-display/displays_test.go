@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestInstanceSocket(t *testing.T) {
+	for _, tt := range []struct{ path, name, want string }{
+		{"/run/trmnl-display.sock", "kitchen", "/run/trmnl-display-kitchen.sock"},
+		{"/tmp/ctl", "hall", "/tmp/ctl-hall"},
+		{"", "hall", ""},
+		{"/run/trmnl-display.sock", "", "/run/trmnl-display.sock"},
+	} {
+		if got := instanceSocket(tt.path, tt.name); got != tt.want {
+			t.Errorf("instanceSocket(%q, %q) = %q, want %q", tt.path, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateDisplayName(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"kitchen":   true,
+		"hall-2":    true,
+		"":          false,
+		"..":        false,
+		"a/b":       false,
+		"two words": false,
+	} {
+		if err := validateDisplayName(name); (err == nil) != valid {
+			t.Errorf("validateDisplayName(%q) = %v, want valid %v", name, err, valid)
+		}
+	}
+}
+
+func TestDisplayLockFile(t *testing.T) {
+	if got := displayLockFile(""); got != lockFilePath {
+		t.Errorf(`displayLockFile("") = %q, want %q`, got, lockFilePath)
+	}
+	if got, want := displayLockFile("kitchen"), "/var/lock/trmnl-display-kitchen.lock"; got != want {
+		t.Errorf(`displayLockFile("kitchen") = %q, want %q`, got, want)
+	}
+}
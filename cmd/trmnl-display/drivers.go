@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/panel"
+	"trmnl-display/pkg/render"
+)
+
+// Panels the display can drive: a framebuffer provided by a kernel driver,
+// a monitor through DRM mode setting, an e-paper panel driven directly over
+// SPI, or a PNG file, browser preview, or terminal standing in for a panel
+const (
+	panelFramebuffer     = "framebuffer"
+	panelDRM             = "drm"
+	panelDRMPrefix       = "drm:"
+	panelAuto            = "auto"
+	panelWaveshare7in5V2 = "waveshare-7in5-v2"
+	panelWaveshare7in5B  = "waveshare-7in5b-v2"
+	panelIT8951          = "it8951"
+	panelWaveshare7in3F  = "waveshare-7in3f"
+	panelWaveshare5in65F = "waveshare-5in65f"
+	panelWaveshare7in3E  = "waveshare-7in3e"
+	panelFilePrefix      = "file:"
+	panelPreview         = "preview"
+	panelPreviewPrefix   = "preview:"
+	panelTerm            = "term"
+	panelTermPrefix      = "term:"
+)
+
+// Colour panels by -panel name
+var colorPanels = map[string]panel.ColorModel{
+	panelWaveshare7in3F:  panel.ACeP7in3F,
+	panelWaveshare5in65F: panel.ACeP5in65F,
+	panelWaveshare7in3E:  panel.Spectra7in3E,
+}
+
+// detectPanel picks the panel for -panel auto from the HAT product name in
+// its ID EEPROM, falling back to the 7.5" V2 HAT, the commonest and one
+// without an EEPROM. It also describes what was found, for the log.
+func detectPanel(product, vendor string) (name, detected string) {
+	if product == "" {
+		return panelWaveshare7in5V2, "no HAT EEPROM found, assuming " + panelWaveshare7in5V2 + " (set -panel to choose another)"
+	}
+	desc := fmt.Sprintf("HAT %q by %q", product, vendor)
+	if hat, ok := findHAT(product); ok && hat.Panel != "" {
+		return hat.Panel, desc
+	}
+	return panelWaveshare7in5V2, desc + ", not a known panel, assuming " + panelWaveshare7in5V2 + " (set -panel to choose another)"
+}
+
+// validatePanel checks a -panel value
+func validatePanel(name string) error {
+	switch {
+	case name == panelFramebuffer, name == panelWaveshare7in5V2, name == panelWaveshare7in5B, name == panelIT8951, isPreviewPanel(name):
+		return nil
+	case isColorPanel(name), isDRMPanel(name):
+		return nil
+	case strings.HasPrefix(name, panelFilePrefix):
+		if strings.TrimPrefix(name, panelFilePrefix) == "" {
+			return fmt.Errorf("-panel file: needs a path, e.g. file:/tmp/frame.png")
+		}
+		return nil
+	case name == panelTerm:
+		return nil
+	case strings.HasPrefix(name, panelTermPrefix):
+		switch mode := strings.TrimPrefix(name, panelTermPrefix); mode {
+		case panel.TermModeSixel, panel.TermModeBlocks:
+			return nil
+		default:
+			return fmt.Errorf("unknown terminal mode %q, expected %s or %s", mode, panel.TermModeSixel, panel.TermModeBlocks)
+		}
+	}
+	return fmt.Errorf("unknown panel %q, expected %s, %s, drm[:CARD], %s, %s, %s, %s, %s, %s, file:PATH, preview[:ADDR], or term[:MODE]", name, panelAuto, panelFramebuffer, panelWaveshare7in5V2, panelWaveshare7in5B, panelWaveshare7in3F, panelWaveshare5in65F, panelWaveshare7in3E, panelIT8951)
+}
+
+// isPreviewPanel reports whether panel is a browser preview
+func isPreviewPanel(name string) bool {
+	return name == panelPreview || strings.HasPrefix(name, panelPreviewPrefix)
+}
+
+// isDRMPanel reports whether panel is a monitor driven through DRM
+func isDRMPanel(name string) bool {
+	return name == panelDRM || strings.HasPrefix(name, panelDRMPrefix)
+}
+
+// isColorPanel reports whether panel is one of the colour panels
+func isColorPanel(name string) bool {
+	_, ok := colorPanels[name]
+	return ok
+}
+
+// needsHardware reports whether options draw on a real display, which
+// needs root and the framebuffer lock
+func needsHardware(options AppOptions) bool {
+	if options.Headless {
+		return false
+	}
+	switch options.Panel {
+	case "", panelFramebuffer, panelWaveshare7in5V2, panelWaveshare7in5B, panelIT8951:
+		return true
+	}
+	return isColorPanel(options.Panel) || isDRMPanel(options.Panel)
+}
+
+// openPanel opens the panel driver options select; the framebuffer needs
+// no setup
+func (d *Daemon) openPanel(options AppOptions) error {
+	if options.PanelDetected != "" {
+		displayLog.Info("Panel detected", "panel", options.Panel, "from", options.PanelDetected)
+	}
+	switch {
+	case options.Panel == "" || options.Panel == panelFramebuffer:
+		return nil
+	case isStandInPanel(options.Panel):
+		standIn, err := openStandIn(options.Panel)
+		if err != nil {
+			return err
+		}
+		d.panel = standIn
+		return nil
+	case isDRMPanel(options.Panel):
+		drm, err := panel.OpenDRM(strings.TrimPrefix(strings.TrimPrefix(options.Panel, panelDRM), ":"))
+		if err != nil {
+			return err
+		}
+		displayLog.Info("DRM display opened", "card", drm.Path, "connector", drm.Connector, "size", drm.Bounds().Size())
+		d.panel = drm
+		return nil
+	case options.Panel == panelIT8951:
+		it8951, err := panel.OpenIT8951(options.SPI)
+		if err != nil {
+			return err
+		}
+		it8951.DeepSleep = options.PanelSleep
+		it8951.Dither = options.Dither
+		it8951.Trace = func(step string, elapsed time.Duration) {
+			drawingTimings.Add("panel_"+step, elapsed)
+		}
+		d.panel = it8951
+		return nil
+	case isColorPanel(options.Panel):
+		epd, err := panel.OpenColorEPD(options.SPI, colorPanels[options.Panel])
+		if err != nil {
+			return err
+		}
+		epd.Saturation = options.ColorSaturation
+		epd.Dither = options.Dither
+		epd.Trace = func(step string, elapsed time.Duration) {
+			drawingTimings.Add("panel_"+step, elapsed)
+		}
+		d.panel = epd
+		return nil
+	}
+	epd, err := panel.OpenEPD(options.SPI)
+	if err != nil {
+		return err
+	}
+	epd.DeepSleep = options.PanelSleep
+	epd.PartialThreshold = options.PartialThreshold
+	epd.Grayscale = options.Grayscale
+	epd.Dither = options.Dither
+	epd.Red = options.Panel == panelWaveshare7in5B
+	epd.RedRule = options.RedRule
+	epd.Trace = func(step string, elapsed time.Duration) {
+		drawingTimings.Add("panel_"+step, elapsed)
+	}
+	d.panel = epd
+	return nil
+}
+
+// isStandInPanel reports whether name is a PNG file, browser preview, or
+// terminal standing in for a panel
+func isStandInPanel(name string) bool {
+	return strings.HasPrefix(name, panelFilePrefix) || isPreviewPanel(name) || name == panelTerm || strings.HasPrefix(name, panelTermPrefix)
+}
+
+// openStandIn opens a PNG file, browser preview, or terminal to show frames
+// on, as the panel or a mirror of it
+func openStandIn(name string) (panel.Panel, error) {
+	switch {
+	case strings.HasPrefix(name, panelFilePrefix):
+		return panel.NewFilePanel(strings.TrimPrefix(name, panelFilePrefix)), nil
+	case isPreviewPanel(name):
+		preview, err := panel.OpenPreview(strings.TrimPrefix(strings.TrimPrefix(name, panelPreview), ":"))
+		if err != nil {
+			return nil, err
+		}
+		displayLog.Info("Preview started", "url", "http://"+preview.Addr+"/")
+		return preview, nil
+	}
+	mode, _ := strings.CutPrefix(name, panelTermPrefix)
+	if name == panelTerm {
+		mode = panel.TermModeAuto
+	}
+	return panel.NewTermPanel(mode), nil
+}
+
+// openConfiguredPanel opens the panel selected in the config file, for
+// commands that draw without taking the run flags
+func (d *Daemon) openConfiguredPanel() error {
+	options, _, err := parseOptions(nil)
+	if err != nil {
+		return err
+	}
+	return d.openPanel(options)
+}
+
+// closePanel releases the panel driver, if one is open
+func (d *Daemon) closePanel() {
+	if d.panel != nil {
+		d.panel.Close()
+		d.panel = nil
+	}
+}
+
+// lumaFrame converts img to grey with the -luma weights, unless they are the
+// standard BT.601 ones on gamma-encoded values every driver converts with
+// anyway, or the panel shows colour
+func lumaFrame(img image.Image, options AppOptions) image.Image {
+	if options.Luma == (render.Luma{}) || options.Luma == render.Lumas[render.DefaultLuma] || isColorPanel(options.Panel) || options.Panel == panelWaveshare7in5B {
+		return img
+	}
+	start := time.Now()
+	gray := render.ToGray(img, options.Luma)
+	drawingTimings.Since("luma", start)
+	drawingStages.Record("luma", gray, map[string]interface{}{
+		"weights": []float64{options.Luma.R, options.Luma.G, options.Luma.B},
+		"linear":  options.Luma.Linear,
+	})
+	return gray
+}
+
+// enhanceFrame applies the image adjustments options ask for to a frame
+// scaled to the panel, before the panel converts it: auto-levels, then
+// sharpening
+func enhanceFrame(img *image.RGBA, options AppOptions) *image.RGBA {
+	if options.AutoLevels != "" {
+		start := time.Now()
+		img = render.AutoLevels(img, options.AutoLevels)
+		drawingTimings.Since("levels", start)
+		drawingStages.Record("levels", img, map[string]interface{}{"mode": options.AutoLevels})
+	}
+	if options.Sharpen > 0 {
+		start := time.Now()
+		img = render.Sharpen(img, options.Sharpen)
+		drawingTimings.Since("sharpen", start)
+		drawingStages.Record("sharpen", img, map[string]interface{}{"amount": options.Sharpen})
+	}
+	return img
+}
+
+// frameBuffers holds two frames to scale into, one after the other, so each
+// refresh reuses the memory of the one before last rather than allocating a
+// frame, while the last one drawn, in d.panelImage, stays as it was
+type frameBuffers struct {
+	frames [2]*image.RGBA
+	next   int
+}
+
+// scale scales img to fill r in the next frame, reallocating it only when
+// the size changed
+func (f *frameBuffers) scale(img image.Image, r image.Rectangle) *image.RGBA {
+	frame := f.frames[f.next]
+	if frame == nil || frame.Rect != r {
+		frame = image.NewRGBA(r)
+		f.frames[f.next] = frame
+	}
+	f.next = 1 - f.next
+	render.ScaleTo(frame, img)
+	return frame
+}
+
+// pacedDue returns when the next refresh after one finished at now may
+// start: at due, or later if the panel needs a rest in between, as the slow
+// colour panels do. A refresh requested by hand still goes ahead at once.
+func (d *Daemon) pacedDue(due, now time.Time) time.Time {
+	if p, ok := d.panel.(panel.PacedPanel); ok {
+		if earliest := now.Add(p.MinInterval()); due.Before(earliest) {
+			return earliest
+		}
+	}
+	return due
+}
+
+// validateSPI checks an SPI panel's wiring settings
+func validateSPI(config panel.SPIConfig) error {
+	if config.SpeedHz <= 0 || config.SpeedHz > panel.MaxSPISpeedHz {
+		return fmt.Errorf("SPI speed must be between 1 and %d Hz", panel.MaxSPISpeedHz)
+	}
+	// Large enough for the IT8951's preamble and a few words
+	if config.ChunkSize != 0 && config.ChunkSize < 16 {
+		return fmt.Errorf("SPI.ChunkSize must be at least 16 bytes, or 0 for spidev's buffer size")
+	}
+	return nil
+}
+
+// drawPanelFrame scales img to the panel and shows it, in the refresh mode
+// options select on panels that have more than one. Panel drivers always
+// get the whole frame; those with a partial mode work out what changed.
+func (d *Daemon) drawPanelFrame(img image.Image, options AppOptions) error {
+	start := time.Now()
+	scaledImg := d.scaledFrames.scale(img, d.panel.Bounds())
+	drawingTimings.Since("scale", start)
+	drawingStages.Record("scale", scaledImg, map[string]interface{}{
+		"from":   img.Bounds().String(),
+		"to":     d.panel.Bounds().String(),
+		"scaler": "nearest-neighbor",
+	})
+	scaledImg = enhanceFrame(scaledImg, options)
+	if p, ok := d.panel.(panel.ModePanel); ok {
+		p.SetMode(panel.Mode(options.RefreshMode))
+	}
+	start = time.Now()
+	if err := d.panel.Display(scaledImg); err != nil {
+		return fmt.Errorf("error drawing to panel: %v", err)
+	}
+	drawingTimings.Since("draw", start)
+	d.panelImage = scaledImg
+	mirrorFrame(scaledImg)
+	displayLog.Debug("Image drawing completed", "panel", options.Panel, "mode", options.RefreshMode)
+	return nil
+}
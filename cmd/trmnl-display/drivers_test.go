@@ -0,0 +1,237 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"trmnl-display/pkg/panel"
+)
+
+// fakePanel records the frames it is asked to show
+type fakePanel struct {
+	bounds image.Rectangle
+	frames []image.Image
+	closed bool
+}
+
+func (p *fakePanel) Bounds() image.Rectangle { return p.bounds }
+
+func (p *fakePanel) Display(img image.Image) error {
+	p.frames = append(p.frames, img)
+	return nil
+}
+
+func (p *fakePanel) Clear() error {
+	return p.Display(image.NewUniform(color.White))
+}
+
+func (p *fakePanel) Close() { p.closed = true }
+
+// panelDaemon returns a daemon drawing on p
+func panelDaemon(p panel.Panel) *Daemon {
+	d := newDaemon()
+	d.panel = p
+	return d
+}
+
+func TestDrawFrameUsesPanel(t *testing.T) {
+	panel := &fakePanel{bounds: image.Rect(0, 0, 400, 240)}
+	d := panelDaemon(panel)
+
+	img := image.NewGray(image.Rect(0, 0, 800, 480))
+	img.SetGray(799, 479, color.Gray{Y: 255})
+	if err := d.drawFrame(img, AppOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(panel.frames) != 1 {
+		t.Fatalf("panel showed %d frames, want 1", len(panel.frames))
+	}
+	shown := panel.frames[0]
+	if shown.Bounds() != panel.bounds {
+		t.Errorf("frame bounds = %v, want the panel's %v", shown.Bounds(), panel.bounds)
+	}
+	if got := color.GrayModel.Convert(shown.At(399, 239)).(color.Gray).Y; got != 255 {
+		t.Errorf("bottom-right pixel = %d, want 255 after scaling", got)
+	}
+}
+
+func TestControlServesDaemonPanelImage(t *testing.T) {
+	// Each control server shows what its own daemon drew
+	small := panelDaemon(&fakePanel{bounds: image.Rect(0, 0, 400, 240)})
+	large := panelDaemon(&fakePanel{bounds: image.Rect(0, 0, 800, 480)})
+	for _, d := range []*Daemon{small, large} {
+		if err := d.drawFrame(image.NewGray(image.Rect(0, 0, 800, 480)), AppOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, d := range []*Daemon{small, large} {
+		w := httptest.NewRecorder()
+		NewControlServer(d, "", t.TempDir(), Config{}, AppOptions{}).handlePanelImage(w, httptest.NewRequest("GET", "/frame.png", nil))
+		img, err := png.Decode(w.Body)
+		if err != nil {
+			t.Fatalf("GET /frame.png: %v", err)
+		}
+		if want := d.panel.Bounds(); img.Bounds() != want {
+			t.Errorf("panel image bounds = %v, want %v", img.Bounds(), want)
+		}
+	}
+	w := httptest.NewRecorder()
+	NewControlServer(newDaemon(), "", t.TempDir(), Config{}, AppOptions{}).handlePanelImage(w, httptest.NewRequest("GET", "/frame.png", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("GET /frame.png before drawing = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestDrawFrameMirrors(t *testing.T) {
+	d := panelDaemon(&fakePanel{bounds: image.Rect(0, 0, 800, 480)})
+	mirror1 := &fakePanel{bounds: image.Rect(0, 0, 800, 480)}
+	mirror2 := &fakePanel{bounds: image.Rect(0, 0, 200, 120)}
+	mirrors = []mirror{{name: "one", out: mirror1}, {name: "two", out: mirror2}}
+	t.Cleanup(func() { mirrors = nil })
+
+	if err := d.drawFrame(image.NewGray(image.Rect(0, 0, 800, 480)), AppOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []*fakePanel{mirror1, mirror2} {
+		if len(m.frames) != 1 {
+			t.Fatalf("mirror showed %d frames, want 1", len(m.frames))
+		}
+		if got := m.frames[0].Bounds(); got != m.bounds {
+			t.Errorf("mirror frame bounds = %v, want %v", got, m.bounds)
+		}
+	}
+	closeMirrors()
+	if !mirror1.closed || !mirror2.closed || mirrors != nil {
+		t.Errorf("closeMirrors left mirrors open")
+	}
+}
+
+func TestValidateMirror(t *testing.T) {
+	for _, tt := range []struct {
+		mirror, panel string
+		valid         bool
+	}{
+		{"file:/tmp/frame.png", panelWaveshare7in5V2, true},
+		{"preview", panelFramebuffer, true},
+		{"term:blocks", "", true},
+		{panelFramebuffer, panelWaveshare7in5V2, true},
+		{panelFramebuffer, panelFramebuffer, false},
+		{panelFramebuffer, "", false},
+		{"drm", panelWaveshare7in3E, true},
+		{"drm", panelFramebuffer, false},
+		{panelFramebuffer, "drm:/dev/dri/card1", false},
+		{"file:/tmp/frame.png", "file:/tmp/frame.png", false},
+		{"file:", panelFramebuffer, false},
+		{panelWaveshare7in5V2, panelFramebuffer, false},
+	} {
+		if err := validateMirror(tt.mirror, tt.panel); (err == nil) != tt.valid {
+			t.Errorf("validateMirror(%q, %q) = %v, want valid %v", tt.mirror, tt.panel, err, tt.valid)
+		}
+	}
+}
+
+func TestClosePanel(t *testing.T) {
+	panel := &fakePanel{}
+	d := panelDaemon(panel)
+	d.closePanel()
+	if !panel.closed || d.panel != nil {
+		t.Errorf("closePanel left the panel open")
+	}
+}
+
+func TestValidatePanel(t *testing.T) {
+	for panel, valid := range map[string]bool{
+		panelFramebuffer:      true,
+		panelWaveshare7in5V2:  true,
+		panelWaveshare7in5B:   true,
+		panelIT8951:           true,
+		panelWaveshare7in3F:   true,
+		panelWaveshare7in3E:   true,
+		"waveshare-7in3":      false,
+		"file:/tmp/frame.png": true,
+		"file:":               false,
+		"drm":                 true,
+		"drm:/dev/dri/card1":  true,
+		"preview":             true,
+		"preview:0.0.0.0:80":  true,
+		"term":                true,
+		"term:sixel":          true,
+		"term:kitty":          false,
+		"inky":                false,
+	} {
+		if err := validatePanel(panel); (err == nil) != valid {
+			t.Errorf("validatePanel(%q) = %v, want valid %v", panel, err, valid)
+		}
+	}
+}
+
+// pacedPanel is a fakePanel that needs a rest between refreshes
+type pacedPanel struct {
+	fakePanel
+}
+
+func (p *pacedPanel) MinInterval() time.Duration { return 3 * time.Minute }
+
+func TestPacedDue(t *testing.T) {
+	now := time.Now()
+	due := now.Add(time.Minute)
+	if got := panelDaemon(&fakePanel{}).pacedDue(due, now); !got.Equal(due) {
+		t.Errorf("pacedDue without a rest = %v, want %v", got, due)
+	}
+	d := panelDaemon(&pacedPanel{})
+	if got := d.pacedDue(due, now); !got.Equal(now.Add(3 * time.Minute)) {
+		t.Errorf("pacedDue = %v, want 3m after the refresh", got.Sub(now))
+	}
+	if later := now.Add(time.Hour); !d.pacedDue(later, now).Equal(later) {
+		t.Errorf("pacedDue moved a refresh already due after the rest")
+	}
+}
+
+func TestDetectPanel(t *testing.T) {
+	for _, tt := range []struct{ product, want string }{
+		{"", panelWaveshare7in5V2},
+		{"7.5inch e-Paper HAT (B)", panelWaveshare7in5B},
+		{"7.5inch e-Paper HAT", panelWaveshare7in5V2},
+		{"7.3inch e-Paper HAT (E)", panelWaveshare7in3E},
+		{"e-Paper IT8951 Driver HAT", panelIT8951},
+		{"Inky Impression 7.3", panelWaveshare7in5V2},
+	} {
+		got, detected := detectPanel(tt.product, "Waveshare")
+		if got != tt.want || detected == "" {
+			t.Errorf("detectPanel(%q) = %q (%q), want %q", tt.product, got, detected, tt.want)
+		}
+	}
+}
+
+func TestFramebufferSleepDefault(t *testing.T) {
+	// Monitors go dark while blanked, so the framebuffer is only put to
+	// sleep when asked for
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	saved := configFileFlag
+	t.Cleanup(func() { configFileFlag = saved })
+
+	options, _, err := parseOptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if power := framebufferPower(options); power != nil {
+		t.Errorf("framebuffer power control created by default for %s", power.Device)
+	}
+	if !options.PanelSleep {
+		t.Error("SPI panels should still deep sleep by default")
+	}
+
+	options, _, err = parseOptions([]string{"-framebuffer-sleep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if framebufferPower(options) == nil {
+		t.Error("no framebuffer power control with -framebuffer-sleep")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// Gallery keeps the last Size frames shown, as PNGs in Dir, for the gallery
+// page on the control API
+type Gallery struct {
+	Dir  string
+	Size int
+
+	mu sync.Mutex
+}
+
+// Global gallery, nil when -gallery is not set
+var gallery *Gallery
+
+// NewGallery creates a gallery of size frames in dir
+func NewGallery(dir string, size int) (*Gallery, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating gallery directory: %v", err)
+	}
+	return &Gallery{Dir: dir, Size: size}, nil
+}
+
+// Add saves a frame, scaled as the archive scales it, and drops the oldest
+// frames beyond Size. Failures are logged but never interrupt the display
+// loop.
+func (g *Gallery) Add(img image.Image, t time.Time) {
+	frame := render.Scale(img, image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight))
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, err := saveFrame(g.Dir, frame, t); err != nil {
+		displayLog.Warn("Error saving frame to the gallery", "err", err)
+		return
+	}
+	if err := pruneFrames(g.Dir, g.Size, 0, 0); err != nil {
+		displayLog.Warn("Error pruning gallery", "err", err)
+	}
+}
+
+// List returns the frames, newest first
+func (g *Gallery) List() ([]ArchivedFrame, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return listFrames(g.Dir)
+}
+
+// handleGalleryPage serves the gallery page, which like the web UI uses the
+// token stored when the browser was paired
+func (s *ControlServer) handleGalleryPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, galleryPage)
+}
+
+// handleListGallery lists the frames in the gallery
+func (s *ControlServer) handleListGallery(w http.ResponseWriter, r *http.Request) {
+	frames, err := gallery.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, frames)
+}
+
+// handleGalleryFrame serves one frame from the gallery
+func (s *ControlServer) handleGalleryFrame(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !frameFileName.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "max-age=86400, immutable")
+	http.ServeFile(w, r, filepath.Join(gallery.Dir, name))
+}
+
+// galleryPage shows the frames in the gallery, newest first
+const galleryPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>TRMNL Display gallery</title>
+<style>
+body { font-family: sans-serif; max-width: 72em; margin: 2em auto; padding: 0 1em }
+#frames { display: grid; grid-template-columns: repeat(auto-fill, minmax(16em, 1fr)); gap: 1em }
+figure { margin: 0 }
+img { width: 100%; border: 1px solid #888; background: #eee }
+figcaption { font-size: 0.9em; color: #555 }
+</style>
+</head>
+<body>
+<h1>Recent frames</h1>
+<p id="message"></p>
+<div id="frames"></div>
+<script>
+const token = localStorage.getItem("trmnl-token");
+const $ = id => document.getElementById(id);
+const api = path => fetch(path, {headers: {Authorization: "Bearer " + token}})
+  .then(r => r.ok ? r : r.text().then(t => Promise.reject(t || r.status)));
+
+if (!token) {
+  $("message").textContent = "Pair this browser first: pick “Pair phone” in the settings menu, or start with -pair, and scan the code.";
+} else {
+  api("/api/gallery").then(r => r.json()).then(frames => {
+    if (!frames.length) $("message").textContent = "No frames yet.";
+    for (const f of frames) {
+      const figure = document.createElement("figure");
+      const img = document.createElement("img");
+      const caption = document.createElement("figcaption");
+      img.alt = f.name;
+      caption.textContent = new Date(f.time).toLocaleString();
+      figure.append(img, caption);
+      $("frames").append(figure);
+      api("/api/gallery/" + f.name).then(r => r.blob()).then(b => { img.src = URL.createObjectURL(b); });
+    }
+  }).catch(err => { $("message").textContent = err; });
+}
+</script>
+</body>
+</html>
+`
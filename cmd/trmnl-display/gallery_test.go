@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGalleryKeepsNewest(t *testing.T) {
+	g, err := NewGallery(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files that are not gallery frames are left alone
+	os.WriteFile(filepath.Join(g.Dir, "notes.txt"), nil, 0644)
+
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.Local)
+	for i := 0; i < 3; i++ {
+		g.Add(image.NewGray(image.Rect(0, 0, 8, 8)), start.Add(time.Duration(i)*time.Minute))
+	}
+	frames, err := g.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || !frames[0].Time.Equal(start.Add(2*time.Minute)) || !frames[1].Time.Equal(start.Add(time.Minute)) {
+		t.Errorf("List = %v, want the two newest frames, newest first", frames)
+	}
+	if _, err := os.Stat(filepath.Join(g.Dir, "notes.txt")); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
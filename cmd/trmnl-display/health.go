@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Health tracks how the display loop is doing, for the /healthz endpoint
+type Health struct {
+	mu           sync.Mutex
+	lastFetch    time.Time
+	lastDisplay  time.Time
+	fetchError   string
+	displayError string
+	errorSince   time.Time
+	configSum    string
+	nextRefresh  time.Time
+	recent       []HealthError
+}
+
+// How many recent errors Health keeps for the web UI
+const recentErrorCount = 10
+
+// HealthError is a failed fetch or display
+type HealthError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// Global health state, updated by the display loop
+var health = &Health{}
+
+// HealthReport is the body of a /healthz response
+type HealthReport struct {
+	Status         string     `json:"status"` // "ok" or "error"
+	LastFetch      *time.Time `json:"last_fetch,omitempty"`
+	LastDisplay    *time.Time `json:"last_display,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	ErrorSince     *time.Time `json:"error_since,omitempty"`
+	ConfigChecksum string     `json:"config_checksum"`
+}
+
+// Fetched records the result of preparing a frame
+func (h *Health) Fetched(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.setError(&h.fetchError, err.Error())
+		return
+	}
+	h.lastFetch = time.Now()
+	h.setError(&h.fetchError, "")
+}
+
+// Displayed records the result of drawing a frame
+func (h *Health) Displayed(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.setError(&h.displayError, err.Error())
+		return
+	}
+	h.lastDisplay = time.Now()
+	h.setError(&h.displayError, "")
+}
+
+// setError updates one of the error states, noting when the frame went from
+// healthy to failing
+func (h *Health) setError(field *string, msg string) {
+	failing := h.fetchError != "" || h.displayError != ""
+	*field = msg
+	if msg != "" {
+		h.recent = append(h.recent, HealthError{Time: time.Now(), Error: msg})
+		if len(h.recent) > recentErrorCount {
+			h.recent = h.recent[1:]
+		}
+	}
+	switch {
+	case h.fetchError == "" && h.displayError == "":
+		h.errorSince = time.Time{}
+	case !failing:
+		h.errorSince = time.Now()
+	}
+}
+
+// Scheduled records when the next refresh is due
+func (h *Health) Scheduled(due time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextRefresh = due
+}
+
+// NextRefresh returns when the next refresh is due, or the zero time if
+// none is scheduled yet
+func (h *Health) NextRefresh() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nextRefresh
+}
+
+// RecentErrors returns the last few errors, newest first
+func (h *Health) RecentErrors() []HealthError {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	errs := make([]HealthError, len(h.recent))
+	for i, e := range h.recent {
+		errs[len(h.recent)-1-i] = e
+	}
+	return errs
+}
+
+// SetConfig records a checksum of the settings in use, so monitors can tell
+// when a device is running a different config than expected
+func (h *Health) SetConfig(config Config) {
+	data, _ := json.Marshal(config)
+	sum := sha256.Sum256(data)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.configSum = hex.EncodeToString(sum[:8])
+}
+
+// Report returns the current health
+func (h *Health) Report() HealthReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	timeOrNil := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	report := HealthReport{
+		Status:         "ok",
+		LastFetch:      timeOrNil(h.lastFetch),
+		LastDisplay:    timeOrNil(h.lastDisplay),
+		ErrorSince:     timeOrNil(h.errorSince),
+		ConfigChecksum: h.configSum,
+	}
+	// A display error is the more serious: the panel is not being updated
+	if report.Error = h.displayError; report.Error == "" {
+		report.Error = h.fetchError
+	}
+	if report.Error != "" {
+		report.Status = "error"
+	}
+	return report
+}
+
+// handleHealthz reports the frame's health. It needs no token, so uptime
+// monitors and probes can use it; failing frames answer 503.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	report := health.Report()
+	if report.Status != "ok" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+	writeJSON(w, report)
+}
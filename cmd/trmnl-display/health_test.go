@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHealthReport(t *testing.T) {
+	h := &Health{}
+	h.SetConfig(Config{BaseURL: "https://example.com"})
+	if r := h.Report(); r.Status != "ok" || r.LastFetch != nil || r.ConfigChecksum == "" {
+		t.Errorf("new health = %+v, want ok with a checksum and no fetch", r)
+	}
+
+	h.Fetched(nil)
+	h.Displayed(errors.New("panel busy"))
+	r := h.Report()
+	if r.Status != "error" || r.Error != "panel busy" || r.LastFetch == nil || r.ErrorSince == nil {
+		t.Errorf("after a display error = %+v, want the error since now", r)
+	}
+	since := *r.ErrorSince
+
+	// A fetch error while already failing keeps the time failing started,
+	// and the display error is the one reported
+	h.Fetched(errors.New("offline"))
+	if r := h.Report(); r.Error != "panel busy" || !r.ErrorSince.Equal(since) {
+		t.Errorf("after a fetch error = %+v, want panel busy since %v", r, since)
+	}
+
+	h.Displayed(nil)
+	if r := h.Report(); r.Error != "offline" {
+		t.Errorf("after displaying = %+v, want the fetch error left", r)
+	}
+	h.Fetched(nil)
+	if r := h.Report(); r.Status != "ok" || r.ErrorSince != nil || r.LastDisplay == nil {
+		t.Errorf("after recovering = %+v, want ok", r)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"trmnl-display/pkg/history"
+)
+
+// runExport implements the export subcommand, writing the refresh history as CSV
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fromStr := fs.String("from", "", "Start date or time (YYYY-MM-DD or RFC 3339, default: everything)")
+	toStr := fs.String("to", "", "End date or time (YYYY-MM-DD or RFC 3339, default: now)")
+	output := fs.String("o", "", "Write CSV to this file instead of standard output")
+	fs.Parse(args)
+
+	from, err := parseExportTime(*fromStr, false)
+	if err != nil {
+		fmt.Printf("Error parsing -from: %v\n", err)
+		os.Exit(1)
+	}
+	to, err := parseExportTime(*toStr, true)
+	if err != nil {
+		fmt.Printf("Error parsing -to: %v\n", err)
+		os.Exit(1)
+	}
+
+	stateDir, err := stateDirectory("")
+	if err != nil {
+		fmt.Printf("Error setting up state directory: %v\n", err)
+		os.Exit(1)
+	}
+	records, err := history.New(stateDir).Read(from, to)
+	if err != nil {
+		fmt.Printf("Error reading history: %v\n", err)
+		os.Exit(1)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		w, err = os.Create(*output)
+		if err != nil {
+			fmt.Printf("Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+	}
+	if err := history.WriteCSV(w, records); err != nil {
+		fmt.Printf("Error writing CSV: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// parseExportTime parses a date or RFC 3339 time. Empty values mean the
+// beginning of time, or now for the end of the range; a bare end date covers
+// that whole day.
+func parseExportTime(s string, end bool) (time.Time, error) {
+	if s == "" {
+		if end {
+			return time.Now(), nil
+		}
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected YYYY-MM-DD or RFC 3339", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
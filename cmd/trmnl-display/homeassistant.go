@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"regexp"
+	"strings"
+
+	"trmnl-display/pkg/mqtt"
+)
+
+// Default Home Assistant discovery prefix
+const defaultMQTTDiscovery = "homeassistant"
+
+// haEntity is one entity in a Home Assistant MQTT discovery message
+type haEntity struct {
+	Component string // button, switch, sensor, camera
+	ObjectID  string
+	Config    map[string]any
+}
+
+// haNodeID makes a discovery node ID from the host name
+func haNodeID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "frame"
+	}
+	id := regexp.MustCompile(`[^a-z0-9_-]+`).ReplaceAllString(strings.ToLower(hostname), "_")
+	return "trmnl_" + id
+}
+
+// haEntities lists the entities the frame appears with in Home Assistant,
+// all driven by the bridge's own topics
+func haEntities(topic, nodeID string, battery bool) []haEntity {
+	hostname, _ := os.Hostname()
+	device := map[string]any{
+		"identifiers":  []string{nodeID},
+		"name":         strings.TrimSpace("TRMNL " + hostname),
+		"manufacturer": "TRMNL",
+		"model":        "trmnl-display",
+		"sw_version":   version,
+	}
+	common := func(name, objectID string) map[string]any {
+		return map[string]any{
+			"name":                  name,
+			"unique_id":             nodeID + "_" + objectID,
+			"device":                device,
+			"availability_topic":    topic + "/status",
+			"payload_available":     "online",
+			"payload_not_available": "offline",
+		}
+	}
+	with := func(config map[string]any, extra map[string]any) map[string]any {
+		for k, v := range extra {
+			config[k] = v
+		}
+		return config
+	}
+
+	entities := []haEntity{
+		{"button", "refresh", with(common("Refresh", "refresh"), map[string]any{
+			"command_topic": topic + "/command",
+			"payload_press": "refresh",
+			"icon":          "mdi:refresh",
+		})},
+		{"switch", "pause", with(common("Pause", "pause"), map[string]any{
+			"command_topic":  topic + "/command",
+			"payload_on":     "pause",
+			"payload_off":    "resume",
+			"state_topic":    topic + "/state",
+			"value_template": "{{ 'pause' if value_json.paused else 'resume' }}",
+			"state_on":       "pause",
+			"state_off":      "resume",
+			"icon":           "mdi:pause",
+		})},
+		{"switch", "dark_mode", with(common("Dark mode", "dark_mode"), map[string]any{
+			"command_topic":  topic + "/command",
+			"payload_on":     "dark-mode on",
+			"payload_off":    "dark-mode off",
+			"state_topic":    topic + "/state",
+			"value_template": "{{ 'dark-mode on' if value_json.dark_mode else 'dark-mode off' }}",
+			"state_on":       "dark-mode on",
+			"state_off":      "dark-mode off",
+			"icon":           "mdi:theme-light-dark",
+		})},
+		{"sensor", "last_refresh", with(common("Last refresh", "last_refresh"), map[string]any{
+			"state_topic":    topic + "/state",
+			"value_template": "{{ value_json.last_refresh }}",
+			"device_class":   "timestamp",
+		})},
+		{"sensor", "screen", with(common("Screen", "screen"), map[string]any{
+			"state_topic":    topic + "/state",
+			"value_template": "{{ value_json.screen }}",
+			"icon":           "mdi:monitor",
+		})},
+		{"camera", "frame", with(common("Frame", "frame"), map[string]any{
+			"topic": topic + "/frame",
+		})},
+	}
+	if battery {
+		entities = append(entities, haEntity{"sensor", "battery", with(common("Battery", "battery"), map[string]any{
+			"state_topic":         topic + "/state",
+			"value_template":      "{{ value_json.battery }}",
+			"device_class":        "battery",
+			"unit_of_measurement": "%",
+			"state_class":         "measurement",
+		})})
+	}
+	return entities
+}
+
+// publishDiscovery announces the frame's entities to Home Assistant under
+// the discovery prefix
+func publishDiscovery(client *mqtt.Client, prefix, topic string) error {
+	nodeID := haNodeID()
+	_, battery := readBatteryPercent()
+	for _, e := range haEntities(topic, nodeID, battery) {
+		data, err := json.Marshal(e.Config)
+		if err != nil {
+			return err
+		}
+		msg := mqtt.Message{
+			Topic:   prefix + "/" + e.Component + "/" + nodeID + "/" + e.ObjectID + "/config",
+			Payload: data,
+			Retain:  true,
+		}
+		if err := client.Publish(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
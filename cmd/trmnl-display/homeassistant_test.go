@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestHAEntities(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, e := range haEntities("trmnl", "trmnl_frame", false) {
+		id := e.Config["unique_id"].(string)
+		if seen[id] {
+			t.Errorf("duplicate unique_id %q", id)
+		}
+		seen[id] = true
+		if e.Config["availability_topic"] != "trmnl/status" {
+			t.Errorf("%s availability_topic = %v", id, e.Config["availability_topic"])
+		}
+		if e.ObjectID == "battery" {
+			t.Error("battery sensor announced without a battery")
+		}
+	}
+	if !seen["trmnl_frame_refresh"] || !seen["trmnl_frame_frame"] {
+		t.Errorf("entities = %v, want the refresh button and frame camera", seen)
+	}
+
+	withBattery := haEntities("trmnl", "trmnl_frame", true)
+	if last := withBattery[len(withBattery)-1]; last.ObjectID != "battery" {
+		t.Errorf("last entity = %s, want the battery sensor", last.ObjectID)
+	}
+}
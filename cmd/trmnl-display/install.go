@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Name of the installed systemd unit
+const serviceName = "trmnl-display.service"
+
+// runInstall implements the install subcommand, which writes a systemd unit
+// for the current binary and config, enables it, and checks that the service
+// will be able to reach the display hardware. Arguments after the flags are
+// passed on to the service, e.g. `install -- -q -control-addr :8080`.
+func runInstall(args []string) {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	userUnit := fs.Bool("user", false, "Install a user service instead of a system service")
+	printOnly := fs.Bool("print", false, "Print the unit file instead of installing it")
+	fs.Parse(args)
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Printf("Error finding the trmnl-display binary: %v\n", err)
+		os.Exit(1)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		fmt.Printf("Error getting home directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	unit := serviceUnit(exe, home, fs.Args(), *userUnit)
+	if *printOnly {
+		fmt.Print(unit)
+		return
+	}
+
+	unitDir := "/etc/systemd/system"
+	if *userUnit {
+		unitDir = filepath.Join(home, ".config", "systemd", "user")
+	} else if os.Geteuid() != 0 {
+		fmt.Println("Installing a system service requires root privileges.")
+		fmt.Println("Please run with sudo, or use -user for a user service.")
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(unitDir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %v\n", unitDir, err)
+		os.Exit(1)
+	}
+	unitPath := filepath.Join(unitDir, serviceName)
+	if err := os.WriteFile(unitPath, []byte(unit), 0644); err != nil {
+		fmt.Printf("Error writing %s: %v\n", unitPath, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", unitPath)
+
+	problems := checkDeviceAccess(*userUnit)
+
+	systemctl := []string{"systemctl"}
+	if *userUnit {
+		systemctl = append(systemctl, "--user")
+	}
+	for _, step := range [][]string{{"daemon-reload"}, {"enable", "--now", serviceName}} {
+		cmd := exec.Command(systemctl[0], append(systemctl[1:], step...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Printf("Error running %s: %v\n", strings.Join(cmd.Args, " "), err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Enabled and started %s\n", serviceName)
+
+	if *userUnit && !containsArg(fs.Args(), "-headless") {
+		fmt.Println("Warning: drawing to the framebuffer requires root; without -headless a user service will exit at startup.")
+	}
+	if *userUnit {
+		fmt.Println("To start it at boot without logging in, run: sudo loginctl enable-linger " + currentUsername())
+	}
+	if problems > 0 {
+		fmt.Println("The service may not be able to drive the display until the problems above are fixed.")
+		os.Exit(1)
+	}
+}
+
+// serviceUnit renders the systemd unit. HOME is set so the service reads
+// the same config directory as the user who installed it.
+func serviceUnit(exe, home string, args []string, userUnit bool) string {
+	execStart := []string{systemdQuote(exe)}
+	for _, arg := range args {
+		execStart = append(execStart, systemdQuote(arg))
+	}
+
+	var b strings.Builder
+	b.WriteString("[Unit]\n")
+	b.WriteString("Description=TRMNL Display\n")
+	if !userUnit {
+		// network-online.target is only available to system services
+		b.WriteString("After=network-online.target\n")
+		b.WriteString("Wants=network-online.target\n")
+	}
+	b.WriteString("\n[Service]\n")
+	b.WriteString("Type=notify\n")
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(execStart, " "))
+	fmt.Fprintf(&b, "Environment=HOME=%s\n", systemdQuote(home))
+	b.WriteString("WatchdogSec=120\n")
+	b.WriteString("Restart=on-failure\n")
+	b.WriteString("\n[Install]\n")
+	if userUnit {
+		b.WriteString("WantedBy=default.target\n")
+	} else {
+		b.WriteString("WantedBy=multi-user.target\n")
+	}
+	return b.String()
+}
+
+// containsArg reports whether flag was passed, in either -flag or --flag form
+func containsArg(args []string, flag string) bool {
+	for _, arg := range args {
+		if arg == flag || arg == "-"+flag || strings.HasPrefix(arg, flag+"=") || strings.HasPrefix(arg, "-"+flag+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// systemdQuote quotes a unit file argument when it contains spaces or quotes
+func systemdQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// checkDeviceAccess reports whether the service user can open the SPI,
+// GPIO and framebuffer devices, suggesting how to fix it where not, and
+// returns the number of problems found. System services run as root, so
+// only the presence of SPI is checked for them.
+func checkDeviceAccess(userUnit bool) int {
+	problems := 0
+	spi, _ := filepath.Glob("/dev/spidev*")
+	if len(spi) == 0 {
+		fmt.Println("Warning: no SPI devices found. If your panel is driven over SPI, enable it with")
+		fmt.Println("  `sudo raspi-config nonint do_spi 0` or `dtparam=spi=on` in config.txt, then reboot.")
+	}
+	if !userUnit {
+		return problems
+	}
+
+	gpio, _ := filepath.Glob("/dev/gpiochip*")
+	devices := append(append(spi, gpio...), "/dev/fb0")
+	for _, device := range devices {
+		if _, err := os.Stat(device); err != nil {
+			continue
+		}
+		if err := syscall.Access(device, 0x6); err == nil { // R_OK|W_OK
+			fmt.Printf("Access to %s ✓\n", device)
+			continue
+		}
+		problems++
+		group := deviceGroup(device)
+		if group == "" {
+			fmt.Printf("Error: %s is not readable and writable by %s\n", device, currentUsername())
+			continue
+		}
+		fmt.Printf("Error: %s is not readable and writable by %s. Add your user to the %s group with\n", device, currentUsername(), group)
+		fmt.Printf("  `sudo usermod -aG %s %s` and log in again.\n", group, currentUsername())
+	}
+	return problems
+}
+
+// deviceGroup returns the name of the group that owns path, or "" if it is
+// owned by root's group
+func deviceGroup(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Gid == 0 {
+		return ""
+	}
+	group, err := user.LookupGroupId(fmt.Sprint(stat.Gid))
+	if err != nil {
+		return ""
+	}
+	return group.Name
+}
+
+// currentUsername returns the name of the user running the command
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"trmnl-display/pkg/render"
+)
+
+// Width of the hysteresis band around the auto-invert threshold. A source
+// starts being inverted once its dark fraction rises above the threshold and
+// stops only when it falls below threshold minus this band.
+const autoInvertHysteresis = 0.15
+
+// autoInverted remembers, per screen, whether its frames are currently being
+// inverted so the decision only changes when the content clearly does
+var autoInverted = make(map[string]bool)
+
+// applyAutoInvert inverts predominantly dark frames from screens listed in
+// -auto-invert, so dark-themed plugins come out as dark text on white
+func applyAutoInvert(screen string, img image.Image, options AppOptions) image.Image {
+	if !options.AutoInvert[screen] && !options.AutoInvert["all"] {
+		return img
+	}
+
+	dark := render.DarkFraction(img)
+	wasInverted := autoInverted[screen]
+	invert := wasInverted
+	if dark > options.AutoInvertThreshold {
+		invert = true
+	} else if dark < options.AutoInvertThreshold-autoInvertHysteresis {
+		invert = false
+	}
+	autoInverted[screen] = invert
+
+	if invert != wasInverted {
+		displayLog.Debug("Auto-invert turned "+onOff(invert), "screen", screen, "dark", fmt.Sprintf("%.0f%%", dark*100))
+	}
+	if !invert {
+		return img
+	}
+	return render.Invert(img)
+}
+
+// applyDarkMode inverts img when dark mode is on, whatever the format it was
+// decoded from or whether it was rendered on the device
+func applyDarkMode(img image.Image, options AppOptions) image.Image {
+	if !options.DarkMode {
+		return img
+	}
+	return render.Invert(img)
+}
+
+// onOff formats a boolean for log messages
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
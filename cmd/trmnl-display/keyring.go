@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Where the API key is kept, chosen with the KeyStorage setting
+const (
+	keyStorageFile          = "file"           // in config.json (the default)
+	keyStorageSecretService = "secret-service" // the desktop keyring, via secret-tool
+	keyStorageKeyctl        = "keyctl"         // the kernel's persistent keyring, via keyctl
+)
+
+// Keyring entries are stored under this service name
+const keyringService = "trmnl-display"
+
+// validateKeyStorage checks a KeyStorage setting
+func validateKeyStorage(storage string) error {
+	switch storage {
+	case "", keyStorageFile, keyStorageSecretService, keyStorageKeyctl:
+		return nil
+	}
+	return fmt.Errorf("unknown key storage %q, expected %s, %s, or %s",
+		storage, keyStorageFile, keyStorageSecretService, keyStorageKeyctl)
+}
+
+// usesKeyring reports whether storage keeps the API key outside the config file
+func usesKeyring(storage string) bool {
+	return storage != "" && storage != keyStorageFile
+}
+
+// apiKeyName names the keyring entry for a profile's API key
+func apiKeyName(profile string) string {
+	if profile == "" {
+		return "api-key"
+	}
+	return "api-key:" + profile
+}
+
+// keyringGet returns a secret from the keyring, or "" if it is not there
+func keyringGet(storage, name string) (string, error) {
+	switch storage {
+	case keyStorageSecretService:
+		out, err := exec.Command("secret-tool", "lookup", "service", keyringService, "account", name).Output()
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) == 0 {
+			return "", nil // secret-tool exits with 1 and no message when nothing matches
+		}
+		if err != nil {
+			return "", keyringError("secret-tool", err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	case keyStorageKeyctl:
+		keyring, err := persistentKeyring()
+		if err != nil {
+			return "", err
+		}
+		id, err := exec.Command("keyctl", "search", keyring, "user", keyringService+":"+name).Output()
+		if err != nil {
+			return "", nil
+		}
+		out, err := exec.Command("keyctl", "pipe", strings.TrimSpace(string(id))).Output()
+		if err != nil {
+			return "", keyringError("keyctl", err)
+		}
+		return string(out), nil
+	}
+	return "", nil
+}
+
+// keyringSet stores a secret in the keyring, replacing any previous value
+func keyringSet(storage, name, secret string) error {
+	var cmd *exec.Cmd
+	switch storage {
+	case keyStorageSecretService:
+		cmd = exec.Command("secret-tool", "store", "--label=TRMNL API key",
+			"service", keyringService, "account", name)
+	case keyStorageKeyctl:
+		keyring, err := persistentKeyring()
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("keyctl", "padd", "user", keyringService+":"+name, keyring)
+	default:
+		return fmt.Errorf("key storage %q is not a keyring", storage)
+	}
+	cmd.Stdin = strings.NewReader(secret)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return keyringError(cmd.Args[0], fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
+
+// keyringDelete removes a secret from the keyring if it is there
+func keyringDelete(storage, name string) error {
+	switch storage {
+	case keyStorageSecretService:
+		if err := exec.Command("secret-tool", "clear", "service", keyringService, "account", name).Run(); err != nil {
+			return keyringError("secret-tool", err)
+		}
+	case keyStorageKeyctl:
+		keyring, err := persistentKeyring()
+		if err != nil {
+			return err
+		}
+		id, err := exec.Command("keyctl", "search", keyring, "user", keyringService+":"+name).Output()
+		if err != nil {
+			return nil
+		}
+		if err := exec.Command("keyctl", "unlink", strings.TrimSpace(string(id)), keyring).Run(); err != nil {
+			return keyringError("keyctl", err)
+		}
+	}
+	return nil
+}
+
+// persistentKeyring returns the ID of the user's persistent kernel keyring,
+// which outlives login sessions (but not reboots)
+func persistentKeyring() (string, error) {
+	out, err := exec.Command("keyctl", "get_persistent", "@u").Output()
+	if err != nil {
+		return "", keyringError("keyctl", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keyringError explains a failed keyring command, pointing out when the
+// tool is not installed
+func keyringError(tool string, err error) error {
+	if _, lookErr := exec.LookPath(tool); lookErr != nil {
+		return fmt.Errorf("error using the keyring: %s is not installed", tool)
+	}
+	return fmt.Errorf("error using the keyring: %s: %v", tool, err)
+}
+
+// saveAPIKey stores the API key for config's profile where its KeyStorage
+// setting says: in the keyring, or in the config file
+func saveAPIKey(configDir string, config Config, key string) error {
+	if usesKeyring(config.KeyStorage) {
+		return keyringSet(config.KeyStorage, apiKeyName(config.Profile), key)
+	}
+	return updateConfigFile(configDir, func(c *Config) {
+		if config.Profile == "" {
+			c.APIKey = key
+			return
+		}
+		profile := c.Profiles[config.Profile]
+		profile.APIKey = key
+		c.Profiles[config.Profile] = profile
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/sensor"
+)
+
+// How often the light sensor is read
+const lightPollInterval = 30 * time.Second
+
+// LightLevel follows an ambient light sensor to tell whether the room is
+// dim, with hysteresis so a level near the threshold does not flip it back
+// and forth
+type LightLevel struct {
+	mu         sync.Mutex
+	known      bool // a reading has been taken
+	dim        bool
+	threshold  float64
+	hysteresis float64
+}
+
+// Global light level, nil when no light sensor is configured
+var light *LightLevel
+
+// NewLightLevel creates a light level that turns dim below threshold lux and
+// bright again above threshold plus hysteresis
+func NewLightLevel(threshold, hysteresis float64) *LightLevel {
+	return &LightLevel{threshold: threshold, hysteresis: hysteresis}
+}
+
+// Update records a reading, and reports whether the room turned dim or
+// bright
+func (l *LightLevel) Update(lux float64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dim := l.dim
+	switch {
+	case !l.known:
+		dim = lux < l.threshold
+	case l.dim:
+		dim = lux <= l.threshold+l.hysteresis
+	default:
+		dim = lux < l.threshold
+	}
+	changed := l.known && dim != l.dim
+	l.known, l.dim = true, dim
+	return changed
+}
+
+// Dim reports whether the room is dim, and whether that is known yet
+func (l *LightLevel) Dim() (dim, known bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dim, l.known
+}
+
+// startLightSensor opens the -light-sensor and reads it every
+// lightPollInterval, refreshing when the room turns dim or bright so dim and
+// bright rules take effect
+func startLightSensor(options AppOptions) error {
+	s, err := sensor.OpenLightSensor(options.LightSensor)
+	if err != nil {
+		return err
+	}
+	l := NewLightLevel(options.LightThreshold, options.LightHysteresis)
+	if lux, err := s.Lux(); err == nil {
+		l.Update(lux)
+		dim, _ := l.Dim()
+		powerLog.Info("Light sensor opened", "sensor", options.LightSensor.Model, "lux", lux, "dim", dim)
+	} else {
+		powerLog.Warn("Error reading light sensor", "err", err)
+	}
+	light = l
+	go func() {
+		for range time.Tick(lightPollInterval) {
+			lux, err := s.Lux()
+			if err != nil {
+				powerLog.Warn("Error reading light sensor", "err", err)
+				continue
+			}
+			if l.Update(lux) {
+				dim, _ := l.Dim()
+				powerLog.Info("Light level changed, refreshing", "lux", lux, "dim", dim)
+				requestRefresh()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"trmnl-display/pkg/rules"
+)
+
+func TestLightLevel(t *testing.T) {
+	l := NewLightLevel(10, 5)
+	if _, known := l.Dim(); known {
+		t.Fatalf("light level known before any reading")
+	}
+	if l.Update(50) {
+		t.Errorf("first reading reported as a change")
+	}
+	steps := []struct {
+		lux     float64
+		dim     bool
+		changed bool
+	}{
+		{12, false, false},
+		{8, true, true},
+		{12, true, false}, // within the hysteresis
+		{15, true, false},
+		{16, false, true},
+		{10, false, false},
+	}
+	for _, step := range steps {
+		changed := l.Update(step.lux)
+		if dim, _ := l.Dim(); dim != step.dim || changed != step.changed {
+			t.Errorf("Update(%v): dim %v, changed %v, want %v, %v", step.lux, dim, changed, step.dim, step.changed)
+		}
+	}
+}
+
+func TestDimRules(t *testing.T) {
+	rule, err := rules.Parse("when dim dark on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if rule.Matches(rules.State{Now: now}) {
+		t.Errorf("dim rule matched without a light sensor")
+	}
+	if !rule.Matches(rules.State{Now: now, HasLight: true, Dim: true}) {
+		t.Errorf("dim rule did not match in a dim room")
+	}
+	bright, err := rules.Parse("when bright interval 5m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bright.Matches(rules.State{Now: now, HasLight: true, Dim: true}) || !bright.Matches(rules.State{Now: now, HasLight: true}) {
+		t.Errorf("bright rule matched the wrong light level")
+	}
+}
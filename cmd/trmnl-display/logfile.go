@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Defaults for the -log-max-* flags
+const (
+	defaultLogMaxSize  = 10 // MB
+	defaultLogMaxAge   = 7 * 24 * time.Hour
+	defaultLogMaxFiles = 5
+)
+
+// Suffix layout of rotated log files, e.g. trmnl.log.20240131-154500
+const logFileTimeLayout = "20060102-150405"
+
+// RotatingFile is a log file that is moved aside once it reaches MaxSize
+// bytes. Rotated files older than MaxAge, and all but the newest MaxFiles,
+// are deleted. Zero limits are not enforced.
+type RotatingFile struct {
+	Path     string
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// Open opens the log file for appending, creating it and its directory if
+// needed
+func (r *RotatingFile) Open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.open()
+}
+
+func (r *RotatingFile) open() error {
+	if r.file != nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %v", err)
+	}
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error opening log file: %v", err)
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the log file, rotating it first if p would take it
+// past MaxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.open(); err != nil {
+		return 0, err
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// rotate moves the current file aside, starts a new one, and prunes old
+// rotated files
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	r.file = nil
+	rotated := r.Path + "." + time.Now().Format(logFileTimeLayout)
+	if err := os.Rename(r.Path, rotated); err != nil {
+		return fmt.Errorf("error rotating log file: %v", err)
+	}
+	r.prune()
+	return r.open()
+}
+
+// prune deletes rotated files past MaxAge or beyond the newest MaxFiles
+func (r *RotatingFile) prune() {
+	matches, err := filepath.Glob(r.Path + ".*")
+	if err != nil {
+		return
+	}
+	var rotated []string
+	for _, path := range matches {
+		if _, err := time.Parse(logFileTimeLayout, path[len(r.Path)+1:]); err == nil {
+			rotated = append(rotated, path)
+		}
+	}
+	// Newest first; the timestamp suffix sorts by time
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	for i, path := range rotated {
+		expired := false
+		if r.MaxAge > 0 {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > r.MaxAge {
+				expired = true
+			}
+		}
+		if expired || (r.MaxFiles > 0 && i >= r.MaxFiles) {
+			os.Remove(path)
+		}
+	}
+}
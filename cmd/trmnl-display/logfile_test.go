@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trmnl.log")
+
+	// Rotated files from earlier runs: one expired, two within MaxAge
+	old := time.Now().Add(-48 * time.Hour)
+	for i, name := range []string{"20200101-000000", "20200102-000000", "20200103-000000"} {
+		rotated := path + "." + name
+		if err := os.WriteFile(rotated, []byte("old\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now()
+		if i == 0 {
+			mtime = old
+		}
+		os.Chtimes(rotated, mtime, mtime)
+	}
+
+	r := &RotatingFile{Path: path, MaxSize: 10, MaxAge: 24 * time.Hour, MaxFiles: 2}
+	defer r.Close()
+	for _, line := range []string{"first\n", "second\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second\n" {
+		t.Errorf("log file holds %q, want the line written after rotating", data)
+	}
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 2 {
+		t.Fatalf("rotated files = %v, want 2", matches)
+	}
+	for _, m := range matches {
+		if strings.HasSuffix(m, "20200101-000000") || strings.HasSuffix(m, "20200102-000000") {
+			t.Errorf("%s was kept, want it pruned", filepath.Base(m))
+		}
+	}
+}
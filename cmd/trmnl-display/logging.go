@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"strings"
+)
+
+// Loggers for each part of the daemon, so their levels can be set apart
+// with -log-level (e.g. info,menu=debug). They log at info until
+// setupLogging runs.
+var (
+	mainLog    = slog.Default()
+	fetchLog   = slog.Default()
+	displayLog = slog.Default()
+	powerLog   = slog.Default()
+	menuLog    = slog.Default()
+	controlLog = slog.Default()
+	configLog  = slog.Default()
+	mqttLog    = slog.Default()
+)
+
+// subsystemLoggers names each logger for -log-level and in log records
+var subsystemLoggers = map[string]**slog.Logger{
+	"main":    &mainLog,
+	"fetch":   &fetchLog,
+	"display": &displayLog,
+	"power":   &powerLog,
+	"menu":    &menuLog,
+	"control": &controlLog,
+	"config":  &configLog,
+	"mqtt":    &mqttLog,
+}
+
+// Log output formats for -log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logLevels are the names -log-level accepts
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// parseLogLevels parses a -log-level value: a default level, optionally
+// followed by SUBSYSTEM=LEVEL overrides, e.g. "warn,fetch=debug"
+func parseLogLevels(spec string) (slog.Level, map[string]slog.Level, error) {
+	level := slog.LevelInfo
+	overrides := make(map[string]slog.Level)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, levelName, isOverride := strings.Cut(part, "=")
+		if !isOverride {
+			levelName = name
+		}
+		l, ok := logLevels[strings.ToLower(levelName)]
+		if !ok {
+			return level, nil, fmt.Errorf("unknown log level %q, expected debug, info, warn, or error", levelName)
+		}
+		if !isOverride {
+			level = l
+			continue
+		}
+		if _, ok := subsystemLoggers[name]; !ok {
+			return level, nil, fmt.Errorf("unknown log subsystem %q, expected one of %s", name, strings.Join(logSubsystems(), ", "))
+		}
+		overrides[name] = l
+	}
+	return level, overrides, nil
+}
+
+// logSubsystems lists the subsystem names in a stable order
+func logSubsystems() []string {
+	return []string{"main", "fetch", "display", "power", "menu", "control", "config"}
+}
+
+// validateLogging checks -log-level, -log-format, and -log-target values
+func validateLogging(level, format, target string) error {
+	if _, _, err := parseLogLevels(level); err != nil {
+		return err
+	}
+	switch format {
+	case "", logFormatText, logFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q, expected %s or %s", format, logFormatText, logFormatJSON)
+	}
+	return validateLogTarget(target)
+}
+
+// logFile is the file logs are also written to, if -log-file is set
+var logFile *RotatingFile
+
+// setupLogging points every subsystem logger at the log target, and the
+// log file if there is one, at the levels from options.LogLevel (checked by
+// validateLogging). It is called again on reload.
+func setupLogging(options AppOptions) error {
+	var file io.Writer
+	var err error
+	if logFile != nil && logFile.Path != options.LogFile {
+		logFile.Close()
+		logFile = nil
+	}
+	if options.LogFile != "" {
+		if logFile == nil {
+			logFile = &RotatingFile{Path: options.LogFile}
+		}
+		logFile.MaxSize = int64(options.LogMaxSize) << 20
+		logFile.MaxAge = options.LogMaxAge
+		logFile.MaxFiles = options.LogMaxFiles
+		if err = logFile.Open(); err == nil {
+			file = logFile
+		}
+	}
+
+	// Stdout gets plain lines; journald and syslog also need each line's
+	// priority, so lines for them go through a prioritySink
+	var sink *prioritySink
+	switch resolveLogTarget(options.LogTarget) {
+	case logTargetJournal:
+		sink = &prioritySink{emit: journalLine, file: file}
+	case logTargetSyslog:
+		if syslogWriter == nil {
+			w, syslogErr := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, "trmnl-display")
+			if syslogErr != nil {
+				err = fmt.Errorf("error connecting to syslog: %v", syslogErr)
+				break
+			}
+			syslogWriter = w
+		}
+		sink = &prioritySink{emit: syslogLine, file: file}
+	}
+	var out io.Writer = os.Stdout
+	if sink != nil {
+		out = &sink.buf
+	} else if file != nil {
+		out = io.MultiWriter(os.Stdout, file)
+	}
+
+	defaultLevel, overrides, _ := parseLogLevels(options.LogLevel)
+	newHandler := func(level slog.Level) slog.Handler {
+		opts := &slog.HandlerOptions{Level: level}
+		var h slog.Handler = slog.NewTextHandler(out, opts)
+		if options.LogFormat == logFormatJSON {
+			h = slog.NewJSONHandler(out, opts)
+		}
+		if sink != nil {
+			h = &priorityHandler{inner: h, sink: sink}
+		}
+		if options.Instance != "" {
+			h = h.WithAttrs([]slog.Attr{slog.String("display", options.Instance)})
+		}
+		return h
+	}
+
+	slog.SetDefault(slog.New(newHandler(defaultLevel)))
+	for name, logger := range subsystemLoggers {
+		l, ok := overrides[name]
+		if !ok {
+			l = defaultLevel
+		}
+		*logger = slog.New(newHandler(l)).With("subsystem", name)
+	}
+	return err
+}
+
+// logLevelFor picks the -log-level to use: an explicit level wins, then -q
+// and -verbose, which are shorthands for warn and debug
+func logLevelFor(level string, verbose, quiet bool) string {
+	switch {
+	case level != "":
+		return level
+	case quiet:
+		return "warn"
+	case verbose:
+		return "debug"
+	}
+	return "info"
+}
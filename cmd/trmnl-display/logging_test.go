@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+)
+
+func TestParseLogLevels(t *testing.T) {
+	level, overrides, err := parseLogLevels("warn,fetch=debug, menu=ERROR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level != slog.LevelWarn {
+		t.Errorf("level = %v, want %v", level, slog.LevelWarn)
+	}
+	if overrides["fetch"] != slog.LevelDebug || overrides["menu"] != slog.LevelError || len(overrides) != 2 {
+		t.Errorf("overrides = %v, want fetch=DEBUG menu=ERROR", overrides)
+	}
+
+	for _, spec := range []string{"loud", "info,fetch=loud", "info,camera=debug"} {
+		if _, _, err := parseLogLevels(spec); err == nil {
+			t.Errorf("parseLogLevels(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestLogLevelFor(t *testing.T) {
+	for _, tc := range []struct {
+		level          string
+		verbose, quiet bool
+		want           string
+	}{
+		{"", false, false, "info"},
+		{"", true, false, "debug"},
+		{"", true, true, "warn"},
+		{"error", true, false, "error"},
+	} {
+		if got := logLevelFor(tc.level, tc.verbose, tc.quiet); got != tc.want {
+			t.Errorf("logLevelFor(%q, %v, %v) = %q, want %q", tc.level, tc.verbose, tc.quiet, got, tc.want)
+		}
+	}
+}
+
+func TestPriorityHandler(t *testing.T) {
+	var lines []string
+	sink := &prioritySink{emit: func(level slog.Level, line []byte) error {
+		lines = append(lines, fmt.Sprintf("<%d>%s", journalPriority(level), line))
+		return nil
+	}}
+	inner := slog.NewTextHandler(&sink.buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	logger := slog.New(&priorityHandler{inner: inner, sink: sink}).With("subsystem", "fetch")
+	logger.Error("Failed", "err", "timeout")
+	logger.Debug("Fetched")
+
+	want := []string{
+		"<3>level=ERROR msg=Failed subsystem=fetch err=timeout\n",
+		"<7>level=DEBUG msg=Fetched subsystem=fetch\n",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got lines %q, want %q", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"log/syslog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Where logs go, for -log-target
+const (
+	logTargetAuto    = "auto"
+	logTargetStdout  = "stdout"
+	logTargetJournal = "journald"
+	logTargetSyslog  = "syslog"
+)
+
+// validateLogTarget checks a -log-target value
+func validateLogTarget(target string) error {
+	switch target {
+	case "", logTargetAuto, logTargetStdout, logTargetJournal, logTargetSyslog:
+		return nil
+	}
+	return fmt.Errorf("unknown log target %q, expected %s, %s, %s, or %s", target, logTargetAuto, logTargetStdout, logTargetJournal, logTargetSyslog)
+}
+
+// resolveLogTarget turns auto into journald when stdout is connected to the
+// journal, as it is under systemd, and stdout otherwise
+func resolveLogTarget(target string) string {
+	if target != "" && target != logTargetAuto {
+		return target
+	}
+	if stdoutIsJournal() {
+		return logTargetJournal
+	}
+	return logTargetStdout
+}
+
+// stdoutIsJournal reports whether stdout is the journal stream systemd
+// described in JOURNAL_STREAM (device:inode), rather than one a child
+// process inherited the variable past
+func stdoutIsJournal() bool {
+	dev, ino, ok := parseJournalStream(os.Getenv("JOURNAL_STREAM"))
+	if !ok {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stdout.Fd()), &st); err != nil {
+		return false
+	}
+	return uint64(st.Dev) == dev && uint64(st.Ino) == ino
+}
+
+// parseJournalStream parses a JOURNAL_STREAM value
+func parseJournalStream(s string) (dev, ino uint64, ok bool) {
+	devStr, inoStr, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, false
+	}
+	dev, err := strconv.ParseUint(devStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	ino, err = strconv.ParseUint(inoStr, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return dev, ino, true
+}
+
+// journalPriority maps a level to its syslog priority, which journald reads
+// from a <N> prefix on each line
+func journalPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3
+	case level >= slog.LevelWarn:
+		return 4
+	case level >= slog.LevelInfo:
+		return 6
+	}
+	return 7
+}
+
+// journalLine writes a log line to stdout with its journald priority prefix
+func journalLine(level slog.Level, line []byte) error {
+	_, err := fmt.Fprintf(os.Stdout, "<%d>%s", journalPriority(level), line)
+	return err
+}
+
+// syslogWriter is the connection to syslog, opened the first time the
+// syslog target is used
+var syslogWriter *syslog.Writer
+
+// syslogLine sends a log line to syslog at the priority for its level
+func syslogLine(level slog.Level, line []byte) error {
+	msg := string(bytes.TrimSuffix(line, []byte("\n")))
+	switch journalPriority(level) {
+	case 3:
+		return syslogWriter.Err(msg)
+	case 4:
+		return syslogWriter.Warning(msg)
+	case 6:
+		return syslogWriter.Info(msg)
+	}
+	return syslogWriter.Debug(msg)
+}
+
+// prioritySink sends each formatted log line to a target that needs its
+// level, and to the log file if there is one
+type prioritySink struct {
+	emit func(slog.Level, []byte) error
+	file io.Writer
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// priorityHandler formats records with a text or JSON handler writing to
+// the sink's buffer, then passes each line on with its level
+type priorityHandler struct {
+	inner slog.Handler
+	sink  *prioritySink
+}
+
+func (h *priorityHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *priorityHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.sink.mu.Lock()
+	defer h.sink.mu.Unlock()
+	h.sink.buf.Reset()
+	if err := h.inner.Handle(ctx, r); err != nil {
+		return err
+	}
+	line := h.sink.buf.Bytes()
+	if h.sink.file != nil {
+		h.sink.file.Write(line)
+	}
+	return h.sink.emit(r.Level, line)
+}
+
+func (h *priorityHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithAttrs(attrs), sink: h.sink}
+}
+
+func (h *priorityHandler) WithGroup(name string) slog.Handler {
+	return &priorityHandler{inner: h.inner.WithGroup(name), sink: h.sink}
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"net"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/compose"
+	"trmnl-display/pkg/panel"
+)
+
+// The menu closes itself after this long without a button press
+const menuIdleTimeout = 30 * time.Second
+
+// menuItem is one entry in the on-device settings menu
+type menuItem struct {
+	Label  func() string
+	Action func()
+}
+
+// Menu is the on-panel settings menu, navigated with GPIO buttons or a
+// rotary encoder
+type Menu struct {
+	mu        sync.Mutex
+	open      bool
+	page      []string // an information page shown instead of the item list
+	selected  int
+	lastInput time.Time
+	items     []menuItem
+	daemon    *Daemon
+	options   AppOptions
+}
+
+// Global menu, nil when no menu buttons are configured
+var menu *Menu
+
+// NewMenu creates the settings menu, drawing on d
+func NewMenu(d *Daemon, options AppOptions) *Menu {
+	m := &Menu{daemon: d, options: options}
+	m.items = []menuItem{
+		{
+			Label: func() string { return "Dark mode: " + onOff(live.DarkMode(options.DarkMode)) },
+			Action: func() {
+				live.ToggleDarkMode(options.DarkMode)
+				requestRefresh()
+			},
+		},
+		{
+			Label:  func() string { return "Source: " + m.sourceLabel() },
+			Action: m.nextSource,
+		},
+		{
+			Label: func() string {
+				if live.Paused() {
+					return "Resume refreshing"
+				}
+				return "Pause refreshing"
+			},
+			Action: func() {
+				if live.Paused() {
+					live.Resume(options.ResumeClear)
+				} else {
+					live.Pause()
+				}
+			},
+		},
+		{
+			Label: func() string { return "Full clear" },
+			Action: func() {
+				m.close()
+				m.daemon.mu.Lock()
+				m.daemon.clearFramebuffer()
+				m.daemon.mu.Unlock()
+				m.restore()
+			},
+		},
+		{
+			Label: func() string { return "Network info" },
+			Action: func() {
+				info := networkInfo()
+				m.mu.Lock()
+				m.page = info
+				m.mu.Unlock()
+			},
+		},
+		{
+			Label: func() string { return "Pair phone" },
+			Action: func() {
+				m.close()
+				live.SetScreen(screenPair)
+				requestRefresh()
+			},
+		},
+		{
+			Label:  func() string { return "Shut down" },
+			Action: m.shutdown,
+		},
+		{
+			Label: func() string { return "Close menu" },
+			Action: func() {
+				m.close()
+				m.restore()
+			},
+		},
+	}
+	return m
+}
+
+// startMenu sets up the settings menu, and wires the rotary encoder to it;
+// menu buttons are bound to their actions with the other buttons
+func startMenu(d *Daemon, options AppOptions) error {
+	m := NewMenu(d, options)
+
+	if len(options.MenuEncoder) == 2 {
+		a, err := openPinInput(options.GPIOChip, options.MenuEncoder[0])
+		if err != nil {
+			return err
+		}
+		b, err := openPinInput(options.GPIOChip, options.MenuEncoder[1])
+		if err != nil {
+			return err
+		}
+		go watchEncoder(a, b, m)
+	}
+
+	go m.closeWhenIdle()
+	menu = m
+	return nil
+}
+
+// openPinInput resolves pin and opens it as a button input
+func openPinInput(chip string, pin panel.GPIOPin) (*panel.GPIOLine, error) {
+	chipPath, offset, err := pin.Resolve(chip)
+	if err != nil {
+		return nil, err
+	}
+	return panel.OpenGPIOInput(chipPath, offset, false)
+}
+
+// watchEncoder decodes a rotary encoder: on each falling edge of A, the level
+// of B gives the direction of rotation
+func watchEncoder(a, b *panel.GPIOLine, m *Menu) {
+	for {
+		if _, err := a.WaitForEdge(); err != nil {
+			menuLog.Error("Error watching encoder", "err", err)
+			return
+		}
+		clockwise, err := b.Value()
+		if err != nil {
+			menuLog.Error("Error watching encoder", "err", err)
+			return
+		}
+		if clockwise {
+			m.Next()
+		} else {
+			m.Prev()
+		}
+	}
+}
+
+// IsOpen reports whether the menu is currently on screen
+func (m *Menu) IsOpen() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.open
+}
+
+// Next moves the selection down, opening the menu if it is closed
+func (m *Menu) Next() {
+	m.navigate(1)
+}
+
+// Prev moves the selection up, opening the menu if it is closed
+func (m *Menu) Prev() {
+	m.navigate(-1)
+}
+
+// navigate moves the selection by delta and redraws the menu
+func (m *Menu) navigate(delta int) {
+	if dismissReminder() {
+		return
+	}
+	m.mu.Lock()
+	if m.open && m.page == nil {
+		m.selected = (m.selected + delta + len(m.items)) % len(m.items)
+	}
+	m.open = true
+	m.page = nil
+	m.lastInput = time.Now()
+	m.mu.Unlock()
+	m.draw()
+}
+
+// ShowPage opens the menu on an information page
+func (m *Menu) ShowPage(lines []string) {
+	m.mu.Lock()
+	m.open = true
+	m.page = lines
+	m.lastInput = time.Now()
+	m.mu.Unlock()
+	m.draw()
+}
+
+// Select opens the menu, leaves an information page, or runs the selected item
+func (m *Menu) Select() {
+	if dismissReminder() {
+		return
+	}
+	m.mu.Lock()
+	m.lastInput = time.Now()
+	if !m.open || m.page != nil {
+		m.open = true
+		m.page = nil
+		m.mu.Unlock()
+		m.draw()
+		return
+	}
+	item := m.items[m.selected]
+	m.mu.Unlock()
+
+	item.Action()
+	if m.IsOpen() {
+		m.draw()
+	}
+}
+
+// dismissReminder takes down a reminder on screen, so that a button press
+// dismisses it rather than opening the menu
+func dismissReminder() bool {
+	if !reminders.Dismiss() {
+		return false
+	}
+	requestRefresh()
+	return true
+}
+
+// close hides the menu without redrawing
+func (m *Menu) close() {
+	m.mu.Lock()
+	m.open = false
+	m.page = nil
+	m.mu.Unlock()
+}
+
+// restore redraws the frame that was on screen before the menu opened
+func (m *Menu) restore() {
+	if err := m.daemon.showCurrentFrame(m.options); err != nil {
+		menuLog.Error("Error restoring screen", "err", err)
+	}
+}
+
+// closeWhenIdle closes the menu after menuIdleTimeout without input
+func (m *Menu) closeWhenIdle() {
+	for range time.Tick(time.Second) {
+		m.mu.Lock()
+		idle := m.open && time.Since(m.lastInput) > menuIdleTimeout
+		m.mu.Unlock()
+		if idle {
+			m.close()
+			m.restore()
+		}
+	}
+}
+
+// sources lists the screens the menu can switch between; "" follows the rules
+func (m *Menu) sources() []string {
+	sources := []string{"", screenPlaylist, screenMorning, screenClock, screenStats}
+	if m.options.SlideshowDir != "" {
+		sources = append(sources, screenSlideshow)
+	}
+	var named []string
+	for name := range m.options.Screens {
+		named = append(named, name)
+	}
+	sort.Strings(named)
+	return append(sources, named...)
+}
+
+// sourceLabel describes the current source selection
+func (m *Menu) sourceLabel() string {
+	if screen := live.Screen(); screen != "" {
+		return screen
+	}
+	return "automatic"
+}
+
+// nextSource pins the display to the next screen and refreshes
+func (m *Menu) nextSource() {
+	sources := m.sources()
+	current := live.Screen()
+	for i, source := range sources {
+		if source == current {
+			live.SetScreen(sources[(i+1)%len(sources)])
+			break
+		}
+	}
+	requestRefresh()
+}
+
+// shutdown shows a farewell screen and powers the device off
+func (m *Menu) shutdown() {
+	m.mu.Lock()
+	m.page = []string{"Shutting down…", "", "Wait for the activity light to stop", "before removing power."}
+	m.mu.Unlock()
+	m.draw()
+
+	if err := exec.Command("shutdown", "-h", "now").Run(); err != nil {
+		menuLog.Error("Error shutting down", "err", err)
+		m.mu.Lock()
+		m.page = []string{"Shutdown failed:", err.Error()}
+		m.mu.Unlock()
+	}
+}
+
+// draw renders the menu (or the current information page) to the display
+func (m *Menu) draw() {
+	img, err := m.render()
+	if err != nil {
+		menuLog.Error("Error rendering menu", "err", err)
+		return
+	}
+	if err := m.daemon.showOverlay(img, m.options); err != nil {
+		menuLog.Error("Error drawing menu", "err", err)
+	}
+}
+
+// render lays out the menu items, highlighting the selection
+func (m *Menu) render() (image.Image, error) {
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	page := m.page
+	selected := m.selected
+	m.mu.Unlock()
+
+	titleFace := c.Face(true, 36)
+	itemFace := c.Face(false, 26)
+	defer titleFace.Close()
+	defer itemFace.Close()
+
+	c.FillRect(image.Rect(0, 0, defaultFrameWidth, 70), color.Black)
+	if page != nil {
+		c.DrawText("Information", 30, 48, titleFace, color.White)
+		for i, line := range page {
+			c.DrawText(line, 40, 125+i*44, itemFace, color.Black)
+		}
+		c.DrawText("Press any button to go back", 40, 450, itemFace, color.Black)
+		return c.Frame, nil
+	}
+
+	c.DrawText("Settings", 30, 48, titleFace, color.White)
+	for i, item := range m.items {
+		top := 80 + i*48
+		textColor := color.Color(color.Black)
+		if i == selected {
+			c.FillRect(image.Rect(20, top, defaultFrameWidth-20, top+44), color.Black)
+			textColor = color.White
+		}
+		c.DrawText(item.Label(), 40, top+32, itemFace, textColor)
+	}
+	return c.Frame, nil
+}
+
+// networkInfo describes the hostname, addresses, and Wi-Fi network
+func networkInfo() []string {
+	var lines []string
+	if hostname, err := os.Hostname(); err == nil {
+		lines = append(lines, "Hostname: "+hostname)
+	}
+	if ssid, err := exec.Command("iwgetid", "-r").Output(); err == nil && len(strings.TrimSpace(string(ssid))) > 0 {
+		lines = append(lines, "Wi-Fi: "+strings.TrimSpace(string(ssid)))
+	}
+
+	for _, addr := range interfaceAddresses() {
+		lines = append(lines, fmt.Sprintf("%s: %s", addr.Name, addr.IP))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No network information available")
+	}
+	return lines
+}
+
+// interfaceAddr is an IPv4 address assigned to a network interface
+type interfaceAddr struct {
+	Name string
+	IP   net.IP
+}
+
+// interfaceAddresses lists the IPv4 addresses of the interfaces that are up,
+// ignoring loopback
+func interfaceAddresses() []interfaceAddr {
+	var result []interfaceAddr
+	interfaces, _ := net.Interfaces()
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				result = append(result, interfaceAddr{Name: iface.Name, IP: ipnet.IP})
+			}
+		}
+	}
+	return result
+}
+
+// parseMenuButtons parses comma-separated name=gpio pairs, where each GPIO
+// is a line offset or name
+func parseMenuButtons(s string) (map[string]panel.GPIOPin, error) {
+	buttons := make(map[string]panel.GPIOPin)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, pin, found := strings.Cut(pair, "=")
+		if !found || !validPin(pin) {
+			return nil, fmt.Errorf("invalid button %q, expected name=gpio", pair)
+		}
+		buttons[name] = panel.GPIOPin(pin)
+	}
+	return buttons, nil
+}
+
+// parseGPIOList parses comma-separated GPIO line offsets or names
+func parseGPIOList(s string) ([]panel.GPIOPin, error) {
+	var pins []panel.GPIOPin
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !validPin(field) {
+			return nil, fmt.Errorf("invalid GPIO %q", field)
+		}
+		pins = append(pins, panel.GPIOPin(field))
+	}
+	return pins, nil
+}
+
+// validPin reports whether s can name a GPIO: a non-negative offset, or a
+// line name
+func validPin(s string) bool {
+	if offset, err := strconv.Atoi(s); err == nil {
+		return offset >= 0
+	}
+	return s != "" && !strings.HasPrefix(s, "-")
+}
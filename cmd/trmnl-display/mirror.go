@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"strings"
+
+	"trmnl-display/pkg/panel"
+	"trmnl-display/pkg/render"
+
+	"github.com/gonutz/framebuffer"
+)
+
+// Mirrors show every frame drawn on the panel as well, each scaled to its
+// own size. Guarded by Daemon.mu.
+var mirrors []mirror
+
+// mirror is an output frames are copied to, and the -mirror value naming it
+type mirror struct {
+	name string
+	out  panel.Panel
+}
+
+// validateMirror checks a -mirror value: a stand-in panel, or the
+// framebuffer or a DRM monitor when neither is the panel, as they would
+// fight over the screen
+func validateMirror(name, primary string) error {
+	switch {
+	case name == panelFramebuffer || isDRMPanel(name):
+		if primary == "" || primary == panelFramebuffer || isDRMPanel(primary) {
+			return fmt.Errorf("-mirror %s: the panel already uses the screen", name)
+		}
+		return validatePanel(name)
+	case isStandInPanel(name):
+		if name == primary {
+			return fmt.Errorf("-mirror %s: already the panel", name)
+		}
+		return validatePanel(name)
+	}
+	return fmt.Errorf("unknown mirror %q, expected framebuffer, drm[:CARD], file:PATH, preview[:ADDR], or term[:MODE]", name)
+}
+
+// openMirrors opens the mirrors options list, closing any opened before
+// one that fails
+func openMirrors(options AppOptions) error {
+	for _, name := range options.Mirrors {
+		var out panel.Panel
+		if name == panelFramebuffer {
+			fb, err := openFramebufferMirror()
+			if err != nil {
+				closeMirrors()
+				return fmt.Errorf("error opening mirror %s: %v", name, err)
+			}
+			out = fb
+		} else if isDRMPanel(name) {
+			drm, err := panel.OpenDRM(strings.TrimPrefix(strings.TrimPrefix(name, panelDRM), ":"))
+			if err != nil {
+				closeMirrors()
+				return fmt.Errorf("error opening mirror %s: %v", name, err)
+			}
+			out = drm
+		} else {
+			standIn, err := openStandIn(name)
+			if err != nil {
+				closeMirrors()
+				return fmt.Errorf("error opening mirror %s: %v", name, err)
+			}
+			out = standIn
+		}
+		mirrors = append(mirrors, mirror{name: name, out: out})
+	}
+	return nil
+}
+
+// closeMirrors releases the mirrors
+func closeMirrors() {
+	for _, m := range mirrors {
+		m.out.Close()
+	}
+	mirrors = nil
+}
+
+// mirrorFrame shows img, a frame just drawn on the panel, on each mirror. A
+// mirror that fails is logged and skipped; it never fails the refresh.
+func mirrorFrame(img image.Image) {
+	for _, m := range mirrors {
+		frame := img
+		if m.out.Bounds() != img.Bounds() {
+			frame = render.Scale(img, m.out.Bounds())
+		}
+		if err := m.out.Display(frame); err != nil {
+			displayLog.Warn("Error drawing to mirror", "mirror", m.name, "err", err)
+		}
+	}
+}
+
+// framebufferMirror shows frames on the framebuffer, opening it for each
+// frame just as drawing to it as the panel does
+type framebufferMirror struct {
+	bounds image.Rectangle
+}
+
+// openFramebufferMirror checks the framebuffer can be opened and notes its
+// size
+func openFramebufferMirror() (*framebufferMirror, error) {
+	fb, err := framebuffer.Open("/dev/fb0")
+	if err != nil {
+		return nil, err
+	}
+	defer fb.Close()
+	return &framebufferMirror{bounds: fb.Bounds()}, nil
+}
+
+// Bounds returns the framebuffer's size when it was opened
+func (m *framebufferMirror) Bounds() image.Rectangle {
+	return m.bounds
+}
+
+// Display draws img on the framebuffer
+func (m *framebufferMirror) Display(img image.Image) error {
+	fb, err := framebuffer.Open("/dev/fb0")
+	if err != nil {
+		return err
+	}
+	defer fb.Close()
+	draw.Draw(fb, fb.Bounds(), img, img.Bounds().Min, draw.Src)
+	if fbFlusher, ok := interface{}(fb).(interface{ Flush() error }); ok {
+		return fbFlusher.Flush()
+	}
+	return nil
+}
+
+// Clear fills the framebuffer with black
+func (m *framebufferMirror) Clear() error {
+	return m.Display(image.NewRGBA(m.bounds))
+}
+
+// Close does nothing, as the framebuffer is only open while drawing
+func (m *framebufferMirror) Close() {}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/compose"
+	"trmnl-display/pkg/sources"
+)
+
+// How often the morning briefing is redrawn while its window is active
+const morningRefreshInterval = 15 * time.Minute
+
+// Maximum number of headlines shown on the morning briefing
+const morningHeadlineCount = 5
+
+// parseLocation parses a "latitude,longitude" pair
+func parseLocation(s string) (float64, float64, error) {
+	latStr, lonStr, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid location %q, expected latitude,longitude", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latStr), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid latitude %q", latStr)
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonStr), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid longitude %q", lonStr)
+	}
+	return lat, lon, nil
+}
+
+// morningFrame renders the morning briefing, due to be redrawn after
+// morningRefreshInterval
+func morningFrame(ctx context.Context, options AppOptions, now time.Time) (*Frame, error) {
+	img, err := renderMorningBriefing(ctx, options, now)
+	if err != nil {
+		return nil, fmt.Errorf("error rendering morning briefing: %v", err)
+	}
+	return &Frame{Image: img, Refresh: morningRefreshInterval}, nil
+}
+
+// renderMorningBriefing composes the weather, agenda, and headlines zones
+func renderMorningBriefing(ctx context.Context, options AppOptions, now time.Time) (image.Image, error) {
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header band
+	header := image.Rect(0, 0, defaultFrameWidth, 70)
+	c.FillRect(header, color.Black)
+	titleFace := c.Face(true, 36)
+	dateFace := c.Face(false, 24)
+	defer titleFace.Close()
+	defer dateFace.Close()
+	c.DrawText("Good morning", 20, 48, titleFace, color.White)
+	date := now.Format("Monday 2 January")
+	c.DrawText(date, defaultFrameWidth-20-compose.MeasureText(dateFace, date), 46, dateFace, color.White)
+
+	c.DrawZone(compose.Zone{
+		Rect:  image.Rect(20, 90, 300, 300),
+		Title: "Weather",
+		Lines: weatherLines(ctx, options),
+	}, 24, 22)
+	c.DrawZone(compose.Zone{
+		Rect:  image.Rect(330, 90, 780, 300),
+		Title: "Agenda",
+		Lines: agendaLines(options, now),
+	}, 24, 22)
+	c.DrawZone(compose.Zone{
+		Rect:  image.Rect(20, 320, 780, 470),
+		Title: "Headlines",
+		Lines: headlineLines(ctx, options),
+	}, 24, 20)
+
+	return c.Frame, nil
+}
+
+// weatherLines formats the weather zone
+func weatherLines(ctx context.Context, options AppOptions) []string {
+	if !options.HasLocation {
+		return []string{"No location configured"}
+	}
+	report, err := sourcesClient().Weather(ctx, options.Latitude, options.Longitude)
+	if err != nil {
+		fetchLog.Error("Error fetching weather", "err", err)
+		return []string{"Weather unavailable"}
+	}
+	return []string{
+		fmt.Sprintf("%.0f%s %s", report.Temperature, report.TemperatureUOM, report.Condition),
+		fmt.Sprintf("High %.0f%s / Low %.0f%s", report.High, report.TemperatureUOM, report.Low, report.TemperatureUOM),
+		fmt.Sprintf("Chance of rain %d%%", report.PrecipChance),
+	}
+}
+
+// agendaLines formats the agenda zone
+func agendaLines(options AppOptions, now time.Time) []string {
+	if options.AgendaFile == "" {
+		return []string{"No calendar configured"}
+	}
+	events, err := sources.LoadAgenda(options.AgendaFile, now)
+	if err != nil {
+		fetchLog.Error("Error loading agenda", "err", err)
+		return []string{"Agenda unavailable"}
+	}
+	if len(events) == 0 {
+		return []string{"Nothing scheduled today"}
+	}
+
+	var lines []string
+	for _, event := range events {
+		when := "All day"
+		if !event.AllDay {
+			when = event.Start.In(now.Location()).Format("15:04")
+		}
+		lines = append(lines, fmt.Sprintf("%s  %s", when, event.Summary))
+	}
+	return lines
+}
+
+// headlineLines formats the headlines zone from all configured feeds
+func headlineLines(ctx context.Context, options AppOptions) []string {
+	if len(options.HeadlineFeeds) == 0 {
+		return []string{"No news feeds configured"}
+	}
+
+	var lines []string
+	for _, feedURL := range options.HeadlineFeeds {
+		headlines, err := sourcesClient().Headlines(ctx, feedURL, morningHeadlineCount)
+		if err != nil {
+			fetchLog.Error("Error fetching headlines", "url", feedURL, "err", err)
+			continue
+		}
+		for _, headline := range headlines {
+			lines = append(lines, "• "+headline)
+		}
+	}
+	if len(lines) == 0 {
+		return []string{"Headlines unavailable"}
+	}
+	if len(lines) > morningHeadlineCount {
+		lines = lines[:morningHeadlineCount]
+	}
+	return lines
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/panel"
+)
+
+// With -motion-idle-refresh 0 an empty room still gets a refresh this often,
+// so the screen is never more than a day out of date
+const motionWaitLimit = 24 * time.Hour
+
+// MotionSensor follows a PIR sensor, which holds its output high while it
+// sees movement, to tell whether anyone is in the room
+type MotionSensor struct {
+	mu         sync.Mutex
+	active     bool      // the sensor sees movement now
+	lastMotion time.Time // when movement was last seen
+	timeout    time.Duration
+}
+
+// Global motion sensor, nil when none is configured
+var motion *MotionSensor
+
+// NewMotionSensor creates a sensor that counts the room as empty timeout
+// after the last movement; the room counts as occupied at startup
+func NewMotionSensor(timeout time.Duration) *MotionSensor {
+	return &MotionSensor{lastMotion: time.Now(), timeout: timeout}
+}
+
+// startMotionSensor watches the -motion-sensor GPIO
+func startMotionSensor(options AppOptions) error {
+	chipPath, offset, err := options.MotionSensor.Resolve(options.GPIOChip)
+	if err != nil {
+		return err
+	}
+	line, err := panel.OpenGPIOInput(chipPath, offset, true)
+	if err != nil {
+		return err
+	}
+	m := NewMotionSensor(options.MotionTimeout)
+	if high, err := line.Value(); err == nil {
+		m.active = high
+	}
+	motion = m
+	go func() {
+		for {
+			edge, err := line.WaitForEdge()
+			if err != nil {
+				powerLog.Error("Error watching motion sensor", "err", err)
+				return
+			}
+			if m.Movement(edge.Rising, edge.Time) {
+				powerLog.Info("Motion detected, refreshing")
+				requestRefresh()
+			}
+		}
+	}()
+	return nil
+}
+
+// Movement records the sensor's output changing at t, and reports whether
+// it brought someone into a room that was empty
+func (m *MotionSensor) Movement(active bool, t time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	wasPresent := m.present(t)
+	m.active = active
+	m.lastMotion = t
+	return active && !wasPresent
+}
+
+// Present reports whether anyone has been seen in the room lately
+func (m *MotionSensor) Present(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.present(now)
+}
+
+// present is Present with m.mu held
+func (m *MotionSensor) present(now time.Time) bool {
+	return m.active || now.Sub(m.lastMotion) < m.timeout
+}
+
+// motionDue returns when the refresh due next should happen given whether
+// anyone is about: as scheduled while the room is occupied, and no sooner
+// than -motion-idle-refresh, or a day with 0, once it is empty
+func motionDue(due, now time.Time, options AppOptions) time.Time {
+	if motion == nil || motion.Present(now) {
+		return due
+	}
+	idle := options.MotionIdleRefresh
+	if idle <= 0 {
+		idle = motionWaitLimit
+	}
+	if earliest := now.Add(idle); due.Before(earliest) {
+		return earliest
+	}
+	return due
+}
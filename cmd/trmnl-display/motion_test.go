@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMotionDue(t *testing.T) {
+	now := time.Now()
+	due := now.Add(5 * time.Minute)
+	options := AppOptions{MotionIdleRefresh: time.Hour}
+	if got := motionDue(due, now, options); !got.Equal(due) {
+		t.Errorf("motionDue without a sensor = %v, want the schedule", got.Sub(now))
+	}
+
+	motion = NewMotionSensor(10 * time.Minute)
+	t.Cleanup(func() { motion = nil })
+	motion.lastMotion = now.Add(-5 * time.Minute)
+	if got := motionDue(due, now, options); !got.Equal(due) {
+		t.Errorf("motionDue with someone about = %v, want the schedule", got.Sub(now))
+	}
+
+	motion.lastMotion = now.Add(-20 * time.Minute)
+	if got := motionDue(due, now, options); !got.Equal(now.Add(time.Hour)) {
+		t.Errorf("motionDue in an empty room = %v, want 1h", got.Sub(now))
+	}
+	options.MotionIdleRefresh = 0
+	if got := motionDue(due, now, options); !got.Equal(now.Add(motionWaitLimit)) {
+		t.Errorf("motionDue waiting for movement = %v, want %v", got.Sub(now), motionWaitLimit)
+	}
+
+	if !motion.Movement(true, now) {
+		t.Errorf("movement in an empty room was not reported as someone arriving")
+	}
+	if motion.Movement(false, now.Add(time.Second)) || motion.Movement(true, now.Add(time.Minute)) {
+		t.Errorf("movement in an occupied room was reported as someone arriving")
+	}
+	if !motion.Present(now.Add(time.Hour)) {
+		t.Errorf("room counted as empty while the sensor still sees movement")
+	}
+}
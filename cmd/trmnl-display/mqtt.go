@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/mqtt"
+)
+
+// Default topic prefix; images arrive on PREFIX/image and commands on
+// PREFIX/command, and the frame reports on PREFIX/status and PREFIX/state
+const defaultMQTTTopic = "trmnl"
+
+// How often the state is republished between refreshes, so changes made
+// through the control API or menu show up and the battery level stays current
+const mqttStateInterval = time.Minute
+
+// MQTTState is published (retained) on PREFIX/state
+type MQTTState struct {
+	Screen      string     `json:"screen,omitempty"`
+	LastRefresh *time.Time `json:"last_refresh,omitempty"`
+	NextRefresh *time.Time `json:"next_refresh,omitempty"`
+	DarkMode    bool       `json:"dark_mode"`
+	Paused      bool       `json:"paused"`
+	Battery     *int       `json:"battery,omitempty"` // percent
+	Error       string     `json:"error,omitempty"`
+}
+
+// MQTTBridge connects the frame to an MQTT broker, showing images and
+// running commands published by home-automation systems
+type MQTTBridge struct {
+	Broker string
+	Topic  string
+
+	daemon  *Daemon
+	mu      sync.Mutex
+	config  Config
+	options AppOptions
+	client  *mqtt.Client // nil while disconnected
+	screen  string       // screen shown by the last refresh
+}
+
+// Global MQTT bridge, nil when -mqtt-broker is not set
+var mqttBridge *MQTTBridge
+
+// NewMQTTBridge creates the bridge for options.MQTTBroker, showing images
+// and running commands on d
+func NewMQTTBridge(d *Daemon, config Config, options AppOptions) *MQTTBridge {
+	return &MQTTBridge{
+		Broker:  options.MQTTBroker,
+		Topic:   options.MQTTTopic,
+		daemon:  d,
+		config:  config,
+		options: options,
+	}
+}
+
+// validateMQTTBroker checks a -mqtt-broker value
+func validateMQTTBroker(broker string) error {
+	for _, scheme := range []string{"mqtt://", "mqtts://", "tcp://", "ssl://", "tls://"} {
+		if strings.HasPrefix(broker, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("-mqtt-broker must be an mqtt:// or mqtts:// URL, not %q", broker)
+}
+
+// UpdateConfig switches to reloaded settings
+func (b *MQTTBridge) UpdateConfig(config Config, options AppOptions) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.config = config
+	b.options = options
+}
+
+// currentOptions returns the options in effect
+func (b *MQTTBridge) currentOptions() AppOptions {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.options
+}
+
+// Run stays connected to the broker until ctx is cancelled, reconnecting
+// with the same backoff as the push endpoint
+func (b *MQTTBridge) Run(ctx context.Context) {
+	backoff := pushMinBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := b.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > pushStableTime {
+			backoff = pushMinBackoff
+		}
+		mqttLog.Warn("MQTT connection lost, reconnecting", "broker", b.Broker, "in", backoff, "err", err)
+		sleepUntil(ctx, time.Now().Add(backoff))
+		backoff = min(backoff*2, pushMaxBackoff)
+	}
+}
+
+// session connects, subscribes, and handles messages until the connection
+// fails
+func (b *MQTTBridge) session(ctx context.Context) error {
+	b.mu.Lock()
+	config := b.config
+	b.mu.Unlock()
+
+	// The broker marks the frame offline if the connection drops
+	opts := mqtt.Options{
+		Broker:   b.Broker,
+		ClientID: mqttClientID(),
+		Username: config.MQTTUsername,
+		Password: config.MQTTPassword,
+		Will:     &mqtt.Message{Topic: b.Topic + "/status", Payload: []byte("offline"), Retain: true},
+	}
+	if strings.HasPrefix(b.Broker, "mqtts://") || strings.HasPrefix(b.Broker, "ssl://") || strings.HasPrefix(b.Broker, "tls://") {
+		tlsConf, err := tlsConfig(config)
+		if err != nil {
+			return err
+		}
+		opts.TLS = tlsConf
+	}
+	client, err := mqtt.Dial(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := client.Subscribe(b.Topic+"/image", b.Topic+"/command"); err != nil {
+		return err
+	}
+	if err := client.Publish(mqtt.Message{Topic: b.Topic + "/status", Payload: []byte("online"), Retain: true}); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.client = client
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.client = nil
+		b.mu.Unlock()
+	}()
+	if discovery := b.currentOptions().MQTTDiscovery; discovery != "" {
+		if err := publishDiscovery(client, discovery, b.Topic); err != nil {
+			return err
+		}
+	}
+	mqttLog.Info("Connected to MQTT broker", "broker", b.Broker, "topic", b.Topic)
+	b.PublishState()
+	b.publishFrame()
+
+	// Images are downloaded and decoded away from the reading goroutine so
+	// the connection is kept alive meanwhile; a newer image replaces one
+	// still loading
+	images := make(chan []byte, 1)
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		ticker := time.NewTicker(mqttStateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-sessionCtx.Done():
+				return
+			case payload := <-images:
+				b.showImage(sessionCtx, payload)
+			case <-ticker.C:
+				b.PublishState()
+			}
+		}
+	}()
+
+	err = client.Run(ctx, func(msg mqtt.Message) {
+		switch msg.Topic {
+		case b.Topic + "/image":
+			select {
+			case <-images:
+			default:
+			}
+			images <- msg.Payload
+		case b.Topic + "/command":
+			if err := b.runCommand(string(msg.Payload)); err != nil {
+				mqttLog.Warn("Ignoring MQTT command", "command", string(msg.Payload), "err", err)
+			}
+		}
+	})
+	if ctx.Err() != nil {
+		// Shutting down: a clean disconnect does not trigger the will
+		client.Publish(mqtt.Message{Topic: b.Topic + "/status", Payload: []byte("offline"), Retain: true})
+	}
+	return err
+}
+
+// Refreshed records the screen just shown and publishes the new state and
+// frame
+func (b *MQTTBridge) Refreshed(screen string) {
+	b.mu.Lock()
+	b.screen = screen
+	b.mu.Unlock()
+	b.PublishState()
+	b.publishFrame()
+}
+
+// publishFrame publishes the frame last handed to the display as a PNG on
+// PREFIX/frame, if connected
+func (b *MQTTBridge) publishFrame() {
+	b.mu.Lock()
+	client := b.client
+	b.mu.Unlock()
+	b.daemon.mu.Lock()
+	img := b.daemon.lastFrame
+	b.daemon.mu.Unlock()
+	if client == nil || img == nil {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		mqttLog.Error("Error encoding frame", "err", err)
+		return
+	}
+	if err := client.Publish(mqtt.Message{Topic: b.Topic + "/frame", Payload: buf.Bytes(), Retain: true}); err != nil {
+		mqttLog.Warn("Error publishing frame", "err", err)
+	}
+}
+
+// PublishState publishes the frame's state, if connected
+func (b *MQTTBridge) PublishState() {
+	b.mu.Lock()
+	client, screen, options := b.client, b.screen, b.options
+	b.mu.Unlock()
+	if client == nil {
+		return
+	}
+
+	data, err := json.Marshal(currentMQTTState(screen, options))
+	if err != nil {
+		mqttLog.Error("Error encoding MQTT state", "err", err)
+		return
+	}
+	if err := client.Publish(mqtt.Message{Topic: b.Topic + "/state", Payload: data, Retain: true}); err != nil {
+		mqttLog.Warn("Error publishing MQTT state", "err", err)
+	}
+}
+
+// currentMQTTState gathers the state published on PREFIX/state
+func currentMQTTState(screen string, options AppOptions) MQTTState {
+	report := health.Report()
+	state := MQTTState{
+		Screen:      screen,
+		LastRefresh: report.LastDisplay,
+		DarkMode:    live.DarkMode(options.DarkMode),
+		Paused:      live.Paused(),
+		Error:       report.Error,
+	}
+	if next := health.NextRefresh(); !next.IsZero() {
+		state.NextRefresh = &next
+	}
+	if percent, ok := readBatteryPercent(); ok {
+		state.Battery = &percent
+	}
+	return state
+}
+
+// mqttClientID identifies the frame to the broker
+func mqttClientID() string {
+	if hostname, err := os.Hostname(); err == nil {
+		return "trmnl-display-" + hostname
+	}
+	return "trmnl-display"
+}
+
+// showImage shows an image published as a URL, base64, or raw image bytes
+// until the push duration runs out
+func (b *MQTTBridge) showImage(ctx context.Context, payload []byte) {
+	tmpDir, err := os.MkdirTemp("", "trmnl-mqtt")
+	if err != nil {
+		mqttLog.Error("Error creating temporary directory", "err", err)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	source := strings.TrimSpace(string(payload))
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		data := payload
+		if decoded, err := base64.StdEncoding.DecodeString(source); err == nil {
+			data = decoded
+		}
+		source = filepath.Join(tmpDir, "image")
+		if err := os.WriteFile(source, data, 0600); err != nil {
+			mqttLog.Error("Error saving MQTT image", "err", err)
+			return
+		}
+	}
+
+	options := b.currentOptions()
+	options.DarkMode = live.DarkMode(options.DarkMode)
+	img, err := loadShowImage(ctx, source, tmpDir, options)
+	if err != nil {
+		mqttLog.Error("Error loading MQTT image", "err", err)
+		return
+	}
+	until := b.daemon.showPushed(img, defaultPushDuration)
+	mqttLog.Info("Showing image from MQTT", "until", until.Format("15:04:05"))
+}
+
+// runCommand runs a command published on PREFIX/command: refresh, clear,
+// pause, resume, sleep, wake, dark-mode [on|off|toggle], or screen [NAME]
+func (b *MQTTBridge) runCommand(command string) error {
+	options := b.currentOptions()
+	name, arg, _ := strings.Cut(strings.TrimSpace(command), " ")
+	arg = strings.TrimSpace(arg)
+	switch strings.ToLower(name) {
+	case "refresh":
+		requestRefresh()
+	case "clear":
+		// Blank the panel and take any pushed content down; the next
+		// refresh draws the current screen again
+		b.daemon.pushed.Clear()
+		if !options.Headless {
+			b.daemon.mu.Lock()
+			b.daemon.clearFramebuffer()
+			b.daemon.mu.Unlock()
+		}
+	case "pause":
+		live.Pause()
+	case "resume":
+		live.Resume(options.ResumeClear)
+	case "sleep":
+		live.Pause()
+		if b.daemon.power != nil {
+			b.daemon.mu.Lock()
+			err := b.daemon.power.Sleep()
+			b.daemon.mu.Unlock()
+			if err != nil {
+				return fmt.Errorf("error putting the panel to sleep: %v", err)
+			}
+		}
+	case "wake":
+		live.Resume(options.ResumeClear)
+	case "dark-mode":
+		switch strings.ToLower(arg) {
+		case "on":
+			live.SetDarkMode(true)
+		case "off":
+			live.SetDarkMode(false)
+		case "", "toggle":
+			live.ToggleDarkMode(options.DarkMode)
+		default:
+			return fmt.Errorf("dark-mode takes on, off, or toggle")
+		}
+		requestRefresh()
+	case "screen":
+		if err := validateScreen(arg, options.Screens); err != nil {
+			return err
+		}
+		live.SetScreen(arg)
+		requestRefresh()
+	default:
+		return fmt.Errorf("unknown command")
+	}
+	mqttLog.Info("Ran MQTT command", "command", command)
+	b.PublishState()
+	return nil
+}
@@ -0,0 +1,951 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/panel"
+	"trmnl-display/pkg/render"
+	"trmnl-display/pkg/rules"
+	"trmnl-display/pkg/sensor"
+)
+
+// Config holds application configuration. Besides the API key and network
+// settings it can hold a default for every run flag, listed in configFlags;
+// flags given on the command line take precedence. It stays in JSON, the
+// format config.json has always had, rather than YAML or TOML, so existing
+// files load unchanged and no parser dependency is needed.
+type Config struct {
+	APIKey string
+
+	// Where the API key is kept: file (here), secret-service, or keyctl
+	KeyStorage string `json:",omitempty"`
+
+	// Server to fetch screens from, for self-hosted (BYOS) servers, and its
+	// server-sent events or WebSocket endpoint announcing new screens
+	BaseURL string `json:",omitempty"`
+	PushURL string `json:",omitempty"`
+
+	// Display and content settings, named after their flags
+	DarkMode            *bool             `json:",omitempty"`
+	Verbose             *bool             `json:",omitempty"`
+	LogLevel            string            `json:",omitempty"`
+	LogFormat           string            `json:",omitempty"`
+	LogTarget           string            `json:",omitempty"`
+	LogFile             string            `json:",omitempty"`
+	LogMaxSize          int               `json:",omitempty"` // MB
+	LogMaxAge           string            `json:",omitempty"` // duration, e.g. "168h"
+	LogMaxFiles         int               `json:",omitempty"`
+	Headless            *bool             `json:",omitempty"`
+	HeadlessFallback    *bool             `json:",omitempty"`
+	ArchiveDir          string            `json:",omitempty"`
+	ArchiveMaxFiles     int               `json:",omitempty"`
+	ArchiveMaxAge       string            `json:",omitempty"` // duration, e.g. "720h"
+	ArchiveMaxSize      int               `json:",omitempty"` // MB
+	MaxPixels           int               `json:",omitempty"`
+	PanelSleep          *bool             `json:",omitempty"`
+	FramebufferSleep    *bool             `json:",omitempty"`
+	Prefetch            string            `json:",omitempty"` // duration, e.g. "10s"
+	Refresh             string            `json:",omitempty"` // duration, e.g. "15m"
+	RefreshMin          string            `json:",omitempty"` // duration
+	RefreshMax          string            `json:",omitempty"` // duration
+	RefreshDefault      string            `json:",omitempty"` // duration
+	RefreshJitter       int               `json:",omitempty"` // percent
+	Source              string            `json:",omitempty"`
+	SlideshowInterval   string            `json:",omitempty"` // duration, e.g. "5m"
+	Shuffle             *bool             `json:",omitempty"`
+	Screens             map[string]string `json:",omitempty"` // name to image URL
+	Rules               []string          `json:",omitempty"`
+	RulesFile           string            `json:",omitempty"`
+	AutoInvert          []string          `json:",omitempty"`
+	AutoInvertThreshold *float64          `json:",omitempty"`
+	ResumeClear         *bool             `json:",omitempty"`
+	ClearEvery          string            `json:",omitempty"` // refresh count, or "daily"
+	RefreshMode         string            `json:",omitempty"` // "quality", "fast", or "partial"
+	QualityEvery        *int              `json:",omitempty"`
+	PartialThreshold    *float64          `json:",omitempty"`
+	Grayscale           *bool             `json:",omitempty"`
+	ColorSaturation     *float64          `json:",omitempty"`
+	Dither              string            `json:",omitempty"` // kernel name
+	DitherSerpentine    *bool             `json:",omitempty"`
+	RedRule             string            `json:",omitempty"` // rule name, or thresholds
+	Luma                string            `json:",omitempty"` // named weights, or "R,G,B"
+	LumaLinear          *bool             `json:",omitempty"`
+	Sharpen             *float64          `json:",omitempty"`
+	AutoLevels          string            `json:",omitempty"` // "stretch" or "equalize"
+	DumpStages          string            `json:",omitempty"`
+	QuietHours          string            `json:",omitempty"` // window, e.g. "23:00-07:00"
+	DarkSchedule        string            `json:",omitempty"` // window, or "sunset"
+	QuietMode           string            `json:",omitempty"` // "freeze" or "blank"
+	Schedule            string            `json:",omitempty"` // cron expressions separated by semicolons
+
+	// Morning briefing
+	Morning   string   `json:",omitempty"` // window, e.g. "06:30-09:00"
+	Location  string   `json:",omitempty"` // "latitude,longitude"
+	Agenda    string   `json:",omitempty"`
+	Headlines []string `json:",omitempty"`
+
+	// Reminders that take over the screen at set times
+	Reminders []Reminder `json:",omitempty"`
+
+	// GPIO chip, settings menu buttons (same syntax as -menu-buttons),
+	// rotary encoder, refresh button, and how long buttons bounce for
+	GPIOChip       string `json:",omitempty"`
+	MenuButtons    string `json:",omitempty"`
+	MenuEncoder    string `json:",omitempty"`
+	RefreshButton  string `json:",omitempty"`
+	ButtonDebounce string `json:",omitempty"` // duration, e.g. "50ms"
+
+	// Actions bound to button presses (same syntax as -buttons)
+	Buttons string `json:",omitempty"`
+
+	// Touchscreen device, and actions bound to its regions (same syntax as
+	// -touch-regions)
+	Touch        string `json:",omitempty"`
+	TouchRegions string `json:",omitempty"`
+
+	// PIR sensor GPIO, how long after the last movement the room counts as
+	// empty, and how often to refresh while it is
+	MotionSensor      string `json:",omitempty"`
+	MotionTimeout     string `json:",omitempty"` // duration, e.g. "10m"
+	MotionIdleRefresh string `json:",omitempty"` // duration, e.g. "1h"
+
+	// Ambient light sensor, the lux below which the room counts as dim and
+	// the margin above it to count as bright again, and whether dimness
+	// turns dark mode on or stops refreshes
+	LightSensor     string   `json:",omitempty"`
+	LightThreshold  *float64 `json:",omitempty"`
+	LightHysteresis *float64 `json:",omitempty"`
+	LightDarkMode   *bool    `json:",omitempty"`
+	LightQuiet      *bool    `json:",omitempty"`
+
+	// Where the battery is read from, the charge below which it counts as
+	// low, how often to refresh while it is, and the charge at which to
+	// shut down
+	Battery           string `json:",omitempty"`
+	LowBattery        *int   `json:",omitempty"`
+	LowBatteryRefresh string `json:",omitempty"` // duration, e.g. "1h"
+	CriticalBattery   int    `json:",omitempty"`
+	ShutdownCommand   string `json:",omitempty"`
+
+	// Control API listen address, and the local socket for `ctl` commands
+	ControlAddr   string  `json:",omitempty"`
+	ControlSocket *string `json:",omitempty"`
+
+	// Serve the status and settings page on the control API
+	WebUI *bool `json:",omitempty"`
+
+	// How many recent frames the control API's gallery keeps
+	Gallery int `json:",omitempty"`
+
+	// Shared secret for the control API's refresh webhook
+	WebhookSecret string `json:",omitempty"`
+
+	// Profiling endpoint listen address
+	Pprof string `json:",omitempty"`
+
+	// MQTT broker URL, topic prefix, Home Assistant discovery prefix, and
+	// credentials
+	MQTTBroker    string  `json:",omitempty"`
+	MQTTTopic     string  `json:",omitempty"`
+	MQTTDiscovery *string `json:",omitempty"`
+	MQTTUsername  string  `json:",omitempty"`
+	MQTTPassword  string  `json:",omitempty"`
+
+	// Panel to draw on, how an SPI panel is wired, and where else to show
+	// its frames
+	Panel   string           `json:",omitempty"`
+	SPI     *panel.SPIConfig `json:",omitempty"`
+	Mirrors []string         `json:",omitempty"`
+
+	// Profiles to drive as separate displays from one daemon
+	Displays []string `json:",omitempty"`
+
+	// Proxies for API and image requests; when unset the HTTP_PROXY,
+	// HTTPS_PROXY, and NO_PROXY environment variables are used
+	HTTPProxy  string `json:",omitempty"`
+	HTTPSProxy string `json:",omitempty"`
+	NoProxy    string `json:",omitempty"`
+
+	// TLS settings for self-hosted servers with private PKI
+	CAFile             string `json:",omitempty"`
+	ClientCert         string `json:",omitempty"`
+	ClientKey          string `json:",omitempty"`
+	InsecureSkipVerify bool   `json:",omitempty"`
+
+	// SHA-256 hashes of the tokens issued to paired control API clients
+	ControlTokens []string `json:",omitempty"`
+
+	// Named sets of settings that override those above, for example an API
+	// key and base URL per frame, and the one to use without -profile
+	Profiles map[string]Config `json:",omitempty"`
+	Profile  string            `json:",omitempty"`
+}
+
+// Values for -quiet-mode
+const (
+	quietModeFreeze = "freeze"
+	quietModeBlank  = "blank"
+)
+
+// AppOptions holds command line options
+type AppOptions struct {
+	DarkMode   bool
+	LogLevel   string
+	LogFormat  string
+	LogTarget  string
+	Headless   bool
+	ArchiveDir string
+	MaxPixels  int
+	PanelSleep bool
+
+	// FramebufferSleep blanks /dev/fb0 between refreshes. It is off by
+	// default because monitors go dark while blanked; only e-paper
+	// framebuffer drivers keep their image.
+	FramebufferSleep bool
+
+	// Archive retention: frames beyond these limits are deleted, oldest
+	// first; zero means no limit
+	ArchiveMaxFiles int
+	ArchiveMaxAge   time.Duration
+	ArchiveMaxSize  int // MB
+
+	// File logs are also written to, rotated at LogMaxSize MB, keeping
+	// rotated files for LogMaxAge and at most LogMaxFiles of them
+	LogFile     string
+	LogMaxSize  int
+	LogMaxAge   time.Duration
+	LogMaxFiles int
+	// Run headless when the display cannot be set up, rather than exiting
+	HeadlessFallback bool
+
+	// How long before a refresh is due to start fetching the next frame
+	PrefetchLead time.Duration
+
+	// Fully clear the panel before every ClearEvery-th refresh, or before
+	// the first refresh of each day with ClearDaily, to clear ghosting
+	ClearEvery int
+	ClearDaily bool
+
+	// Panel refresh mode, unless a rule chooses one, and how many fast
+	// refreshes may run before a quality one (0 for no limit)
+	RefreshMode  string
+	QualityEvery int
+
+	// Fraction of the panel that may change before a partial refresh is
+	// replaced by a full one
+	PartialThreshold float64
+
+	// Draw in four dithered greys on panels that can
+	Grayscale bool
+
+	// How vivid the palette colour panels dither to is, from 0 (the inks as
+	// they look) to 1 (pure colours)
+	ColorSaturation float64
+
+	// How grey and colour frames are dithered
+	Dither render.Dither
+
+	// Which pixels black, white, and red panels show in red
+	RedRule render.RedRule
+
+	// Channel weights for converting frames to grey
+	Luma render.Luma
+
+	// Unsharp mask amount applied after scaling, 0 for none
+	Sharpen float64
+
+	// How to stretch the contrast of frames after scaling, if at all
+	AutoLevels string
+
+	// Refresh interval for the playlist instead of the server's refresh
+	// rate, bounds on the server's rate, and the interval used when the
+	// server gives none; zero means unset
+	RefreshOverride time.Duration
+	RefreshMin      time.Duration
+	RefreshMax      time.Duration
+	RefreshDefault  time.Duration
+
+	// Waits for the server, the playlist's refresh interval and retries,
+	// are randomly lengthened or shortened by up to this percentage
+	RefreshJitter int
+
+	// Server endpoint whose messages trigger a refresh
+	PushURL string
+
+	// Screens whose predominantly dark frames are inverted, and how dark
+	// (fraction of pixels) a frame must be to trigger it
+	AutoInvert          map[string]bool
+	AutoInvertThreshold float64
+
+	// Content rules and the screens they can show
+	Rules   []rules.Rule
+	Screens map[string]string
+
+	// What the panel shows while a quiet rule holds: the last frame
+	// (quietModeFreeze) or nothing (quietModeBlank)
+	QuietMode string
+
+	// When to refresh the playlist instead of the server's refresh rate
+	Schedule []*rules.CronSchedule
+
+	// Morning briefing
+	HasLocation   bool
+	Latitude      float64
+	Longitude     float64
+	AgendaFile    string
+	HeadlineFeeds []string
+
+	// GPIO buttons (next, prev, select) and rotary encoder (A, B) driving
+	// the on-device settings menu
+	GPIOChip    string
+	MenuButtons map[string]panel.GPIOPin
+	MenuEncoder []panel.GPIOPin
+
+	// GPIO button that refreshes straight away, and how long after a press
+	// further edges on a button are taken as contact bounce
+	RefreshButton  panel.GPIOPin
+	ButtonDebounce time.Duration
+
+	// Action bound to each press of each button, by GPIO and press type,
+	// including the refresh and menu buttons
+	Buttons map[panel.GPIOPin]map[Press]string
+
+	// Touchscreen to read taps from ("auto" to find one, empty for none),
+	// and the actions run by taps in each region
+	Touch        string
+	TouchRegions []TouchRegion
+
+	// PIR sensor slowing refreshes while nobody is about: the room counts
+	// as empty MotionTimeout after the last movement, and is then refreshed
+	// every MotionIdleRefresh, or once a day if zero, until someone returns
+	MotionSensor      panel.GPIOPin
+	MotionTimeout     time.Duration
+	MotionIdleRefresh time.Duration
+
+	// Ambient light sensor read for dim and bright rules, if Model is set:
+	// the room turns dim below LightThreshold lux, and bright again above
+	// LightThreshold plus LightHysteresis
+	LightSensor     sensor.LightConfig
+	LightThreshold  float64
+	LightHysteresis float64
+
+	// Battery provider (sysfs, pisugar, ina219, or none). Below LowBattery
+	// percent, while not charging, a badge is shown and refreshes slow to
+	// LowBatteryRefresh; at CriticalBattery percent the program shuts down
+	// cleanly, leaving a battery low screen up and running ShutdownCommand,
+	// if set. Zero turns each off.
+	Battery           string
+	LowBattery        int
+	LowBatteryRefresh time.Duration
+	CriticalBattery   int
+	ShutdownCommand   string
+
+	// Control API listen address, and whether to show the pairing QR code
+	// at startup
+	ControlAddr string
+	Pair        bool
+
+	// Unix socket serving the control API to `ctl` commands, off when empty
+	ControlSocket string
+
+	// Serve the status and settings page on the control API
+	WebUI bool
+
+	// Recent frames kept for the gallery page, off when zero
+	GallerySize int
+
+	// Shared secret accepted by POST /refresh, which is off when empty
+	WebhookSecret string
+
+	// Address to serve the Go profiling endpoints on
+	PprofAddr string
+
+	// MQTT broker to take images and commands from, the topic prefix, and
+	// the Home Assistant discovery prefix ("" to stay out of discovery)
+	MQTTBroker    string
+	MQTTTopic     string
+	MQTTDiscovery string
+
+	// Clear the panel when resuming after a pause
+	ResumeClear bool
+
+	// Directory for bundles of the image after each pipeline stage
+	DumpStages string
+
+	// Local slideshow shown instead of the TRMNL playlist
+	SlideshowDir      string
+	SlideshowInterval time.Duration
+	Shuffle           bool
+
+	// Config file given with -config
+	ConfigFile string
+
+	// Profiles driven as separate displays, and the display this process
+	// is when it is one of them
+	Displays []string
+	Instance string
+
+	// Panel to draw on, and its wiring when driven over SPI. With -panel
+	// auto, PanelDetected says what the panel was chosen from.
+	Panel         string
+	PanelDetected string
+	SPI           panel.SPIConfig
+
+	// Outputs that show every frame drawn on the panel as well
+	Mirrors []string
+}
+
+// parseOptions parses the run command's flags and the config file, and
+// returns the app options and config. Settings from the file are used for
+// flags that were not given on the command line.
+func parseOptions(args []string) (AppOptions, Config, error) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	configPath := fs.String("config", "", "Config file to use (default ~/.config/trmnl/config.json)")
+	profile := fs.String("profile", "", "Use this profile from the config file")
+	displays := fs.String("displays", "", "Drive each of these comma-separated profiles as a display of its own, each in a process of its own")
+	instance := fs.String("instance", "", "Run as the named one of several displays, with its own lock file, control socket, and state directory (set by -displays)")
+	darkMode := fs.Bool("d", false, "Enable dark mode (invert images)")
+	darkSchedule := fs.String("dark-schedule", "", "Turn dark mode on during this window (e.g. 22:00-07:00), or from sunset to sunrise at -location with \"sunset\"")
+	showVersion := fs.Bool("v", false, "Show version information")
+	verbose := fs.Bool("verbose", false, "Log debug messages (shorthand for -log-level debug)")
+	quiet := fs.Bool("q", false, "Only log warnings and errors (shorthand for -log-level warn)")
+	logLevel := fs.String("log-level", "", "Log level (debug, info, warn, error), optionally with per-subsystem levels (e.g. info,fetch=debug)")
+	logFormat := fs.String("log-format", logFormatText, "Log format: text or json")
+	logTarget := fs.String("log-target", logTargetAuto, "Where logs go: stdout, journald (stdout with priorities), syslog, or auto for journald when running under systemd")
+	logFilePath := fs.String("log-file", "", "Also write logs to this file, rotating it as it grows")
+	logMaxSize := fs.Int("log-max-size", defaultLogMaxSize, "Rotate the log file when it reaches this many megabytes (0 never rotates)")
+	logMaxAge := fs.Duration("log-max-age", defaultLogMaxAge, "Delete rotated log files older than this (0 keeps them)")
+	logMaxFiles := fs.Int("log-max-files", defaultLogMaxFiles, "Keep at most this many rotated log files (0 keeps them all)")
+	headless := fs.Bool("headless", false, "Archive frames instead of drawing them (no display required)")
+	headlessFallback := fs.Bool("headless-fallback", false, "Run headless instead of exiting when the display cannot be set up (not root, no framebuffer, panel not connected)")
+	archiveDir := fs.String("archive-dir", "", "Save every frame shown to this directory (headless mode always archives, by default to ~/.local/state/trmnl/archive)")
+	archiveMaxFiles := fs.Int("archive-max-files", 0, "Keep at most this many archived frames (0 keeps them all)")
+	archiveMaxAge := fs.Duration("archive-max-age", 0, "Delete archived frames older than this (0 keeps them)")
+	archiveMaxSize := fs.Int("archive-max-size", 0, "Keep the archive under this many megabytes (0 for no limit)")
+	spiSpeed := fs.Int("spi-speed", 0, "SPI clock for SPI panels in Hz, overriding SPI.SpeedHz in the config file (default 4000000); short wiring often runs at 10-20 MHz, which makes full-frame transfers much quicker")
+	panelSleep := fs.Bool("panel-sleep", true, "Put SPI panels into deep sleep between refreshes rather than only powering them off")
+	framebufferSleep := fs.Bool("framebuffer-sleep", false, "Blank the framebuffer between refreshes, for e-paper framebuffer drivers that keep their image while powered down (monitors go dark)")
+	morning := fs.String("morning", "", "Show the morning briefing during this window instead of the playlist (e.g. 06:30-09:00)")
+	quietHours := fs.String("quiet-hours", "", "Stop refreshing during this window (e.g. 23:00-07:00)")
+	schedule := fs.String("schedule", "", "Refresh the playlist at the times given by cron expressions separated by semicolons (e.g. \"*/5 9-17 * * mon-fri; 0 * * * *\"), though never sooner than the server's refresh rate")
+	quietMode := fs.String("quiet-mode", quietModeFreeze, "What quiet hours and quiet rules leave on the panel: freeze (the last frame) or blank")
+	location := fs.String("location", "", "Latitude,longitude for the morning briefing weather and sunset times")
+	agenda := fs.String("agenda", "", "iCalendar (.ics) file for the morning briefing agenda")
+	headlines := fs.String("headlines", "", "Comma-separated RSS/Atom feed URLs for the morning briefing headlines")
+	ruleText := fs.String("rules", "", "Content rules separated by semicolons (e.g. \"when weekday 07:00-09:00 show transit; when offline show clock\")")
+	rulesFile := fs.String("rules-file", "", "File with one content rule per line")
+	screens := fs.String("screen", "", "Comma-separated name=URL images that rules can show by name")
+	autoInvert := fs.String("auto-invert", "", "Comma-separated screens (or \"all\") whose mostly-dark frames are inverted")
+	autoInvertThreshold := fs.Float64("auto-invert-threshold", 0.6, "Fraction of dark pixels above which a frame is auto-inverted")
+	pushURL := fs.String("push-url", "", "Refresh when this server-sent events (http/https) or WebSocket (ws/wss) endpoint sends a message, as well as on the timer")
+	refresh := fs.Duration("refresh", 0, "Refresh the playlist this often, ignoring the server's refresh rate")
+	refreshMin := fs.Duration("refresh-min", 0, "Never refresh the playlist more often than this, whatever the server asks")
+	refreshMax := fs.Duration("refresh-max", 0, "Never wait longer than this between playlist refreshes, whatever the server asks")
+	refreshDefault := fs.Duration("refresh-default", defaultRefreshInterval, "Refresh interval when the server gives none")
+	refreshJitter := fs.Int("refresh-jitter", 0, "Randomly lengthen or shorten each wait for the server by up to this percentage, so frames set up alike do not all refresh at once")
+	refreshMode := fs.String("refresh-mode", string(panel.ModeQuality), "Panel refresh mode where the panel has a choice: quality, fast (less flashing, some ghosting), or partial (only the part that changed)")
+	qualityEvery := fs.Int("quality-every", 10, "Follow this many fast or partial refreshes with a quality one (0 never)")
+	partialThreshold := fs.Float64("partial-threshold", panel.DefaultPartialThreshold, "Refresh the whole panel instead of partially once more than this fraction of it changed")
+	grayscale := fs.Bool("grayscale", false, "Draw frames in four dithered grey levels on panels that can (waveshare-7in5-v2), for photos and charts")
+	dither := fs.String("dither", render.DefaultKernel, "Error-diffusion kernel for grey and colour frames: floyd-steinberg, atkinson, stucki, sierra, or none")
+	ditherSerpentine := fs.Bool("dither-serpentine", false, "Dither alternate rows right to left, which breaks up diagonal patterns")
+	colorSaturation := fs.Float64("color-saturation", panel.DefaultSaturation, "On colour panels, dither to the inks as they look (0), pure colours (1), or in between; higher suits charts, lower photos")
+	autoLevels := fs.String("auto-levels", "", "Stretch the contrast of frames before converting them: stretch (the brightness range to black and white) or equalize (histogram equalisation)")
+	sharpen := fs.Float64("sharpen", 0, "Sharpen frames after scaling by this amount (around 0.5 to 1), so small text stays legible (0 off)")
+	lumaLinear := fs.Bool("luma-linear", false, "Convert colour to grey in linear light (sRGB-correct) rather than on gamma-encoded values")
+	luma := fs.String("luma", render.DefaultLuma, "Channel weights for converting colour to grey: bt601, bt709, average, red, green, blue, or weights R,G,B")
+	redRule := fs.String("red-rule", render.DefaultRedRule, "On black, white, and red panels, which pixels to draw in red: red, warm (reds, oranges, and pinks), color (any colour), or thresholds like hue=330-30,saturation=0.4,value=0.25")
+	clearEvery := fs.String("clear-every", "", "Fully clear the panel after this many refreshes, or once a day with \"daily\", to clear ghosting")
+	prefetch := fs.Duration("prefetch", 10*time.Second, "Start fetching the next screen this long before the refresh is due (0 fetches on time)")
+	maxPixels := fs.Int("max-pixels", defaultMaxPixels, "Reject images with more pixels than this (0 for the 64 megapixel ceiling)")
+	gpioChip := fs.String("gpio-chip", "/dev/gpiochip0", "GPIO chip for menu buttons given as line offsets (path, name, or label)")
+	menuButtons := fs.String("menu-buttons", "", "Settings menu buttons as name=gpio pairs (e.g. next=5,prev=6,select=13)")
+	menuEncoder := fs.String("menu-encoder", "", "Rotary encoder A,B GPIOs for navigating the settings menu (e.g. 17,27)")
+	refreshButton := fs.String("refresh-button", "", "GPIO of a button that refetches and redraws the screen when pressed")
+	buttons := fs.String("buttons", "", "Actions for button presses as GPIO[:short|long|double]=action (e.g. 26=next,26:long=pause,19=info); actions are refresh, next, source, dark-mode, pause, info, clear, menu, menu-next, menu-prev, and menu-select")
+	touch := fs.String("touch", "", "Run actions on taps of this touchscreen, a /dev/input/event device, or auto to find one")
+	touchRegions := fs.String("touch-regions", defaultTouchRegions, "Actions for taps on regions of the touchscreen as region=action, first match first (regions are any, left, right, top, bottom, center, top-left, top-right, bottom-left, and bottom-right; actions as for -buttons)")
+	motionSensor := fs.String("motion-sensor", "", "GPIO of a PIR sensor: refresh as scheduled while someone is about and less often while the room is empty")
+	motionTimeout := fs.Duration("motion-timeout", 10*time.Minute, "Count the room as empty this long after the motion sensor last saw movement")
+	motionIdleRefresh := fs.Duration("motion-idle-refresh", time.Hour, "Refresh this often while the room is empty (0 waits for movement, refreshing at least daily)")
+	lightSensor := fs.String("light-sensor", "", "Ambient light sensor for dim and bright rules as MODEL[:BUS[:ADDR]], where MODEL is bh1750 or tsl2561 (e.g. bh1750, tsl2561:1:0x49)")
+	lightThreshold := fs.Float64("light-threshold", 10, "Count the room as dim below this many lux")
+	lightHysteresis := fs.Float64("light-hysteresis", 5, "Count a dim room as bright again only above -light-threshold plus this many lux")
+	lightDarkMode := fs.Bool("light-dark-mode", false, "Turn dark mode on while the room is dim")
+	lightQuiet := fs.Bool("light-quiet", false, "Stop refreshing while the room is dim, as in quiet hours")
+	batterySpec := fs.String("battery", batterySysfs, "Where to read the battery: sysfs (the kernel's power supply), pisugar[:HOST:PORT] (pisugar-server), ina219[:BUS[:ADDR]] (an INA219 on I2C), or none")
+	lowBatteryPercent := fs.Int("low-battery", 20, "Below this charge, while not charging, show a badge and refresh no more often than -low-battery-refresh (0 to turn off)")
+	lowBatteryRefresh := fs.Duration("low-battery-refresh", time.Hour, "Refresh no more often than this while the battery is low (0 to keep the schedule)")
+	criticalBattery := fs.Int("critical-battery", 0, "At or below this charge, while not charging, show a battery low screen and shut down cleanly (0 to turn off)")
+	shutdownCommand := fs.String("shutdown-command", "", "Shell command run after -critical-battery shuts down, to power the frame off (e.g. poweroff)")
+	buttonDebounce := fs.Duration("button-debounce", panel.DefaultDebounce, "Ignore further edges on a button for this long after a press")
+	controlAddr := fs.String("control-addr", "", "Serve the control API on this address (e.g. :8080)")
+	controlSocket := fs.String("control-socket", defaultControlSocket(), "Serve the control API to trmnl-display ctl on this Unix socket (empty to disable)")
+	webUI := fs.Bool("web-ui", false, "Serve a status and settings page on the control API (needs -control-addr)")
+	gallerySize := fs.Int("gallery", 0, "Keep this many recent frames for a gallery page on the control API (needs -control-addr)")
+	webhookSecret := fs.String("webhook-secret", "", "Accept POST /refresh on the control API from webhooks sending this shared secret")
+	mqttBroker := fs.String("mqtt-broker", "", "Show images and run commands published to this MQTT broker (mqtt://host[:port] or mqtts://…)")
+	mqttTopic := fs.String("mqtt-topic", defaultMQTTTopic, "MQTT topic prefix: images on PREFIX/image, commands on PREFIX/command")
+	mqttDiscovery := fs.String("mqtt-discovery", defaultMQTTDiscovery, "Home Assistant MQTT discovery prefix the frame announces itself under (empty to disable)")
+	pprofAddr := fs.String("pprof", "", "Serve Go profiling endpoints (net/http/pprof) on this address (e.g. localhost:6060)")
+	pair := fs.Bool("pair", false, "Show a QR code for pairing a phone with the control API at startup")
+	resumeClear := fs.Bool("resume-clear", false, "Clear the panel before the first refresh after resuming from a pause")
+	source := fs.String("source", screenPlaylist, "Content source: playlist for the TRMNL API, or dir:/path for a slideshow of the images in a directory")
+	slideshowInterval := fs.Duration("slideshow-interval", 5*time.Minute, "How long each slideshow image is shown")
+	shuffle := fs.Bool("shuffle", false, "Show slideshow images in random order instead of sorted by name")
+	dumpStages := fs.String("dump-stages", "", "Save the image after each pipeline stage, with its parameters, as a zip bundle in this directory")
+	panelName := fs.String("panel", panelFramebuffer, "Panel to draw on: auto (the e-paper HAT its EEPROM names), framebuffer, drm[:CARD] for a monitor through DRM/KMS, waveshare-7in5-v2, waveshare-7in5b-v2, waveshare-7in3f, waveshare-5in65f, waveshare-7in3e, or it8951 driven over SPI (wired as set by SPI in the config file), file:PATH to write each frame to a PNG, preview[:ADDR] to show frames in a browser, or term[:sixel|:blocks] to draw them in the terminal")
+	mirrorOutputs := fs.String("mirror", "", "Also show every frame on these comma-separated outputs: framebuffer or drm[:CARD] (when -panel is neither), file:PATH, preview[:ADDR], or term[:MODE]")
+	fs.Parse(args)
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if *showVersion {
+		printVersion()
+		os.Exit(0)
+	}
+
+	configFileFlag = *configPath
+	config, err := readConfigIfExists()
+	if err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	// The profile overrides the rest of the file, the environment overrides
+	// the file, and flags override everything
+	if explicit["profile"] {
+		config.Profile = *profile
+	} else if name, ok := os.LookupEnv(configEnvName("Profile")); ok {
+		config.Profile = name
+	}
+	config, err = selectProfile(config, config.Profile)
+	if err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	config, err = applyConfigEnv(config)
+	if err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	if err := validateKeyStorage(config.KeyStorage); err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	if config.APIKey == "" && usesKeyring(config.KeyStorage) {
+		config.APIKey, err = keyringGet(config.KeyStorage, apiKeyName(config.Profile))
+		if err != nil {
+			return AppOptions{}, Config{}, err
+		}
+	}
+	values, err := configFlagValues(config)
+	if err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	for name, value := range values {
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error in config file: invalid value %q for %s: %v", value, configFlagField(name), err)
+		}
+	}
+
+	options := AppOptions{
+		DarkMode:    *darkMode,
+		LogLevel:    logLevelFor(*logLevel, *verbose, *quiet),
+		LogFormat:   *logFormat,
+		LogTarget:   *logTarget,
+		LogFile:     *logFilePath,
+		LogMaxSize:  *logMaxSize,
+		LogMaxAge:   *logMaxAge,
+		LogMaxFiles: *logMaxFiles,
+		Headless:    *headless,
+		ArchiveDir:  *archiveDir,
+		MaxPixels:   *maxPixels,
+		PanelSleep:  *panelSleep,
+		AgendaFile:  *agenda,
+
+		FramebufferSleep: *framebufferSleep,
+
+		ArchiveMaxFiles: *archiveMaxFiles,
+		ArchiveMaxAge:   *archiveMaxAge,
+		ArchiveMaxSize:  *archiveMaxSize,
+
+		HeadlessFallback: *headlessFallback,
+
+		RefreshOverride: *refresh,
+		RefreshMin:      *refreshMin,
+		RefreshMax:      *refreshMax,
+		RefreshDefault:  *refreshDefault,
+		RefreshJitter:   *refreshJitter,
+		RefreshMode:     *refreshMode,
+		QualityEvery:    *qualityEvery,
+
+		PartialThreshold: *partialThreshold,
+		Grayscale:        *grayscale,
+		ColorSaturation:  *colorSaturation,
+		Sharpen:          *sharpen,
+		AutoLevels:       *autoLevels,
+
+		PrefetchLead:  *prefetch,
+		PushURL:       *pushURL,
+		GPIOChip:      *gpioChip,
+		ControlAddr:   *controlAddr,
+		ControlSocket: *controlSocket,
+		Pair:          *pair,
+		PprofAddr:     *pprofAddr,
+		MQTTBroker:    *mqttBroker,
+		MQTTTopic:     *mqttTopic,
+		MQTTDiscovery: *mqttDiscovery,
+		WebUI:         *webUI,
+		GallerySize:   *gallerySize,
+		WebhookSecret: *webhookSecret,
+		ResumeClear:   *resumeClear,
+		QuietMode:     *quietMode,
+		DumpStages:    *dumpStages,
+
+		SlideshowInterval: *slideshowInterval,
+		Shuffle:           *shuffle,
+		ConfigFile:        *configPath,
+		Panel:             *panelName,
+
+		AutoInvert:          make(map[string]bool),
+		AutoInvertThreshold: *autoInvertThreshold,
+	}
+
+	for _, screen := range strings.Split(*autoInvert, ",") {
+		if screen = strings.TrimSpace(screen); screen != "" {
+			options.AutoInvert[screen] = true
+		}
+	}
+
+	if options.Panel == panelAuto {
+		options.Panel, options.PanelDetected = detectPanel(readDeviceTreeString("hat/product"), readDeviceTreeString("hat/vendor"))
+	}
+	if err := validatePanel(options.Panel); err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	for _, name := range strings.Split(*mirrorOutputs, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := validateMirror(name, options.Panel); err != nil {
+			return AppOptions{}, Config{}, err
+		}
+		options.Mirrors = append(options.Mirrors, name)
+	}
+	if options.PushURL != "" {
+		if err := validatePushURL(options.PushURL); err != nil {
+			return AppOptions{}, Config{}, err
+		}
+	}
+	if options.MQTTBroker != "" {
+		if err := validateMQTTBroker(options.MQTTBroker); err != nil {
+			return AppOptions{}, Config{}, err
+		}
+		if options.MQTTTopic == "" {
+			return AppOptions{}, Config{}, fmt.Errorf("-mqtt-topic must not be empty")
+		}
+	}
+	if err := validateLogging(options.LogLevel, options.LogFormat, options.LogTarget); err != nil {
+		return AppOptions{}, Config{}, err
+	}
+	if config.SPI != nil {
+		options.SPI = *config.SPI
+	}
+	if *spiSpeed != 0 {
+		options.SPI.SpeedHz = *spiSpeed
+	}
+	options.SPI = options.SPI.WithDefaults()
+	if err := validateSPI(options.SPI); err != nil {
+		return AppOptions{}, Config{}, err
+	}
+
+	options.SlideshowDir, err = parseSource(*source)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -source: %v", err)
+	}
+	if options.SlideshowInterval <= 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-slideshow-interval must be positive")
+	}
+
+	options.Screens, err = parseScreens(*screens)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -screen: %v", err)
+	}
+
+	// The morning window is shorthand for a rule showing the briefing
+	if *morning != "" {
+		window, err := rules.ParseTimeWindow(*morning)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -morning: %v", err)
+		}
+		options.Rules = append(options.Rules, rules.Rule{
+			Text:   "when " + window.String() + " show " + screenMorning,
+			Window: window,
+			Show:   screenMorning,
+		})
+	}
+	// So is the dark schedule for a rule turning dark mode on
+	if *darkSchedule != "" {
+		condition := "night"
+		if *darkSchedule != "sunset" {
+			window, err := rules.ParseTimeWindow(*darkSchedule)
+			if err != nil {
+				return AppOptions{}, Config{}, fmt.Errorf("error parsing -dark-schedule: expected a time window or sunset: %v", err)
+			}
+			condition = window.String()
+		}
+		rule, err := rules.Parse("when " + condition + " dark on")
+		if err != nil {
+			return AppOptions{}, Config{}, err
+		}
+		options.Rules = append(options.Rules, rule)
+	}
+	// So are quiet hours for a quiet rule
+	if *quietHours != "" {
+		window, err := rules.ParseTimeWindow(*quietHours)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -quiet-hours: %v", err)
+		}
+		options.Rules = append(options.Rules, rules.Rule{
+			Text:   "when " + window.String() + " quiet",
+			Window: window,
+			Quiet:  true,
+		})
+	}
+	// And the light sensor options for dim rules
+	if *lightSensor != "" {
+		config, err := sensor.ParseLightConfig(*lightSensor)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -light-sensor: %v", err)
+		}
+		options.LightSensor = config
+	}
+	if *lightThreshold < 0 || *lightHysteresis < 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-light-threshold and -light-hysteresis must not be negative")
+	}
+	options.LightThreshold = *lightThreshold
+	options.LightHysteresis = *lightHysteresis
+	if (*lightDarkMode || *lightQuiet) && *lightSensor == "" {
+		return AppOptions{}, Config{}, fmt.Errorf("-light-dark-mode and -light-quiet need -light-sensor")
+	}
+	for _, lightRule := range []struct {
+		set  bool
+		text string
+	}{{*lightDarkMode, "when dim dark on"}, {*lightQuiet, "when dim quiet"}} {
+		if !lightRule.set {
+			continue
+		}
+		rule, err := rules.Parse(lightRule.text)
+		if err != nil {
+			return AppOptions{}, Config{}, err
+		}
+		options.Rules = append(options.Rules, rule)
+	}
+	if err := validateBattery(*batterySpec); err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -battery: %v", err)
+	}
+	if *lowBatteryPercent < 0 || *lowBatteryPercent > 100 || *criticalBattery < 0 || *criticalBattery > 100 {
+		return AppOptions{}, Config{}, fmt.Errorf("-low-battery and -critical-battery must be percentages from 0 to 100")
+	}
+	if *lowBatteryRefresh < 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-low-battery-refresh must not be negative")
+	}
+	options.Battery = *batterySpec
+	options.LowBattery = *lowBatteryPercent
+	options.LowBatteryRefresh = *lowBatteryRefresh
+	options.CriticalBattery = *criticalBattery
+	if *shutdownCommand != "" && options.CriticalBattery == 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-shutdown-command needs -critical-battery")
+	}
+	options.ShutdownCommand = *shutdownCommand
+	if options.QuietMode != quietModeFreeze && options.QuietMode != quietModeBlank {
+		return AppOptions{}, Config{}, fmt.Errorf("-quiet-mode must be %s or %s", quietModeFreeze, quietModeBlank)
+	}
+	if options.RefreshOverride < 0 || options.RefreshMin < 0 || options.RefreshMax < 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-refresh, -refresh-min, and -refresh-max cannot be negative")
+	}
+	if options.RefreshDefault <= 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-refresh-default must be positive")
+	}
+	if options.RefreshMax > 0 && options.RefreshMin > options.RefreshMax {
+		return AppOptions{}, Config{}, fmt.Errorf("-refresh-min cannot be longer than -refresh-max")
+	}
+	if options.RefreshJitter < 0 || options.RefreshJitter > 50 {
+		return AppOptions{}, Config{}, fmt.Errorf("-refresh-jitter must be between 0 and 50")
+	}
+	if err := panel.ValidateMode(options.RefreshMode); err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -refresh-mode: %v", err)
+	}
+	if options.PartialThreshold < 0 || options.PartialThreshold > 1 {
+		return AppOptions{}, Config{}, fmt.Errorf("-partial-threshold must be between 0 and 1")
+	}
+	if err := render.ValidateLevels(options.AutoLevels); err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -auto-levels: %v", err)
+	}
+	if options.Sharpen < 0 || options.Sharpen > 5 {
+		return AppOptions{}, Config{}, fmt.Errorf("-sharpen must be between 0 and 5")
+	}
+	if options.ColorSaturation < 0 || options.ColorSaturation > 1 {
+		return AppOptions{}, Config{}, fmt.Errorf("-color-saturation must be between 0 and 1")
+	}
+	if options.QualityEvery < 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-quality-every cannot be negative")
+	}
+	if *clearEvery == "daily" {
+		options.ClearDaily = true
+	} else if *clearEvery != "" {
+		options.ClearEvery, err = strconv.Atoi(*clearEvery)
+		if err != nil || options.ClearEvery <= 0 {
+			return AppOptions{}, Config{}, fmt.Errorf("-clear-every must be a number of refreshes or daily")
+		}
+	}
+	options.Luma, err = render.ParseLuma(*luma)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -luma: %v", err)
+	}
+	options.Luma.Linear = *lumaLinear
+	options.Dither.Kernel, err = render.ParseKernel(*dither)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -dither: %v", err)
+	}
+	options.Dither.Serpentine = *ditherSerpentine
+	options.RedRule, err = render.ParseRedRule(*redRule)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -red-rule: %v", err)
+	}
+	options.Schedule, err = rules.ParseSchedule(*schedule)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -schedule: %v", err)
+	}
+	if *ruleText != "" {
+		parsed, err := rules.ParseList(*ruleText)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -rules: %v", err)
+		}
+		options.Rules = append(options.Rules, parsed...)
+	}
+	if *rulesFile != "" {
+		parsed, err := rules.LoadFile(*rulesFile)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -rules-file: %v", err)
+		}
+		options.Rules = append(options.Rules, parsed...)
+	}
+	// Rules from the file come after those from the command line, so the
+	// command line wins where both match
+	for _, text := range config.Rules {
+		rule, err := rules.Parse(text)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error in config file rule %q: %v", text, err)
+		}
+		options.Rules = append(options.Rules, rule)
+	}
+	for _, rule := range options.Rules {
+		if err := validateScreen(rule.Show, options.Screens); err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error in rule %q: %v", rule.Text, err)
+		}
+	}
+	if *location != "" {
+		lat, lon, err := parseLocation(*location)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -location: %v", err)
+		}
+		options.HasLocation = true
+		options.Latitude = lat
+		options.Longitude = lon
+	}
+	for _, rule := range options.Rules {
+		if rule.Night != nil && !options.HasLocation {
+			return AppOptions{}, Config{}, fmt.Errorf("rule %q needs -location to know when the sun sets", rule.Text)
+		}
+	}
+	options.Instance = *instance
+	if options.Instance != "" {
+		if err := validateDisplayName(options.Instance); err != nil {
+			return AppOptions{}, Config{}, err
+		}
+		if !explicit["control-socket"] {
+			options.ControlSocket = instanceSocket(options.ControlSocket, options.Instance)
+		}
+	}
+	for _, name := range strings.Split(*displays, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if err := validateDisplayName(name); err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -displays: %v", err)
+		}
+		if _, ok := config.Profiles[name]; !ok {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -displays: no profile named %q in the config file", name)
+		}
+		options.Displays = append(options.Displays, name)
+	}
+	for _, feedURL := range strings.Split(*headlines, ",") {
+		if feedURL = strings.TrimSpace(feedURL); feedURL != "" {
+			options.HeadlineFeeds = append(options.HeadlineFeeds, feedURL)
+		}
+	}
+	options.MenuButtons, err = parseMenuButtons(*menuButtons)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -menu-buttons: %v", err)
+	}
+	options.MenuEncoder, err = parseGPIOList(*menuEncoder)
+	if err != nil || (len(options.MenuEncoder) != 0 && len(options.MenuEncoder) != 2) {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -menu-encoder: expected two GPIOs A,B")
+	}
+	if pins, err := parseGPIOList(*refreshButton); err != nil || len(pins) > 1 {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -refresh-button: expected one GPIO line offset or name")
+	} else if len(pins) == 1 {
+		options.RefreshButton = pins[0]
+	}
+	options.Buttons, err = parseButtons(*buttons)
+	if err != nil {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -buttons: %v", err)
+	}
+	if options.RefreshButton != "" {
+		if err := bindButton(options.Buttons, options.RefreshButton, PressShort, "refresh"); err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -refresh-button: %v", err)
+		}
+	}
+	if pins, err := parseGPIOList(*motionSensor); err != nil || len(pins) > 1 {
+		return AppOptions{}, Config{}, fmt.Errorf("error parsing -motion-sensor: expected one GPIO line offset or name")
+	} else if len(pins) == 1 {
+		options.MotionSensor = pins[0]
+	}
+	if *motionTimeout <= 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-motion-timeout must be positive")
+	}
+	if *motionIdleRefresh < 0 {
+		return AppOptions{}, Config{}, fmt.Errorf("-motion-idle-refresh must not be negative")
+	}
+	options.MotionTimeout = *motionTimeout
+	options.MotionIdleRefresh = *motionIdleRefresh
+	options.Touch = *touch
+	if options.Touch != "" {
+		options.TouchRegions, err = parseTouchRegions(*touchRegions)
+		if err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -touch-regions: %v", err)
+		}
+	}
+	for name, pin := range options.MenuButtons {
+		if name != "next" && name != "prev" && name != "select" {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -menu-buttons: unknown menu button %q (expected next, prev, or select)", name)
+		}
+		if err := bindButton(options.Buttons, pin, PressShort, "menu-"+name); err != nil {
+			return AppOptions{}, Config{}, fmt.Errorf("error parsing -menu-buttons: %v", err)
+		}
+	}
+	if *buttonDebounce <= 0 || *buttonDebounce > time.Second {
+		return AppOptions{}, Config{}, fmt.Errorf("-button-debounce must be more than 0 and at most 1s")
+	}
+	options.ButtonDebounce = *buttonDebounce
+
+	return options, config, nil
+}
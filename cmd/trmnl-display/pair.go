@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+
+	"trmnl-display/pkg/compose"
+	"trmnl-display/pkg/qr"
+)
+
+// pairFrame shows a QR code that pairs a phone with the control API. Each
+// frame carries a fresh one-time token and is redrawn when it expires.
+func (d *Daemon) pairFrame(options AppOptions) (*Frame, error) {
+	if d.control == nil {
+		return nil, fmt.Errorf("pairing needs the control API (-control-addr)")
+	}
+	token, err := d.control.IssuePairingToken()
+	if err != nil {
+		return nil, err
+	}
+	pairURL := d.control.PairingURL(token)
+	code, err := qr.Encode(pairURL)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	// QR code on the left, with the four-module quiet zone the standard requires
+	scale := (defaultFrameHeight - 40) / (code.Size + 8)
+	origin := image.Pt(20+4*scale, (defaultFrameHeight-code.Size*scale)/2)
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Dark(x, y) {
+				module := origin.Add(image.Pt(x*scale, y*scale))
+				c.FillRect(image.Rectangle{Min: module, Max: module.Add(image.Pt(scale, scale))}, color.Black)
+			}
+		}
+	}
+
+	left := 20 + (code.Size+8)*scale + 20
+	c.DrawZone(compose.Zone{
+		Rect:  image.Rect(left, 80, defaultFrameWidth-20, defaultFrameHeight-20),
+		Title: "Pair your phone",
+		Lines: []string{
+			"Scan the code with your phone's camera to control this frame.",
+			fmt.Sprintf("The code works once and expires in %d minutes.", int(pairingTokenLifetime.Minutes())),
+			"Control API: " + d.control.BaseURL(),
+		},
+	}, 32, 22)
+
+	return &Frame{Image: c.Frame, Refresh: pairingTokenLifetime}, nil
+}
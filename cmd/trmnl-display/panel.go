@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Framebuffer blanking ioctl and levels from linux/fb.h
+const (
+	fbioBlank        = 0x4611
+	fbBlankUnblank   = 0
+	fbBlankPowerdown = 4
+)
+
+// PanelPower puts the display into its low-power state between refreshes and
+// keeps track of how long it takes to wake up again
+type PanelPower struct {
+	mu          sync.Mutex
+	Device      string
+	Asleep      bool
+	Unsupported bool
+	WakeLatency time.Duration
+}
+
+// NewPanelPower creates power control for the given framebuffer device
+func NewPanelPower(device string) *PanelPower {
+	return &PanelPower{
+		Device: device,
+	}
+}
+
+// Sleep powers the panel down. Drivers without blanking support are detected
+// on the first attempt and skipped afterwards.
+func (p *PanelPower) Sleep() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Unsupported || p.Asleep {
+		return nil
+	}
+	if err := p.blank(fbBlankPowerdown); err != nil {
+		return err
+	}
+	p.Asleep = true
+	return nil
+}
+
+// framebufferPower returns power control for /dev/fb0 if -framebuffer-sleep
+// asks for it, or nil. Monitors go dark while blanked, so only e-paper
+// framebuffer drivers, which keep their image, should be put to sleep.
+func framebufferPower(options AppOptions) *PanelPower {
+	if !options.FramebufferSleep {
+		return nil
+	}
+	return NewPanelPower("/dev/fb0")
+}
+
+// Wake powers the panel back up and records how long it took
+func (p *PanelPower) Wake() error {
+	_, err := p.wake()
+	return err
+}
+
+// wake powers the panel back up and returns how long it took, or zero if it
+// was already awake
+func (p *PanelPower) wake() (time.Duration, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.Unsupported || !p.Asleep {
+		return 0, nil
+	}
+	start := time.Now()
+	if err := p.blank(fbBlankUnblank); err != nil {
+		return 0, err
+	}
+	p.WakeLatency = time.Since(start)
+	p.Asleep = false
+	return p.WakeLatency, nil
+}
+
+// IsAsleep reports whether the panel is powered down
+func (p *PanelPower) IsAsleep() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Asleep
+}
+
+// WakeBy keeps the panel asleep until just early enough, given the measured
+// wake latency, for it to be ready at deadline. The panel is left asleep if
+// ctx is cancelled first.
+func (p *PanelPower) WakeBy(ctx context.Context, deadline time.Time) {
+	p.mu.Lock()
+	asleep, latency := p.Asleep, p.WakeLatency
+	p.mu.Unlock()
+	if !asleep {
+		return
+	}
+
+	timer := time.NewTimer(time.Until(deadline.Add(-latency)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return
+	}
+	latency, err := p.wake()
+	if err != nil {
+		powerLog.Warn("Failed to wake panel", "err", err)
+		return
+	}
+	powerLog.Debug("Panel woke", "latency", latency)
+}
+
+// blank issues FBIOBLANK with the given level on the framebuffer device
+func (p *PanelPower) blank(level int) error {
+	fb, err := os.OpenFile(p.Device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", p.Device, err)
+	}
+	defer fb.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fb.Fd(), fbioBlank, uintptr(level))
+	if errno == syscall.ENOTTY || errno == syscall.EINVAL {
+		powerLog.Warn("Panel sleep is not supported by the driver, leaving it powered", "device", p.Device)
+		p.Unsupported = true
+		return nil
+	}
+	if errno != 0 {
+		return fmt.Errorf("FBIOBLANK ioctl error: %v", errno)
+	}
+	return nil
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"trmnl-display/pkg/history"
+	"trmnl-display/pkg/panel"
+	"trmnl-display/pkg/rules"
+)
+
+// How long to wait before trying again after a failed fetch or display
+const retryInterval = 60 * time.Second
+
+// Refresh interval used when the API does not provide one, unless
+// -refresh-default says otherwise
+const defaultRefreshInterval = 60 * time.Second
+
+// How often quiet rules are re-checked when no time window ends them sooner
+const quietCheckInterval = 15 * time.Minute
+
+// Frame is a screen that has been fetched and decoded, ready to be shown. A
+// frame without an image leaves the current screen in place.
+type Frame struct {
+	Image   image.Image
+	Refresh time.Duration
+
+	// The time the frame was prepared for, which Refresh counts from: when
+	// it was fetched, or for a prefetched frame, when it is due on screen
+	At time.Time
+
+	// Refresh is how often to ask the server again, which -refresh-jitter
+	// spreads out, rather than a local deadline such as the next minute
+	Poll bool
+
+	// Which screen the frame shows and how long it took to prepare
+	Screen    string
+	FetchTime time.Duration
+
+	// The image file as fetched, for screens that are not rendered locally
+	Source []byte
+
+	// Images from each pipeline stage, when -dump-stages is set
+	Stages *StageDump
+
+	// Time spent in each stage, completed as the frame is drawn
+	Timings *StageTimings
+
+	// Panel refresh mode the rules chose, if any
+	Mode string
+}
+
+// runDisplayLoop shows frames until ctx is cancelled. The next frame is
+// fetched and decoded ahead of time, PrefetchLead before it is due, so the
+// panel can be updated as soon as the refresh interval elapses; it is
+// prepared for the time it is due, so rules and the clock are evaluated for
+// when it will be on screen. The config file is re-read before the next
+// fetch after a SIGHUP.
+func (d *Daemon) runDisplayLoop(ctx context.Context, tmpDir string, config Config, options AppOptions) {
+	frame := d.fetchFrameWithRetry(ctx, tmpDir, config, options, time.Now())
+	blanked := false // the panel was blanked for the current quiet period
+	// The panel is cleared at startup, which counts as the last full clear
+	sinceClear, lastClear := 0, time.Now()
+	sinceQuality := 0 // fast and partial refreshes since the last quality one
+	for frame != nil {
+		// Hold the current screen while paused, then start over with a
+		// fresh frame
+		if d.waitWhilePaused(ctx, options) {
+			frame = d.fetchFrameWithRetry(ctx, tmpDir, config, options, time.Now())
+			continue
+		}
+
+		refresh := frame.Refresh
+		if frame.Poll {
+			refresh = withJitter(refresh, options.RefreshJitter)
+		}
+		due := frame.At.Add(refresh)
+		if idle := motionDue(due, time.Now(), options); idle.After(due) {
+			fetchLog.Debug("Nobody about, refreshing less often", "next", idle.Format("15:04:05"))
+			due = idle
+		}
+		if slow := batteryDue(due, time.Now(), options); slow.After(due) {
+			fetchLog.Debug("Battery low, refreshing less often", "next", slow.Format("15:04:05"))
+			due = slow
+		}
+		if frame.Image == nil {
+			if options.QuietMode == quietModeBlank && !blanked {
+				fetchLog.Info("Quiet rule active, blanking the panel")
+				d.blankPanel(options)
+				blanked = true
+			} else {
+				fetchLog.Debug("Quiet rule active, leaving the current screen", "for", frame.Refresh.Round(time.Second))
+			}
+			sdNotify("STATUS=Quiet until " + due.Format("15:04:05"))
+		} else {
+			blanked = false
+			if !options.Headless && fullClearDue(options, sinceClear, lastClear, time.Now()) {
+				displayLog.Info("Clearing the panel fully against ghosting", "refreshes", sinceClear)
+				d.blankPanel(options)
+				sinceClear, lastClear = 0, time.Now()
+			}
+			drawOptions := options
+			drawOptions.RefreshMode = refreshMode(frame, options, sinceQuality)
+			start := time.Now()
+			d.mu.Lock()
+			d.lastSource = frame.Source
+			d.mu.Unlock()
+			done := watchdog.Busy()
+			err := d.presentFrame(frame.Image, frame.Stages, frame.Timings, drawOptions)
+			done()
+			health.Displayed(err)
+			if paced := d.pacedDue(due, time.Now()); err == nil && paced.After(due) {
+				displayLog.Debug("Letting the panel rest before the next refresh", "until", paced.Format("15:04:05"))
+				due = paced
+			}
+			if frame.Stages != nil {
+				if path, err := frame.Stages.Write(); err != nil {
+					displayLog.Error("Error saving pipeline stages", "err", err)
+				} else {
+					displayLog.Debug("Saved pipeline stages", "path", path)
+				}
+			}
+			sdNotify(fmt.Sprintf("STATUS=Showing %s, next refresh at %s", frame.Screen, due.Format("15:04:05")))
+			record := history.Record{
+				Time:      start,
+				Screen:    frame.Screen,
+				FetchMs:   frame.FetchTime.Milliseconds(),
+				DisplayMs: time.Since(start).Milliseconds(),
+				Hash:      imageHash(frame.Image),
+				StagesMs:  frame.Timings.Millis(),
+			}
+			stageMetrics.Observe(frame.Timings)
+			displayLog.Debug("Cycle timings", frame.Timings.logAttrs()...)
+			if err != nil {
+				displayLog.Error("Error displaying image", "err", err)
+				sdNotify("STATUS=Error displaying image: " + err.Error())
+				record.Error = err.Error()
+				due = time.Now().Add(withJitter(retryInterval, options.RefreshJitter))
+			} else {
+				sinceClear++
+				if drawOptions.RefreshMode != string(panel.ModeQuality) {
+					sinceQuality++
+				} else {
+					sinceQuality = 0
+				}
+				displayLog.Info("Refreshed", "screen", frame.Screen, "fetch_ms", record.FetchMs, "display_ms", record.DisplayMs, "next", due.Format("15:04:05"))
+				if mqttBridge != nil {
+					mqttBridge.Refreshed(frame.Screen)
+				}
+				if gallery != nil {
+					gallery.Add(frame.Image, start)
+				}
+			}
+			d.recordCycle(record)
+		}
+
+		health.Scheduled(due)
+
+		if d.power != nil {
+			if err := d.power.Sleep(); err != nil {
+				powerLog.Warn("Failed to put panel to sleep", "err", err)
+			}
+		}
+
+		// Start preparing the next frame shortly before it is due, or right
+		// away if a refresh is requested
+		if sleepUntil(ctx, due.Add(-options.PrefetchLead)) {
+			due = time.Now()
+		}
+		if reloadRequested() {
+			config, options = d.reloadConfig(config, options)
+		}
+		if live.Paused() {
+			continue
+		}
+		frame = d.fetchFrameWithRetry(ctx, tmpDir, config, options, due)
+		if frame == nil {
+			return
+		}
+		if time.Until(due) > 0 {
+			fetchLog.Debug("Next frame ready ahead of refresh", "ahead", time.Until(due).Round(time.Millisecond))
+		}
+
+		if d.power != nil {
+			d.power.WakeBy(ctx, due)
+		}
+		sleepUntil(ctx, due)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// refreshMode chooses how the panel refreshes frame: in the mode the rules
+// chose, or -refresh-mode, except that every -quality-every fast or partial
+// refreshes are followed by a quality one to clear the ghosting they leave
+func refreshMode(frame *Frame, options AppOptions, sinceQuality int) string {
+	mode := options.RefreshMode
+	if frame.Mode != "" {
+		mode = frame.Mode
+	}
+	if mode != string(panel.ModeQuality) && options.QualityEvery > 0 && sinceQuality >= options.QualityEvery {
+		return string(panel.ModeQuality)
+	}
+	return mode
+}
+
+// fullClearDue reports whether the panel should be cleared before the next
+// frame, given the refreshes since the last full clear and when it was:
+// after -clear-every refreshes, or on the first refresh of a new day
+func fullClearDue(options AppOptions, sinceClear int, lastClear, now time.Time) bool {
+	if options.ClearDaily {
+		y1, m1, d1 := lastClear.Date()
+		y2, m2, d2 := now.Date()
+		return y1 != y2 || m1 != m2 || d1 != d2
+	}
+	return options.ClearEvery > 0 && sinceClear >= options.ClearEvery
+}
+
+// blankPanel clears the panel for a quiet period or a periodic full clear.
+// The last frame is kept, so the menu can still restore it.
+func (d *Daemon) blankPanel(options AppOptions) {
+	if options.Headless {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if menu != nil && menu.IsOpen() {
+		return
+	}
+	d.clearFramebuffer()
+}
+
+// recordCycle adds a cycle to the history and the stats
+func (d *Daemon) recordCycle(record history.Record) {
+	if d.history != nil {
+		logged := record
+		if percent, ok := readBatteryPercent(); ok {
+			logged.Battery = &percent
+		}
+		if err := d.history.Append(logged); err != nil {
+			mainLog.Error("Error recording history", "err", err)
+		}
+	}
+	if statsStore != nil {
+		statsStore.Record(record)
+	}
+}
+
+// fetchFrameWithRetry fetches the next frame, to be shown at at, retrying
+// until it succeeds. Frames fetched after at has passed are prepared for the
+// time they were fetched. It returns nil once ctx is cancelled.
+func (d *Daemon) fetchFrameWithRetry(ctx context.Context, tmpDir string, config Config, options AppOptions, at time.Time) *Frame {
+	for {
+		start := time.Now()
+		if at.Before(start) {
+			at = start
+		}
+		done := watchdog.Busy()
+		frame, err := d.fetchFrame(ctx, tmpDir, config, options, at)
+		done()
+		if ctx.Err() != nil {
+			return nil
+		}
+		health.Fetched(err)
+		if err == nil {
+			frame.FetchTime = time.Since(start)
+			return frame
+		}
+		fetchLog.Error("Error preparing next screen", "err", err)
+		sdNotify("STATUS=Error preparing next screen: " + err.Error())
+		d.recordCycle(history.Record{
+			Time:    start,
+			FetchMs: time.Since(start).Milliseconds(),
+			Error:   err.Error(),
+		})
+		sleepUntil(ctx, time.Now().Add(withJitter(retryInterval, options.RefreshJitter)))
+	}
+}
+
+// withJitter randomly lengthens or shortens d by up to percent percent, so
+// a fleet of frames started together drifts apart instead of calling the
+// API in the same second
+func withJitter(d time.Duration, percent int) time.Duration {
+	spread := int64(d) * int64(percent) / 100
+	if spread <= 0 {
+		return d
+	}
+	return d + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
+
+// fetchFrame evaluates the content rules for the time at, when the frame is
+// to be shown, and produces the screen they select, falling back to the
+// rules' offline screen if it cannot be fetched
+func (d *Daemon) fetchFrame(ctx context.Context, tmpDir string, config Config, options AppOptions, at time.Time) (frame *Frame, err error) {
+	// Use defer and recover to handle any panics
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("recovered from panic: %v", r)
+		}
+	}()
+
+	stages := newStageDump(options)
+	ctx = withStageDump(ctx, stages)
+	timings := &StageTimings{}
+	ctx = withStageTimings(ctx, timings)
+	defer func() {
+		if frame != nil {
+			frame.Timings = timings
+			frame.At = at
+		}
+	}()
+
+	state := rules.State{
+		Now:    at,
+		Online: true,
+	}
+	state.Battery, state.HasBattery = readBatteryPercent()
+	var location *rules.Location
+	if options.HasLocation {
+		location = &rules.Location{Latitude: options.Latitude, Longitude: options.Longitude}
+		state.HasSun = true
+		state.Night = rules.IsNight(state.Now, options.Latitude, options.Longitude)
+	}
+	if light != nil {
+		state.Dim, state.HasLight = light.Dim()
+	}
+
+	decision := rules.Evaluate(options.Rules, state)
+	if decision.Dark != nil {
+		options.DarkMode = *decision.Dark
+	}
+	// Choices made on the device override the rules
+	options.DarkMode = live.DarkMode(options.DarkMode)
+
+	// Content pushed through the control API, then a reminder that is due,
+	// takes over the screen. Pushed images were put in dark mode as they
+	// were loaded.
+	if img, until, ok := d.pushed.Active(state.Now); ok {
+		return pushedFrame(img, until, state.Now), nil
+	}
+	if reminder, until, ok := reminders.Active(state.Now); ok {
+		frame, err := reminderFrame(reminder, until, state.Now)
+		if err != nil {
+			return nil, err
+		}
+		frame.Image = applyDarkMode(frame.Image, options)
+		return frame, nil
+	}
+
+	if screen := live.Screen(); screen != "" {
+		decision.Show = screen
+		decision.Quiet = false
+	}
+	// A slideshow replaces the playlist as the default content
+	if decision.Show == "" && options.SlideshowDir != "" {
+		decision.Show = screenSlideshow
+	}
+
+	if decision.Quiet {
+		frame = &Frame{Refresh: quietCheckInterval}
+	} else {
+		frame, err = d.showFrame(ctx, decision.Show, tmpDir, config, options, at)
+		if err != nil {
+			// Fail over to whatever the rules want shown while offline
+			state.Online = false
+			failover := rules.Evaluate(options.Rules, state)
+			if failover.Show == decision.Show {
+				return nil, err
+			}
+			fetchLog.Warn("Error fetching screen, showing another instead", "screen", screenName(decision.Show), "instead", screenName(failover.Show), "err", err)
+			decision = failover
+			frame, err = d.showFrame(ctx, decision.Show, tmpDir, config, options, at)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	frame.Screen = screenName(decision.Show)
+	frame.Mode = decision.Mode
+	if frame.Image != nil {
+		// Locally rendered screens have no download and decode stages
+		if stages.Empty() {
+			stages.Record("render", frame.Image, map[string]interface{}{"screen": frame.Screen})
+		}
+		frame.Image = applyAutoInvert(frame.Screen, frame.Image, options)
+		stages.Record("auto-invert", frame.Image, map[string]interface{}{
+			"enabled":   options.AutoInvert[frame.Screen] || options.AutoInvert["all"],
+			"threshold": options.AutoInvertThreshold,
+			"inverted":  autoInverted[frame.Screen],
+		})
+		frame.Image = applyDarkMode(frame.Image, options)
+		stages.Record("dark-mode", frame.Image, map[string]interface{}{"enabled": options.DarkMode})
+		if stages != nil {
+			stages.Screen = frame.Screen
+			frame.Stages = stages
+		}
+	}
+
+	// The refresh schedule overrides the playlist's refresh rate, but never
+	// asks the server again sooner than its rate allows
+	if !decision.Quiet && screenName(decision.Show) == screenPlaylist {
+		if untilNext := rules.UntilScheduled(options.Schedule, at); untilNext > frame.Refresh {
+			frame.Refresh, frame.Poll = untilNext, false
+		}
+	}
+	if decision.Interval > 0 {
+		frame.Refresh = decision.Interval
+	}
+	// Come back as soon as a rule's time window opens or closes
+	if untilChange := rules.UntilNextChange(options.Rules, location, at); untilChange > 0 && untilChange < frame.Refresh {
+		frame.Refresh, frame.Poll = untilChange, false
+	}
+	// Be back in time for the next reminder
+	if untilReminder := reminders.UntilNext(at); untilReminder > 0 && untilReminder < frame.Refresh {
+		frame.Refresh, frame.Poll = untilReminder, false
+	}
+	return frame, nil
+}
+
+// showFrame produces the named screen, to be shown at at, with the TRMNL
+// playlist as the default
+func (d *Daemon) showFrame(ctx context.Context, screen, tmpDir string, config Config, options AppOptions, at time.Time) (*Frame, error) {
+	if screen == "" || screen == screenPlaylist {
+		return fetchPlaylistFrame(ctx, tmpDir, config, options)
+	}
+	return d.screenFrame(ctx, screen, tmpDir, options, at)
+}
+
+// screenName names a rule's screen for log messages
+func screenName(screen string) string {
+	if screen == "" {
+		return screenPlaylist
+	}
+	return screen
+}
+
+// fetchPlaylistFrame gets the current screen from the TRMNL API, downloads
+// it, and decodes it
+func fetchPlaylistFrame(ctx context.Context, tmpDir string, config Config, options AppOptions) (*Frame, error) {
+	terminal, err := apiClient(config).Display(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching display: %v", err)
+	}
+
+	// Set default filename if not provided
+	filename := terminal.Filename
+	if filename == "" {
+		filename = "display.jpg"
+	}
+
+	// Create full path to temporary file
+	filePath := filepath.Join(tmpDir, filename)
+
+	if err := downloadImage(ctx, terminal.ImageURL, filePath); err != nil {
+		return nil, err
+	}
+
+	stages := stageDumpFrom(ctx)
+	stages.RecordFile("download", filePath, map[string]interface{}{"url": terminal.ImageURL})
+	start := time.Now()
+	img, err := decodeImage(filePath, options)
+	if err != nil {
+		return nil, err
+	}
+	stageTimingsFrom(ctx).Since("decode", start)
+	stages.Record("decode", img, decodeParams(options))
+
+	return &Frame{Image: img, Refresh: playlistRefresh(terminal.RefreshRate, options), Poll: true, Source: readSource(filePath)}, nil
+}
+
+// playlistRefresh turns the server's refresh rate in seconds (0 when it
+// gave none) into the playlist's refresh interval, applying -refresh and
+// the -refresh-min and -refresh-max bounds
+func playlistRefresh(rate int, options AppOptions) time.Duration {
+	if options.RefreshOverride > 0 {
+		return options.RefreshOverride
+	}
+	refresh := options.RefreshDefault
+	if rate > 0 {
+		refresh = time.Duration(rate) * time.Second
+	}
+	if options.RefreshMin > 0 {
+		refresh = max(refresh, options.RefreshMin)
+	}
+	if options.RefreshMax > 0 {
+		refresh = min(refresh, options.RefreshMax)
+	}
+	return refresh
+}
+
+// readSource keeps a copy of the image file a frame was decoded from, for
+// GET /frame/source; it is only informational, so errors just leave it out
+func readSource(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// decodeParams describes the settings decodeImage uses, for stage dumps
+func decodeParams(options AppOptions) map[string]interface{} {
+	return map[string]interface{}{
+		"max_pixels": options.MaxPixels,
+	}
+}
+
+// downloadImage saves the image at imageURL to filePath
+func downloadImage(ctx context.Context, imageURL, filePath string) error {
+	defer stageTimingsFrom(ctx).Since("download", time.Now())
+	out, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	defer out.Close()
+	if err := apiClient(Config{}).Download(ctx, imageURL, out); err != nil {
+		os.Remove(filePath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"trmnl-display/pkg/api"
+	"trmnl-display/pkg/rules"
+)
+
+// fakeHTTPClient answers requests from a table of URL to response body,
+// with 404 for anything else
+type fakeHTTPClient struct {
+	responses map[string]string
+	requests  []*http.Request
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	body, ok := c.responses[req.URL.String()]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// useHTTPClient swaps in client for the rest of the test
+func useHTTPClient(t *testing.T, client api.HTTPClient) {
+	previous := httpClient
+	httpClient = client
+	t.Cleanup(func() { httpClient = previous })
+}
+
+// encodePNG returns img as PNG data
+func encodePNG(t *testing.T, img image.Image) string {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFetchPlaylistFrame(t *testing.T) {
+	screen := image.NewGray(image.Rect(0, 0, 800, 480))
+	screen.SetGray(10, 20, color.Gray{Y: 255})
+	client := &fakeHTTPClient{responses: map[string]string{
+		"https://trmnl.test/api/display": `{"image_url": "https://cdn.test/screen.png", "filename": "screen.png", "refresh_rate": 900}`,
+		"https://cdn.test/screen.png":    encodePNG(t, screen),
+	}}
+	useHTTPClient(t, client)
+
+	config := Config{APIKey: "secret", BaseURL: "https://trmnl.test/"}
+	frame, err := fetchPlaylistFrame(context.Background(), t.TempDir(), config, AppOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Refresh != 900*time.Second || !frame.Poll {
+		t.Errorf("refresh = %v, poll %v, want a 15m server poll", frame.Refresh, frame.Poll)
+	}
+	if got := frame.Image.Bounds(); got != screen.Bounds() {
+		t.Errorf("image bounds = %v, want %v", got, screen.Bounds())
+	}
+	if got := color.GrayModel.Convert(frame.Image.At(10, 20)).(color.Gray).Y; got != 255 {
+		t.Errorf("pixel (10, 20) = %d, want 255", got)
+	}
+	if got := client.requests[0].Header.Get("access-token"); got != "secret" {
+		t.Errorf("access-token = %q, want the API key", got)
+	}
+}
+
+func TestFetchPlaylistFrameErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		responses map[string]string
+		want      string
+	}{
+		{"unknown key", map[string]string{}, "status code 404"},
+		{"bad JSON", map[string]string{"https://trmnl.test/api/display": "<html>"}, "error parsing JSON"},
+		{"missing image", map[string]string{
+			"https://trmnl.test/api/display": `{"image_url": "https://cdn.test/gone.png"}`,
+		}, "error downloading image: status code 404"},
+		{"corrupt image", map[string]string{
+			"https://trmnl.test/api/display": `{"image_url": "https://cdn.test/bad.png", "filename": "bad.png"}`,
+			"https://cdn.test/bad.png":       "\x89PNG\r\n\x1a\nnot really",
+		}, "error decoding image"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			useHTTPClient(t, &fakeHTTPClient{responses: tt.responses})
+			config := Config{APIKey: "secret", BaseURL: "https://trmnl.test"}
+			_, err := fetchPlaylistFrame(context.Background(), t.TempDir(), config, AppOptions{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetchFrameForDueTime(t *testing.T) {
+	// Prefetched ten seconds before the minute it is shown in, the clock
+	// shows that minute and is due again at the next one
+	at := time.Date(2026, 3, 2, 9, 59, 50, 0, time.Local)
+	rule, err := rules.Parse("when 09:00-10:00 show stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock, err := rules.Parse("when always show clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := AppOptions{Rules: []rules.Rule{rule, clock}}
+
+	due := at.Add(10 * time.Second)
+	frame, err := newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, options, due)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Screen != screenClock {
+		t.Errorf("screen = %s, want the clock once the 09:00-10:00 window has closed", frame.Screen)
+	}
+	if next := frame.At.Add(frame.Refresh); !next.Equal(due.Add(time.Minute)) {
+		t.Errorf("next refresh at %s, want %s", next.Format("15:04:05"), due.Add(time.Minute).Format("15:04:05"))
+	}
+	if frame.Poll {
+		t.Errorf("the clock's next minute is spread out by -refresh-jitter")
+	}
+
+	// Before the window closes, the frame is back for the moment it does
+	frame, err = newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, options, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := frame.At.Add(frame.Refresh); frame.Screen != screenStats || !next.Equal(due) {
+		t.Errorf("screen %s due at %s, want stats due at %s", frame.Screen, next.Format("15:04:05"), due.Format("15:04:05"))
+	}
+}
+
+func TestFetchFrameReminderOnTime(t *testing.T) {
+	saved := reminders
+	reminders = &ReminderSchedule{dismissed: make(map[string]time.Time)}
+	t.Cleanup(func() { reminders = saved })
+	if err := reminders.SetConfigured([]Reminder{{At: "10:00", Message: "Bins out"}}); err != nil {
+		t.Fatal(err)
+	}
+	clock, err := rules.Parse("when always show clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := AppOptions{Rules: []rules.Rule{clock}, PrefetchLead: 10 * time.Second}
+
+	// A frame shown at 09:59:30 is due again at 10:00 for the reminder,
+	// and the frame prefetched for then is the reminder
+	at := time.Date(2026, 3, 2, 9, 59, 30, 0, time.Local)
+	frame, err := newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, options, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reminderAt := time.Date(2026, 3, 2, 10, 0, 0, 0, time.Local)
+	if due := frame.At.Add(frame.Refresh); !due.Equal(reminderAt) {
+		t.Fatalf("due at %s, want 10:00:00", due.Format("15:04:05"))
+	}
+	frame, err = newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, options, reminderAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Screen != "reminder" {
+		t.Errorf("screen at 10:00 = %s, want the reminder", frame.Screen)
+	}
+}
+
+func TestFetchFrameDarkMode(t *testing.T) {
+	// Dark mode inverts screens rendered on the device as well as decoded ones
+	clock, err := rules.Parse("when always show clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 3, 2, 9, 30, 0, 0, time.Local)
+	light, err := newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, AppOptions{Rules: []rules.Rule{clock}}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dark, err := newDaemon().fetchFrame(context.Background(), t.TempDir(), Config{}, AppOptions{Rules: []rules.Rule{clock}, DarkMode: true}, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lightY := color.GrayModel.Convert(light.Image.At(5, 5)).(color.Gray).Y
+	darkY := color.GrayModel.Convert(dark.Image.At(5, 5)).(color.Gray).Y
+	if lightY != 255-darkY {
+		t.Errorf("corner pixel = %d in dark mode, want the inverse of %d", darkY, lightY)
+	}
+}
+
+func TestPlaylistRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    int
+		options AppOptions
+		want    time.Duration
+	}{
+		{"server rate", 900, AppOptions{RefreshDefault: time.Minute}, 15 * time.Minute},
+		{"no server rate", 0, AppOptions{RefreshDefault: 5 * time.Minute}, 5 * time.Minute},
+		{"override", 900, AppOptions{RefreshOverride: 2 * time.Hour}, 2 * time.Hour},
+		{"raised to min", 60, AppOptions{RefreshMin: 10 * time.Minute}, 10 * time.Minute},
+		{"lowered to max", 7200, AppOptions{RefreshMax: 30 * time.Minute}, 30 * time.Minute},
+		{"within bounds", 900, AppOptions{RefreshMin: 5 * time.Minute, RefreshMax: time.Hour}, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := playlistRefresh(tt.rate, tt.options); got != tt.want {
+			t.Errorf("%s: playlistRefresh(%d) = %s, want %s", tt.name, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	if got := withJitter(time.Minute, 0); got != time.Minute {
+		t.Errorf("withJitter without jitter = %s, want 1m", got)
+	}
+	varied := false
+	for i := 0; i < 100; i++ {
+		got := withJitter(10*time.Minute, 10)
+		if got < 9*time.Minute || got > 11*time.Minute {
+			t.Fatalf("withJitter(10m, 10%%) = %s, want within 9m-11m", got)
+		}
+		varied = varied || got != 10*time.Minute
+	}
+	if !varied {
+		t.Error("withJitter never changed the interval")
+	}
+}
+
+func TestFullClearDue(t *testing.T) {
+	morning := time.Date(2025, 6, 20, 8, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		options    AppOptions
+		sinceClear int
+		now        time.Time
+		want       bool
+	}{
+		{"disabled", AppOptions{}, 1000, morning.AddDate(0, 0, 3), false},
+		{"before count", AppOptions{ClearEvery: 10}, 9, morning, false},
+		{"at count", AppOptions{ClearEvery: 10}, 10, morning, true},
+		{"same day", AppOptions{ClearDaily: true}, 100, morning.Add(15 * time.Hour), false},
+		{"next day", AppOptions{ClearDaily: true}, 1, morning.Add(17 * time.Hour), true},
+	}
+	for _, tt := range tests {
+		if got := fullClearDue(tt.options, tt.sinceClear, morning, tt.now); got != tt.want {
+			t.Errorf("%s: fullClearDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRefreshMode(t *testing.T) {
+	options := AppOptions{RefreshMode: "quality", QualityEvery: 3}
+	if got := refreshMode(&Frame{}, options, 5); got != "quality" {
+		t.Errorf("default mode = %s, want quality", got)
+	}
+	if got := refreshMode(&Frame{Mode: "fast"}, options, 2); got != "fast" {
+		t.Errorf("rule mode = %s, want fast", got)
+	}
+	if got := refreshMode(&Frame{Mode: "fast"}, options, 3); got != "quality" {
+		t.Errorf("mode after 3 fast refreshes = %s, want quality", got)
+	}
+	options.QualityEvery = 0
+	if got := refreshMode(&Frame{Mode: "fast"}, options, 100); got != "fast" {
+		t.Errorf("mode without -quality-every = %s, want fast", got)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"trmnl-display/pkg/panel"
+)
+
+// HardwareReport is what the probe subcommand found
+type HardwareReport struct {
+	Board        string
+	HAT          string
+	HATVendor    string
+	GPIOChips    []panel.GPIOChip
+	SPIDevices   []string
+	Framebuffers []string
+	HasBattery   bool
+}
+
+// knownHAT holds setup hints for HATs identified by their EEPROM
+type knownHAT struct {
+	Product     string // part of the EEPROM product string
+	Panel       string // -panel to drive it with, if it is an e-paper HAT
+	MenuButtons string
+}
+
+// HATs with buttons that can drive the settings menu, and e-paper HATs
+// -panel auto recognises, checked in order so longer names come first
+var knownHATs = []knownHAT{
+	{Product: "Inky Impression", MenuButtons: "select=5,next=6,prev=16"},
+	{Product: "7.5inch e-Paper HAT (B)", Panel: panelWaveshare7in5B},
+	{Product: "7.3inch e-Paper HAT (F)", Panel: panelWaveshare7in3F},
+	{Product: "7.3inch e-Paper HAT (E)", Panel: panelWaveshare7in3E},
+	{Product: "5.65inch e-Paper", Panel: panelWaveshare5in65F},
+	{Product: "7.5inch e-Paper", Panel: panelWaveshare7in5V2},
+	{Product: "IT8951", Panel: panelIT8951},
+}
+
+// findHAT looks up a HAT by its EEPROM product string, ignoring case
+func findHAT(product string) (knownHAT, bool) {
+	for _, hat := range knownHATs {
+		if strings.Contains(strings.ToLower(product), strings.ToLower(hat.Product)) {
+			return hat, true
+		}
+	}
+	return knownHAT{}, false
+}
+
+// runProbe implements the probe subcommand, which reports the board, GPIO
+// and SPI layout, and attached HAT, and writes a matching config file
+func runProbe(args []string) {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	output := fs.String("o", "-", "Write the suggested config to this file (- for standard output)")
+	fs.Parse(args)
+
+	report := probeHardware()
+	report.Print()
+
+	// Start from the existing config so the API key and network settings survive
+	config := Config{}
+	if configDir, err := configDirectory(); err == nil {
+		config = loadConfig(configDir)
+	}
+	report.Suggest(&config)
+
+	// Probe output is often pasted into support requests, so secrets only
+	// go to a file
+	shown := config
+	if *output == "-" {
+		shown = maskConfigSecrets(config)
+	}
+	data, err := json.MarshalIndent(shown, "", "  ")
+	if err != nil {
+		fmt.Printf("Error encoding config: %v\n", err)
+		os.Exit(1)
+	}
+	data = append(data, '\n')
+	if *output == "-" {
+		fmt.Println("\nSuggested config.json (secrets masked, write it with -o to keep them):")
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*output, data, 0600); err != nil {
+		fmt.Printf("Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("\nWrote suggested config to %s\n", *output)
+}
+
+// maskConfigSecrets returns config with the API key and other secrets
+// masked and the control token hashes left out, in it and its profiles
+func maskConfigSecrets(config Config) Config {
+	config.APIKey = maskSecret(config.APIKey)
+	config.WebhookSecret = maskSecret(config.WebhookSecret)
+	config.MQTTPassword = maskSecret(config.MQTTPassword)
+	config.ControlTokens = nil
+	if config.Profiles != nil {
+		profiles := make(map[string]Config, len(config.Profiles))
+		for name, p := range config.Profiles {
+			profiles[name] = maskConfigSecrets(p)
+		}
+		config.Profiles = profiles
+	}
+	return config
+}
+
+// probeHardware inspects the device tree, /dev, and sysfs
+func probeHardware() HardwareReport {
+	report := HardwareReport{
+		Board:     readDeviceTreeString("model"),
+		HAT:       readDeviceTreeString("hat/product"),
+		HATVendor: readDeviceTreeString("hat/vendor"),
+	}
+	if report.Board == "" {
+		report.Board = readSysfsString("/sys/class/dmi/id/product_name")
+	}
+
+	chips, _ := filepath.Glob("/dev/gpiochip*")
+	for _, path := range chips {
+		if chip, err := panel.ReadGPIOChip(path); err == nil {
+			report.GPIOChips = append(report.GPIOChips, chip)
+		}
+	}
+	report.SPIDevices, _ = filepath.Glob("/dev/spidev*")
+
+	fbs, _ := filepath.Glob("/sys/class/graphics/fb*")
+	for _, fb := range fbs {
+		description := "/dev/" + filepath.Base(fb)
+		if name := readSysfsString(filepath.Join(fb, "name")); name != "" {
+			description += " (" + name
+			if size := readSysfsString(filepath.Join(fb, "virtual_size")); size != "" {
+				description += ", " + strings.Replace(size, ",", "x", 1)
+			}
+			description += ")"
+		}
+		report.Framebuffers = append(report.Framebuffers, description)
+	}
+	sort.Strings(report.Framebuffers)
+
+	_, report.HasBattery = readBatteryPercent()
+	return report
+}
+
+// Print writes the report in a human-readable form
+func (r HardwareReport) Print() {
+	orNone := func(s string) string {
+		if s == "" {
+			return "not detected"
+		}
+		return s
+	}
+
+	fmt.Printf("Board:        %s\n", orNone(r.Board))
+	hat := r.HAT
+	if hat != "" && r.HATVendor != "" {
+		hat += " by " + r.HATVendor
+	}
+	fmt.Printf("HAT:          %s\n", orNone(hat))
+
+	fmt.Println("GPIO chips:")
+	if len(r.GPIOChips) == 0 {
+		fmt.Println("  none")
+	}
+	for _, chip := range r.GPIOChips {
+		fmt.Printf("  %s: %s, %d lines\n", chip.Path, chip.Label, chip.Lines)
+	}
+	fmt.Printf("SPI devices:  %s\n", orNone(strings.Join(r.SPIDevices, ", ")))
+	fmt.Printf("Framebuffers: %s\n", orNone(strings.Join(r.Framebuffers, ", ")))
+	if r.HasBattery {
+		fmt.Println("Battery:      present")
+	}
+}
+
+// Suggest fills in the config settings implied by the detected hardware
+func (r HardwareReport) Suggest(config *Config) {
+	if chip := r.headerChip(); chip != "" {
+		config.GPIOChip = chip
+	}
+	if hat, ok := findHAT(r.HAT); ok {
+		if hat.MenuButtons != "" {
+			config.MenuButtons = hat.MenuButtons
+		}
+		if hat.Panel != "" {
+			config.Panel = hat.Panel
+		}
+	}
+}
+
+// headerChip picks the GPIO chip that drives the pin header: the SoC pin
+// controller where one is present, otherwise the chip with the most lines
+func (r HardwareReport) headerChip() string {
+	best, lines := "", 0
+	for _, chip := range r.GPIOChips {
+		if strings.HasPrefix(chip.Label, "pinctrl-") {
+			return chip.Path
+		}
+		if chip.Lines > lines {
+			best, lines = chip.Path, chip.Lines
+		}
+	}
+	return best
+}
+
+// readDeviceTreeString reads a NUL-terminated device tree property
+func readDeviceTreeString(name string) string {
+	data, err := os.ReadFile(filepath.Join("/proc/device-tree", name))
+	if err != nil {
+		return ""
+	}
+	return cString(data)
+}
+
+// readSysfsString reads a single-line sysfs attribute
+func readSysfsString(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// cString converts a NUL-terminated byte string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
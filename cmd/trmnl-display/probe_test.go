@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestMaskConfigSecrets(t *testing.T) {
+	config := Config{
+		APIKey:        "abcdefgh1234",
+		MQTTPassword:  "hunter2hunter2",
+		ControlTokens: []string{"sha256:0123456789"},
+		Profiles:      map[string]Config{"kitchen": {APIKey: "kitchenkey5678"}},
+	}
+	data, err := json.Marshal(maskConfigSecrets(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"abcdefgh", "hunter2", "0123456789", "kitchenkey"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("masked config still holds %q: %s", secret, data)
+		}
+	}
+	if config.Profiles["kitchen"].APIKey != "kitchenkey5678" {
+		t.Errorf("masking changed the original config's profiles")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// environmentProxy picks a proxy from HTTP_PROXY, HTTPS_PROXY, and NO_PROXY.
+// It is a variable so tests can stand in for the environment, which
+// net/http only reads once.
+var environmentProxy = http.ProxyFromEnvironment
+
+// proxyFunc returns the proxy selector for the shared HTTP client. Proxies
+// set in the config file take precedence; for a scheme the config leaves
+// unset, the standard HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment
+// variables are honoured.
+func proxyFunc(config Config) (func(*http.Request) (*url.URL, error), error) {
+	if config.HTTPProxy == "" && config.HTTPSProxy == "" {
+		return environmentProxy, nil
+	}
+
+	var httpProxy, httpsProxy *url.URL
+	var err error
+	if config.HTTPProxy != "" {
+		if httpProxy, err = parseProxyURL(config.HTTPProxy); err != nil {
+			return nil, err
+		}
+	}
+	if config.HTTPSProxy != "" {
+		if httpsProxy, err = parseProxyURL(config.HTTPSProxy); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(req.URL.Hostname(), config.NoProxy) {
+			return nil, nil
+		}
+		proxy := httpProxy
+		if req.URL.Scheme == "https" {
+			proxy = httpsProxy
+		}
+		if proxy == nil {
+			return environmentProxy(req)
+		}
+		return proxy, nil
+	}, nil
+}
+
+// parseProxyURL parses a proxy address, assuming http:// when no scheme is given
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy address %q", proxy)
+	}
+	return u, nil
+}
+
+// bypassProxy reports whether host matches the comma-separated NO_PROXY style
+// list: "*", exact hosts, domain suffixes (with or without a leading dot),
+// IP addresses, and CIDR ranges
+func bypassProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, cidr, err := net.ParseCIDR(entry); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		case ip != nil:
+			if entryIP := net.ParseIP(entry); entryIP != nil && entryIP.Equal(ip) {
+				return true
+			}
+		default:
+			domain := strings.TrimPrefix(entry, ".")
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestProxyFuncFallsBackToEnvironment(t *testing.T) {
+	// Stand in for HTTP_PROXY=http://env-http:3128 and
+	// HTTPS_PROXY=http://env-https:3128
+	saved := environmentProxy
+	environmentProxy = func(req *http.Request) (*url.URL, error) {
+		if req.URL.Scheme == "https" {
+			return url.Parse("http://env-https:3128")
+		}
+		return url.Parse("http://env-http:3128")
+	}
+	t.Cleanup(func() { environmentProxy = saved })
+
+	for _, tt := range []struct {
+		name   string
+		config Config
+		http   string
+		https  string
+	}{
+		{"no config", Config{}, "env-http:3128", "env-https:3128"},
+		{"http only", Config{HTTPProxy: "config-http:8080"}, "config-http:8080", "env-https:3128"},
+		{"https only", Config{HTTPSProxy: "config-https:8080"}, "env-http:3128", "config-https:8080"},
+		{"both", Config{HTTPProxy: "config-http:8080", HTTPSProxy: "config-https:8080"}, "config-http:8080", "config-https:8080"},
+		{"no proxy", Config{HTTPProxy: "config-http:8080", NoProxy: "usetrmnl.com"}, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, err := proxyFunc(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for scheme, want := range map[string]string{"http": tt.http, "https": tt.https} {
+				req, _ := http.NewRequest("GET", scheme+"://usetrmnl.com/api/display", nil)
+				got, err := proxy(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				host := ""
+				if got != nil {
+					host = got.Host
+				}
+				if host != want {
+					t.Errorf("%s proxy = %q, want %q", scheme, host, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/api"
+	"trmnl-display/pkg/compose"
+)
+
+// How long pushed content stays up when the request does not say
+const defaultPushDuration = 10 * time.Minute
+
+// PushedContent is an image pushed through the control API, shown in place
+// of the normal screens until it expires
+type PushedContent struct {
+	mu    sync.Mutex
+	image image.Image
+	until time.Time
+}
+
+// Set shows img until the given time
+func (p *PushedContent) Set(img image.Image, until time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.image, p.until = img, until
+}
+
+// Clear takes pushed content down, reporting whether there was any
+func (p *PushedContent) Clear() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	had := p.image != nil
+	p.image = nil
+	return had
+}
+
+// Active returns the pushed image and when it expires, if one is up at now
+func (p *PushedContent) Active(now time.Time) (image.Image, time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.image == nil || !now.Before(p.until) {
+		p.image = nil
+		return nil, time.Time{}, false
+	}
+	return p.image, p.until, true
+}
+
+// pushedFrame is the frame for pushed content
+func pushedFrame(img image.Image, until, now time.Time) *Frame {
+	return &Frame{Image: img, Refresh: until.Sub(now), Screen: "push"}
+}
+
+// parsePushDuration parses the duration a push request asks for
+func parsePushDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultPushDuration, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// showPushed puts img up for duration and redraws straight away
+func (d *Daemon) showPushed(img image.Image, duration time.Duration) time.Time {
+	until := time.Now().Add(duration)
+	d.pushed.Set(img, until)
+	requestRefresh()
+	return until
+}
+
+// handlePushDisplay shows an image sent as the "image" part of a multipart
+// form, or fetched from {"url": "…"}, through the normal image pipeline
+func (s *ControlServer) handlePushDisplay(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, api.MaxDownloadBytes)
+	tmpDir, err := os.MkdirTemp("", "trmnl-push")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var source, durationStr string
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		file, header, err := r.FormFile("image")
+		if err != nil {
+			http.Error(w, "missing image part", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		source = filepath.Join(tmpDir, "upload"+filepath.Ext(header.Filename))
+		if err := saveUpload(file, source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		durationStr = r.FormValue("duration")
+	} else {
+		var req struct {
+			URL      string `json:"url"`
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+			http.Error(w, "expected a multipart image or {\"url\": \"…\"}", http.StatusBadRequest)
+			return
+		}
+		if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+			http.Error(w, "url must be http or https", http.StatusBadRequest)
+			return
+		}
+		source, durationStr = req.URL, req.Duration
+	}
+	d, err := parsePushDuration(durationStr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options := s.currentOptions()
+	options.DarkMode = live.DarkMode(options.DarkMode)
+	img, err := loadShowImage(r.Context(), source, tmpDir, options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	until := s.daemon.showPushed(img, d)
+	controlLog.Info("Showing pushed image", "addr", r.RemoteAddr, "until", until.Format("15:04:05"))
+	writeJSON(w, map[string]any{"until": until})
+}
+
+// saveUpload copies an uploaded file to path
+func saveUpload(file io.Reader, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, file); err != nil {
+		return fmt.Errorf("error reading upload: %v", err)
+	}
+	return nil
+}
+
+// handlePushText shows {"text": "…", "title": "…"} rendered as a full
+// screen message
+func (s *ControlServer) handlePushText(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+		Duration string `json:"duration"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Text) == "" {
+		http.Error(w, "expected {\"text\": \"…\"}", http.StatusBadRequest)
+		return
+	}
+	d, err := parsePushDuration(req.Duration)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	img, err := renderTextScreen(req.Title, req.Text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	until := s.daemon.showPushed(img, d)
+	controlLog.Info("Showing pushed text", "addr", r.RemoteAddr, "until", until.Format("15:04:05"))
+	writeJSON(w, map[string]any{"until": until})
+}
+
+// handleClearPush takes pushed content down and goes back to the normal
+// screens
+func (s *ControlServer) handleClearPush(w http.ResponseWriter, r *http.Request) {
+	if !s.daemon.pushed.Clear() {
+		http.Error(w, "nothing has been pushed", http.StatusNotFound)
+		return
+	}
+	requestRefresh()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// renderTextScreen lays out a message under an optional title bar, in the
+// style of the reminder screen
+func renderTextScreen(title, text string) (image.Image, error) {
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+	headerFace := c.Face(true, 32)
+	messageFace := c.Face(true, 56)
+	defer headerFace.Close()
+	defer messageFace.Close()
+
+	bounds := image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight)
+	top := 0
+	if title != "" {
+		top = 70
+		c.FillRect(image.Rect(0, 0, defaultFrameWidth, top), color.Black)
+		c.DrawText(title, 30, 48, headerFace, color.White)
+	}
+
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		lines = append(lines, compose.WrapText(paragraph, messageFace, defaultFrameWidth-80)...)
+	}
+	lineHeight := messageFace.Metrics().Height.Ceil()
+	y := top + (defaultFrameHeight-top-len(lines)*lineHeight)/2 + messageFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		c.DrawTextCentered(line, bounds, y, messageFace, color.Black)
+		y += lineHeight
+	}
+	return c.Frame, nil
+}
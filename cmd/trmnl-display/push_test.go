@@ -0,0 +1,53 @@
+package main
+
+import (
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushedContentExpires(t *testing.T) {
+	p := &PushedContent{}
+	now := time.Now()
+	p.Set(image.NewGray(image.Rect(0, 0, 1, 1)), now.Add(time.Minute))
+
+	if _, until, ok := p.Active(now); !ok || !until.Equal(now.Add(time.Minute)) {
+		t.Errorf("Active before expiry = %v, %v, want the pushed image", until, ok)
+	}
+	if _, _, ok := p.Active(now.Add(time.Minute)); ok {
+		t.Error("Active at expiry, want it taken down")
+	}
+	if p.Clear() {
+		t.Error("Clear after expiry reported pushed content")
+	}
+}
+
+func TestParsePushDuration(t *testing.T) {
+	if d, err := parsePushDuration(""); err != nil || d != defaultPushDuration {
+		t.Errorf(`parsePushDuration("") = %v, %v, want the default`, d, err)
+	}
+	for _, s := range []string{"soon", "-1m", "0s"} {
+		if _, err := parsePushDuration(s); err == nil {
+			t.Errorf("parsePushDuration(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestPushTextBodyLimit(t *testing.T) {
+	d := newDaemon()
+
+	// A message longer than the request limit is turned away unread
+	body := `{"text": "` + strings.Repeat("x", maxRequestBytes) + `"}`
+	r := httptest.NewRequest("POST", "/api/display/text", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	(&ControlServer{daemon: d}).handlePushText(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if _, _, ok := d.pushed.Active(time.Now()); ok {
+		t.Error("an oversized message was shown")
+	}
+}
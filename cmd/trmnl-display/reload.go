@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"trmnl-display/pkg/panel"
+)
+
+// commandLineArgs are the run command's arguments. Reloads parse them again
+// together with the new config file.
+var commandLineArgs []string
+
+// reloadRequests is signalled by SIGHUP and settings changes
+var reloadRequests = make(chan struct{}, 1)
+
+// setupReloadSignal reloads the config file on SIGHUP
+func setupReloadSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		for range c {
+			configLog.Info("Received SIGHUP, reloading configuration")
+			requestReload()
+		}
+	}()
+}
+
+// requestReload re-reads the config file before the next fetch, which
+// happens straight away
+func requestReload() {
+	select {
+	case reloadRequests <- struct{}{}:
+	default:
+	}
+	requestRefresh()
+}
+
+// reloadRequested reports whether a reload is pending
+func reloadRequested() bool {
+	select {
+	case <-reloadRequests:
+		return true
+	default:
+		return false
+	}
+}
+
+// reloadConfig re-reads the config file and returns the settings to continue
+// with. If the file cannot be used the current settings are kept.
+func (d *Daemon) reloadConfig(config Config, options AppOptions) (Config, AppOptions) {
+	newOptions, newConfig, err := parseOptions(commandLineArgs)
+	if err != nil {
+		configLog.Error("Error reloading config, keeping the current settings", "err", err)
+		return config, options
+	}
+	if newConfig.APIKey == "" {
+		newConfig.APIKey = config.APIKey
+	}
+	// Keep settings that were filled in at startup or only take effect then
+	newOptions.ArchiveDir = options.ArchiveDir
+	newOptions.Headless = options.Headless
+	newOptions.ControlAddr = options.ControlAddr
+	newOptions.ControlSocket = options.ControlSocket
+	newOptions.GallerySize = options.GallerySize
+	newOptions.PprofAddr = options.PprofAddr
+	newOptions.PushURL = options.PushURL
+	newOptions.MQTTBroker = options.MQTTBroker
+	newOptions.MQTTTopic = options.MQTTTopic
+	newOptions.MQTTDiscovery = options.MQTTDiscovery
+	newOptions.Panel = options.Panel
+	newOptions.SPI = options.SPI
+
+	if err := configureHTTPClient(newConfig); err != nil {
+		configLog.Error("Error reloading config, keeping the current settings", "err", err)
+		return config, options
+	}
+	if err := reminders.SetConfigured(newConfig.Reminders); err != nil {
+		configLog.Error("Error reloading reminders, keeping the current ones", "err", err)
+	}
+	if d.control != nil {
+		d.control.UpdateConfig(newConfig, newOptions)
+	}
+	if localControl != nil {
+		localControl.UpdateConfig(newConfig, newOptions)
+	}
+	if mqttBridge != nil {
+		mqttBridge.UpdateConfig(newConfig, newOptions)
+	}
+	if !newOptions.Headless && (newOptions.PanelSleep != options.PanelSleep || newOptions.FramebufferSleep != options.FramebufferSleep) {
+		d.reinitPanel(newOptions)
+	}
+
+	if err := setupLogging(newOptions); err != nil {
+		mainLog.Error("Error setting up logging", "err", err)
+	}
+	health.SetConfig(newConfig)
+	configLog.Info("Configuration reloaded")
+	return newConfig, newOptions
+}
+
+// reinitPanel applies changed panel settings
+func (d *Daemon) reinitPanel(options AppOptions) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if epd, ok := d.panel.(*panel.EPD); ok {
+		epd.DeepSleep = options.PanelSleep
+		powerLog.Info("Panel deep sleep changed", "enabled", options.PanelSleep)
+		return
+	}
+	if d.panel != nil {
+		return
+	}
+	if options.FramebufferSleep {
+		powerLog.Info("Framebuffer sleep enabled")
+		d.power = framebufferPower(options)
+		return
+	}
+	powerLog.Info("Framebuffer sleep disabled")
+	if d.power != nil {
+		if err := d.power.Wake(); err != nil {
+			powerLog.Warn("Failed to wake panel", "err", err)
+		}
+		d.power = nil
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/compose"
+	"trmnl-display/pkg/rules"
+)
+
+// How long a reminder stays up when it does not set a duration
+const defaultReminderDuration = 5 * time.Minute
+
+// Reminders that wait for a button press give up after this long
+const reminderDismissTimeout = 12 * time.Hour
+
+// Reminder is a message that takes over the screen at a set time, from the
+// config file or added through the control API
+type Reminder struct {
+	ID       string `json:",omitempty"`
+	At       string // HH:MM every day, or an RFC 3339 time for a one-off
+	Days     string `json:",omitempty"` // limit a daily reminder, e.g. "weekday" or "mon,thu"
+	Message  string
+	Duration string `json:",omitempty"` // how long it stays up, e.g. "10m"
+	Dismiss  bool   `json:",omitempty"` // stay up until a button is pressed
+}
+
+// scheduledReminder is a parsed Reminder
+type scheduledReminder struct {
+	Reminder
+	clock    int // minutes after midnight for daily reminders
+	once     time.Time
+	days     map[time.Weekday]bool
+	duration time.Duration
+}
+
+// ReminderSchedule tracks configured and API-provisioned reminders
+type ReminderSchedule struct {
+	mu         sync.Mutex
+	configured []*scheduledReminder
+	added      []*scheduledReminder
+	dismissed  map[string]time.Time // occurrence already dismissed, by ID
+	nextID     int
+}
+
+// Global reminder schedule
+var reminders = &ReminderSchedule{dismissed: make(map[string]time.Time)}
+
+// parseReminder validates a reminder
+func parseReminder(r Reminder) (*scheduledReminder, error) {
+	if strings.TrimSpace(r.Message) == "" {
+		return nil, fmt.Errorf("reminder at %q has no message", r.At)
+	}
+	s := &scheduledReminder{Reminder: r, duration: defaultReminderDuration}
+
+	if t, err := time.Parse(time.RFC3339, r.At); err == nil {
+		s.once = t
+	} else {
+		clock, err := rules.ParseClockTime(r.At)
+		if err != nil {
+			return nil, fmt.Errorf("reminder %q: invalid time %q, expected HH:MM or RFC 3339", r.Message, r.At)
+		}
+		s.clock = clock
+	}
+
+	if r.Days != "" {
+		days, err := rules.ParseDays(r.Days)
+		if err != nil {
+			return nil, fmt.Errorf("reminder %q: invalid days %q", r.Message, r.Days)
+		}
+		s.days = days
+	}
+
+	if r.Duration != "" {
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reminder %q: invalid duration %q", r.Message, r.Duration)
+		}
+		s.duration = d
+	}
+	return s, nil
+}
+
+// lastOccurrence is the most recent time at or before now the reminder went
+// off, or the zero time if it never has
+func (s *scheduledReminder) lastOccurrence(now time.Time) time.Time {
+	if !s.once.IsZero() {
+		if s.once.After(now) {
+			return time.Time{}
+		}
+		return s.once
+	}
+	for back := 0; back <= 7; back++ {
+		day := now.AddDate(0, 0, -back)
+		t := time.Date(day.Year(), day.Month(), day.Day(), s.clock/60, s.clock%60, 0, 0, now.Location())
+		if t.After(now) || (s.days != nil && !s.days[t.Weekday()]) {
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// nextOccurrence is the first time after now the reminder goes off, or the
+// zero time if it never will
+func (s *scheduledReminder) nextOccurrence(now time.Time) time.Time {
+	if !s.once.IsZero() {
+		if s.once.After(now) {
+			return s.once
+		}
+		return time.Time{}
+	}
+	for ahead := 0; ahead <= 7; ahead++ {
+		day := now.AddDate(0, 0, ahead)
+		t := time.Date(day.Year(), day.Month(), day.Day(), s.clock/60, s.clock%60, 0, 0, now.Location())
+		if !t.After(now) || (s.days != nil && !s.days[t.Weekday()]) {
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// expiry is when an occurrence stops being shown
+func (s *scheduledReminder) expiry(occurrence time.Time) time.Time {
+	if s.Dismiss {
+		return occurrence.Add(reminderDismissTimeout)
+	}
+	return occurrence.Add(s.duration)
+}
+
+// SetConfigured replaces the reminders from the config file
+func (rs *ReminderSchedule) SetConfigured(list []Reminder) error {
+	var parsed []*scheduledReminder
+	for i, r := range list {
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("config-%d", i+1)
+		}
+		s, err := parseReminder(r)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, s)
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.configured = parsed
+	return nil
+}
+
+// Add schedules a reminder and returns its ID
+func (rs *ReminderSchedule) Add(r Reminder) (string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if r.ID == "" {
+		rs.nextID++
+		r.ID = fmt.Sprintf("api-%d", rs.nextID)
+	}
+	for _, existing := range rs.all() {
+		if existing.ID == r.ID {
+			return "", fmt.Errorf("reminder %q already exists", r.ID)
+		}
+	}
+	s, err := parseReminder(r)
+	if err != nil {
+		return "", err
+	}
+	rs.added = append(rs.added, s)
+	return r.ID, nil
+}
+
+// Remove deletes a reminder added through the API
+func (rs *ReminderSchedule) Remove(id string) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for i, s := range rs.added {
+		if s.ID == id {
+			rs.added = append(rs.added[:i], rs.added[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// List returns every scheduled reminder
+func (rs *ReminderSchedule) List() []Reminder {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	list := []Reminder{}
+	for _, s := range rs.all() {
+		list = append(list, s.Reminder)
+	}
+	return list
+}
+
+// Active returns the reminder that should be on screen at now and when it
+// stops being shown
+func (rs *ReminderSchedule) Active(now time.Time) (Reminder, time.Time, bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	s, occurrence := rs.active(now)
+	if s == nil {
+		return Reminder{}, time.Time{}, false
+	}
+	return s.Reminder, s.expiry(occurrence), true
+}
+
+// Dismiss takes down the reminder on screen, reporting whether there was one
+func (rs *ReminderSchedule) Dismiss() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	s, occurrence := rs.active(time.Now())
+	if s == nil {
+		return false
+	}
+	rs.dismissed[s.ID] = occurrence
+	return true
+}
+
+// UntilNext returns how long until the next reminder goes off, or 0 if none
+// is scheduled
+func (rs *ReminderSchedule) UntilNext(now time.Time) time.Duration {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var next time.Time
+	for _, s := range rs.all() {
+		if t := s.nextOccurrence(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// all lists configured and added reminders; rs.mu must be held
+func (rs *ReminderSchedule) all() []*scheduledReminder {
+	return append(append([]*scheduledReminder{}, rs.configured...), rs.added...)
+}
+
+// active finds the reminder to show at now; rs.mu must be held
+func (rs *ReminderSchedule) active(now time.Time) (*scheduledReminder, time.Time) {
+	for _, s := range rs.all() {
+		occurrence := s.lastOccurrence(now)
+		if occurrence.IsZero() || !now.Before(s.expiry(occurrence)) {
+			continue
+		}
+		if dismissed, ok := rs.dismissed[s.ID]; ok && dismissed.Equal(occurrence) {
+			continue
+		}
+		return s, occurrence
+	}
+	return nil, time.Time{}
+}
+
+// reminderFrame renders a reminder as a large message, shown until it expires
+func reminderFrame(r Reminder, until time.Time, now time.Time) (*Frame, error) {
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+	headerFace := c.Face(true, 32)
+	messageFace := c.Face(true, 64)
+	footerFace := c.Face(false, 26)
+	defer headerFace.Close()
+	defer messageFace.Close()
+	defer footerFace.Close()
+
+	bounds := image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight)
+	c.FillRect(image.Rect(0, 0, defaultFrameWidth, 70), color.Black)
+	c.DrawText("Reminder", 30, 48, headerFace, color.White)
+	clock := r.At
+	if t, err := time.Parse(time.RFC3339, r.At); err == nil {
+		clock = t.In(now.Location()).Format("15:04")
+	}
+	c.DrawText(clock, defaultFrameWidth-30-compose.MeasureText(headerFace, clock), 48, headerFace, color.White)
+
+	lines := compose.WrapText(r.Message, messageFace, defaultFrameWidth-80)
+	lineHeight := messageFace.Metrics().Height.Ceil()
+	y := 70 + (defaultFrameHeight-70-len(lines)*lineHeight)/2 + messageFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		c.DrawTextCentered(line, bounds, y, messageFace, color.Black)
+		y += lineHeight
+	}
+
+	if r.Dismiss {
+		c.DrawTextCentered("Press any button to dismiss", bounds, defaultFrameHeight-25, footerFace, color.Black)
+	}
+	return &Frame{Image: c.Frame, Refresh: until.Sub(now), Screen: "reminder"}, nil
+}
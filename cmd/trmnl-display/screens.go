@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/compose"
+)
+
+// Screens that are generated locally rather than fetched
+const (
+	screenPlaylist = "playlist"
+	screenMorning  = "morning"
+	screenClock    = "clock"
+	screenPair     = "pair"
+	screenStats    = "stats"
+
+	// Images from the -source directory
+	screenSlideshow = "slideshow"
+)
+
+// screenFrame produces the frame for a screen chosen by the rules, to be
+// shown at at: one of the built-in screens, or an image URL registered with
+// -screen
+func (d *Daemon) screenFrame(ctx context.Context, name, tmpDir string, options AppOptions, at time.Time) (*Frame, error) {
+	switch name {
+	case screenMorning:
+		return morningFrame(ctx, options, at)
+	case screenClock:
+		return clockFrame(at)
+	case screenPair:
+		return d.pairFrame(options)
+	case screenStats:
+		return statsFrame(at)
+	case screenSlideshow:
+		return slideshowFrame(ctx, options)
+	}
+
+	imageURL, ok := options.Screens[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown screen %q", name)
+	}
+	filePath := filepath.Join(tmpDir, "screen-"+name)
+	if err := downloadImage(ctx, imageURL, filePath); err != nil {
+		return nil, err
+	}
+	stages := stageDumpFrom(ctx)
+	stages.RecordFile("download", filePath, map[string]interface{}{"url": imageURL})
+	start := time.Now()
+	img, err := decodeImage(filePath, options)
+	if err != nil {
+		return nil, err
+	}
+	stageTimingsFrom(ctx).Since("decode", start)
+	stages.Record("decode", img, decodeParams(options))
+	return &Frame{Image: img, Refresh: options.RefreshDefault, Source: readSource(filePath)}, nil
+}
+
+// validateScreen reports an error for screens that are neither built in nor
+// registered with -screen
+func validateScreen(name string, screens map[string]string) error {
+	switch name {
+	case "", screenPlaylist, screenMorning, screenClock, screenPair, screenStats, screenSlideshow:
+		return nil
+	}
+	if _, ok := screens[name]; !ok {
+		return fmt.Errorf("unknown screen %q (register it with -screen %s=URL)", name, name)
+	}
+	return nil
+}
+
+// parseScreens parses comma-separated name=URL pairs
+func parseScreens(s string) (map[string]string, error) {
+	screens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, imageURL, found := strings.Cut(pair, "=")
+		if !found || name == "" || imageURL == "" {
+			return nil, fmt.Errorf("invalid screen %q, expected name=URL", pair)
+		}
+		screens[name] = imageURL
+	}
+	return screens, nil
+}
+
+// clockFrame renders the current time and date, due to be redrawn at the
+// start of the next minute
+func clockFrame(now time.Time) (*Frame, error) {
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	timeFace := c.Face(true, 200)
+	dateFace := c.Face(false, 40)
+	defer timeFace.Close()
+	defer dateFace.Close()
+
+	bounds := image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight)
+	c.DrawTextCentered(now.Format("15:04"), bounds, 290, timeFace, color.Black)
+	c.DrawTextCentered(now.Format("Monday 2 January"), bounds, 380, dateFace, color.Black)
+
+	nextMinute := now.Truncate(time.Minute).Add(time.Minute)
+	return &Frame{Image: c.Frame, Refresh: nextMinute.Sub(now)}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/api"
+)
+
+// Reconnection delays for the push endpoint. The delay doubles after each
+// failure and starts over once a connection has stayed up for a while.
+const (
+	pushMinBackoff = time.Second
+	pushMaxBackoff = 5 * time.Minute
+	pushStableTime = time.Minute
+)
+
+// validatePushURL checks a -push-url value
+func validatePushURL(pushURL string) error {
+	for _, scheme := range []string{"http://", "https://", "ws://", "wss://"} {
+		if strings.HasPrefix(pushURL, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("-push-url must be an http(s):// server-sent events URL or a ws(s):// WebSocket URL, not %q", pushURL)
+}
+
+// watchServerPush listens on pushURL and refreshes whenever the server sends
+// a message, reconnecting until ctx is cancelled. The refresh timer keeps
+// running alongside, so a lost connection only delays updates.
+func watchServerPush(ctx context.Context, pushURL string, config Config) {
+	header := http.Header{}
+	header.Set("access-token", config.APIKey)
+	header.Set("User-Agent", fmt.Sprintf("trmnl-display/%s", version))
+
+	backoff := pushMinBackoff
+	for ctx.Err() == nil {
+		start := time.Now()
+		var err error
+		if strings.HasPrefix(pushURL, "ws://") || strings.HasPrefix(pushURL, "wss://") {
+			err = listenWebSocket(ctx, pushURL, header)
+		} else {
+			err = listenServerSentEvents(ctx, pushURL, header)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > pushStableTime {
+			backoff = pushMinBackoff
+		}
+		fetchLog.Warn("Push connection lost, reconnecting", "url", pushURL, "in", backoff, "err", err)
+		sleepUntil(ctx, time.Now().Add(backoff))
+		backoff = min(backoff*2, pushMaxBackoff)
+	}
+}
+
+// streamingClient is the shared HTTP client without its overall timeout,
+// which would cut long-lived connections off
+func streamingClient() api.HTTPClient {
+	if c, ok := httpClient.(*http.Client); ok {
+		streaming := *c
+		streaming.Timeout = 0
+		return &streaming
+	}
+	return httpClient
+}
+
+// pushRefresh refreshes the panel for a message from the push endpoint
+func pushRefresh(kind string) {
+	fetchLog.Info("Push message received, refreshing now", "type", kind)
+	requestRefresh()
+}
+
+// listenServerSentEvents reads an event stream until it ends. Every event
+// other than a "ping" keep-alive triggers a refresh.
+func listenServerSentEvents(ctx context.Context, pushURL string, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pushURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header = header.Clone()
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := streamingClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer api.CloseResponse(resp)
+	if resp.StatusCode != http.StatusOK {
+		return &api.StatusError{StatusCode: resp.StatusCode}
+	}
+	fetchLog.Info("Connected to push endpoint", "url", pushURL)
+
+	var event string
+	hasData := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case line == "":
+			// A blank line dispatches the event
+			if (hasData || event != "") && event != "ping" {
+				if event == "" {
+					event = "message"
+				}
+				pushRefresh(event)
+			}
+			event, hasData = "", false
+		case field == "":
+			// Comment, often sent as a keep-alive
+		case field == "event":
+			event = value
+		case field == "data":
+			hasData = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream ended")
+}
+
+// listenWebSocket reads messages until the connection closes. Every message
+// triggers a refresh.
+func listenWebSocket(ctx context.Context, pushURL string, header http.Header) error {
+	conn, err := dialWebSocket(ctx, streamingClient(), pushURL, header)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	fetchLog.Info("Connected to push endpoint", "url", pushURL)
+
+	for {
+		if _, err := conn.ReadMessage(); err != nil {
+			return err
+		}
+		pushRefresh("websocket")
+	}
+}
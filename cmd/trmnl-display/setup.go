@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/api"
+)
+
+// checkAPIKey asks the server for the current screen with config's API key,
+// returning the HTTP status and, on success, what the server said. An error
+// means the server could not be reached.
+func checkAPIKey(config Config) (api.Display, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	info, err := apiClient(config).Display(ctx)
+	var statusErr *api.StatusError
+	if errors.As(err, &statusErr) {
+		return info, statusErr.StatusCode, nil
+	}
+	if err != nil {
+		return info, 0, err
+	}
+	return info, http.StatusOK, nil
+}
+
+// serverURL returns the TRMNL server config points at
+func serverURL(config Config) string {
+	return apiClient(config).URL()
+}
+
+// describeDisplay names the device for the setup prompt
+func describeDisplay(info api.Display) string {
+	switch {
+	case info.Name != "" && info.FriendlyID != "":
+		return fmt.Sprintf("%s (%s)", info.Name, info.FriendlyID)
+	case info.Name != "":
+		return info.Name
+	case info.FriendlyID != "":
+		return info.FriendlyID
+	case info.Filename != "":
+		return "the device showing " + info.Filename
+	}
+	return "your device"
+}
+
+// promptAPIKey asks for an API key until the server accepts one, so a typo
+// is caught now rather than failing every refresh. If the server cannot be
+// reached the key can be kept anyway. It exits when there is no terminal to
+// ask on.
+func promptAPIKey(config Config) string {
+	input := bufio.NewScanner(os.Stdin)
+	ask := func(prompt string) string {
+		fmt.Print(prompt)
+		if !input.Scan() {
+			fmt.Println()
+			fmt.Println("No API key given; set TRMNL_API_KEY or run `trmnl-display config set APIKey KEY`")
+			os.Exit(1)
+		}
+		return strings.TrimSpace(input.Text())
+	}
+
+	fmt.Println("TRMNL API Key not found.")
+	for {
+		key := ask("Please enter your TRMNL API Key: ")
+		if key == "" {
+			continue
+		}
+		config.APIKey = key
+		info, status, err := checkAPIKey(config)
+		switch {
+		case err != nil:
+			fmt.Printf("Could not reach %s to check the key: %v\n", serverURL(config), err)
+			if answer := ask("Save the key anyway? [y/N] "); strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
+				return key
+			}
+		case status == http.StatusOK:
+			fmt.Printf("Key accepted: connected to %s\n", describeDisplay(info))
+			return key
+		case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound:
+			fmt.Printf("%s did not recognise that key (status %d); check it in the device settings and try again\n", serverURL(config), status)
+		default:
+			fmt.Printf("%s returned status %d while checking the key\n", serverURL(config), status)
+			if answer := ask("Save the key anyway? [y/N] "); strings.EqualFold(answer, "y") || strings.EqualFold(answer, "yes") {
+				return key
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/png"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// runShow implements the show command, which puts a local image or one
+// from a URL through the display pipeline once and exits
+func runShow(args []string) {
+	fs := flag.NewFlagSet("show", flag.ExitOnError)
+	darkMode := fs.Bool("d", false, "Enable dark mode (invert images)")
+	quiet := fs.Bool("q", false, "Quiet mode (only log warnings and errors)")
+	output := fs.String("o", "", "Save the frame as it would be drawn to this PNG instead of drawing it")
+	maxPixels := fs.Int("max-pixels", defaultMaxPixels, "Reject images with more pixels than this (0 disables the limit)")
+	dumpStages := fs.String("dump-stages", "", "Save the image after each pipeline stage, with its parameters, as a zip bundle in this directory")
+	fs.Usage = func() {
+		fmt.Println("Usage: trmnl-display show [flags] <file|url>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source := fs.Arg(0)
+
+	options := AppOptions{
+		DarkMode:   *darkMode,
+		LogLevel:   logLevelFor("", !*quiet, *quiet),
+		Headless:   *output != "",
+		MaxPixels:  *maxPixels,
+		DumpStages: *dumpStages,
+	}
+	setupLogging(options)
+	if !options.Headless {
+		checkRoot()
+	}
+
+	// Downloads honour the proxy and TLS settings from the config file and
+	// environment
+	if configDir, err := configDirectory(); err == nil {
+		config, err := applyConfigEnv(loadConfig(configDir))
+		if err == nil {
+			err = configureHTTPClient(config)
+		}
+		if err != nil {
+			fmt.Printf("Error configuring HTTP client: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "trmnl-display")
+	if err != nil {
+		fmt.Printf("Error creating temp directory: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	stages := newStageDump(options)
+	timings := &StageTimings{}
+	ctx := withStageTimings(withStageDump(context.Background(), stages), timings)
+	img, err := loadShowImage(ctx, source, tmpDir, options)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if options.Headless {
+		err = saveShownFrame(img, *output, stages)
+	} else {
+		d := newDaemon()
+		d.lock = NewFramebufferLock(lockFilePath)
+		if err := d.lock.Acquire(); err != nil {
+			fmt.Printf("Error acquiring framebuffer lock: %v\n", err)
+			os.Exit(1)
+		}
+		if err := d.openConfiguredPanel(); err != nil {
+			fmt.Printf("Error opening panel: %v\n", err)
+			os.Exit(1)
+		}
+		err = d.presentFrame(img, stages, timings, options)
+		d.closePanel()
+		d.lock.Release()
+	}
+	displayLog.Debug("Timings", timings.logAttrs()...)
+	if err != nil {
+		fmt.Printf("Error displaying image: %v\n", err)
+		os.Exit(1)
+	}
+
+	if stages != nil {
+		if path, err := stages.Write(); err != nil {
+			fmt.Printf("Error saving pipeline stages: %v\n", err)
+		} else {
+			fmt.Printf("Saved pipeline stages to %s\n", path)
+		}
+	}
+}
+
+// loadShowImage downloads source if it is a URL, then decodes it
+func loadShowImage(ctx context.Context, source, tmpDir string, options AppOptions) (image.Image, error) {
+	stages := stageDumpFrom(ctx)
+	filePath := source
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %q: %v", source, err)
+		}
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			name = "image"
+		}
+		filePath = filepath.Join(tmpDir, name)
+		if err := downloadImage(ctx, source, filePath); err != nil {
+			return nil, err
+		}
+		stages.RecordFile("download", filePath, map[string]interface{}{"url": source})
+	} else {
+		stages.RecordFile("source", filePath, map[string]interface{}{"path": source})
+	}
+
+	start := time.Now()
+	img, err := decodeImage(filePath, options)
+	if err != nil {
+		return nil, err
+	}
+	stageTimingsFrom(ctx).Since("decode", start)
+	stages.Record("decode", img, decodeParams(options))
+	img = applyDarkMode(img, options)
+	stages.Record("dark-mode", img, map[string]interface{}{"enabled": options.DarkMode})
+	return img, nil
+}
+
+// saveShownFrame writes img to path as a PNG, scaled exactly as headless
+// mode archives it
+func saveShownFrame(img image.Image, path string, stages *StageDump) error {
+	frame := render.Scale(img, image.Rect(0, 0, defaultFrameWidth, defaultFrameHeight))
+	stages.Record("scale", frame, map[string]interface{}{
+		"from":   img.Bounds().String(),
+		"to":     frame.Bounds().String(),
+		"scaler": "nearest-neighbor",
+	})
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", path, err)
+	}
+	if err := png.Encode(out, frame); err != nil {
+		out.Close()
+		return fmt.Errorf("error encoding %s: %v", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	fmt.Printf("Saved frame to %s\n", path)
+	return nil
+}
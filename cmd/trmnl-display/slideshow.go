@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Image extensions the slideshow picks up
+var slideshowExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".bmp":  true,
+}
+
+// Slideshow rotates through the images in a directory
+type Slideshow struct {
+	mu    sync.Mutex
+	files []string // the directory listing the order was made from
+	order []string
+	next  int
+}
+
+// Global slideshow position, kept across refreshes
+var slideshow = &Slideshow{}
+
+// parseSource parses -source, returning the slideshow directory for
+// "dir:/path" and "" for the TRMNL playlist
+func parseSource(source string) (string, error) {
+	switch {
+	case source == "" || source == screenPlaylist:
+		return "", nil
+	case strings.HasPrefix(source, "dir:"):
+		dir := strings.TrimPrefix(source, "dir:")
+		info, err := os.Stat(dir)
+		if err != nil {
+			return "", err
+		}
+		if !info.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", dir)
+		}
+		return dir, nil
+	}
+	return "", fmt.Errorf("unknown source %q, expected playlist or dir:/path", source)
+}
+
+// slideshowFrame shows the next image from the slideshow directory
+func slideshowFrame(ctx context.Context, options AppOptions) (*Frame, error) {
+	if options.SlideshowDir == "" {
+		return nil, fmt.Errorf("no slideshow directory (set one with -source dir:/path)")
+	}
+	path, err := slideshow.Next(options.SlideshowDir, options.Shuffle)
+	if err != nil {
+		return nil, err
+	}
+
+	stages := stageDumpFrom(ctx)
+	stages.RecordFile("source", path, map[string]interface{}{"path": path})
+	start := time.Now()
+	img, err := decodeImage(path, options)
+	if err != nil {
+		return nil, err
+	}
+	stageTimingsFrom(ctx).Since("decode", start)
+	stages.Record("decode", img, decodeParams(options))
+	return &Frame{Image: img, Refresh: options.SlideshowInterval, Source: readSource(path)}, nil
+}
+
+// Next returns the path of the next image to show. The directory is listed
+// each time, so images can be added and removed while the slideshow runs;
+// when the listing changes the order starts over, reshuffled if enabled.
+func (s *Slideshow) Next(dir string, shuffle bool) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("error reading slideshow directory: %v", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && slideshowExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no images in %s", dir)
+	}
+	sort.Strings(files)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Equal(files, s.files) {
+		s.files = files
+		s.reorder(shuffle)
+	} else if s.next >= len(s.order) {
+		s.reorder(shuffle)
+	}
+	path := s.order[s.next]
+	s.next++
+	return path, nil
+}
+
+// reorder starts a new pass over the files; s.mu must be held
+func (s *Slideshow) reorder(shuffle bool) {
+	s.order = append([]string{}, s.files...)
+	if shuffle {
+		rand.Shuffle(len(s.order), func(i, j int) {
+			s.order[i], s.order[j] = s.order[j], s.order[i]
+		})
+	}
+	s.next = 0
+}
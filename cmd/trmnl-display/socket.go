@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Control server for the local socket when the network control API is off
+var localControl *ControlServer
+
+// defaultControlSocket is where the daemon listens for `ctl` commands: in
+// /run for root, which the framebuffer usually needs, and otherwise in the
+// user's runtime directory
+func defaultControlSocket() string {
+	if os.Geteuid() == 0 {
+		return "/run/trmnl-display.sock"
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "trmnl-display.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("trmnl-display-%d.sock", os.Getuid()))
+}
+
+// ServeSocket serves the control API on a Unix socket until ctx is
+// cancelled. Only the socket's owner and group can connect, so requests need
+// no token.
+func (s *ControlServer) ServeSocket(ctx context.Context, path string) error {
+	// A socket left behind by a crash is removed, but not one in use
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("another trmnl-display is listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing stale control socket: %v", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("error creating control socket: %v", err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return fmt.Errorf("error creating control socket: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	s.registerAPI(mux, func(next http.HandlerFunc) http.HandlerFunc { return next })
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		server.Close() // also removes the socket file
+	}()
+	return nil
+}
+
+// socketClient returns an HTTP client that talks to the daemon over its
+// control socket; request URLs only need a path
+func socketClient(path string) *http.Client {
+	return &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+}
+
+// runCtl sends a command to the running daemon over its control socket
+func runCtl(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultControlSocket(), "Control socket of the running display")
+	duration := fs.String("duration", "", "How long show keeps the image up (default 10m)")
+	fs.Usage = func() {
+		fmt.Println("Usage: trmnl-display ctl [-socket PATH] [-duration D] refresh | pause | resume | status | show FILE|URL")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	client := socketClient(*socket)
+	var req *http.Request
+	var err error
+	switch action := args[0]; {
+	case action == "refresh" || action == "pause" || action == "resume":
+		req, err = http.NewRequest(http.MethodPost, "http://trmnl/api/"+action, nil)
+	case action == "status" && len(args) == 1:
+		req, err = http.NewRequest(http.MethodGet, "http://trmnl/api/status", nil)
+	case action == "show" && len(args) == 2:
+		req, err = showRequest(args[1], *duration)
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Printf("Error: cannot reach the running display at %s: %v\n", *socket, err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		fmt.Printf("Error: %s\n", strings.TrimSpace(string(body)))
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "status":
+		var out bytes.Buffer
+		if json.Indent(&out, body, "", "  ") != nil {
+			out.Write(body)
+		}
+		fmt.Println(strings.TrimSpace(out.String()))
+	case "show":
+		var shown struct {
+			Until time.Time `json:"until"`
+		}
+		json.Unmarshal(body, &shown)
+		fmt.Printf("Showing %s until %s\n", args[1], shown.Until.Local().Format("15:04:05"))
+	default:
+		fmt.Printf("Asked the display to %s\n", args[0])
+	}
+}
+
+// showRequest builds the push request for `ctl show`: URLs are passed on for
+// the daemon to fetch and files are uploaded
+func showRequest(source, duration string) (*http.Request, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		body, _ := json.Marshal(map[string]string{"url": source, "duration": duration})
+		req, err := http.NewRequest(http.MethodPost, "http://trmnl/api/display", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("image", filepath.Base(source))
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	if duration != "" {
+		form.WriteField("duration", duration)
+	}
+	form.Close()
+	req, err := http.NewRequest(http.MethodPost, "http://trmnl/api/display", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req, nil
+}
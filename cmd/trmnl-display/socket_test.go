@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestControlSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	s := NewControlServer(newDaemon(), "", t.TempDir(), Config{}, AppOptions{})
+	if err := s.ServeSocket(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ServeSocket(ctx, path); err == nil {
+		t.Error("second ServeSocket on a live socket succeeded, want an error")
+	}
+
+	// No token is needed over the socket
+	resp, err := socketClient(path).Get("http://trmnl/api/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /api/status = %s, want 200", resp.Status)
+	}
+}
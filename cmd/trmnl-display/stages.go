@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of stage bundles kept in the -dump-stages directory
+const maxStageDumps = 20
+
+// StageDump collects the image after each stage of the rendering pipeline,
+// with the parameters that stage used, and writes them as a single zip
+// bundle that can be attached to a bug report
+type StageDump struct {
+	Dir     string
+	Started time.Time
+	Screen  string
+
+	mu     sync.Mutex
+	stages []dumpedStage
+}
+
+// dumpedStage is one entry in a bundle
+type dumpedStage struct {
+	Name   string
+	File   string
+	Params map[string]interface{} `json:",omitempty"`
+
+	image image.Image
+	data  []byte
+}
+
+// drawingStages is the dump of the frame being presented, so drawing can
+// record its final stage; Daemon.mu must be held
+var drawingStages *StageDump
+
+// stageDumpKey carries a StageDump through a context
+type stageDumpKey struct{}
+
+// newStageDump starts a bundle if -dump-stages is set, otherwise returns nil
+func newStageDump(options AppOptions) *StageDump {
+	if options.DumpStages == "" {
+		return nil
+	}
+	return &StageDump{Dir: options.DumpStages, Started: time.Now()}
+}
+
+// withStageDump attaches d to ctx so the fetch functions can record into it
+func withStageDump(ctx context.Context, d *StageDump) context.Context {
+	if d == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, stageDumpKey{}, d)
+}
+
+// stageDumpFrom returns the dump attached to ctx, or nil
+func stageDumpFrom(ctx context.Context) *StageDump {
+	d, _ := ctx.Value(stageDumpKey{}).(*StageDump)
+	return d
+}
+
+// Record adds the image produced by a stage. It does nothing on a nil dump,
+// so callers need not check whether dumping is enabled.
+func (d *StageDump) Record(name string, img image.Image, params map[string]interface{}) {
+	if d == nil || img == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stages = append(d.stages, dumpedStage{
+		Name:   name,
+		File:   fmt.Sprintf("%02d-%s.png", len(d.stages)+1, name),
+		Params: params,
+		image:  img,
+	})
+}
+
+// RecordFile adds a stage's output as the raw file at path, such as the
+// image exactly as it was downloaded
+func (d *StageDump) RecordFile(name, path string, params map[string]interface{}) {
+	if d == nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		displayLog.Error("Error reading image for stage dump", "path", path, "err", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stages = append(d.stages, dumpedStage{
+		Name:   name,
+		File:   fmt.Sprintf("%02d-%s%s", len(d.stages)+1, name, filepath.Ext(path)),
+		Params: params,
+		data:   data,
+	})
+}
+
+// Empty reports whether no stages have been recorded
+func (d *StageDump) Empty() bool {
+	if d == nil {
+		return true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.stages) == 0
+}
+
+// Write saves the bundle as <dir>/<time>-<screen>.zip, with stages.json
+// describing each stage, and removes the oldest bundles beyond
+// maxStageDumps. It returns the bundle's path.
+func (d *StageDump) Write() (string, error) {
+	if d == nil {
+		return "", nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating stage dump directory: %v", err)
+	}
+	name := d.Started.Format("20060102-150405")
+	if d.Screen != "" {
+		name += "-" + d.Screen
+	}
+	path := filepath.Join(d.Dir, name+".zip")
+
+	out, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating stage dump: %v", err)
+	}
+	zw := zip.NewWriter(out)
+	err = d.writeEntries(zw)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("error writing stage dump: %v", err)
+	}
+
+	pruneStageDumps(d.Dir)
+	return path, nil
+}
+
+// writeEntries writes each stage and the manifest; d.mu must be held
+func (d *StageDump) writeEntries(zw *zip.Writer) error {
+	for _, stage := range d.stages {
+		w, err := zw.Create(stage.File)
+		if err != nil {
+			return err
+		}
+		if stage.image != nil {
+			err = png.Encode(w, stage.image)
+		} else {
+			_, err = w.Write(stage.data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest := struct {
+		Version string
+		Time    time.Time
+		Screen  string
+		Stages  []dumpedStage
+	}{version, d.Started, d.Screen, d.stages}
+	w, err := zw.Create("stages.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(manifest)
+}
+
+// pruneStageDumps keeps only the newest maxStageDumps bundles in dir
+func pruneStageDumps(dir string) {
+	bundles, err := filepath.Glob(filepath.Join(dir, "*.zip"))
+	if err != nil || len(bundles) <= maxStageDumps {
+		return
+	}
+	// Names start with a timestamp, so they sort oldest first
+	sort.Strings(bundles)
+	for _, path := range bundles[:len(bundles)-maxStageDumps] {
+		os.Remove(path)
+	}
+}
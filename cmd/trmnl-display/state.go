@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// LiveState holds settings changed while the daemon is running, for example
+// from the on-device menu. They take precedence over the startup options.
+type LiveState struct {
+	mu          sync.Mutex
+	darkMode    *bool
+	screen      string
+	paused      bool
+	resumeClear bool
+}
+
+// Global live state shared by the display loop and input handlers
+var live = &LiveState{}
+
+// DarkMode returns the live dark mode setting, or def if it was never changed
+func (s *LiveState) DarkMode(def bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.darkMode == nil {
+		return def
+	}
+	return *s.darkMode
+}
+
+// ToggleDarkMode flips dark mode relative to its current value and returns the result
+func (s *LiveState) ToggleDarkMode(def bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dark := def
+	if s.darkMode != nil {
+		dark = *s.darkMode
+	}
+	dark = !dark
+	s.darkMode = &dark
+	return dark
+}
+
+// SetDarkMode overrides dark mode
+func (s *LiveState) SetDarkMode(dark bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.darkMode = &dark
+}
+
+// Screen returns the screen chosen at runtime, or "" to follow the rules
+func (s *LiveState) Screen() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.screen
+}
+
+// SetScreen pins the display to a screen ("" returns control to the rules)
+func (s *LiveState) SetScreen(screen string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.screen = screen
+}
+
+// Paused reports whether refreshing is paused
+func (s *LiveState) Paused() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Pause stops refreshing, keeping the current screen
+func (s *LiveState) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused = true
+}
+
+// Resume restarts refreshing, clearing the panel first if clear is set
+func (s *LiveState) Resume(clear bool) {
+	s.mu.Lock()
+	s.paused = false
+	s.resumeClear = clear
+	s.mu.Unlock()
+	requestRefresh()
+}
+
+// takeResumeClear reports (once) whether the last resume asked for a clear
+func (s *LiveState) takeResumeClear() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear := s.resumeClear
+	s.resumeClear = false
+	return clear
+}
+
+// waitWhilePaused blocks while refreshing is paused, returning true if it
+// waited (so the caller knows its prepared frame is stale)
+func (d *Daemon) waitWhilePaused(ctx context.Context, options AppOptions) bool {
+	if !live.Paused() {
+		return false
+	}
+	controlLog.Info("Paused, keeping the current screen")
+	sdNotify("STATUS=Paused")
+	for live.Paused() {
+		sleepUntil(ctx, time.Now().Add(time.Hour))
+		if ctx.Err() != nil {
+			return true
+		}
+	}
+
+	if live.takeResumeClear() && !options.Headless {
+		d.mu.Lock()
+		if d.power != nil {
+			d.power.Wake()
+		}
+		d.clearFramebuffer()
+		d.mu.Unlock()
+	}
+	controlLog.Info("Resumed")
+	return true
+}
+
+// setupPauseSignals pauses on SIGTSTP and resumes on SIGCONT
+func setupPauseSignals(options AppOptions) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTSTP, syscall.SIGCONT)
+	go func() {
+		for sig := range c {
+			if sig == syscall.SIGTSTP {
+				live.Pause()
+			} else if live.Paused() {
+				live.Resume(options.ResumeClear)
+			}
+		}
+	}()
+}
+
+// refreshRequests wakes the display loop to fetch a new screen immediately
+var refreshRequests = make(chan struct{}, 1)
+
+// requestRefresh asks the display loop to refresh as soon as possible
+func requestRefresh() {
+	select {
+	case refreshRequests <- struct{}{}:
+	default:
+		// A refresh is already pending
+	}
+}
+
+// setupRefreshSignal refreshes immediately on SIGUSR1
+func setupRefreshSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			controlLog.Info("Received SIGUSR1, refreshing now")
+			requestRefresh()
+		}
+	}()
+}
+
+// runSignalCommand implements the refresh, pause, and resume subcommands,
+// which signal the running display
+func runSignalCommand(name string, args []string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Parse(args)
+
+	sig := map[string]syscall.Signal{
+		"refresh": syscall.SIGUSR1,
+		"pause":   syscall.SIGTSTP,
+		"resume":  syscall.SIGCONT,
+	}[name]
+
+	lock := NewFramebufferLock(lockFilePath)
+	pid, err := lock.readLockFile()
+	if err != nil || !lock.isProcessRunning(pid) {
+		fmt.Println("Error: trmnl-display does not appear to be running")
+		os.Exit(1)
+	}
+	if err := syscall.Kill(pid, sig); err != nil {
+		fmt.Printf("Error signalling process %d: %v\n", pid, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Asked process %d to %s\n", pid, name)
+}
+
+// sleepUntil waits until deadline, returning early (and true) if a refresh
+// was requested in the meantime. It also returns early, with false, when ctx
+// is cancelled.
+func sleepUntil(ctx context.Context, deadline time.Time) bool {
+	d := time.Until(deadline)
+	if d <= 0 {
+		return false
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-refreshRequests:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"trmnl-display/pkg/compose"
+	"trmnl-display/pkg/history"
+)
+
+// Daily totals are kept for this many days
+const statsDays = 90
+
+// StatsTotals are counts and timings over a period
+type StatsTotals struct {
+	Cycles    int   `json:"cycles"`    // refreshes and failed attempts
+	Refreshes int   `json:"refreshes"` // frames drawn on the panel
+	Changed   int   `json:"changed"`   // refreshes whose image differed from the one before
+	Errors    int   `json:"errors"`
+	FetchMs   int64 `json:"fetch_ms"`   // total
+	DisplayMs int64 `json:"display_ms"` // total, over refreshes
+}
+
+// Stats are the figures kept by the stats store. Unlike the history, which
+// is rotated, they cover the panel's whole life.
+type Stats struct {
+	Since     time.Time `json:"since"`
+	Started   time.Time `json:"started"` // when the display last started
+	LastCycle time.Time `json:"last_cycle"`
+	LastHash  string    `json:"last_hash,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	StatsTotals
+
+	// Totals per day (YYYY-MM-DD, local time) for graphs
+	Days map[string]StatsTotals `json:"days,omitempty"`
+}
+
+// StatsStore keeps Stats in a JSON file in the state directory, updated
+// after every cycle
+type StatsStore struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Global stats store for recording cycles
+var statsStore *StatsStore
+
+// NewStatsStore creates a stats store in dir
+func NewStatsStore(dir string) *StatsStore {
+	return &StatsStore{Path: filepath.Join(dir, "stats.json")}
+}
+
+// Read loads the stats, which are empty before the first cycle
+func (s *StatsStore) Read() (Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+// read loads the stats with s.mu held
+func (s *StatsStore) read() (Stats, error) {
+	var st Stats
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("error reading stats: %v", err)
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("error reading stats: %v", err)
+	}
+	return st, nil
+}
+
+// Started records when the display started, for its uptime
+func (s *StatsStore) Started(t time.Time) {
+	s.update(func(st *Stats) {
+		st.Started = t
+	})
+}
+
+// Record adds a cycle
+func (s *StatsStore) Record(record history.Record) {
+	s.update(func(st *Stats) {
+		if st.Since.IsZero() {
+			st.Since = record.Time
+		}
+		st.LastCycle = record.Time
+		changed := record.Error == "" && record.Hash != "" && record.Hash != st.LastHash
+		st.StatsTotals.add(record, changed)
+		if record.Error != "" {
+			st.LastError = record.Error
+		} else if record.Hash != "" {
+			st.LastHash = record.Hash
+		}
+
+		if st.Days == nil {
+			st.Days = make(map[string]StatsTotals)
+		}
+		day := record.Time.Local().Format(time.DateOnly)
+		totals := st.Days[day]
+		totals.add(record, changed)
+		st.Days[day] = totals
+		pruneStatsDays(st.Days, record.Time)
+	})
+}
+
+// update applies change to the stats and saves them. Failures are reported
+// but never interrupt the display loop.
+func (s *StatsStore) update(change func(*Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, err := s.read()
+	if err != nil {
+		// A damaged file would otherwise stop the stats for good
+		mainLog.Warn("Starting new stats", "err", err)
+		st = Stats{}
+	}
+	change(&st)
+
+	data, err := json.Marshal(st)
+	if err != nil {
+		mainLog.Error("Error encoding stats", "err", err)
+		return
+	}
+	// Written to a temporary file first so a power cut cannot leave it
+	// half written
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		mainLog.Error("Error writing stats", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, s.Path); err != nil {
+		mainLog.Error("Error writing stats", "err", err)
+	}
+}
+
+// add counts a cycle in the totals
+func (t *StatsTotals) add(record history.Record, changed bool) {
+	t.Cycles++
+	t.FetchMs += record.FetchMs
+	if record.Error != "" {
+		t.Errors++
+		return
+	}
+	t.Refreshes++
+	t.DisplayMs += record.DisplayMs
+	if changed {
+		t.Changed++
+	}
+}
+
+// pruneStatsDays drops the days older than statsDays before now
+func pruneStatsDays(days map[string]StatsTotals, now time.Time) {
+	if len(days) <= statsDays {
+		return
+	}
+	keys := make([]string, 0, len(days))
+	for day := range days {
+		keys = append(keys, day)
+	}
+	sort.Strings(keys)
+	oldest := now.Local().AddDate(0, 0, -statsDays+1).Format(time.DateOnly)
+	for _, day := range keys {
+		if day >= oldest {
+			break
+		}
+		delete(days, day)
+	}
+}
+
+// imageHash identifies a frame's pixels, to tell which refreshes changed the
+// picture
+func imageHash(img image.Image) string {
+	h := fnv.New64a()
+	b := img.Bounds()
+	binary.Write(h, binary.BigEndian, [4]int32{int32(b.Min.X), int32(b.Min.Y), int32(b.Max.X), int32(b.Max.Y)})
+	var buf [3]byte
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			buf[0], buf[1], buf[2] = byte(r>>8), byte(g>>8), byte(bl>>8)
+			h.Write(buf[:])
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// How often the stats screen is redrawn
+const statsRefreshInterval = 15 * time.Minute
+
+// statsSummary describes the stats in a few lines, for the stats command and
+// screen. Uptime is only shown while running is true.
+func statsSummary(st Stats, running bool, now time.Time) []string {
+	if st.Cycles == 0 {
+		return []string{"No refreshes recorded yet"}
+	}
+	lines := []string{fmt.Sprintf("Recording since %s", st.Since.Local().Format("2 January 2006"))}
+	if running && !st.Started.IsZero() {
+		lines = append(lines, "Up for "+formatUptime(now.Sub(st.Started)))
+	}
+	lines = append(lines,
+		fmt.Sprintf("Refreshes: %d, %d of them changed the picture", st.Refreshes, st.Changed),
+		fmt.Sprintf("Errors: %d of %d cycles (%.1f%%)", st.Errors, st.Cycles, 100*float64(st.Errors)/float64(st.Cycles)),
+		fmt.Sprintf("Average fetch %s, display %s", averageMs(st.FetchMs, st.Cycles), averageMs(st.DisplayMs, st.Refreshes)),
+	)
+	week := StatsTotals{}
+	for i := 0; i < 7; i++ {
+		day := st.Days[now.Local().AddDate(0, 0, -i).Format(time.DateOnly)]
+		week.Cycles += day.Cycles
+		week.Refreshes += day.Refreshes
+		week.Errors += day.Errors
+	}
+	lines = append(lines, fmt.Sprintf("Last 7 days: %d refreshes, %d errors", week.Refreshes, week.Errors))
+	if st.LastError != "" {
+		lines = append(lines, "Last error: "+st.LastError)
+	}
+	return lines
+}
+
+// averageMs formats an average of total milliseconds over n
+func averageMs(total int64, n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return (time.Duration(total/int64(n)) * time.Millisecond).String()
+}
+
+// formatUptime formats d in days, hours, and minutes
+func formatUptime(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days, hours, minutes := int(d/(24*time.Hour)), int(d/time.Hour)%24, int(d/time.Minute)%60
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// statsFrame renders the stats screen
+func statsFrame(now time.Time) (*Frame, error) {
+	st := Stats{}
+	if statsStore != nil {
+		var err error
+		if st, err = statsStore.Read(); err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := compose.New(defaultFrameWidth, defaultFrameHeight)
+	if err != nil {
+		return nil, err
+	}
+	titleFace := c.Face(true, 36)
+	lineFace := c.Face(false, 24)
+	defer titleFace.Close()
+	defer lineFace.Close()
+
+	c.FillRect(image.Rect(0, 0, defaultFrameWidth, 70), color.Black)
+	c.DrawText("Statistics", 20, 48, titleFace, color.White)
+	for i, line := range statsSummary(st, true, now) {
+		line = compose.WrapText(line, lineFace, defaultFrameWidth-60)[0]
+		c.DrawText(line, 30, 125+i*48, lineFace, color.Black)
+	}
+	return &Frame{Image: c.Frame, Refresh: statsRefreshInterval}, nil
+}
+
+// runStats implements the stats command, which summarises the stats store
+func runStats(args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	days := fs.Int("days", 7, "Also show totals for each of this many days")
+	asJSON := fs.Bool("json", false, "Print the stats store as JSON")
+	fs.Parse(args)
+
+	stateDir, err := stateDirectory("")
+	if err != nil {
+		fmt.Printf("Error setting up state directory: %v\n", err)
+		os.Exit(1)
+	}
+	st, err := NewStatsStore(stateDir).Read()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *asJSON {
+		data, _ := json.MarshalIndent(st, "", "  ")
+		fmt.Println(string(data))
+		return
+	}
+
+	lock := NewFramebufferLock(lockFilePath)
+	pid, err := lock.readLockFile()
+	running := err == nil && lock.isProcessRunning(pid)
+	now := time.Now()
+	for _, line := range statsSummary(st, running, now) {
+		fmt.Println(line)
+	}
+	if st.Cycles == 0 || *days <= 0 {
+		return
+	}
+
+	fmt.Printf("\n%-10s  %9s  %7s  %6s  %9s  %9s\n", "Day", "Refreshes", "Changed", "Errors", "Fetch", "Display")
+	for i := 0; i < *days; i++ {
+		day := now.Local().AddDate(0, 0, -i).Format(time.DateOnly)
+		t := st.Days[day]
+		fmt.Printf("%-10s  %9d  %7d  %6d  %9s  %9s\n", day, t.Refreshes, t.Changed, t.Errors,
+			averageMs(t.FetchMs, t.Cycles), averageMs(t.DisplayMs, t.Refreshes))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"trmnl-display/pkg/history"
+)
+
+func TestStatsStoreRecord(t *testing.T) {
+	s := NewStatsStore(t.TempDir())
+	start := time.Date(2025, 6, 1, 9, 0, 0, 0, time.Local)
+	s.Record(history.Record{Time: start, FetchMs: 100, DisplayMs: 1000, Hash: "a"})
+	s.Record(history.Record{Time: start.Add(time.Minute), FetchMs: 200, DisplayMs: 1000, Hash: "a"})
+	s.Record(history.Record{Time: start.Add(2 * time.Minute), FetchMs: 50, Error: "timeout"})
+	s.Record(history.Record{Time: start.Add(24 * time.Hour), FetchMs: 100, DisplayMs: 2000, Hash: "b"})
+
+	st, err := s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := StatsTotals{Cycles: 4, Refreshes: 3, Changed: 2, Errors: 1, FetchMs: 450, DisplayMs: 4000}
+	if st.StatsTotals != want {
+		t.Errorf("totals = %+v, want %+v", st.StatsTotals, want)
+	}
+	if !st.Since.Equal(start) || st.LastError != "timeout" || st.LastHash != "b" {
+		t.Errorf("stats = %+v", st)
+	}
+	if len(st.Days) != 2 || st.Days["2025-06-01"].Errors != 1 || st.Days["2025-06-02"].Refreshes != 1 {
+		t.Errorf("days = %+v", st.Days)
+	}
+}
+
+func TestPruneStatsDays(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.Local)
+	days := make(map[string]StatsTotals)
+	for i := 0; i < statsDays+5; i++ {
+		days[now.AddDate(0, 0, -i).Format(time.DateOnly)] = StatsTotals{}
+	}
+	pruneStatsDays(days, now)
+	if len(days) != statsDays {
+		t.Errorf("%d days kept, want %d", len(days), statsDays)
+	}
+	if _, ok := days[now.Format(time.DateOnly)]; !ok {
+		t.Error("today was pruned")
+	}
+}
+
+func TestImageHash(t *testing.T) {
+	a := image.NewGray(image.Rect(0, 0, 4, 4))
+	b := image.NewGray(image.Rect(0, 0, 4, 4))
+	if imageHash(a) != imageHash(b) {
+		t.Error("identical images hash differently")
+	}
+	b.SetGray(1, 1, color.Gray{Y: 255})
+	if imageHash(a) == imageHash(b) {
+		t.Error("different images hash the same")
+	}
+}
+
+func TestStatsSummary(t *testing.T) {
+	now := time.Date(2025, 6, 3, 12, 0, 0, 0, time.Local)
+	st := Stats{
+		Since:       time.Date(2025, 6, 1, 9, 0, 0, 0, time.Local),
+		Started:     now.Add(-26*time.Hour - 5*time.Minute),
+		StatsTotals: StatsTotals{Cycles: 4, Refreshes: 3, Changed: 2, Errors: 1, FetchMs: 400, DisplayMs: 3000},
+		Days:        map[string]StatsTotals{"2025-06-03": {Refreshes: 2, Errors: 1}, "2025-05-01": {Refreshes: 9}},
+	}
+	want := []string{
+		"Recording since 1 June 2025",
+		"Up for 1d 2h 5m",
+		"Refreshes: 3, 2 of them changed the picture",
+		"Errors: 1 of 4 cycles (25.0%)",
+		"Average fetch 100ms, display 1s",
+		"Last 7 days: 2 refreshes, 1 errors",
+	}
+	got := statsSummary(st, true, now)
+	if len(got) != len(want) {
+		t.Fatalf("statsSummary = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if lines := statsSummary(st, false, now); lines[1] == want[1] {
+		t.Error("uptime shown while not running")
+	}
+}
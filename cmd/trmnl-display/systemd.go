@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify sends a state update to systemd when running as a Type=notify
+// service. It does nothing when NOTIFY_SOCKET is unset.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // Abstract socket
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		mainLog.Error("Error connecting to systemd", "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		mainLog.Error("Error notifying systemd", "err", err)
+	}
+}
+
+// Watchdog pings the systemd watchdog while the display loop is healthy. The
+// loop marks the steps that should finish promptly (fetching and drawing) as
+// busy; if one of them hangs, for example on a stuck panel, the pings stop
+// and systemd restarts the service.
+type Watchdog struct {
+	Timeout time.Duration
+
+	mu        sync.Mutex
+	busySince time.Time
+}
+
+// Global watchdog, nil when systemd has not enabled one
+var watchdog *Watchdog
+
+// startWatchdog starts pinging if systemd set WATCHDOG_USEC for this process
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	watchdog = &Watchdog{Timeout: time.Duration(usec) * time.Microsecond}
+	go func() {
+		for range time.Tick(watchdog.Timeout / 2) {
+			if watchdog.healthy() {
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
+
+// Busy marks the start of a step that should not take longer than the
+// watchdog timeout; call the returned function when it is done
+func (w *Watchdog) Busy() func() {
+	if w == nil {
+		return func() {}
+	}
+	w.mu.Lock()
+	w.busySince = time.Now()
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		w.busySince = time.Time{}
+		w.mu.Unlock()
+	}
+}
+
+// healthy reports whether the loop is idle or has been busy for less than
+// the timeout
+func (w *Watchdog) healthy() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.busySince.IsZero() || time.Since(w.busySince) < w.Timeout
+}
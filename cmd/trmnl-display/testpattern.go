@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gonutz/framebuffer"
+
+	"trmnl-display/pkg/compose"
+)
+
+// testPattern is an image generated to check a panel
+type testPattern struct {
+	Name        string
+	Description string
+	Render      func(c *compose.Compositor) error
+}
+
+// testPatterns are shown in this order by the testpattern command
+var testPatterns = []testPattern{
+	{"border", "1px border, centre cross, and corner marks for checking framing", renderBorderPattern},
+	{"checkerboard", "16px checkerboard", func(c *compose.Compositor) error { return renderCheckerboard(c, 16) }},
+	{"pixels", "1px checkerboard, the finest detail the panel can show", func(c *compose.Compositor) error { return renderCheckerboard(c, 1) }},
+	{"rows", "numbered bands of rows, for spotting dead or stuck rows and columns", renderRowsPattern},
+	{"gradient", "smooth and 16-step grey ramps, for tuning dithering and thresholds", renderGradientPattern},
+	{"text", "text chart from 8 to 40px", renderTextPattern},
+	{"black", "solid black, for spotting stuck white pixels", func(c *compose.Compositor) error { c.FillRect(c.Frame.Bounds(), color.Black); return nil }},
+	{"white", "solid white, for spotting stuck black pixels and ghosting", func(c *compose.Compositor) error { return nil }},
+}
+
+// runTestPattern implements the testpattern command, which cycles through
+// generated patterns at the panel's native resolution
+func runTestPattern(args []string) {
+	fs := flag.NewFlagSet("testpattern", flag.ExitOnError)
+	interval := fs.Duration("interval", 10*time.Second, "How long each pattern is shown")
+	only := fs.String("pattern", "", "Show only these comma-separated patterns (default all)")
+	loop := fs.Bool("loop", false, "Keep cycling until interrupted")
+	output := fs.String("o", "", "Save the patterns as PNGs in this directory instead of drawing them")
+	fs.Usage = func() {
+		fmt.Println("Usage: trmnl-display testpattern [flags]")
+		fs.PrintDefaults()
+		fmt.Println("\nPatterns:")
+		for _, p := range testPatterns {
+			fmt.Printf("  %-13s %s\n", p.Name, p.Description)
+		}
+	}
+	fs.Parse(args)
+
+	patterns, err := selectTestPatterns(*only)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if *output != "" {
+		if err := os.MkdirAll(*output, 0755); err != nil {
+			fmt.Printf("Error creating %s: %v\n", *output, err)
+			os.Exit(1)
+		}
+		for _, p := range patterns {
+			img, err := renderTestPattern(p, defaultFrameWidth, defaultFrameHeight)
+			if err != nil {
+				fmt.Printf("Error rendering %s pattern: %v\n", p.Name, err)
+				os.Exit(1)
+			}
+			path := filepath.Join(*output, p.Name+".png")
+			if err := saveShownFrame(img, path, nil); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	checkRoot()
+	d := newDaemon()
+	d.lock = NewFramebufferLock(lockFilePath)
+	if err := d.lock.Acquire(); err != nil {
+		fmt.Printf("Error acquiring framebuffer lock: %v\n", err)
+		os.Exit(1)
+	}
+	defer d.lock.Release()
+	if err := d.openConfiguredPanel(); err != nil {
+		fmt.Printf("Error opening panel: %v\n", err)
+		os.Exit(1)
+	}
+	defer d.closePanel()
+
+	// Draw at the panel's own resolution so every pixel maps 1:1
+	var bounds image.Rectangle
+	if d.panel != nil {
+		bounds = d.panel.Bounds()
+	} else {
+		fb, err := framebuffer.Open("/dev/fb0")
+		if err != nil {
+			fmt.Printf("Error opening framebuffer: %v\n", err)
+			return
+		}
+		bounds = fb.Bounds()
+		fb.Close()
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	options := AppOptions{}
+	for {
+		for _, p := range patterns {
+			img, err := renderTestPattern(p, bounds.Dx(), bounds.Dy())
+			if err != nil {
+				fmt.Printf("Error rendering %s pattern: %v\n", p.Name, err)
+				return
+			}
+			fmt.Printf("Showing %s: %s\n", p.Name, p.Description)
+			if err := d.drawFrame(img, options); err != nil {
+				fmt.Printf("Error drawing %s pattern: %v\n", p.Name, err)
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(*interval):
+			}
+		}
+		if !*loop {
+			return
+		}
+	}
+}
+
+// selectTestPatterns picks the named patterns, or all of them for ""
+func selectTestPatterns(names string) ([]testPattern, error) {
+	if names == "" {
+		return testPatterns, nil
+	}
+	var selected []testPattern
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, p := range testPatterns {
+			if p.Name == name {
+				selected = append(selected, p)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown pattern %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// renderTestPattern draws a pattern at the given size
+func renderTestPattern(p testPattern, width, height int) (image.Image, error) {
+	c, err := compose.New(width, height)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Render(c); err != nil {
+		return nil, err
+	}
+	return c.Frame, nil
+}
+
+// renderBorderPattern outlines the frame and marks its centre and corners
+func renderBorderPattern(c *compose.Compositor) error {
+	b := c.Frame.Bounds()
+	w, h := b.Dx(), b.Dy()
+	c.FillRect(image.Rect(0, 0, w, 1), color.Black)
+	c.FillRect(image.Rect(0, h-1, w, h), color.Black)
+	c.FillRect(image.Rect(0, 0, 1, h), color.Black)
+	c.FillRect(image.Rect(w-1, 0, w, h), color.Black)
+	c.FillRect(image.Rect(w/2, 0, w/2+1, h), color.Black)
+	c.FillRect(image.Rect(0, h/2, w, h/2+1), color.Black)
+
+	mark := h / 10
+	for _, corner := range []image.Point{{0, 0}, {w - mark, 0}, {0, h - mark}, {w - mark, h - mark}} {
+		c.FillRect(image.Rectangle{Min: corner, Max: corner.Add(image.Pt(mark, mark))}, color.Black)
+	}
+
+	face := c.Face(false, float64(h)/20)
+	defer face.Close()
+	c.DrawTextCentered(fmt.Sprintf("%d × %d", w, h), image.Rect(0, 0, w/2, h), h/4, face, color.Black)
+	return nil
+}
+
+// renderCheckerboard fills the frame with squares of the given size
+func renderCheckerboard(c *compose.Compositor, size int) error {
+	b := c.Frame.Bounds()
+	for y := 0; y < b.Dy(); y += size {
+		for x := 0; x < b.Dx(); x += size {
+			if (x/size+y/size)%2 == 0 {
+				c.FillRect(image.Rect(x, y, x+size, y+size), color.Black)
+			}
+		}
+	}
+	return nil
+}
+
+// renderRowsPattern draws alternating bands of rows labelled with their
+// first row, and a column ruler along the top, so a dead row or column can
+// be located exactly
+func renderRowsPattern(c *compose.Compositor) error {
+	b := c.Frame.Bounds()
+	band := 20
+	face := c.Face(false, 14)
+	defer face.Close()
+
+	for y := 0; y < b.Dy(); y += band {
+		if (y/band)%2 == 1 {
+			c.FillRect(image.Rect(0, y, b.Dx(), y+band), color.Black)
+			c.DrawText(fmt.Sprint(y), 4, y+band-5, face, color.White)
+		} else {
+			c.DrawText(fmt.Sprint(y), 4, y+band-5, face, color.Black)
+		}
+	}
+	// Ruler: a tick every 10 columns, longer every 50, in the top band
+	for x := 50; x < b.Dx(); x += 10 {
+		length := 6
+		if x%50 == 0 {
+			length = band
+		}
+		c.FillRect(image.Rect(x, 0, x+1, length), color.Black)
+	}
+	return nil
+}
+
+// renderGradientPattern draws a smooth grey ramp above a 16-step one
+func renderGradientPattern(c *compose.Compositor) error {
+	b := c.Frame.Bounds()
+	w, h := b.Dx(), b.Dy()
+	for x := 0; x < w; x++ {
+		level := uint8(x * 255 / max(w-1, 1))
+		c.FillRect(image.Rect(x, 0, x+1, h/2), color.Gray{Y: level})
+	}
+	for step := 0; step < 16; step++ {
+		level := uint8(step * 255 / 15)
+		c.FillRect(image.Rect(step*w/16, h/2, (step+1)*w/16, h), color.Gray{Y: level})
+	}
+	return nil
+}
+
+// renderTextPattern draws sample text at a range of sizes
+func renderTextPattern(c *compose.Compositor) error {
+	b := c.Frame.Bounds()
+	y := 4
+	for _, size := range []float64{8, 10, 12, 14, 16, 20, 24, 32, 40} {
+		for _, bold := range []bool{false, true} {
+			face := c.Face(bold, size)
+			y += face.Metrics().Ascent.Ceil()
+			if y > b.Dy() {
+				face.Close()
+				return nil
+			}
+			weight := "regular"
+			if bold {
+				weight = "bold"
+			}
+			c.DrawText(fmt.Sprintf("%gpx %s: Hamburgefonstiv 0123456789", size, weight), 8, y, face, color.Black)
+			y += face.Metrics().Descent.Ceil() + 2
+			face.Close()
+		}
+	}
+	return nil
+}
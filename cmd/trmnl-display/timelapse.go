@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// Default timelapse settings
+const (
+	defaultTimelapseSince = 24 * time.Hour
+	defaultTimelapseDelay = 500 * time.Millisecond
+)
+
+// runTimelapse implements the timelapse command, which stitches archived
+// frames into an animated GIF, or an MP4 when ffmpeg is installed
+func runTimelapse(args []string) {
+	fs := flag.NewFlagSet("timelapse", flag.ExitOnError)
+	dir := fs.String("dir", "", "Archive directory (default ArchiveDir from the config, or ~/.local/state/trmnl/archive)")
+	since := fs.Duration("since", defaultTimelapseSince, "Include frames archived within this long")
+	delay := fs.Duration("delay", defaultTimelapseDelay, "How long each frame is shown")
+	width := fs.Int("width", defaultFrameWidth, "Scale frames to this width in pixels")
+	output := fs.String("o", "timelapse.gif", "Write the timelapse to this file (.gif or .mp4)")
+	keepRepeats := fs.Bool("keep-repeats", false, "Keep frames identical to the one before")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: trmnl-display timelapse [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *width <= 0 || *delay <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	ext := strings.ToLower(filepath.Ext(*output))
+	if ext != ".gif" && ext != ".mp4" {
+		fmt.Printf("Error: %s should end in .gif or .mp4\n", *output)
+		os.Exit(2)
+	}
+
+	if *dir == "" {
+		*dir = defaultArchiveDir()
+	}
+	paths, err := timelapseFrames(*dir, time.Now().Add(-*since), !*keepRepeats)
+	if err != nil {
+		fmt.Printf("Error reading archive: %v\n", err)
+		os.Exit(1)
+	}
+	if len(paths) == 0 {
+		fmt.Printf("No frames archived in %s within %s\n", *dir, *since)
+		os.Exit(1)
+	}
+
+	if ext == ".mp4" {
+		err = writeTimelapseMP4(*output, paths, *delay, *width)
+	} else {
+		err = writeTimelapseGIF(*output, paths, *delay, *width)
+	}
+	if err != nil {
+		fmt.Printf("Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d frames to %s\n", len(paths), *output)
+}
+
+// defaultArchiveDir is the archive the daemon writes to without
+// -archive-dir: ArchiveDir from the config file, or the state directory's
+func defaultArchiveDir() string {
+	if config, err := readConfigIfExists(); err == nil && config.ArchiveDir != "" {
+		return config.ArchiveDir
+	}
+	stateDir, err := stateDirectory("")
+	if err != nil {
+		fmt.Printf("Error setting up state directory: %v\n", err)
+		os.Exit(1)
+	}
+	return filepath.Join(stateDir, "archive")
+}
+
+// timelapseFrames lists the frames in dir archived since from, oldest
+// first. With skipRepeats, frames whose file matches the one before are left
+// out, so a dashboard that rarely changes does not stall the animation.
+func timelapseFrames(dir string, from time.Time, skipRepeats bool) ([]string, error) {
+	frames, err := listFrames(dir)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(frames)
+
+	var paths []string
+	var previous []byte
+	for _, frame := range frames {
+		if frame.Time.Before(from) {
+			continue
+		}
+		path := filepath.Join(dir, frame.Name)
+		if skipRepeats {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(data, previous) {
+				continue
+			}
+			previous = data
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// loadTimelapseFrame decodes an archived frame and scales it to width
+func loadTimelapseFrame(path string, width int) (image.Image, error) {
+	img, err := loadImageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	if b.Dx() == width {
+		return img, nil
+	}
+	height := max(1, b.Dy()*width/b.Dx())
+	return render.Scale(img, image.Rect(0, 0, width, height)), nil
+}
+
+// writeTimelapseGIF writes the frames as a looping GIF. Frames are mapped to
+// the nearest palette colour without dithering, since panel frames are
+// already dithered.
+func writeTimelapseGIF(output string, paths []string, delay time.Duration, width int) error {
+	anim := &gif.GIF{}
+	for _, path := range paths {
+		img, err := loadTimelapseFrame(path, width)
+		if err != nil {
+			return err
+		}
+		frame := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.Draw(frame, frame.Rect, img, img.Bounds().Min, draw.Src)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, int(delay/(10*time.Millisecond)))
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(out, anim); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// writeTimelapseMP4 hands the frames to ffmpeg, as a numbered PNG sequence
+// of equally sized frames
+func writeTimelapseMP4(output string, paths []string, delay time.Duration, width int) error {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("MP4 output needs ffmpeg installed; write a .gif instead")
+	}
+	tmpDir, err := os.MkdirTemp("", "trmnl-timelapse")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var size image.Rectangle
+	for i, path := range paths {
+		img, err := loadTimelapseFrame(path, width)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			// Most players need even dimensions
+			size = image.Rect(0, 0, img.Bounds().Dx()&^1, img.Bounds().Dy()&^1)
+		}
+		out, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("%06d.png", i)))
+		if err != nil {
+			return err
+		}
+		err = png.Encode(out, render.Scale(img, size))
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	rate := fmt.Sprintf("%g", float64(time.Second)/float64(delay))
+	cmd := exec.Command(ffmpeg, "-loglevel", "error", "-y",
+		"-framerate", rate, "-i", filepath.Join(tmpDir, "%06d.png"),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-r", "30", output)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTimelapseFrames(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	write := func(age time.Duration, content string) string {
+		name := now.Add(-age).Format("20060102-150405") + ".png"
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		return filepath.Join(dir, name)
+	}
+	write(3*time.Hour, "a") // too old
+	first := write(2*time.Hour, "a")
+	repeat := write(90*time.Minute, "a")
+	last := write(time.Hour, "b")
+
+	from := now.Add(-150 * time.Minute)
+	paths, err := timelapseFrames(dir, from, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || paths[0] != first || paths[1] != last {
+		t.Errorf("timelapseFrames = %v, want %v", paths, []string{first, last})
+	}
+
+	paths, err = timelapseFrames(dir, from, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 || paths[1] != repeat {
+		t.Errorf("timelapseFrames keeping repeats = %v", paths)
+	}
+}
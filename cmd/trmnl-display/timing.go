@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StageTimings records how long each stage of a cycle took: download,
+// decode, scale, draw, and the panel's own steps. Like StageDump, it travels
+// through the fetch functions in a context and is reached by drawing through
+// drawingTimings.
+type StageTimings struct {
+	mu     sync.Mutex
+	stages []StageTiming
+}
+
+// StageTiming is the time one stage took
+type StageTiming struct {
+	Name     string
+	Duration time.Duration
+}
+
+// drawingTimings are the timings of the frame being presented, so drawing
+// can add its stages; Daemon.mu must be held
+var drawingTimings *StageTimings
+
+// stageTimingsKey carries StageTimings through a context
+type stageTimingsKey struct{}
+
+// withStageTimings attaches t to ctx so the fetch functions can record into it
+func withStageTimings(ctx context.Context, t *StageTimings) context.Context {
+	return context.WithValue(ctx, stageTimingsKey{}, t)
+}
+
+// stageTimingsFrom returns the timings attached to ctx, or nil
+func stageTimingsFrom(ctx context.Context) *StageTimings {
+	t, _ := ctx.Value(stageTimingsKey{}).(*StageTimings)
+	return t
+}
+
+// Add records a stage, adding to its time if it ran before. It does nothing
+// on nil timings.
+func (t *StageTimings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.stages {
+		if t.stages[i].Name == name {
+			t.stages[i].Duration += d
+			return
+		}
+	}
+	t.stages = append(t.stages, StageTiming{Name: name, Duration: d})
+}
+
+// Since records a stage that started at start
+func (t *StageTimings) Since(name string, start time.Time) {
+	t.Add(name, time.Since(start))
+}
+
+// Stages returns the stages in the order they first ran
+func (t *StageTimings) Stages() []StageTiming {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]StageTiming(nil), t.stages...)
+}
+
+// Millis returns each stage's time in milliseconds, for the history
+func (t *StageTimings) Millis() map[string]int64 {
+	stages := t.Stages()
+	if len(stages) == 0 {
+		return nil
+	}
+	ms := make(map[string]int64, len(stages))
+	for _, s := range stages {
+		ms[s.Name] = s.Duration.Milliseconds()
+	}
+	return ms
+}
+
+// logAttrs returns the stages as log attributes, e.g. decode_ms=120
+func (t *StageTimings) logAttrs() []any {
+	var attrs []any
+	for _, s := range t.Stages() {
+		attrs = append(attrs, s.Name+"_ms", s.Duration.Milliseconds())
+	}
+	return attrs
+}
+
+// stageMetric is the running total for one stage
+type stageMetric struct {
+	count int
+	sum   time.Duration
+	last  time.Duration
+	max   time.Duration
+}
+
+// StageMetrics totals the stage timings of every cycle since the display
+// started, for GET /api/metrics
+type StageMetrics struct {
+	mu     sync.Mutex
+	stages map[string]*stageMetric
+}
+
+// Global stage metrics, updated by the display loop
+var stageMetrics = &StageMetrics{}
+
+// Observe adds a cycle's timings
+func (m *StageMetrics) Observe(t *StageTimings) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stages == nil {
+		m.stages = make(map[string]*stageMetric)
+	}
+	for _, s := range t.Stages() {
+		metric := m.stages[s.Name]
+		if metric == nil {
+			metric = &stageMetric{}
+			m.stages[s.Name] = metric
+		}
+		metric.count++
+		metric.sum += s.Duration
+		metric.last = s.Duration
+		metric.max = max(metric.max, s.Duration)
+	}
+}
+
+// WritePrometheus writes the totals in the Prometheus text format
+func (m *StageMetrics) WritePrometheus(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.stages))
+	for name := range m.stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b.WriteString("# HELP trmnl_stage_seconds Time spent in each stage of the refresh cycle\n# TYPE trmnl_stage_seconds summary\n")
+	for _, name := range names {
+		fmt.Fprintf(b, "trmnl_stage_seconds_sum{stage=%q} %g\n", name, m.stages[name].sum.Seconds())
+		fmt.Fprintf(b, "trmnl_stage_seconds_count{stage=%q} %d\n", name, m.stages[name].count)
+	}
+	gauges := []struct {
+		name, help string
+		value      func(*stageMetric) time.Duration
+	}{
+		{"trmnl_stage_last_seconds", "Time the last run of each stage took", func(s *stageMetric) time.Duration { return s.last }},
+		{"trmnl_stage_max_seconds", "Longest time each stage has taken", func(s *stageMetric) time.Duration { return s.max }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, name := range names {
+			fmt.Fprintf(b, "%s{stage=%q} %g\n", gauge.name, name, gauge.value(m.stages[name]).Seconds())
+		}
+	}
+}
+
+// handleMetrics serves the stage timings and the refresh counts for
+// Prometheus
+func (s *ControlServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	stageMetrics.WritePrometheus(&b)
+	if statsStore != nil {
+		if st, err := statsStore.Read(); err == nil {
+			fmt.Fprintf(&b, "# HELP trmnl_refreshes_total Frames drawn on the panel\n# TYPE trmnl_refreshes_total counter\ntrmnl_refreshes_total %d\n", st.Refreshes)
+			fmt.Fprintf(&b, "# HELP trmnl_errors_total Failed fetches and draws\n# TYPE trmnl_errors_total counter\ntrmnl_errors_total %d\n", st.Errors)
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageTimings(t *testing.T) {
+	timings := &StageTimings{}
+	ctx := withStageTimings(context.Background(), timings)
+	stageTimingsFrom(ctx).Add("download", 100*time.Millisecond)
+	stageTimingsFrom(ctx).Add("decode", 20*time.Millisecond)
+	stageTimingsFrom(ctx).Add("download", 50*time.Millisecond)
+
+	stages := timings.Stages()
+	if len(stages) != 2 || stages[0].Name != "download" || stages[0].Duration != 150*time.Millisecond {
+		t.Errorf("Stages = %v, want download (150ms) then decode", stages)
+	}
+	if ms := timings.Millis(); ms["download"] != 150 || ms["decode"] != 20 {
+		t.Errorf("Millis = %v", ms)
+	}
+
+	// Without timings in the context, recording does nothing
+	stageTimingsFrom(context.Background()).Add("download", time.Second)
+	if ms := (*StageTimings)(nil).Millis(); ms != nil {
+		t.Errorf("Millis on nil timings = %v", ms)
+	}
+}
+
+func TestStageMetricsPrometheus(t *testing.T) {
+	m := &StageMetrics{}
+	for _, d := range []time.Duration{2 * time.Second, time.Second} {
+		timings := &StageTimings{}
+		timings.Add("panel_refresh", d)
+		m.Observe(timings)
+	}
+	var b strings.Builder
+	m.WritePrometheus(&b)
+	for _, want := range []string{
+		`trmnl_stage_seconds_sum{stage="panel_refresh"} 3`,
+		`trmnl_stage_seconds_count{stage="panel_refresh"} 2`,
+		`trmnl_stage_last_seconds{stage="panel_refresh"} 1`,
+		`trmnl_stage_max_seconds{stage="panel_refresh"} 2`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"trmnl-display/pkg/panel"
+)
+
+// touchAuto picks the first touchscreen found for -touch
+const touchAuto = "auto"
+
+// Regions used when -touch is given without -touch-regions: the middle
+// opens the menu, and the left and right halves switch source and refresh
+const defaultTouchRegions = "center=menu,left=source,right=refresh"
+
+// touchArea is a part of the panel, from 0 to 1 across and down
+type touchArea struct {
+	X0, Y0, X1, Y1 float64
+}
+
+// touchAreas are the regions -touch-regions can name
+var touchAreas = map[string]touchArea{
+	"any":          {0, 0, 1, 1},
+	"left":         {0, 0, 0.5, 1},
+	"right":        {0.5, 0, 1, 1},
+	"top":          {0, 0, 1, 0.5},
+	"bottom":       {0, 0.5, 1, 1},
+	"center":       {1.0 / 3, 1.0 / 3, 2.0 / 3, 2.0 / 3},
+	"top-left":     {0, 0, 0.5, 0.5},
+	"top-right":    {0.5, 0, 1, 0.5},
+	"bottom-left":  {0, 0.5, 0.5, 1},
+	"bottom-right": {0.5, 0.5, 1, 1},
+}
+
+// TouchRegion binds an action to touches within a region of the panel
+type TouchRegion struct {
+	Name   string
+	Area   touchArea
+	Action string
+}
+
+// parseTouchRegions parses comma-separated REGION=ACTION bindings. A touch
+// runs the action of the first region containing it, so smaller regions
+// go before those around them.
+func parseTouchRegions(s string) ([]TouchRegion, error) {
+	var regions []TouchRegion
+	for _, binding := range strings.Split(s, ",") {
+		binding = strings.TrimSpace(binding)
+		if binding == "" {
+			continue
+		}
+		name, action, found := strings.Cut(binding, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid touch region %q, expected REGION=ACTION", binding)
+		}
+		area, ok := touchAreas[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown touch region %q, expected any, left, right, top, bottom, center, top-left, top-right, bottom-left, or bottom-right", name)
+		}
+		if err := validateAction(action); err != nil {
+			return nil, err
+		}
+		regions = append(regions, TouchRegion{Name: name, Area: area, Action: action})
+	}
+	return regions, nil
+}
+
+// touchRegionAt returns the first region containing p
+func touchRegionAt(regions []TouchRegion, p panel.TouchPoint) (TouchRegion, bool) {
+	for _, region := range regions {
+		a := region.Area
+		if p.X >= a.X0 && p.X <= a.X1 && p.Y >= a.Y0 && p.Y <= a.Y1 {
+			return region, true
+		}
+	}
+	return TouchRegion{}, false
+}
+
+// startTouch opens the touchscreen and asks for the action of the region
+// each tap lands in
+func startTouch(options AppOptions) error {
+	path := options.Touch
+	if path == touchAuto {
+		path = ""
+	}
+	touch, err := panel.OpenTouch(path)
+	if err != nil {
+		return err
+	}
+	menuLog.Info("Touchscreen opened", "device", touch.Path)
+	go func() {
+		for {
+			p, err := touch.Next()
+			if err != nil {
+				menuLog.Error("Error watching touchscreen", "err", err)
+				return
+			}
+			if region, ok := touchRegionAt(options.TouchRegions, p); ok {
+				inputActions <- inputAction{Source: "touch " + region.Name, Action: region.Action}
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,806 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	_ "golang.org/x/image/bmp" // Register BMP decoder
+
+	"github.com/gonutz/framebuffer"
+
+	"trmnl-display/pkg/history"
+	"trmnl-display/pkg/render"
+)
+
+// Default limit protecting low-memory devices from oversized images
+const defaultMaxPixels = 16 * 1024 * 1024 // 16 megapixels, ~64MB once decoded to RGBA
+
+// Version information
+var (
+	version   = "0.1.0"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// FramebufferLock represents the lock file structure
+type FramebufferLock struct {
+	LockPath string
+	Acquired bool
+}
+
+// Lock file holding the PID of the running display; each of several
+// displays has one of its own, named by displayLockFile
+const lockFilePath = "/var/lock/trmnl-display.lock"
+
+// Add this new function to disable the cursor
+func disableCursor() error {
+	// Method 1: Using the terminal settings
+	termios := syscall.Termios{
+		Iflag: 0,
+		Oflag: 0,
+		Cflag: 0,
+		Lflag: 0,
+	}
+
+	tty, err := os.OpenFile("/dev/tty1", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("error opening /dev/tty1: %v", err)
+	}
+	defer tty.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, tty.Fd(), uintptr(syscall.TCSETS), uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return fmt.Errorf("ioctl error: %v", errno)
+	}
+
+	// Method 2: Try to disable the cursor via escape sequence
+	_, err = tty.Write([]byte("\033[?25l"))
+	if err != nil {
+		return fmt.Errorf("error writing escape sequence: %v", err)
+	}
+
+	// Method 3: Use the console blinking cursor control
+	err = ioutil.WriteFile("/sys/class/graphics/fbcon/cursor_blink", []byte("0"), 0644)
+	if err != nil {
+		displayLog.Warn("Failed to disable cursor blink via sysfs", "err", err)
+		// Not returning error as this is optional
+	}
+
+	// Method 4: Try to disable GPM mouse daemon if running
+	if _, err := os.Stat("/var/run/gpm.pid"); err == nil {
+		displayLog.Info("GPM mouse daemon detected, attempting to disable it")
+		exec.Command("sudo", "service", "gpm", "stop").Run()
+	}
+
+	return nil
+}
+
+func restoreCursor() {
+	tty, err := os.OpenFile("/dev/tty1", os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer tty.Close()
+
+	// Re-enable cursor
+	tty.Write([]byte("\033[?25h"))
+
+	// Re-enable cursor blink
+	ioutil.WriteFile("/sys/class/graphics/fbcon/cursor_blink", []byte("1"), 0644)
+}
+
+// runDaemon implements the run command: it shows the TRMNL playlist (or
+// whatever the rules select) until interrupted
+func runDaemon(args []string) {
+	// Parse command line arguments and the config file
+	options, config, err := parseOptions(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	commandLineArgs = args
+	if err := setupLogging(options); err != nil {
+		mainLog.Error("Error setting up logging", "err", err)
+	}
+	if len(options.Displays) > 0 && options.Instance == "" {
+		runDisplays(options.Displays, args)
+		return
+	}
+	if config.Profile != "" {
+		configLog.Info("Using profile", "profile", config.Profile)
+	}
+
+	// Check root privileges (not needed when there is no display to drive,
+	// and checked again when setting it up if headless is the fallback)
+	if needsHardware(options) && !options.HeadlessFallback {
+		checkRoot()
+	}
+
+	// Set up signal handling for clean exit: the first signal cancels ctx
+	// and lets in-flight work wind down
+	d := newDaemon()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.setupSignalHandling(cancel)
+	setupRefreshSignal()
+	setupReloadSignal()
+	setupPauseSignals(options)
+	startWatchdog()
+
+	// Check the environment first
+	mainLog.Debug("Checking system environment")
+	if options.DarkMode {
+		mainLog.Debug("Dark mode enabled - images will be inverted")
+	}
+	if !options.Headless {
+		checkDisplayServer()
+		listFramebufferDevices()
+	}
+
+	// Create a configuration directory
+	configDir, err := configDirectory()
+	if err != nil {
+		configLog.Error("Error setting up config directory", "err", err)
+		os.Exit(1)
+	}
+
+	// Record refresh history and stats in the state directory
+	stateDir, err := stateDirectory(options.Instance)
+	if err != nil {
+		mainLog.Error("Error setting up state directory", "err", err)
+		os.Exit(1)
+	}
+	d.history = history.New(stateDir)
+	statsStore = NewStatsStore(stateDir)
+	statsStore.Started(time.Now())
+
+	// Apply network settings and reminders from the config file
+	if err := reminders.SetConfigured(config.Reminders); err != nil {
+		configLog.Error("Error in config file", "err", err)
+		os.Exit(1)
+	}
+	if err := configureHTTPClient(config); err != nil {
+		configLog.Error("Error configuring HTTP client", "err", err)
+		os.Exit(1)
+	}
+
+	// If the API key is not in the config file, keyring, or TRMNL_API_KEY,
+	// prompt the user; a slideshow works without one
+	if config.APIKey == "" && options.SlideshowDir == "" {
+		config.APIKey = promptAPIKey(config)
+		if err := saveAPIKey(configDir, config, config.APIKey); err != nil {
+			configLog.Error("Error saving API key", "err", err)
+		}
+	}
+
+	health.SetConfig(config)
+
+	// Recent frames for the control API's gallery page
+	if options.GallerySize > 0 {
+		if options.ControlAddr == "" {
+			controlLog.Error("-gallery needs the control API (-control-addr)")
+			os.Exit(1)
+		}
+		gallery, err = NewGallery(filepath.Join(stateDir, "gallery"), options.GallerySize)
+		if err != nil {
+			controlLog.Error("Error setting up gallery", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Control API for phones and other devices on the network
+	if options.ControlAddr != "" {
+		d.control = NewControlServer(d, options.ControlAddr, configDir, config, options)
+		if err := d.control.Start(ctx); err != nil {
+			controlLog.Error("Error setting up control API", "err", err)
+			os.Exit(1)
+		}
+		controlLog.Info("Control API listening", "url", d.control.BaseURL())
+	}
+	// Local socket for `ctl` commands, sharing the network API's state
+	if options.ControlSocket != "" {
+		server := d.control
+		if server == nil {
+			localControl = NewControlServer(d, "", configDir, config, options)
+			server = localControl
+		}
+		if err := server.ServeSocket(ctx, options.ControlSocket); err != nil {
+			controlLog.Warn("Control socket unavailable, ctl commands will not work", "err", err)
+		} else {
+			controlLog.Debug("Control socket listening", "path", options.ControlSocket)
+		}
+	}
+	if options.WebUI {
+		if d.control == nil {
+			controlLog.Error("-web-ui needs the control API (-control-addr)")
+			os.Exit(1)
+		}
+		controlLog.Info("Web UI available", "url", d.control.BaseURL()+"/")
+	}
+	if options.WebhookSecret != "" && d.control == nil {
+		controlLog.Error("-webhook-secret needs the control API (-control-addr)")
+		os.Exit(1)
+	}
+
+	// Images and commands from a home-automation MQTT broker
+	if options.MQTTBroker != "" {
+		mqttBridge = NewMQTTBridge(d, config, options)
+		go mqttBridge.Run(ctx)
+	}
+	if options.PprofAddr != "" {
+		addr, err := startPprof(ctx, options.PprofAddr)
+		if err != nil {
+			mainLog.Error("Error setting up pprof", "err", err)
+			os.Exit(1)
+		}
+		mainLog.Info("Profiling endpoints listening", "url", "http://"+addr+"/debug/pprof/")
+	}
+	if options.Pair {
+		if d.control == nil {
+			controlLog.Error("-pair needs the control API (-control-addr)")
+			os.Exit(1)
+		}
+		live.SetScreen(screenPair)
+	}
+
+	// Create a temporary directory for storing images
+	tmpDir, err := os.MkdirTemp("", "trmnl-display")
+	if err != nil {
+		mainLog.Error("Error creating temp directory", "err", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Take the display, or carry on without it if that is the fallback
+	if !options.Headless {
+		err := d.setupDisplay(options)
+		switch {
+		case err != nil && options.HeadlessFallback:
+			displayLog.Warn("Display unavailable, running headless", "err", err)
+			d.releaseDisplay()
+			options.Headless = true
+			if d.control != nil {
+				d.control.UpdateConfig(config, options)
+			}
+		case err != nil:
+			displayLog.Error("Error setting up display", "err", err)
+			d.releaseDisplay()
+			os.Exit(1)
+		default:
+			defer d.releaseDisplay()
+		}
+	}
+
+	// Headless mode archives every frame; otherwise frames are archived
+	// alongside drawing them if an archive directory is given
+	if options.Headless && options.ArchiveDir == "" {
+		options.ArchiveDir = filepath.Join(stateDir, "archive")
+	}
+	if options.ArchiveDir != "" {
+		if err := os.MkdirAll(options.ArchiveDir, 0755); err != nil {
+			displayLog.Error("Error creating archive directory", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// In headless mode there is no framebuffer to lock or clear
+	if options.Headless {
+		displayLog.Info("Headless mode enabled - frames will be archived", "dir", options.ArchiveDir)
+		if options.PushURL != "" {
+			go watchServerPush(ctx, options.PushURL, config)
+		}
+		sdNotify("READY=1")
+		d.runDisplayLoop(ctx, tmpDir, config, options)
+		sdNotify("STOPPING=1")
+		return
+	}
+
+	if d.panel == nil {
+		// Disable cursor
+		if err := disableCursor(); err != nil {
+			displayLog.Warn("Failed to disable cursor", "err", err)
+			// Continue anyway, as this is not critical
+		}
+	}
+
+	// Clear the framebuffer at startup
+	d.clearFramebuffer()
+
+	// Blank the framebuffer between refreshes if asked to. An SPI panel
+	// manages its own sleep, set up with the driver.
+	if d.panel == nil {
+		d.power = framebufferPower(options)
+	}
+
+	// Settings menu on GPIO buttons
+	if needsMenu(options) {
+		if err := startMenu(d, options); err != nil {
+			menuLog.Error("Error setting up settings menu", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Actions on button presses and taps
+	if len(options.Buttons) > 0 {
+		if err := startButtons(options); err != nil {
+			menuLog.Error("Error setting up buttons", "err", err)
+			os.Exit(1)
+		}
+	}
+	if options.Touch != "" {
+		if err := startTouch(options); err != nil {
+			menuLog.Error("Error setting up touchscreen", "err", err)
+			os.Exit(1)
+		}
+	}
+	go d.runInputActions(options)
+
+	// Refresh less often while nobody is about
+	if options.MotionSensor != "" {
+		if err := startMotionSensor(options); err != nil {
+			powerLog.Error("Error setting up motion sensor", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Read the battery for the TRMNL server, rules, and low battery
+	// behaviour
+	if battery, err = openBattery(options.Battery); err != nil {
+		powerLog.Error("Error setting up battery provider", "err", err)
+		os.Exit(1)
+	}
+	if battery != nil && (options.LowBattery > 0 || options.CriticalBattery > 0) {
+		go watchBattery(ctx, options, cancel)
+	}
+
+	// Follow the room's light for dim and bright rules
+	if options.LightSensor.Model != "" {
+		if err := startLightSensor(options); err != nil {
+			powerLog.Error("Error setting up light sensor", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Let the server announce new screens as well as polling for them
+	if options.PushURL != "" {
+		go watchServerPush(ctx, options.PushURL, config)
+	}
+
+	// Badges from the control API are drawn over the current frame
+	go d.watchBadges(ctx, options)
+
+	sdNotify("READY=1")
+	d.runDisplayLoop(ctx, tmpDir, config, options)
+	sdNotify("STOPPING=1")
+	if batteryCritical.Load() {
+		d.shutdownLowBattery(options)
+	} else {
+		d.shutdownDisplay()
+	}
+}
+
+// NewFramebufferLock creates a new framebuffer lock
+func NewFramebufferLock(lockPath string) *FramebufferLock {
+	return &FramebufferLock{
+		LockPath: lockPath,
+		Acquired: false,
+	}
+}
+
+// Acquire attempts to acquire the framebuffer lock
+func (l *FramebufferLock) Acquire() error {
+	// First check if the lock file exists
+	if _, err := os.Stat(l.LockPath); err == nil {
+		// Lock file exists, check if it's stale
+		pid, err := l.readLockFile()
+		if err != nil {
+			return fmt.Errorf("error reading lock file: %v", err)
+		}
+
+		// Check if the process is still running
+		if l.isProcessRunning(pid) {
+			return fmt.Errorf("framebuffer is currently in use by process %d", pid)
+		}
+
+		// Lock is stale, remove it
+		displayLog.Info("Removing stale lock", "pid", pid)
+		if err := os.Remove(l.LockPath); err != nil {
+			return fmt.Errorf("error removing stale lock file: %v", err)
+		}
+	}
+
+	// Create the lock file with current PID
+	if err := l.writeLockFile(); err != nil {
+		return fmt.Errorf("error creating lock file: %v", err)
+	}
+
+	l.Acquired = true
+	displayLog.Info("Acquired exclusive framebuffer access")
+	return nil
+}
+
+// Release releases the framebuffer lock
+func (l *FramebufferLock) Release() {
+	if l.Acquired {
+		if err := os.Remove(l.LockPath); err != nil {
+			displayLog.Error("Error removing lock file", "err", err)
+		} else {
+			displayLog.Info("Released framebuffer lock")
+			l.Acquired = false
+		}
+	}
+}
+
+// readLockFile reads the PID from the lock file
+func (l *FramebufferLock) readLockFile() (int, error) {
+	data, err := os.ReadFile(l.LockPath)
+	if err != nil {
+		return 0, err
+	}
+
+	pidStr := strings.TrimSpace(string(data))
+	pid, err := strconv.Atoi(pidStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid PID in lock file: %v", err)
+	}
+
+	return pid, nil
+}
+
+// writeLockFile writes the current PID to the lock file
+func (l *FramebufferLock) writeLockFile() error {
+	pid := os.Getpid()
+	return os.WriteFile(l.LockPath, []byte(fmt.Sprintf("%d", pid)), 0644)
+}
+
+// isProcessRunning checks if a process with the given PID is running
+func (l *FramebufferLock) isProcessRunning(pid int) bool {
+	// Try to find the process
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false // Can't find process, must not be running
+	}
+
+	// On Unix, FindProcess always succeeds, so we need to send a signal
+	// to check if the process actually exists
+	err = process.Signal(syscall.Signal(0))
+	return err == nil
+}
+
+// setupSignalHandling sets up handlers for SIGINT and SIGTERM. The first
+// signal calls cancel so the display loop can stop cleanly; a second one
+// exits immediately.
+func (d *Daemon) setupSignalHandling(cancel context.CancelFunc) {
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		mainLog.Info("Received termination signal, cleaning up")
+		cancel()
+		<-c
+		mainLog.Warn("Received second signal, exiting immediately")
+		if lock := d.lock; lock != nil {
+			lock.Release()
+		}
+		os.Exit(1)
+	}()
+}
+
+// setupDisplay takes the framebuffer lock and opens the panel. With
+// -headless-fallback it also checks up front that the framebuffer can be
+// opened, which otherwise only shows as errors on each refresh.
+func (d *Daemon) setupDisplay(options AppOptions) error {
+	if needsHardware(options) {
+		if options.HeadlessFallback && os.Geteuid() != 0 {
+			return fmt.Errorf("root privileges are needed to access the display")
+		}
+		d.lock = NewFramebufferLock(displayLockFile(options.Instance))
+		if err := d.lock.Acquire(); err != nil {
+			return fmt.Errorf("error acquiring framebuffer lock: %v", err)
+		}
+	}
+
+	// An SPI panel is driven directly; the framebuffer is shown on the console
+	if err := d.openPanel(options); err != nil {
+		return fmt.Errorf("error opening panel: %v", err)
+	}
+	if err := openMirrors(options); err != nil {
+		d.closePanel()
+		return err
+	}
+	if options.HeadlessFallback && d.panel == nil {
+		fb, err := framebuffer.Open("/dev/fb0")
+		if err != nil {
+			return fmt.Errorf("error opening framebuffer: %v", err)
+		}
+		fb.Close()
+	}
+	return nil
+}
+
+// releaseDisplay closes the panel and releases the framebuffer lock
+func (d *Daemon) releaseDisplay() {
+	closeMirrors()
+	d.closePanel()
+	if d.lock != nil {
+		d.lock.Release()
+		d.lock = nil
+	}
+}
+
+// shutdownDisplay clears the screen, hands the console back, and puts the
+// panel to sleep
+func (d *Daemon) shutdownDisplay() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.power != nil {
+		d.power.Wake()
+	}
+	d.clearFramebuffer()
+	restoreCursor()
+	if d.power != nil {
+		if err := d.power.Sleep(); err != nil {
+			powerLog.Warn("Failed to put panel to sleep", "err", err)
+		}
+	}
+}
+
+// clearFramebuffer fills the framebuffer with black to clear it, or blanks
+// the SPI panel
+func (d *Daemon) clearFramebuffer() {
+	if d.panel != nil {
+		displayLog.Info("Clearing panel")
+		if err := d.panel.Clear(); err != nil {
+			displayLog.Error("Error clearing panel", "err", err)
+		}
+		return
+	}
+	displayLog.Info("Clearing framebuffer")
+
+	fb, err := framebuffer.Open("/dev/fb0")
+	if err != nil {
+		displayLog.Error("Error opening framebuffer to clear", "err", err)
+		return
+	}
+	defer fb.Close()
+
+	// Create a black image
+	black := image.NewRGBA(fb.Bounds())
+	draw.Draw(fb, fb.Bounds(), black, image.Point{}, draw.Src)
+
+	// Flush the framebuffer if necessary
+	if fbFlusher, ok := interface{}(fb).(interface{ Flush() error }); ok {
+		fbFlusher.Flush()
+	}
+}
+
+// checkRoot verifies if the program is running with root privileges
+func checkRoot() {
+	currentUser, err := user.Current()
+	if err != nil {
+		mainLog.Error("Error determining current user", "err", err)
+		os.Exit(1)
+	}
+
+	if currentUser.Uid != "0" {
+		mainLog.Error("This program requires root privileges to access the framebuffer; run it with sudo or as root")
+		os.Exit(1)
+	}
+
+	mainLog.Debug("Running with root privileges")
+}
+
+// presentFrame draws img on the display, or archives it when running headless.
+// While the settings menu is open the frame is only remembered, and shown
+// when the menu closes.
+func (d *Daemon) presentFrame(img image.Image, stages *StageDump, timings *StageTimings, options AppOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.previousFrame, d.lastFrame = d.lastFrame, img
+	drawingStages, drawingTimings = stages, timings
+	defer func() { drawingStages, drawingTimings = nil, nil }()
+
+	if options.Headless {
+		return d.archiveFrame(img, options)
+	}
+	if menu != nil && menu.IsOpen() {
+		return nil
+	}
+	active := badges.Active(time.Now())
+	img, d.badgeRects = composeBadges(img, active)
+	if len(active) > 0 {
+		stages.Record("badges", img, map[string]interface{}{"badges": active})
+	}
+	if err := d.drawFrame(img, options); err != nil {
+		return err
+	}
+	if options.ArchiveDir != "" {
+		if err := archiveImage(d.panelImage, options); err != nil {
+			displayLog.Warn("Error archiving frame", "err", err)
+		}
+	}
+	return nil
+}
+
+// showCurrentFrame redraws the remembered frame and any badges over it,
+// waking the panel first if needed
+func (d *Daemon) showCurrentFrame(options AppOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lastFrame == nil {
+		return nil
+	}
+	if d.power != nil {
+		if err := d.power.Wake(); err != nil {
+			powerLog.Warn("Failed to wake panel", "err", err)
+		}
+	}
+	var img image.Image
+	img, d.badgeRects = composeBadges(d.lastFrame, badges.Active(time.Now()))
+	return d.drawFrame(img, options)
+}
+
+// showOverlay draws img without replacing the remembered frame, waking the
+// panel first if needed
+func (d *Daemon) showOverlay(img image.Image, options AppOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.power != nil {
+		if err := d.power.Wake(); err != nil {
+			powerLog.Warn("Failed to wake panel", "err", err)
+		}
+	}
+	return d.drawFrame(img, options)
+}
+
+// drawFrame scales img to the framebuffer and draws it
+func (d *Daemon) drawFrame(img image.Image, options AppOptions) error {
+	return d.drawFrameRegion(img, image.Rectangle{}, options)
+}
+
+// drawFrameRegion scales img to the framebuffer and draws only the part
+// within region, given in image coordinates. An empty region draws the
+// whole frame.
+func (d *Daemon) drawFrameRegion(img image.Image, region image.Rectangle, options AppOptions) error {
+	// Verify we still have the lock before proceeding
+	if d.lock != nil && !d.lock.Acquired {
+		return fmt.Errorf("lost framebuffer lock, cannot continue")
+	}
+	img = lumaFrame(img, options)
+	if d.panel != nil {
+		return d.drawPanelFrame(img, options)
+	}
+
+	// Switch to tty1 so the framebuffer becomes active
+	err := exec.Command("chvt", "1").Run()
+	if err != nil {
+		displayLog.Error("Error switching VT to tty1", "err", err)
+	}
+
+	// Open the framebuffer
+	fb, err := framebuffer.Open("/dev/fb0")
+	if err != nil {
+		return fmt.Errorf("error opening framebuffer: %v", err)
+	}
+	defer fb.Close()
+
+	// Get framebuffer bounds
+	fbBounds := fb.Bounds()
+	displayLog.Debug("Framebuffer bounds", "bounds", fbBounds)
+
+	// Scale the image to fill the entire framebuffer
+	targetRect := fbBounds
+	start := time.Now()
+	scaledImg := d.scaledFrames.scale(img, targetRect)
+	drawingTimings.Since("scale", start)
+	if region.Empty() {
+		drawingStages.Record("scale", scaledImg, map[string]interface{}{
+			"from":   img.Bounds().String(),
+			"to":     targetRect.String(),
+			"scaler": "nearest-neighbor",
+		})
+	}
+	scaledImg = enhanceFrame(scaledImg, options)
+
+	// Draw the scaled image, or the requested part of it, to the framebuffer
+	drawRect := targetRect
+	if !region.Empty() {
+		drawRect = render.ScaleRect(region, img.Bounds(), targetRect).Intersect(targetRect)
+	}
+	start = time.Now()
+	draw.Draw(fb, drawRect, scaledImg, drawRect.Min, draw.Src)
+	d.panelImage = scaledImg
+
+	// Flush the framebuffer if necessary
+	if fbFlusher, ok := interface{}(fb).(interface{ Flush() error }); ok {
+		fbFlusher.Flush()
+	}
+	drawingTimings.Since("draw", start)
+	mirrorFrame(scaledImg)
+
+	if region.Empty() {
+		displayLog.Debug("Image drawing completed (full screen)")
+	} else {
+		displayLog.Debug("Image drawing completed", "region", drawRect)
+	}
+	return nil
+}
+
+// decodeImage decodes the image at imagePath with the settings in options,
+// logging each step when verbose
+func decodeImage(imagePath string, options AppOptions) (image.Image, error) {
+	decodeOptions := render.Options{MaxPixels: options.MaxPixels}
+	if displayLog.Enabled(context.Background(), slog.LevelDebug) {
+		decodeOptions.Log = func(format string, args ...interface{}) {
+			displayLog.Debug(strings.TrimSpace(fmt.Sprintf(format, args...)))
+		}
+	}
+	return render.Decode(imagePath, decodeOptions)
+}
+
+func loadConfig(configDir string) Config {
+	config, _ := readConfig(configDir)
+	return config
+}
+
+// readConfig loads the config file, reporting missing or malformed files
+func readConfig(configDir string) (Config, error) {
+	configFile := configFilePath(configDir)
+	config := Config{}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return config, fmt.Errorf("error reading %s: %v", configFile, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return Config{}, fmt.Errorf("error parsing %s: %v", configFile, err)
+	}
+	return config, nil
+}
+
+func saveConfig(configDir string, config Config) {
+	configFile := configFilePath(configDir)
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		configLog.Error("Error saving config", "err", err)
+		return
+	}
+
+	err = os.WriteFile(configFile, data, 0600)
+	if err != nil {
+		configLog.Error("Error writing config file", "err", err)
+	}
+}
+
+// checkDisplayServer is a placeholder for checking if a display server is running.
+func checkDisplayServer() {
+	// Add code here to check for X server, Wayland, etc., if needed.
+	displayLog.Debug("Display server check not implemented, assuming framebuffer usage")
+}
+
+// listFramebufferDevices lists available framebuffer devices.
+func listFramebufferDevices() {
+	files, err := filepath.Glob("/dev/fb*")
+	if err != nil {
+		displayLog.Error("Error listing framebuffer devices", "err", err)
+		return
+	}
+	displayLog.Debug("Found framebuffer devices", "devices", files)
+}
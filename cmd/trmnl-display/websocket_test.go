@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestWebSocketReadMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &wsConn{rw: client, br: bufio.NewReader(client)}
+	defer conn.Close()
+
+	go func() {
+		server.Write([]byte{0x89, 0x02, 'h', 'i'}) // ping
+		// The pong comes back masked: header, mask, payload
+		pong := make([]byte, 8)
+		io.ReadFull(server, pong)
+		if pong[0] != 0x8A || pong[1] != 0x82 || pong[6]^pong[2] != 'h' || pong[7]^pong[3] != 'i' {
+			t.Errorf("pong frame = %x", pong)
+		}
+		server.Write([]byte{0x01, 0x03, 'n', 'e', 'w'}) // text, not final
+		server.Write([]byte{0x80, 0x02, ' ', '1'})      // final continuation
+		server.Write([]byte{0x88, 0x00})                // close
+		io.ReadFull(server, make([]byte, 6))            // close reply
+	}()
+
+	msg, err := conn.ReadMessage()
+	if err != nil || string(msg) != "new 1" {
+		t.Fatalf("ReadMessage = %q, %v, want the reassembled message", msg, err)
+	}
+	if _, err := conn.ReadMessage(); err != io.EOF {
+		t.Errorf("ReadMessage after close = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"trmnl-display/pkg/rules"
+)
+
+// WebSettings are the settings the web UI can change. They are saved to the
+// config file, so flags given on the command line still take precedence.
+type WebSettings struct {
+	DarkMode bool     `json:"dark_mode"`
+	Morning  string   `json:"morning"`
+	Rules    []string `json:"rules"`
+}
+
+// handleWebUI serves the status and settings page. The page itself needs no
+// token; it uses the one stored when the browser was paired.
+func (s *ControlServer) handleWebUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, webUIPage)
+}
+
+// handleFrame serves the frame most recently handed to the display
+func (s *ControlServer) handleFrame(w http.ResponseWriter, r *http.Request) {
+	s.daemon.mu.Lock()
+	img := s.daemon.lastFrame
+	s.daemon.mu.Unlock()
+	if img == nil {
+		http.Error(w, "no frame has been shown yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, img)
+}
+
+// handleGetSettings reports the editable settings as the config file has them
+func (s *ControlServer) handleGetSettings(w http.ResponseWriter, r *http.Request) {
+	config, err := readConfigIfExists()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	settings := WebSettings{
+		DarkMode: config.DarkMode != nil && *config.DarkMode,
+		Morning:  config.Morning,
+		Rules:    config.Rules,
+	}
+	if settings.Rules == nil {
+		settings.Rules = []string{}
+	}
+	writeJSON(w, settings)
+}
+
+// handlePutSettings checks the settings, saves them to the config file, and
+// reloads it
+func (s *ControlServer) handlePutSettings(w http.ResponseWriter, r *http.Request) {
+	var settings WebSettings
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBytes)
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	settings.Morning = strings.TrimSpace(settings.Morning)
+	if settings.Morning != "" {
+		if _, err := rules.ParseTimeWindow(settings.Morning); err != nil {
+			http.Error(w, fmt.Sprintf("invalid morning window: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	var ruleTexts []string
+	for _, text := range settings.Rules {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		if _, err := rules.Parse(text); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ruleTexts = append(ruleTexts, text)
+	}
+
+	s.mu.Lock()
+	configDir := s.configDir
+	s.mu.Unlock()
+	err := updateConfigFile(configDir, func(c *Config) {
+		c.DarkMode = &settings.DarkMode
+		c.Morning = settings.Morning
+		c.Rules = ruleTexts
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	controlLog.Info("Settings changed from the web UI", "addr", r.RemoteAddr)
+	requestReload()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// webUIPage shows the current frame, when the next refresh is due, recent
+// errors, and a form for the editable settings
+const webUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>TRMNL Display</title>
+<style>
+body { font-family: sans-serif; max-width: 52em; margin: 2em auto; padding: 0 1em }
+img { width: 100%; border: 1px solid #888; background: #eee }
+textarea, input[type=text] { width: 100%; box-sizing: border-box }
+.error { color: #b00 }
+</style>
+</head>
+<body>
+<h1>TRMNL Display</h1>
+<p id="message"></p>
+<div id="ui" hidden>
+<img id="frame" alt="Current frame">
+<p>Showing <b id="screen">…</b>. <span id="countdown"></span>
+<button id="refresh">Refresh now</button></p>
+<h2>Recent errors</h2>
+<ul id="errors"></ul>
+<h2>Settings</h2>
+<form id="settings">
+<p><label><input type="checkbox" name="dark_mode"> Dark mode</label></p>
+<p><label>Morning briefing window (e.g. 06:30-09:00)<br><input type="text" name="morning"></label></p>
+<p><label>Content rules, one per line<br><textarea name="rules" rows="5"></textarea></label></p>
+<p><button>Save</button> <span id="saved"></span></p>
+</form>
+</div>
+<script>
+const token = localStorage.getItem("trmnl-token");
+const $ = id => document.getElementById(id);
+const api = (path, opts = {}) => fetch(path, {...opts, headers: {Authorization: "Bearer " + token}})
+  .then(r => r.ok ? r : r.text().then(t => Promise.reject(t || r.status)));
+let next = null;
+
+function tick() {
+  if (!next) { $("countdown").textContent = ""; return; }
+  const s = Math.max(0, Math.round((next - Date.now()) / 1000));
+  $("countdown").textContent = "Next refresh in " + Math.floor(s / 60) + ":" + String(s % 60).padStart(2, "0") + ".";
+}
+
+function loadStatus() {
+  api("/api/status").then(r => r.json()).then(status => {
+    $("screen").textContent = status.screen || "the playlist";
+    if (status.paused) $("screen").textContent += " (paused)";
+    next = status.next_refresh ? new Date(status.next_refresh) : null;
+    $("errors").replaceChildren(...(status.errors || []).map(e => {
+      const li = document.createElement("li");
+      li.className = "error";
+      li.textContent = new Date(e.time).toLocaleString() + ": " + e.error;
+      return li;
+    }));
+    if (!status.errors || !status.errors.length) $("errors").innerHTML = "<li>None</li>";
+  });
+  api("/api/frame.png").then(r => r.blob()).then(b => {
+    URL.revokeObjectURL($("frame").src);
+    $("frame").src = URL.createObjectURL(b);
+  }).catch(() => {});
+}
+
+function loadSettings() {
+  api("/api/settings").then(r => r.json()).then(s => {
+    const f = $("settings");
+    f.dark_mode.checked = s.dark_mode;
+    f.morning.value = s.morning;
+    f.rules.value = s.rules.join("\n");
+  });
+}
+
+$("settings").onsubmit = e => {
+  e.preventDefault();
+  const f = e.target;
+  const settings = {dark_mode: f.dark_mode.checked, morning: f.morning.value, rules: f.rules.value.split("\n")};
+  api("/api/settings", {method: "PUT", body: JSON.stringify(settings)})
+    .then(() => { $("saved").textContent = "Saved."; $("saved").className = ""; setTimeout(loadStatus, 3000); })
+    .catch(err => { $("saved").textContent = err; $("saved").className = "error"; });
+};
+$("refresh").onclick = () => api("/api/refresh", {method: "POST"}).then(() => setTimeout(loadStatus, 3000));
+
+if (!token) {
+  $("message").textContent = "Pair this browser first: pick “Pair phone” in the settings menu, or start with -pair, and scan the code.";
+} else {
+  $("ui").hidden = false;
+  loadStatus();
+  loadSettings();
+  setInterval(loadStatus, 30000);
+  setInterval(tick, 1000);
+}
+</script>
+</body>
+</html>
+`
@@ -5,22 +5,7 @@ go 1.23.0
 toolchain go1.24.1
 
 require (
-	github.com/ChristianHering/WaveShare v0.0.0-20210309061826-e8779d6124f7 // indirect
-	github.com/creack/pty v1.1.24 // indirect
-	github.com/danielgatis/imgcat v1.0.20 // indirect
-	github.com/disintegration/imaging v1.6.2 // indirect
-	github.com/gabriel-vasile/mimetype v1.4.8 // indirect
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	github.com/golang/glog v1.2.3 // indirect
-	github.com/gonutz/framebuffer v1.0.0 // indirect
-	github.com/mat/besticon v3.12.0+incompatible // indirect
-	github.com/mattn/go-isatty v0.0.20 // indirect
-	github.com/stianeikeland/go-rpio/v4 v4.6.0 // indirect
-	github.com/wiless/waveshare v0.0.0-20241202115457-6c2e99d6c075 // indirect
-	golang.org/x/image v0.25.0 // indirect
-	golang.org/x/net v0.35.0 // indirect
-	golang.org/x/sys v0.30.0 // indirect
-	periph.io/x/conn/v3 v3.7.2 // indirect
-	periph.io/x/host/v3 v3.8.4 // indirect
-	periph.io/x/periph v3.7.0+incompatible // indirect
+	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
+	github.com/gonutz/framebuffer v1.0.0
+	golang.org/x/image v0.25.0
 )
@@ -0,0 +1,164 @@
+// Package api is a client for the TRMNL device API, as served by usetrmnl.com
+// and by self-hosted (BYOS) servers.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultBaseURL is the TRMNL cloud server
+const DefaultBaseURL = "https://usetrmnl.com"
+
+// MaxDownloadBytes caps the size of a screen image, so a misbehaving server
+// cannot fill the disk
+const MaxDownloadBytes = 32 * 1024 * 1024
+
+// HTTPClient sends requests; *http.Client, or a fake serving canned
+// responses in tests
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Display is the /api/display response: the screen a device should show
+// and when to ask again. Not every server reports a name or friendly ID.
+type Display struct {
+	ImageURL    string `json:"image_url"`
+	Filename    string `json:"filename"`
+	RefreshRate int    `json:"refresh_rate"` // seconds
+
+	FriendlyID string `json:"friendly_id"`
+	Name       string `json:"name"`
+	Status     int    `json:"status"`
+}
+
+// Telemetry is what a device reports about itself when asking for a screen,
+// as TRMNL's own devices do. Zero values are left out.
+type Telemetry struct {
+	BatteryVoltage float64
+}
+
+// StatusError is returned when the server answers with an HTTP error
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status code %d", e.StatusCode)
+}
+
+// Client talks to a TRMNL server as the device whose API key it holds
+type Client struct {
+	BaseURL   string // DefaultBaseURL if empty
+	APIKey    string
+	UserAgent string
+	HTTP      HTTPClient // http.DefaultClient if nil
+
+	// Telemetry is called for what to report with each Display request, if
+	// set
+	Telemetry func() Telemetry
+}
+
+// Display asks the server for the device's current screen. Some servers
+// report an unknown key as an error status in the body; that is returned
+// as a StatusError too.
+func (c *Client) Display(ctx context.Context) (Display, error) {
+	var display Display
+	req, err := c.newRequest(ctx, c.url("/api/display"))
+	if err != nil {
+		return display, err
+	}
+	req.Header.Add("access-token", c.APIKey)
+	if c.Telemetry != nil {
+		if t := c.Telemetry(); t.BatteryVoltage > 0 {
+			req.Header.Add("Battery-Voltage", strconv.FormatFloat(t.BatteryVoltage, 'f', 2, 64))
+		}
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return display, err
+	}
+	defer CloseResponse(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return display, &StatusError{resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&display); err != nil {
+		return display, fmt.Errorf("error parsing JSON: %v", err)
+	}
+	if display.Status >= 400 {
+		return display, &StatusError{display.Status}
+	}
+	return display, nil
+}
+
+// Download copies the image at imageURL to w, refusing anything larger
+// than MaxDownloadBytes
+func (c *Client) Download(ctx context.Context, imageURL string, w io.Writer) error {
+	req, err := c.newRequest(ctx, imageURL)
+	if err != nil {
+		return fmt.Errorf("error creating image request: %v", err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("error downloading image: %v", err)
+	}
+	defer CloseResponse(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error downloading image: %w", &StatusError{resp.StatusCode})
+	}
+	written, err := io.Copy(w, io.LimitReader(resp.Body, MaxDownloadBytes+1))
+	if err == nil && written > MaxDownloadBytes {
+		err = fmt.Errorf("image exceeds %d byte download limit", MaxDownloadBytes)
+	}
+	if err != nil {
+		return fmt.Errorf("error saving image: %v", err)
+	}
+	return nil
+}
+
+// URL returns the server the client talks to
+func (c *Client) URL() string {
+	if c.BaseURL == "" {
+		return DefaultBaseURL
+	}
+	return c.BaseURL
+}
+
+// url joins path to the server's address
+func (c *Client) url(path string) string {
+	return strings.TrimSuffix(c.URL(), "/") + path
+}
+
+// newRequest creates a GET request with the client's user agent
+func (c *Client) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Add("User-Agent", c.UserAgent)
+	}
+	return req, nil
+}
+
+// do sends req with the client's HTTP client
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.HTTP == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return c.HTTP.Do(req)
+}
+
+// CloseResponse drains and closes a response body so its connection can be
+// returned to the pool
+func CloseResponse(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxDownloadBytes))
+	resp.Body.Close()
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeHTTPClient answers every request with the same status and body
+type fakeHTTPClient struct {
+	status int
+	body   string
+	req    *http.Request
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.req = req
+	return &http.Response{StatusCode: c.status, Body: io.NopCloser(strings.NewReader(c.body))}, nil
+}
+
+func TestDisplay(t *testing.T) {
+	fake := &fakeHTTPClient{status: 200, body: `{"image_url": "https://cdn.test/a.bmp", "refresh_rate": 300, "friendly_id": "ABC123"}`}
+	client := &Client{APIKey: "secret", UserAgent: "test/1", HTTP: fake}
+	display, err := client.Display(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if display.ImageURL != "https://cdn.test/a.bmp" || display.RefreshRate != 300 || display.FriendlyID != "ABC123" {
+		t.Errorf("display = %+v", display)
+	}
+	if got := fake.req.URL.String(); got != DefaultBaseURL+"/api/display" {
+		t.Errorf("requested %s, want the default server", got)
+	}
+	if fake.req.Header.Get("access-token") != "secret" || fake.req.Header.Get("User-Agent") != "test/1" {
+		t.Errorf("headers = %v", fake.req.Header)
+	}
+	if fake.req.Header.Get("Battery-Voltage") != "" {
+		t.Errorf("battery voltage reported without telemetry")
+	}
+
+	client.Telemetry = func() Telemetry { return Telemetry{BatteryVoltage: 3.912} }
+	if _, err := client.Display(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.req.Header.Get("Battery-Voltage"); got != "3.91" {
+		t.Errorf("Battery-Voltage = %q, want 3.91", got)
+	}
+}
+
+func TestDisplayStatus(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		fake   *fakeHTTPClient
+		status int
+	}{
+		{"HTTP status", &fakeHTTPClient{status: 401}, 401},
+		{"status in body", &fakeHTTPClient{status: 200, body: `{"status": 404}`}, 404},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&Client{HTTP: tt.fake}).Display(context.Background())
+			var statusErr *StatusError
+			if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status {
+				t.Errorf("error = %v, want status %d", err, tt.status)
+			}
+		})
+	}
+}
+
+func TestDownload(t *testing.T) {
+	var buf bytes.Buffer
+	client := &Client{HTTP: &fakeHTTPClient{status: 200, body: "BM image"}}
+	if err := client.Download(context.Background(), "https://cdn.test/a.bmp", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "BM image" {
+		t.Errorf("downloaded %q", buf.String())
+	}
+
+	client.HTTP = &fakeHTTPClient{status: 500}
+	if err := client.Download(context.Background(), "https://cdn.test/a.bmp", &buf); err == nil || !strings.Contains(err.Error(), "status code 500") {
+		t.Errorf("error = %v, want status code 500", err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package compose draws the screens rendered on the device, such as the
+// clock, morning briefing, and reminders, out of text in the Go fonts.
+package compose
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/math/fixed"
+)
+
+// Zone is a rectangular region of a composite screen with a heading and body text
+type Zone struct {
+	Rect  image.Rectangle
+	Title string
+	Lines []string
+}
+
+// Compositor assembles locally generated screens out of text zones
+type Compositor struct {
+	Frame   *image.RGBA
+	regular *truetype.Font
+	bold    *truetype.Font
+}
+
+// New creates a compositor with a blank white frame of the given size
+func New(width, height int) (*Compositor, error) {
+	regular, err := truetype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("error loading regular font: %v", err)
+	}
+	bold, err := truetype.Parse(gobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("error loading bold font: %v", err)
+	}
+
+	frame := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(frame, frame.Bounds(), image.White, image.Point{}, draw.Src)
+
+	return &Compositor{
+		Frame:   frame,
+		regular: regular,
+		bold:    bold,
+	}, nil
+}
+
+// Face returns a font face of the given pixel size
+func (c *Compositor) Face(bold bool, size float64) font.Face {
+	f := c.regular
+	if bold {
+		f = c.bold
+	}
+	return truetype.NewFace(f, &truetype.Options{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// DrawText draws a single line of text with its baseline at y
+func (c *Compositor) DrawText(text string, x, y int, face font.Face, col color.Color) {
+	d := &font.Drawer{
+		Dst:  c.Frame,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+// DrawTextCentered draws a single line of text centred horizontally in rect
+func (c *Compositor) DrawTextCentered(text string, rect image.Rectangle, y int, face font.Face, col color.Color) {
+	width := MeasureText(face, text)
+	c.DrawText(text, rect.Min.X+(rect.Dx()-width)/2, y, face, col)
+}
+
+// FillRect fills rect with a solid colour
+func (c *Compositor) FillRect(rect image.Rectangle, col color.Color) {
+	draw.Draw(c.Frame, rect, image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// DrawZone draws a zone's title, a rule beneath it, and its lines wrapped to
+// the zone width. Lines that do not fit are dropped and marked with an ellipsis.
+func (c *Compositor) DrawZone(z Zone, titleSize, bodySize float64) {
+	titleFace := c.Face(true, titleSize)
+	bodyFace := c.Face(false, bodySize)
+	defer titleFace.Close()
+	defer bodyFace.Close()
+
+	y := z.Rect.Min.Y + titleFace.Metrics().Ascent.Ceil()
+	c.DrawText(z.Title, z.Rect.Min.X, y, titleFace, color.Black)
+	y += titleFace.Metrics().Descent.Ceil() + 4
+	c.FillRect(image.Rect(z.Rect.Min.X, y, z.Rect.Max.X, y+2), color.Black)
+	y += 8
+
+	lineHeight := bodyFace.Metrics().Height.Ceil()
+	var wrapped []string
+	for _, line := range z.Lines {
+		wrapped = append(wrapped, WrapText(line, bodyFace, z.Rect.Dx())...)
+	}
+
+	for i, line := range wrapped {
+		if y+lineHeight > z.Rect.Max.Y {
+			break
+		}
+		if i < len(wrapped)-1 && y+2*lineHeight > z.Rect.Max.Y {
+			line = "…"
+		}
+		c.DrawText(line, z.Rect.Min.X, y+bodyFace.Metrics().Ascent.Ceil(), bodyFace, color.Black)
+		y += lineHeight
+	}
+}
+
+// MeasureText returns the width of text in pixels
+func MeasureText(face font.Face, text string) int {
+	return font.MeasureString(face, text).Ceil()
+}
+
+// WrapText splits text into lines no wider than width pixels
+func WrapText(text string, face font.Face, width int) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+
+	var lines []string
+	line := words[0]
+	for _, word := range words[1:] {
+		candidate := line + " " + word
+		if MeasureText(face, candidate) > width {
+			lines = append(lines, line)
+			line = word
+		} else {
+			line = candidate
+		}
+	}
+	return append(lines, line)
+}
@@ -0,0 +1,53 @@
+package compose
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	c, err := New(200, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	face := c.Face(false, 20)
+	defer face.Close()
+
+	lines := WrapText("the quick brown fox jumps over the lazy dog", face, 120)
+	if len(lines) < 2 {
+		t.Fatalf("WrapText = %q, want the text over several lines", lines)
+	}
+	for _, line := range lines {
+		if w := MeasureText(face, line); w > 120 {
+			t.Errorf("line %q is %dpx wide, want at most 120", line, w)
+		}
+	}
+	if got := WrapText("   ", face, 120); len(got) != 1 || got[0] != "" {
+		t.Errorf("WrapText of blank text = %q, want one empty line", got)
+	}
+}
+
+func TestDrawZoneStaysInside(t *testing.T) {
+	c, err := New(300, 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone := image.Rect(20, 20, 180, 120)
+	var lines []string
+	for i := 0; i < 20; i++ {
+		lines = append(lines, "a line of text that wraps")
+	}
+	c.DrawZone(Zone{Rect: zone, Title: "Weather", Lines: lines}, 24, 16)
+
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 300; x++ {
+			if image.Pt(x, y).In(zone) {
+				continue
+			}
+			if g := color.GrayModel.Convert(c.Frame.At(x, y)).(color.Gray).Y; g != 255 {
+				t.Fatalf("pixel (%d, %d) outside the zone was drawn on", x, y)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package history keeps a log of refresh cycles as a JSON lines file, which
+// the stats screen and the export command read back. Appending a line needs
+// no database, so the log is a plain file rather than SQLite, which would
+// add a cgo or large pure-Go dependency. The file is rotated at 5MB keeping
+// one previous generation, which bounds how far back an export can reach:
+// at one refresh a minute, about three weeks.
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// History files are rotated once they grow past this size, keeping one
+// previous generation
+const maxHistoryBytes = 5 * 1024 * 1024
+
+// Record describes one refresh cycle, or one failed attempt at it
+type Record struct {
+	Time      time.Time `json:"time"`
+	Screen    string    `json:"screen,omitempty"`
+	FetchMs   int64     `json:"fetch_ms"`
+	DisplayMs int64     `json:"display_ms"`
+	Error     string    `json:"error,omitempty"`
+	Battery   *int      `json:"battery,omitempty"`
+	Hash      string    `json:"hash,omitempty"` // of the image shown
+
+	// Time spent in each stage: download, decode, scale, draw, and the
+	// panel's own steps
+	StagesMs map[string]int64 `json:"stages_ms,omitempty"`
+}
+
+// File appends cycle records to a JSON lines file
+type File struct {
+	Path string
+}
+
+// New creates a history stored as history.jsonl in dir
+func New(dir string) *File {
+	return &File{
+		Path: filepath.Join(dir, "history.jsonl"),
+	}
+}
+
+// Append adds a record to the end of the file, rotating it first if it has
+// grown too large
+func (h *File) Append(record Record) error {
+	if info, err := os.Stat(h.Path); err == nil && info.Size() > maxHistoryBytes {
+		os.Rename(h.Path, h.Path+".1")
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("error encoding history record: %v", err)
+	}
+
+	f, err := os.OpenFile(h.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing history file: %v", err)
+	}
+	return nil
+}
+
+// Read returns the records between from and to (inclusive), oldest first,
+// including the rotated previous generation
+func (h *File) Read(from, to time.Time) ([]Record, error) {
+	var records []Record
+	for _, path := range []string{h.Path + ".1", h.Path} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error opening history file: %v", err)
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record Record
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				continue // skip partially written lines
+			}
+			if record.Time.Before(from) || record.Time.After(to) {
+				continue
+			}
+			records = append(records, record)
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("error reading history file: %v", err)
+		}
+	}
+	return records, nil
+}
+
+// CSVStages are the stages WriteCSV gives a column each. Other stages, such
+// as the panel's own steps, vary by panel and are left out.
+var CSVStages = []string{"download", "decode", "scale", "draw"}
+
+// WriteCSV writes records as CSV with a header row. Stages a cycle did not go
+// through are left empty.
+func WriteCSV(w io.Writer, records []Record) error {
+	out := csv.NewWriter(w)
+	header := []string{"time", "screen", "fetch_ms", "display_ms", "error", "battery_percent", "hash"}
+	for _, stage := range CSVStages {
+		header = append(header, stage+"_ms")
+	}
+	out.Write(header)
+	for _, record := range records {
+		battery := ""
+		if record.Battery != nil {
+			battery = strconv.Itoa(*record.Battery)
+		}
+		row := []string{
+			record.Time.Format(time.RFC3339),
+			record.Screen,
+			strconv.FormatInt(record.FetchMs, 10),
+			strconv.FormatInt(record.DisplayMs, 10),
+			record.Error,
+			battery,
+			record.Hash,
+		}
+		for _, stage := range CSVStages {
+			ms, ok := record.StagesMs[stage]
+			if !ok {
+				row = append(row, "")
+				continue
+			}
+			row = append(row, strconv.FormatInt(ms, 10))
+		}
+		out.Write(row)
+	}
+	out.Flush()
+	return out.Error()
+}
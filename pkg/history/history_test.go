@@ -0,0 +1,54 @@
+package history
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRead(t *testing.T) {
+	h := New(t.TempDir())
+	start := time.Date(2025, 6, 23, 8, 0, 0, 0, time.UTC)
+	battery := 80
+	for i, record := range []Record{
+		{Time: start, Screen: "clock", FetchMs: 100, DisplayMs: 900},
+		{Time: start.Add(time.Hour), FetchMs: 50, Error: "timeout, retrying"},
+		{Time: start.Add(2 * time.Hour), Screen: "playlist", FetchMs: 200, DisplayMs: 1000, Battery: &battery, Hash: "ab12",
+			StagesMs: map[string]int64{"download": 150, "decode": 40, "scale": 12, "draw": 980, "panel_refresh": 900}},
+	} {
+		if err := h.Append(record); err != nil {
+			t.Fatalf("Append %d: %v", i, err)
+		}
+	}
+	// A line cut short by a crash is skipped
+	f, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time": "2025-06-23T11:00`)
+	f.Close()
+
+	records, err := h.Read(start.Add(time.Minute), start.Add(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Error != "timeout, retrying" || *records[1].Battery != 80 {
+		t.Fatalf("Read = %+v, want the last two records", records)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, records); err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"time,screen,fetch_ms,display_ms,error,battery_percent,hash,download_ms,decode_ms,scale_ms,draw_ms",
+		`2025-06-23T09:00:00Z,,50,0,"timeout, retrying",,,,,,`,
+		"2025-06-23T10:00:00Z,playlist,200,1000,,80,ab12,150,40,12,980",
+		"",
+	}, "\n")
+	if buf.String() != want {
+		t.Errorf("WriteCSV =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
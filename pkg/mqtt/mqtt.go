@@ -0,0 +1,387 @@
+// Package mqtt is a small MQTT 3.1.1 client: enough to subscribe to topics,
+// publish (optionally retained) messages, and leave a last will, over TCP or
+// TLS. Messages are published at QoS 0 and received at QoS 0 or 1.
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Packet types
+const (
+	packetConnect     = 1
+	packetConnack     = 2
+	packetPublish     = 3
+	packetPuback      = 4
+	packetSubscribe   = 8
+	packetSuback      = 9
+	packetPingreq     = 12
+	packetPingresp    = 13
+	packetDisconnect  = 14
+	maxRemainingBytes = 268435455
+)
+
+// DefaultKeepAlive is used when Options.KeepAlive is zero
+const DefaultKeepAlive = 60 * time.Second
+
+// Message is a message published to a topic
+type Message struct {
+	Topic   string
+	Payload []byte
+	QoS     byte
+	Retain  bool
+}
+
+// Options configure a connection
+type Options struct {
+	// Broker is mqtt://host[:1883] or mqtts://host[:8883] (tcp:// and
+	// ssl:// are accepted too). Credentials in the URL are used if
+	// Username is empty.
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive time.Duration
+	TLS       *tls.Config
+
+	// Will is published by the broker if the connection is lost
+	Will *Message
+}
+
+// Client is a connection to a broker
+type Client struct {
+	conn      net.Conn
+	r         *bufio.Reader
+	keepAlive time.Duration
+
+	mu       sync.Mutex // serialises writes
+	packetID uint16
+}
+
+// ConnectError is returned when the broker refuses the connection
+type ConnectError struct {
+	Code byte
+}
+
+func (e *ConnectError) Error() string {
+	reasons := map[byte]string{
+		1: "unacceptable protocol version",
+		2: "client identifier rejected",
+		3: "server unavailable",
+		4: "bad user name or password",
+		5: "not authorized",
+	}
+	if reason, ok := reasons[e.Code]; ok {
+		return "connection refused: " + reason
+	}
+	return fmt.Sprintf("connection refused (code %d)", e.Code)
+}
+
+// Dial connects to the broker and completes the MQTT handshake
+func Dial(ctx context.Context, opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %v", err)
+	}
+	useTLS := false
+	port := "1883"
+	switch u.Scheme {
+	case "mqtt", "tcp":
+	case "mqtts", "ssl", "tls":
+		useTLS, port = true, "8883"
+	default:
+		return nil, fmt.Errorf("invalid broker URL %q: expected mqtt:// or mqtts://", opts.Broker)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if opts.Username == "" && u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+
+	var conn net.Conn
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if useTLS {
+		conf := opts.TLS
+		if conf == nil {
+			conf = &tls.Config{}
+		}
+		if conf.ServerName == "" {
+			conf = conf.Clone()
+			conf.ServerName = u.Hostname()
+		}
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: conf}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn), keepAlive: opts.KeepAlive}
+	if err := c.handshake(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// handshake sends CONNECT and waits for CONNACK
+func (c *Client) handshake(opts Options) error {
+	var flags byte = 0x02 // clean session
+	body := appendString(nil, "MQTT")
+	body = append(body, 4) // protocol level 3.1.1
+	flagsAt := len(body)
+	body = append(body, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(opts.KeepAlive/time.Second))
+
+	body = appendString(body, opts.ClientID)
+	if w := opts.Will; w != nil {
+		flags |= 0x04 | (w.QoS&0x03)<<3
+		if w.Retain {
+			flags |= 0x20
+		}
+		body = appendString(body, w.Topic)
+		body = appendBytes(body, w.Payload)
+	}
+	if opts.Username != "" {
+		flags |= 0x80
+		body = appendString(body, opts.Username)
+		if opts.Password != "" {
+			flags |= 0x40
+			body = appendString(body, opts.Password)
+		}
+	}
+	body[flagsAt] = flags
+
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer c.conn.SetDeadline(time.Time{})
+	if err := c.writePacket(packetConnect<<4, body); err != nil {
+		return err
+	}
+	header, payload, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if header>>4 != packetConnack || len(payload) != 2 {
+		return errors.New("unexpected reply to CONNECT")
+	}
+	if payload[1] != 0 {
+		return &ConnectError{Code: payload[1]}
+	}
+	return nil
+}
+
+// Subscribe asks for messages on the topic filters at QoS 1. The broker's
+// answer arrives in Run.
+func (c *Client) Subscribe(filters ...string) error {
+	body := binary.BigEndian.AppendUint16(nil, c.nextPacketID())
+	for _, filter := range filters {
+		body = appendString(body, filter)
+		body = append(body, 1)
+	}
+	return c.writePacket(packetSubscribe<<4|0x02, body)
+}
+
+// Publish sends a message at QoS 0
+func (c *Client) Publish(msg Message) error {
+	header := byte(packetPublish << 4)
+	if msg.Retain {
+		header |= 0x01
+	}
+	body := appendString(nil, msg.Topic)
+	body = append(body, msg.Payload...)
+	return c.writePacket(header, body)
+}
+
+// Run reads messages, passing each one to handle, and keeps the connection
+// alive until ctx is cancelled or the connection fails. handle runs on the
+// reading goroutine, so it should not block for long. The connection stays
+// open when ctx is cancelled, so a final message can be published before
+// Close.
+func (c *Client) Run(ctx context.Context, handle func(Message)) error {
+	stop := context.AfterFunc(ctx, func() { c.conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(c.keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				c.writePacket(packetPingreq<<4, nil)
+			}
+		}
+	}()
+
+	for {
+		// The broker answers pings, so silence for longer than this means
+		// the connection is gone
+		c.conn.SetReadDeadline(time.Now().Add(c.keepAlive * 3 / 2))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		header, body, err := c.readPacket()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		switch header >> 4 {
+		case packetPublish:
+			msg, id, err := parsePublish(header, body)
+			if err != nil {
+				return err
+			}
+			if msg.QoS > 0 {
+				if err := c.writePacket(packetPuback<<4, binary.BigEndian.AppendUint16(nil, id)); err != nil {
+					return err
+				}
+			}
+			handle(msg)
+		case packetSuback:
+			for _, code := range body[min(2, len(body)):] {
+				if code == 0x80 {
+					return errors.New("subscription refused by broker")
+				}
+			}
+		case packetPingresp, packetPuback:
+		default:
+			return fmt.Errorf("unexpected packet type %d", header>>4)
+		}
+	}
+}
+
+// Close disconnects cleanly, so the broker does not publish the will
+func (c *Client) Close() error {
+	c.writePacket(packetDisconnect<<4, nil)
+	return c.conn.Close()
+}
+
+// nextPacketID returns a non-zero packet identifier
+func (c *Client) nextPacketID() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packetID++
+	if c.packetID == 0 {
+		c.packetID = 1
+	}
+	return c.packetID
+}
+
+// parsePublish decodes a PUBLISH packet, returning its packet identifier
+// for QoS 1 and 2
+func parsePublish(header byte, body []byte) (Message, uint16, error) {
+	msg := Message{QoS: (header >> 1) & 0x03, Retain: header&0x01 != 0}
+	topic, rest, err := readString(body)
+	if err != nil {
+		return msg, 0, err
+	}
+	msg.Topic = topic
+	var id uint16
+	if msg.QoS > 0 {
+		if len(rest) < 2 {
+			return msg, 0, errors.New("malformed PUBLISH packet")
+		}
+		id, rest = binary.BigEndian.Uint16(rest), rest[2:]
+	}
+	msg.Payload = rest
+	return msg, id, nil
+}
+
+// writePacket sends a packet with the given first header byte
+func (c *Client) writePacket(header byte, body []byte) error {
+	if len(body) > maxRemainingBytes {
+		return errors.New("packet too large")
+	}
+	packet := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		packet = append(packet, b)
+		if n == 0 {
+			break
+		}
+	}
+	packet = append(packet, body...)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.conn.Write(packet)
+	return err
+}
+
+// readPacket reads one packet, returning its first header byte and body
+func (c *Client) readPacket() (byte, []byte, error) {
+	header, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7F) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 3 {
+			return 0, nil, errors.New("malformed remaining length")
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+// appendString appends a length-prefixed UTF-8 string
+func appendString(b []byte, s string) []byte {
+	return appendBytes(b, []byte(s))
+}
+
+// appendBytes appends length-prefixed binary data
+func appendBytes(b, data []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+	return append(b, data...)
+}
+
+// readString reads a length-prefixed string, returning what follows it
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(b[2 : 2+n]), b[2+n:], nil
+}
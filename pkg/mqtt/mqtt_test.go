@@ -0,0 +1,105 @@
+package mqtt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts one connection and hands it to serve
+func fakeBroker(t *testing.T, serve func(c *Client)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The client's packet framing works for the broker's side too
+		serve(&Client{conn: conn, r: bufio.NewReader(conn)})
+	}()
+	return "mqtt://user:pass@" + ln.Addr().String()
+}
+
+func TestClientReceivesMessages(t *testing.T) {
+	connected := make(chan []byte, 1)
+	acked := make(chan []byte, 1)
+	broker := fakeBroker(t, func(c *Client) {
+		_, connect, err := c.readPacket()
+		if err != nil {
+			return
+		}
+		connected <- connect
+		c.writePacket(packetConnack<<4, []byte{0, 0})
+
+		header, subscribe, _ := c.readPacket()
+		if header != packetSubscribe<<4|0x02 {
+			t.Errorf("SUBSCRIBE header = %#x", header)
+		}
+		c.writePacket(packetSuback<<4, append(subscribe[:2:2], 1))
+
+		publish := appendString(nil, "trmnl/command")
+		publish = append(publish, 0, 7) // packet identifier
+		publish = append(publish, "refresh"...)
+		c.writePacket(packetPublish<<4|0x02, publish)
+		_, puback, _ := c.readPacket()
+		acked <- puback
+		c.readPacket() // wait for the client to hang up
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := Dial(ctx, Options{
+		Broker:   broker,
+		ClientID: "frame",
+		Will:     &Message{Topic: "trmnl/status", Payload: []byte("offline"), Retain: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	connect := <-connected
+	if flags := connect[7]; flags != 0xE6 {
+		t.Errorf("CONNECT flags = %#x, want clean session, retained will, and credentials from the URL", flags)
+	}
+	if !bytes.HasSuffix(connect, []byte("\x00\x04user\x00\x04pass")) {
+		t.Errorf("CONNECT payload = %q, want the URL's credentials", connect)
+	}
+
+	if err := client.Subscribe("trmnl/command"); err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan Message, 1)
+	go client.Run(ctx, func(msg Message) { got <- msg })
+	select {
+	case msg := <-got:
+		if msg.Topic != "trmnl/command" || string(msg.Payload) != "refresh" || msg.QoS != 1 {
+			t.Errorf("message = %+v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("no message received")
+	}
+	if puback := <-acked; !bytes.Equal(puback, []byte{0, 7}) {
+		t.Errorf("PUBACK = %x, want packet identifier 7", puback)
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	broker := fakeBroker(t, func(c *Client) {
+		c.readPacket()
+		c.writePacket(packetConnack<<4, []byte{0, 5})
+	})
+	_, err := Dial(context.Background(), Options{Broker: broker, ClientID: "frame"})
+	if ce, ok := err.(*ConnectError); !ok || ce.Code != 5 {
+		t.Errorf("Dial = %v, want a not authorized ConnectError", err)
+	}
+}
@@ -0,0 +1,145 @@
+package panel
+
+import "fmt"
+
+// bitBangMaxTransfer is the most bytes a bit-banged write sends before
+// chip select is released, which only bounds the IT8951's packets
+const bitBangMaxTransfer = 1 << 16
+
+// BitBangSPI drives SPI mode 0 by toggling GPIO lines, for boards whose SPI
+// controller is missing, disabled, or taken by another device. Each clock
+// edge is a GPIO ioctl, so it manages some tens of kilobits a second: a
+// black and white 7.5" frame takes several seconds to send rather than a
+// fraction of one.
+type BitBangSPI struct {
+	sclk, mosi *GPIOOutput
+	miso       *GPIOLine   // nil when nothing is read back
+	cs         *GPIOOutput // nil when chip select is tied low
+}
+
+// OpenBitBangSPI opens the SCLK, MOSI, and, if set, MISO and CS lines of
+// config as a bit-banged SPI bus
+func OpenBitBangSPI(config SPIConfig) (*BitBangSPI, error) {
+	b := &BitBangSPI{}
+	var err error
+	fail := func(err error) (*BitBangSPI, error) {
+		b.Close()
+		return nil, err
+	}
+	if b.sclk, err = openPinOutput(config.GPIOChip, config.SCLK, false); err != nil {
+		return fail(err)
+	}
+	if b.mosi, err = openPinOutput(config.GPIOChip, config.MOSI, false); err != nil {
+		return fail(err)
+	}
+	if config.MISO != "" {
+		chip, offset, err := config.MISO.Resolve(config.GPIOChip)
+		if err != nil {
+			return fail(err)
+		}
+		if b.miso, err = OpenGPIOLevel(chip, offset); err != nil {
+			return fail(err)
+		}
+	}
+	if config.CS != "" {
+		if b.cs, err = openPinOutput(config.GPIOChip, config.CS, true); err != nil {
+			return fail(err)
+		}
+	}
+	return b, nil
+}
+
+// Write sends data with chip select asserted, as one transfer
+func (b *BitBangSPI) Write(data []byte) error {
+	if err := b.selectChip(true); err != nil {
+		return err
+	}
+	defer b.selectChip(false)
+	for _, v := range data {
+		if err := b.writeByte(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteRead sends tx and then reads n bytes, with chip select asserted
+// throughout
+func (b *BitBangSPI) WriteRead(tx []byte, n int) ([]byte, error) {
+	if b.miso == nil {
+		return nil, fmt.Errorf("bit-banged SPI cannot read without a MISO line")
+	}
+	if err := b.selectChip(true); err != nil {
+		return nil, err
+	}
+	defer b.selectChip(false)
+	for _, v := range tx {
+		if err := b.writeByte(v); err != nil {
+			return nil, err
+		}
+	}
+	rx := make([]byte, n)
+	for i := range rx {
+		for bit := 7; bit >= 0; bit-- {
+			// Mode 0: the device shifts out on the falling edge, so the
+			// bit is read once the clock has risen
+			if err := b.sclk.Set(true); err != nil {
+				return nil, err
+			}
+			high, err := b.miso.Value()
+			if err != nil {
+				return nil, err
+			}
+			if high {
+				rx[i] |= 1 << bit
+			}
+			if err := b.sclk.Set(false); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rx, nil
+}
+
+// writeByte clocks out v, most significant bit first, setting each bit
+// while the clock is low for the device to read on the rising edge
+func (b *BitBangSPI) writeByte(v byte) error {
+	for bit := 7; bit >= 0; bit-- {
+		if err := b.mosi.Set(v&(1<<bit) != 0); err != nil {
+			return err
+		}
+		if err := b.sclk.Set(true); err != nil {
+			return err
+		}
+		if err := b.sclk.Set(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selectChip drives chip select, which is active low
+func (b *BitBangSPI) selectChip(active bool) error {
+	if b.cs == nil {
+		return nil
+	}
+	return b.cs.Set(!active)
+}
+
+// MaxTransfer returns the most bytes sent in one transfer
+func (b *BitBangSPI) MaxTransfer() int {
+	return bitBangMaxTransfer
+}
+
+// Close releases the lines
+func (b *BitBangSPI) Close() error {
+	for _, line := range []*GPIOOutput{b.sclk, b.mosi, b.cs} {
+		if line != nil {
+			line.Close()
+		}
+	}
+	if b.miso != nil {
+		b.miso.Close()
+	}
+	return nil
+}
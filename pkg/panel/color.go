@@ -0,0 +1,416 @@
+package panel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// Colour panels take tens of seconds to refresh, longer in the cold
+const colorBusyTimeout = 60 * time.Second
+
+// Colour panels should rest this long between refreshes, as Waveshare
+// advise, or they age faster
+const colorMinInterval = 3 * time.Minute
+
+// DefaultSaturation is how vivid the colours frames are matched against
+// are by default, halfway between the inks as the panel shows them and
+// pure ones
+const DefaultSaturation = 0.5
+
+// ColorModel describes one of the colour panels
+type ColorModel struct {
+	Name          string
+	Width, Height int
+
+	// The inks: the colour each shows as on the panel, a pure colour it
+	// stands for, and the code the controller takes for it
+	muted, vivid color.Palette
+	codes        []byte
+
+	// Commands that configure the panel after a reset, and that refresh it
+	// from the frame in its memory, following Waveshare's reference drivers
+	setup, refresh []epdCommand
+}
+
+// Colour panels the colour driver supports
+var (
+	// 7.3" seven-colour ACeP panel (800x480)
+	ACeP7in3F = ColorModel{
+		Name: "7.3\" ACeP (F)", Width: 800, Height: 480,
+		muted: acepMuted, vivid: acepVivid, codes: acepCodes,
+		setup: []epdCommand{
+			{0xAA, []byte{0x49, 0x55, 0x20, 0x08, 0x09, 0x18}}, // command header
+			{0x01, []byte{0x3F, 0x00, 0x32, 0x2A, 0x0E, 0x2A}}, // power setting
+			{0x00, []byte{0x5F, 0x69}},                         // panel setting
+			{0x03, []byte{0x00, 0x54, 0x00, 0x44}},             // power off sequence
+			{0x05, []byte{0x40, 0x1F, 0x1F, 0x2C}},             // booster soft start 1
+			{0x06, []byte{0x6F, 0x1F, 0x1F, 0x22}},             // booster soft start 2
+			{0x08, []byte{0x6F, 0x1F, 0x1F, 0x22}},             // booster soft start 3
+			{0x13, []byte{0x00, 0x04}},                         // IPC
+			{0x30, []byte{0x3C}},                               // PLL: 50 Hz
+			{0x41, []byte{0x00}},                               // temperature sensor: internal
+			{0x50, []byte{0x3F}},                               // VCOM and data interval
+			{0x60, []byte{0x02, 0x00}},                         // TCON
+			{0x61, []byte{0x03, 0x20, 0x01, 0xE0}},             // resolution: 800x480
+			{0x82, []byte{0x1E}},                               // VCOM DC
+			{0x84, []byte{0x00}},
+			{0x86, []byte{0x00}},
+			{0xE3, []byte{0x2F}}, // power saving
+			{0xE0, []byte{0x00}}, // cascade setting: internal temperature
+			{0xE6, []byte{0x00}},
+		},
+		refresh: []epdCommand{
+			{0x04, nil},          // power on
+			{0x12, []byte{0x00}}, // refresh
+		},
+	}
+
+	// 5.65" seven-colour ACeP panel (600x448)
+	ACeP5in65F = ColorModel{
+		Name: "5.65\" ACeP (F)", Width: 600, Height: 448,
+		muted: acepMuted, vivid: acepVivid, codes: acepCodes,
+		setup: []epdCommand{
+			{0x00, []byte{0xEF, 0x08}},             // panel setting
+			{0x01, []byte{0x37, 0x00, 0x23, 0x23}}, // power setting
+			{0x03, []byte{0x00}},                   // power off sequence
+			{0x06, []byte{0xC7, 0xC7, 0x1D}},       // booster soft start
+			{0x30, []byte{0x3C}},                   // PLL: 50 Hz
+			{0x41, []byte{0x00}},                   // temperature sensor: internal
+			{0x50, []byte{0x37}},                   // VCOM and data interval
+			{0x60, []byte{0x22}},                   // TCON
+			{0x61, []byte{0x02, 0x58, 0x01, 0xC0}}, // resolution: 600x448
+			{0xE3, []byte{0xAA}},                   // power saving
+		},
+		refresh: []epdCommand{
+			{0x04, nil},          // power on
+			{0x12, []byte{0x00}}, // refresh
+		},
+	}
+
+	// 7.3" six-colour Spectra 6 panel (800x480)
+	Spectra7in3E = ColorModel{
+		Name: "7.3\" Spectra 6 (E)", Width: 800, Height: 480,
+		muted: color.Palette{
+			color.RGBA{0x02, 0x02, 0x02, 0xFF}, // black
+			color.RGBA{0xBE, 0xC8, 0xC8, 0xFF}, // white
+			color.RGBA{0xCD, 0xCA, 0x00, 0xFF}, // yellow
+			color.RGBA{0x87, 0x13, 0x00, 0xFF}, // red
+			color.RGBA{0x05, 0x40, 0x9E, 0xFF}, // blue
+			color.RGBA{0x27, 0x66, 0x3C, 0xFF}, // green
+		},
+		vivid: color.Palette{
+			color.RGBA{0x00, 0x00, 0x00, 0xFF},
+			color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+			color.RGBA{0xFF, 0xFF, 0x00, 0xFF},
+			color.RGBA{0xFF, 0x00, 0x00, 0xFF},
+			color.RGBA{0x00, 0x00, 0xFF, 0xFF},
+			color.RGBA{0x00, 0xFF, 0x00, 0xFF},
+		},
+		codes: []byte{0x0, 0x1, 0x2, 0x3, 0x5, 0x6},
+		setup: []epdCommand{
+			{0xAA, []byte{0x49, 0x55, 0x20, 0x08, 0x09, 0x18}}, // command header
+			{0x01, []byte{0x3F}},                   // power setting
+			{0x00, []byte{0x5F, 0x69}},             // panel setting
+			{0x03, []byte{0x00, 0x54, 0x00, 0x44}}, // power off sequence
+			{0x05, []byte{0x40, 0x1F, 0x1F, 0x2C}}, // booster soft start 1
+			{0x06, []byte{0x6F, 0x1F, 0x17, 0x49}}, // booster soft start 2
+			{0x08, []byte{0x6F, 0x1F, 0x1F, 0x22}}, // booster soft start 3
+			{0x30, []byte{0x03}},                   // PLL
+			{0x50, []byte{0x3F}},                   // VCOM and data interval
+			{0x60, []byte{0x02, 0x00}},             // TCON
+			{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+			{0x84, []byte{0x01}},
+			{0xE3, []byte{0x2F}}, // power saving
+		},
+		refresh: []epdCommand{
+			{0x04, nil},                            // power on
+			{0x06, []byte{0x6F, 0x1F, 0x17, 0x49}}, // booster soft start 2
+			{0x12, []byte{0x00}},                   // refresh
+		},
+	}
+)
+
+// The seven ACeP inks, in the controller's order: black, white, green,
+// blue, red, yellow, and orange
+var (
+	acepMuted = color.Palette{
+		color.RGBA{0x39, 0x30, 0x39, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0x3A, 0x5B, 0x46, 0xFF},
+		color.RGBA{0x3D, 0x3B, 0x5E, 0xFF},
+		color.RGBA{0x9C, 0x48, 0x4B, 0xFF},
+		color.RGBA{0xD0, 0xBE, 0x47, 0xFF},
+		color.RGBA{0xB1, 0x6A, 0x49, 0xFF},
+	}
+	acepVivid = color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0xFF, 0xFF},
+		color.RGBA{0x00, 0xFF, 0x00, 0xFF},
+		color.RGBA{0x00, 0x00, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0x00, 0x00, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0x00, 0xFF},
+		color.RGBA{0xFF, 0x80, 0x00, 0xFF},
+	}
+	acepCodes = []byte{0x0, 0x1, 0x2, 0x3, 0x4, 0x5, 0x6}
+)
+
+// Palette returns the colours frames are matched against for a saturation
+// from 0, the inks as the panel really shows them, to 1, pure colours.
+// Matching against the real inks keeps the tones of photos truer; pure
+// colours put saturated areas such as charts and logos on solid inks
+// rather than dithering them.
+func (m ColorModel) Palette(saturation float64) color.Palette {
+	return render.MixPalette(m.muted, m.vivid, saturation)
+}
+
+// ColorEPD drives a Waveshare colour e-paper panel, ACeP or Spectra 6, over
+// SPI. Frames are dithered to the panel's inks; there is only the one,
+// slow, full refresh. Between refreshes the panel is kept in deep sleep.
+type ColorEPD struct {
+	Model ColorModel
+
+	// Saturation, from 0 to 1, chooses the palette frames are dithered to;
+	// see ColorModel.Palette
+	Saturation float64
+
+	// Dither is how frames are dithered to the inks
+	Dither render.Dither
+
+	// Trace, if set, is told how long each step of Display took: "init",
+	// "pack", "transfer", and "refresh"
+	Trace func(step string, d time.Duration)
+
+	spi  SPI
+	rst  *GPIOOutput
+	dc   *GPIOOutput
+	cs   *GPIOOutput // nil when the SPI controller drives chip select
+	pwr  *GPIOOutput // nil when the panel is always powered
+	busy *GPIOLine
+
+	// The dithered and packed frames, whose memory each refresh reuses
+	frame *image.Paletted
+	buf   []byte
+}
+
+// OpenColorEPD opens the SPI device and GPIO lines a panel of the given
+// model is wired to
+func OpenColorEPD(config SPIConfig, model ColorModel) (*ColorEPD, error) {
+	config = config.WithDefaults()
+	p := &ColorEPD{Model: model, Saturation: DefaultSaturation, Dither: render.DefaultDither}
+	var err error
+	fail := func(err error) (*ColorEPD, error) {
+		p.Close()
+		return nil, err
+	}
+
+	if p.spi, err = OpenSPIBus(config); err != nil {
+		return fail(err)
+	}
+	if p.rst, err = openPinOutput(config.GPIOChip, config.RST, true); err != nil {
+		return fail(err)
+	}
+	if p.dc, err = openPinOutput(config.GPIOChip, config.DC, false); err != nil {
+		return fail(err)
+	}
+	chip, offset, err := config.BUSY.Resolve(config.GPIOChip)
+	if err != nil {
+		return fail(err)
+	}
+	if p.busy, err = OpenGPIOLevel(chip, offset); err != nil {
+		return fail(err)
+	}
+	if config.gpioCS() {
+		if p.cs, err = openPinOutput(config.GPIOChip, config.CS, true); err != nil {
+			return fail(err)
+		}
+	}
+	if config.PWR != "" {
+		if p.pwr, err = openPinOutput(config.GPIOChip, config.PWR, true); err != nil {
+			return fail(err)
+		}
+	}
+	return p, nil
+}
+
+// Bounds returns the panel's size
+func (p *ColorEPD) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.Model.Width, p.Model.Height)
+}
+
+// MinInterval returns how long the panel should rest between refreshes
+func (p *ColorEPD) MinInterval() time.Duration {
+	return colorMinInterval
+}
+
+// Display dithers img, which must already be the panel's size, to the
+// panel's inks and shows it. The panel is powered off and put into deep
+// sleep afterwards, even if the refresh failed.
+func (p *ColorEPD) Display(img image.Image) error {
+	start := time.Now()
+	p.frame = render.DitherPaletteTo(p.frame, img, p.Model.Width, p.Model.Height, p.Model.Palette(p.Saturation), p.Dither)
+	p.buf = packColor(p.buf, p.frame, p.Model.codes)
+	buf := p.buf
+	p.trace("pack", &start)
+
+	err := p.display(buf, &start)
+	if err != nil {
+		p.powerOff()
+	}
+	if sleepErr := p.send(0x07, 0xA5); err == nil { // deep sleep
+		err = sleepErr
+	}
+	return err
+}
+
+// display wakes the panel, sends it a packed frame, and refreshes it
+func (p *ColorEPD) display(buf []byte, start *time.Time) error {
+	if err := p.reset(); err != nil {
+		return err
+	}
+	if err := p.waitIdle(); err != nil {
+		return err
+	}
+	for _, cmd := range p.Model.setup {
+		if err := p.send(cmd.Command, cmd.Data...); err != nil {
+			return err
+		}
+	}
+	p.trace("init", start)
+
+	if err := p.send(0x10, buf...); err != nil {
+		return err
+	}
+	p.trace("transfer", start)
+
+	for _, cmd := range p.Model.refresh {
+		if err := p.send(cmd.Command, cmd.Data...); err != nil {
+			return err
+		}
+		if err := p.waitIdle(); err != nil {
+			return err
+		}
+	}
+	if err := p.powerOff(); err != nil {
+		return err
+	}
+	p.trace("refresh", start)
+	return nil
+}
+
+// packColor packs a frame of palette indices at 4 bits per pixel as the
+// controller codes for them, the first pixel of each pair in the high bits,
+// with rows padded to a whole byte. It reuses buf when it is big enough.
+func packColor(buf []byte, img *image.Paletted, codes []byte) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	stride := (width + 1) / 2
+	if cap(buf) < stride*height {
+		buf = make([]byte, stride*height)
+	}
+	buf = buf[:stride*height]
+	clear(buf)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			buf[y*stride+x/2] |= (codes[row[x]] & 0x0F) << (4 * (1 - x%2))
+		}
+	}
+	return buf
+}
+
+// trace reports the time since *start to Trace and restarts the clock
+func (p *ColorEPD) trace(step string, start *time.Time) {
+	now := time.Now()
+	if p.Trace != nil {
+		p.Trace(step, now.Sub(*start))
+	}
+	*start = now
+}
+
+// Clear blanks the panel to white
+func (p *ColorEPD) Clear() error {
+	return p.Display(image.NewUniform(color.White))
+}
+
+// Close releases the SPI device and GPIO lines; the panel is already in
+// deep sleep
+func (p *ColorEPD) Close() {
+	if p.spi != nil {
+		p.spi.Close()
+	}
+	for _, line := range []*GPIOOutput{p.rst, p.dc, p.cs, p.pwr} {
+		if line != nil {
+			line.Close()
+		}
+	}
+	if p.busy != nil {
+		p.busy.Close()
+	}
+}
+
+// reset pulses the reset line, which also wakes the panel from deep sleep
+func (p *ColorEPD) reset() error {
+	for _, step := range []struct {
+		high  bool
+		delay time.Duration
+	}{{true, 20 * time.Millisecond}, {false, 2 * time.Millisecond}, {true, 20 * time.Millisecond}} {
+		if err := p.rst.Set(step.high); err != nil {
+			return err
+		}
+		time.Sleep(step.delay)
+	}
+	return nil
+}
+
+// powerOff turns off the panel's drive voltages
+func (p *ColorEPD) powerOff() error {
+	if err := p.send(0x02, 0x00); err != nil {
+		return err
+	}
+	return p.waitIdle()
+}
+
+// send writes a command byte, with DC low, followed by its data, with DC high
+func (p *ColorEPD) send(command byte, data ...byte) error {
+	if p.cs != nil {
+		if err := p.cs.Set(false); err != nil {
+			return err
+		}
+		defer p.cs.Set(true)
+	}
+	if err := p.dc.Set(false); err != nil {
+		return err
+	}
+	if err := p.spi.Write([]byte{command}); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := p.dc.Set(true); err != nil {
+		return err
+	}
+	return p.spi.Write(data)
+}
+
+// waitIdle waits for BUSY to go high, which the panel holds low while
+// working
+func (p *ColorEPD) waitIdle() error {
+	deadline := time.Now().Add(colorBusyTimeout)
+	for {
+		idle, err := p.busy.Value()
+		if err != nil {
+			return err
+		}
+		if idle {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("panel still busy after %v; check the BUSY wiring", colorBusyTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,377 @@
+package panel
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// DefaultDRMCard is the DRM device OpenDRM uses when given none
+const DefaultDRMCard = "/dev/dri/card0"
+
+// DRM mode-setting ioctls from drm/drm.h, all _IOWR('d', nr, struct)
+const (
+	drmIoctlModeGetResources = 0xC04064A0 // struct drm_mode_card_res
+	drmIoctlModeGetCrtc      = 0xC06864A1 // struct drm_mode_crtc
+	drmIoctlModeSetCrtc      = 0xC06864A2 // struct drm_mode_crtc
+	drmIoctlModeGetEncoder   = 0xC01464A6 // struct drm_mode_get_encoder
+	drmIoctlModeGetConnector = 0xC05064A7 // struct drm_mode_get_connector
+	drmIoctlModeAddFB        = 0xC01C64AE // struct drm_mode_fb_cmd
+	drmIoctlModeRmFB         = 0xC00464AF // unsigned int
+	drmIoctlModeCreateDumb   = 0xC02064B2 // struct drm_mode_create_dumb
+	drmIoctlModeMapDumb      = 0xC01064B3 // struct drm_mode_map_dumb
+	drmIoctlModeDestroyDumb  = 0xC00464B4 // struct drm_mode_destroy_dumb
+)
+
+// Connector states and mode types from drm/drm_mode.h
+const (
+	drmModeConnected     = 1
+	drmModeTypePreferred = 1 << 3
+)
+
+// drmModeCardRes is struct drm_mode_card_res
+type drmModeCardRes struct {
+	fbIDPtr, crtcIDPtr, connectorIDPtr, encoderIDPtr     uint64
+	countFbs, countCrtcs, countConnectors, countEncoders uint32
+	minWidth, maxWidth, minHeight, maxHeight             uint32
+}
+
+// drmModeInfo is struct drm_mode_modeinfo
+type drmModeInfo struct {
+	clock                                         uint32
+	hdisplay, hsyncStart, hsyncEnd, htotal, hskew uint16
+	vdisplay, vsyncStart, vsyncEnd, vtotal, vscan uint16
+	vrefresh, flags, typ                          uint32
+	name                                          [32]byte
+}
+
+// drmModeCrtc is struct drm_mode_crtc
+type drmModeCrtc struct {
+	setConnectorsPtr uint64
+	countConnectors  uint32
+	crtcID, fbID     uint32
+	x, y             uint32
+	gammaSize        uint32
+	modeValid        uint32
+	mode             drmModeInfo
+}
+
+// drmModeGetEncoder is struct drm_mode_get_encoder
+type drmModeGetEncoder struct {
+	encoderID, encoderType, crtcID, possibleCrtcs, possibleClones uint32
+}
+
+// drmModeGetConnector is struct drm_mode_get_connector
+type drmModeGetConnector struct {
+	encodersPtr, modesPtr, propsPtr, propValuesPtr uint64
+	countModes, countProps, countEncoders          uint32
+	encoderID, connectorID                         uint32
+	connectorType, connectorTypeID                 uint32
+	connection, mmWidth, mmHeight, subpixel, pad   uint32
+}
+
+// drmModeFBCmd is struct drm_mode_fb_cmd
+type drmModeFBCmd struct {
+	fbID, width, height, pitch, bpp, depth, handle uint32
+}
+
+// drmModeCreateDumb is struct drm_mode_create_dumb
+type drmModeCreateDumb struct {
+	height, width, bpp, flags, handle, pitch uint32
+	size                                     uint64
+}
+
+// drmModeMapDumb is struct drm_mode_map_dumb
+type drmModeMapDumb struct {
+	handle, pad uint32
+	offset      uint64
+}
+
+// DRMPanel shows frames on an HDMI or DSI display through the kernel's DRM
+// mode setting, in a dumb buffer scanned out at the first connected
+// output's preferred mode. It needs no X or Wayland, just to be the only
+// program setting modes on the card, as a console-only Pi is.
+type DRMPanel struct {
+	// Path is the DRM device, and Connector the output drawn on
+	Path      string
+	Connector string
+
+	file      *os.File
+	connector uint32
+	crtc      uint32
+	mode      drmModeInfo
+	fb        uint32
+	handle    uint32
+	pitch     int
+	mem       []byte
+	saved     drmModeCrtc
+	bounds    image.Rectangle
+}
+
+// drmIoctl issues a DRM ioctl on f, retrying when interrupted as libdrm does
+func drmIoctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(arg))
+		if errno == syscall.EINTR || errno == syscall.EAGAIN {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+// drmConnectorNames are the kernel's names for DRM connector types, by
+// DRM_MODE_CONNECTOR_* value
+var drmConnectorNames = map[uint32]string{
+	1: "VGA", 2: "DVI-I", 3: "DVI-D", 4: "DVI-A", 5: "Composite", 6: "SVIDEO",
+	7: "LVDS", 8: "Component", 9: "DIN", 10: "DP", 11: "HDMI-A", 12: "HDMI-B",
+	13: "TV", 14: "eDP", 15: "Virtual", 16: "DSI", 17: "DPI", 18: "Writeback",
+	19: "SPI", 20: "USB",
+}
+
+// OpenDRM takes over the first connected output of the DRM device at path,
+// DefaultDRMCard if empty, showing a black screen until the first frame
+func OpenDRM(path string) (*DRMPanel, error) {
+	if path == "" {
+		path = DefaultDRMCard
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	p := &DRMPanel{Path: path, file: f}
+	if err := p.setup(); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// setup finds a connected output and a CRTC to drive it, and scans out a
+// new dumb buffer at the output's preferred mode
+func (p *DRMPanel) setup() error {
+	var res drmModeCardRes
+	if err := drmIoctl(p.file, drmIoctlModeGetResources, unsafe.Pointer(&res)); err != nil {
+		if errors.Is(err, syscall.ENOTTY) {
+			return fmt.Errorf("%s is not a DRM device", p.Path)
+		}
+		if errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.EOPNOTSUPP) {
+			return fmt.Errorf("%s does not support mode setting; it may be a render-only device, try another card", p.Path)
+		}
+		return fmt.Errorf("error reading %s: %v", p.Path, err)
+	}
+	crtcs := make([]uint32, res.countCrtcs)
+	connectors := make([]uint32, res.countConnectors)
+	encoders := make([]uint32, res.countEncoders)
+	res = drmModeCardRes{countCrtcs: uint32(len(crtcs)), countConnectors: uint32(len(connectors)), countEncoders: uint32(len(encoders))}
+	if len(crtcs) > 0 {
+		res.crtcIDPtr = uint64(uintptr(unsafe.Pointer(&crtcs[0])))
+	}
+	if len(connectors) > 0 {
+		res.connectorIDPtr = uint64(uintptr(unsafe.Pointer(&connectors[0])))
+	}
+	if len(encoders) > 0 {
+		res.encoderIDPtr = uint64(uintptr(unsafe.Pointer(&encoders[0])))
+	}
+	err := drmIoctl(p.file, drmIoctlModeGetResources, unsafe.Pointer(&res))
+	runtime.KeepAlive(crtcs)
+	runtime.KeepAlive(connectors)
+	runtime.KeepAlive(encoders)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", p.Path, err)
+	}
+	if len(crtcs) == 0 {
+		return fmt.Errorf("%s has no display controllers", p.Path)
+	}
+
+	for _, id := range connectors {
+		conn, modes, connEncoders, err := p.getConnector(id)
+		if err != nil {
+			return err
+		}
+		if conn.connection != drmModeConnected || len(modes) == 0 {
+			continue
+		}
+		p.connector = id
+		p.Connector = fmt.Sprintf("%s-%d", drmConnectorNames[conn.connectorType], conn.connectorTypeID)
+		p.mode = modes[0]
+		for _, mode := range modes {
+			if mode.typ&drmModeTypePreferred != 0 {
+				p.mode = mode
+				break
+			}
+		}
+		p.crtc = p.findCrtc(conn.encoderID, connEncoders, crtcs)
+		break
+	}
+	if p.connector == 0 {
+		return fmt.Errorf("no display is connected to %s", p.Path)
+	}
+	if p.crtc == 0 {
+		return fmt.Errorf("no display controller on %s can drive %s", p.Path, p.Connector)
+	}
+	p.bounds = image.Rect(0, 0, int(p.mode.hdisplay), int(p.mode.vdisplay))
+
+	p.saved = drmModeCrtc{crtcID: p.crtc}
+	if err := drmIoctl(p.file, drmIoctlModeGetCrtc, unsafe.Pointer(&p.saved)); err != nil {
+		return fmt.Errorf("error reading display controller on %s: %v", p.Path, err)
+	}
+
+	create := drmModeCreateDumb{width: uint32(p.bounds.Dx()), height: uint32(p.bounds.Dy()), bpp: 32}
+	if err := drmIoctl(p.file, drmIoctlModeCreateDumb, unsafe.Pointer(&create)); err != nil {
+		return fmt.Errorf("error allocating a %v buffer on %s: %v", p.bounds.Size(), p.Path, err)
+	}
+	p.handle, p.pitch = create.handle, int(create.pitch)
+	fbCmd := drmModeFBCmd{width: create.width, height: create.height, pitch: create.pitch, bpp: 32, depth: 24, handle: create.handle}
+	if err := drmIoctl(p.file, drmIoctlModeAddFB, unsafe.Pointer(&fbCmd)); err != nil {
+		return fmt.Errorf("error adding framebuffer on %s: %v", p.Path, err)
+	}
+	p.fb = fbCmd.fbID
+	mapDumb := drmModeMapDumb{handle: p.handle}
+	if err := drmIoctl(p.file, drmIoctlModeMapDumb, unsafe.Pointer(&mapDumb)); err != nil {
+		return fmt.Errorf("error mapping framebuffer on %s: %v", p.Path, err)
+	}
+	p.mem, err = syscall.Mmap(int(p.file.Fd()), int64(mapDumb.offset), int(create.size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("error mapping framebuffer on %s: %v", p.Path, err)
+	}
+
+	if err := p.setCrtc(p.fb, &p.mode); err != nil {
+		if errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("error showing on %s: another program, such as a desktop, is using the display, or this one is not root", p.Connector)
+		}
+		return fmt.Errorf("error showing on %s: %v", p.Connector, err)
+	}
+	return nil
+}
+
+// getConnector reads a connector with its modes and possible encoders
+func (p *DRMPanel) getConnector(id uint32) (drmModeGetConnector, []drmModeInfo, []uint32, error) {
+	conn := drmModeGetConnector{connectorID: id}
+	if err := drmIoctl(p.file, drmIoctlModeGetConnector, unsafe.Pointer(&conn)); err != nil {
+		return conn, nil, nil, fmt.Errorf("error reading connector %d on %s: %v", id, p.Path, err)
+	}
+	modes := make([]drmModeInfo, conn.countModes)
+	encoders := make([]uint32, conn.countEncoders)
+	conn = drmModeGetConnector{connectorID: id, countModes: uint32(len(modes)), countEncoders: uint32(len(encoders))}
+	if len(modes) > 0 {
+		conn.modesPtr = uint64(uintptr(unsafe.Pointer(&modes[0])))
+	}
+	if len(encoders) > 0 {
+		conn.encodersPtr = uint64(uintptr(unsafe.Pointer(&encoders[0])))
+	}
+	err := drmIoctl(p.file, drmIoctlModeGetConnector, unsafe.Pointer(&conn))
+	runtime.KeepAlive(modes)
+	runtime.KeepAlive(encoders)
+	if err != nil {
+		return conn, nil, nil, fmt.Errorf("error reading connector %d on %s: %v", id, p.Path, err)
+	}
+	// A hotplug between the two calls can change the counts
+	return conn, modes[:min(len(modes), int(conn.countModes))], encoders[:min(len(encoders), int(conn.countEncoders))], nil
+}
+
+// findCrtc picks the CRTC to drive a connector: the one its encoder already
+// uses, or the first any of its encoders can use
+func (p *DRMPanel) findCrtc(current uint32, encoders, crtcs []uint32) uint32 {
+	if current != 0 {
+		enc := drmModeGetEncoder{encoderID: current}
+		if drmIoctl(p.file, drmIoctlModeGetEncoder, unsafe.Pointer(&enc)) == nil && enc.crtcID != 0 {
+			return enc.crtcID
+		}
+	}
+	for _, id := range encoders {
+		enc := drmModeGetEncoder{encoderID: id}
+		if drmIoctl(p.file, drmIoctlModeGetEncoder, unsafe.Pointer(&enc)) != nil {
+			continue
+		}
+		for i, crtc := range crtcs {
+			if enc.possibleCrtcs&(1<<i) != 0 {
+				return crtc
+			}
+		}
+	}
+	return 0
+}
+
+// setCrtc scans out fb on the connector in mode
+func (p *DRMPanel) setCrtc(fb uint32, mode *drmModeInfo) error {
+	connector := p.connector
+	crtc := drmModeCrtc{
+		setConnectorsPtr: uint64(uintptr(unsafe.Pointer(&connector))),
+		countConnectors:  1,
+		crtcID:           p.crtc,
+		fbID:             fb,
+		modeValid:        1,
+		mode:             *mode,
+	}
+	err := drmIoctl(p.file, drmIoctlModeSetCrtc, unsafe.Pointer(&crtc))
+	runtime.KeepAlive(&connector)
+	return err
+}
+
+// Bounds returns the size of the output's mode
+func (p *DRMPanel) Bounds() image.Rectangle {
+	return p.bounds
+}
+
+// Display copies img into the buffer being scanned out
+func (p *DRMPanel) Display(img image.Image) error {
+	putXRGB(p.mem, p.pitch, img)
+	return nil
+}
+
+// Clear fills the screen with black, as the framebuffer is cleared
+func (p *DRMPanel) Clear() error {
+	clear(p.mem)
+	return nil
+}
+
+// Close gives the output back to whatever showed on it before, normally
+// the console, and frees the buffer
+func (p *DRMPanel) Close() {
+	if p.fb != 0 && p.saved.modeValid != 0 {
+		p.setCrtc(p.saved.fbID, &p.saved.mode)
+	}
+	if p.mem != nil {
+		syscall.Munmap(p.mem)
+		p.mem = nil
+	}
+	if p.fb != 0 {
+		fb := p.fb
+		drmIoctl(p.file, drmIoctlModeRmFB, unsafe.Pointer(&fb))
+		p.fb = 0
+	}
+	if p.handle != 0 {
+		handle := p.handle
+		drmIoctl(p.file, drmIoctlModeDestroyDumb, unsafe.Pointer(&handle))
+		p.handle = 0
+	}
+	p.file.Close()
+}
+
+// putXRGB writes img into mem as rows of little-endian XRGB8888 pixels,
+// pitch bytes apart, the format dumb buffers are scanned out in
+func putXRGB(mem []byte, pitch int, img image.Image) {
+	bounds := img.Bounds()
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	}
+	width, height := min(bounds.Dx(), pitch/4), min(bounds.Dy(), len(mem)/pitch)
+	for y := 0; y < height; y++ {
+		i := rgba.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		src := rgba.Pix[i : i+width*4]
+		dst := mem[y*pitch : y*pitch+width*4]
+		for i := 0; i < len(src); i += 4 {
+			dst[i], dst[i+1], dst[i+2], dst[i+3] = src[i+2], src[i+1], src[i], 0xFF
+		}
+	}
+}
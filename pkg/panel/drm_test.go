@@ -0,0 +1,46 @@
+package panel
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"unsafe"
+)
+
+func TestDRMStructSizes(t *testing.T) {
+	// The size of each ioctl's argument is encoded in its request number
+	for _, tt := range []struct {
+		name string
+		req  uintptr
+		size uintptr
+	}{
+		{"drm_mode_card_res", drmIoctlModeGetResources, unsafe.Sizeof(drmModeCardRes{})},
+		{"drm_mode_crtc", drmIoctlModeSetCrtc, unsafe.Sizeof(drmModeCrtc{})},
+		{"drm_mode_get_encoder", drmIoctlModeGetEncoder, unsafe.Sizeof(drmModeGetEncoder{})},
+		{"drm_mode_get_connector", drmIoctlModeGetConnector, unsafe.Sizeof(drmModeGetConnector{})},
+		{"drm_mode_fb_cmd", drmIoctlModeAddFB, unsafe.Sizeof(drmModeFBCmd{})},
+		{"drm_mode_create_dumb", drmIoctlModeCreateDumb, unsafe.Sizeof(drmModeCreateDumb{})},
+		{"drm_mode_map_dumb", drmIoctlModeMapDumb, unsafe.Sizeof(drmModeMapDumb{})},
+	} {
+		if want := tt.req >> 16 & 0x3FFF; tt.size != want {
+			t.Errorf("%s is %d bytes, want %d", tt.name, tt.size, want)
+		}
+	}
+	if size := unsafe.Sizeof(drmModeInfo{}); size != 68 {
+		t.Errorf("drm_mode_modeinfo is %d bytes, want 68", size)
+	}
+}
+
+func TestPutXRGB(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(1, 1, color.RGBA{R: 10, G: 20, B: 30, A: 255})
+	pitch := 16 // padded beyond the 8 bytes of a row
+	mem := make([]byte, pitch*2)
+	putXRGB(mem, pitch, img.SubImage(image.Rect(0, 0, 2, 2)))
+	if got := mem[pitch+4 : pitch+8]; got[0] != 30 || got[1] != 20 || got[2] != 10 || got[3] != 0xFF {
+		t.Errorf("pixel = %v, want B G R X of 30 20 10 255", got)
+	}
+	if mem[8] != 0 {
+		t.Errorf("padding at the end of a row was written")
+	}
+}
@@ -0,0 +1,698 @@
+package panel
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// How long to wait for the panel to finish a command before giving up
+const epdBusyTimeout = 30 * time.Second
+
+// modeGray4 is the four-grey refresh, and modeRed the black, white, and red
+// one, which the panel has to be initialised for like the other modes
+const (
+	modeGray4 Mode = "gray4"
+	modeRed   Mode = "red"
+)
+
+// Partial refresh defaults: regions closer than this many pixels are
+// refreshed together, and at most this many are refreshed one after another
+const (
+	epdPartialGap        = 16
+	epdMaxPartialRegions = 4
+)
+
+// DefaultPartialThreshold is the fraction of the panel that may change
+// before a partial refresh gives way to a full one
+const DefaultPartialThreshold = 0.5
+
+// EPD drives a Waveshare 7.5" V2 (800x480, black and white or four greys)
+// or 7.5" B V2 (black, white, and red) e-paper panel over SPI. Between refreshes the panel is powered off, and with DeepSleep
+// kept in deep sleep, so each refresh resets and initialises it again.
+type EPD struct {
+	Width, Height int
+
+	// DeepSleep puts the panel into deep sleep after each refresh, where it
+	// draws the least power. Without it the panel keeps its settings while
+	// powered off and is woken by powering it back on, which is quicker.
+	DeepSleep bool
+	ready     bool // initialised and not in deep sleep since
+
+	// Refresh mode for the next frame, and the one the panel was last
+	// initialised for
+	mode, readyMode Mode
+
+	// The packed frame on the panel, which partial refreshes compare the
+	// next one with; nil when it is not known. The next frame is packed
+	// into spare, the memory of the one before, and the two swap.
+	last, spare []byte
+
+	// Grayscale draws frames in four grey levels, dithered, rather than in
+	// black and white. Grey frames are always refreshed in full.
+	Grayscale bool
+
+	// Dither is how grey frames are dithered
+	Dither render.Dither
+
+	// Red marks a black, white, and red panel, which shows the pixels
+	// RedRule picks in red. Its frames are always refreshed in full.
+	Red     bool
+	RedRule render.RedRule
+
+	// PartialThreshold is the fraction of the panel that may change before
+	// a partial refresh is replaced by a full fast one, which is quicker
+	// than refreshing most of the panel region by region
+	PartialThreshold float64
+
+	spi  SPI
+	rst  *GPIOOutput
+	dc   *GPIOOutput
+	cs   *GPIOOutput // nil when the SPI controller drives chip select
+	pwr  *GPIOOutput // nil when the panel is always powered
+	busy *GPIOLine
+
+	// Waveform tables loaded in place of the panel's built-in ones
+	luts []epdCommand
+
+	// Trace, if set, is told how long each step of Display took: "init",
+	// "pack", "transfer", and "refresh"
+	Trace func(step string, d time.Duration)
+}
+
+// epdCommand is a controller command and its data
+type epdCommand struct {
+	Command byte
+	Data    []byte
+}
+
+// OpenEPD opens the SPI device and GPIO lines the panel is wired to
+func OpenEPD(config SPIConfig) (*EPD, error) {
+	config = config.WithDefaults()
+	e := &EPD{Width: Width, Height: Height, DeepSleep: true, mode: ModeQuality, PartialThreshold: DefaultPartialThreshold, Dither: render.DefaultDither}
+	var err error
+	fail := func(err error) (*EPD, error) {
+		e.Close()
+		return nil, err
+	}
+
+	if config.LUTFile != "" {
+		if e.luts, err = loadLUTs(config.LUTFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.spi, err = OpenSPIBus(config); err != nil {
+		return fail(err)
+	}
+	if e.rst, err = openPinOutput(config.GPIOChip, config.RST, true); err != nil {
+		return fail(err)
+	}
+	if e.dc, err = openPinOutput(config.GPIOChip, config.DC, false); err != nil {
+		return fail(err)
+	}
+	chip, offset, err := config.BUSY.Resolve(config.GPIOChip)
+	if err != nil {
+		return fail(err)
+	}
+	if e.busy, err = OpenGPIOLevel(chip, offset); err != nil {
+		return fail(err)
+	}
+	if config.gpioCS() {
+		if e.cs, err = openPinOutput(config.GPIOChip, config.CS, true); err != nil {
+			return fail(err)
+		}
+	}
+	if config.PWR != "" {
+		if e.pwr, err = openPinOutput(config.GPIOChip, config.PWR, true); err != nil {
+			return fail(err)
+		}
+	}
+	return e, nil
+}
+
+// openPinOutput resolves pin and opens it as an output
+func openPinOutput(chip string, pin GPIOPin, high bool) (*GPIOOutput, error) {
+	chipPath, offset, err := pin.Resolve(chip)
+	if err != nil {
+		return nil, err
+	}
+	return OpenGPIOOutput(chipPath, offset, high)
+}
+
+// Bounds returns the panel's size
+func (e *EPD) Bounds() image.Rectangle {
+	return image.Rect(0, 0, e.Width, e.Height)
+}
+
+// Display shows img, which must already be the panel's size, and puts the
+// panel back to sleep. If anything fails the panel is still powered down,
+// as leaving the drive voltages on can damage it, and it is reset before the
+// next refresh.
+func (e *EPD) Display(img image.Image) error {
+	drawn, err := e.display(img)
+	if err != nil {
+		e.ready = false
+		e.forget()
+		e.powerOff()
+		e.deepSleep()
+		return err
+	}
+	if !drawn {
+		return nil
+	}
+	if err := e.powerOff(); err != nil {
+		e.ready = false
+		return err
+	}
+	if e.DeepSleep {
+		return e.deepSleep()
+	}
+	return nil
+}
+
+// display wakes the panel, sends it img, and refreshes it. In partial mode
+// only the regions that changed since the last frame are sent and refreshed,
+// one after another. The frame is drawn in fast mode instead when more than
+// PartialThreshold of it changed, or when there is nothing to compare with,
+// as for the first frame after the panel was opened or failed. It reports
+// false, leaving the panel asleep, when a partial refresh finds nothing
+// changed.
+func (e *EPD) display(img image.Image) (bool, error) {
+	if e.Red {
+		return true, e.displayRed(img)
+	}
+	if e.Grayscale {
+		return true, e.displayGray4(img)
+	}
+	start := time.Now()
+	buf := render.Pack1BitTo(e.spare, img, e.Width, e.Height)
+	e.spare = nil
+	e.trace("pack", &start)
+
+	mode := e.mode
+	var regions []image.Rectangle
+	if mode == ModePartial && e.last != nil {
+		regions = render.DirtyRegions(e.last, buf, e.Width, e.Height, epdPartialGap, epdMaxPartialRegions)
+		if len(regions) == 0 {
+			e.spare = buf
+			return false, nil
+		}
+	}
+	if mode == ModePartial && (e.last == nil || render.DirtyFraction(regions, e.Width, e.Height) > e.PartialThreshold) {
+		mode = ModeFast
+	}
+
+	if err := e.wake(mode); err != nil {
+		return false, err
+	}
+	e.trace("init", &start)
+	if mode == ModePartial {
+		for _, region := range regions {
+			if err := e.sendPartial(buf, region); err != nil {
+				return false, err
+			}
+			e.trace("transfer", &start)
+			if err := e.refresh(); err != nil {
+				return false, err
+			}
+			if err := e.send(0x92); err != nil { // partial out
+				return false, err
+			}
+			e.trace("refresh", &start)
+		}
+		e.last, e.spare = buf, e.last
+		return true, nil
+	}
+
+	if err := e.sendFull(buf); err != nil {
+		return false, err
+	}
+	e.trace("transfer", &start)
+	if err := e.refresh(); err != nil {
+		return false, err
+	}
+	e.trace("refresh", &start)
+	e.last, e.spare = buf, e.last
+	return true, nil
+}
+
+// forget drops the frame on the panel, when it is no longer known, keeping
+// its memory for the next one
+func (e *EPD) forget() {
+	if e.last != nil {
+		e.spare, e.last = e.last, nil
+	}
+}
+
+// displayGray4 dithers img to four greys, wakes the panel for them, and
+// refreshes it
+func (e *EPD) displayGray4(img image.Image) error {
+	start := time.Now()
+	plane1, plane2 := render.PackGray4(render.DitherGray4(img, e.Width, e.Height, e.Dither), e.Width, e.Height)
+	e.trace("pack", &start)
+	// A partial refresh could not compare the next frame with this one
+	e.forget()
+	if err := e.wake(modeGray4); err != nil {
+		return err
+	}
+	e.trace("init", &start)
+	if err := e.send(0x10, plane1...); err != nil {
+		return err
+	}
+	if err := e.send(0x13, plane2...); err != nil {
+		return err
+	}
+	e.trace("transfer", &start)
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	e.trace("refresh", &start)
+	return nil
+}
+
+// displayRed splits img into its black and white and its red planes, wakes
+// the panel for them, and refreshes it
+func (e *EPD) displayRed(img image.Image) error {
+	start := time.Now()
+	black, red := render.PackRed(img, e.Width, e.Height, e.RedRule)
+	e.trace("pack", &start)
+	e.forget()
+	if err := e.wake(modeRed); err != nil {
+		return err
+	}
+	e.trace("init", &start)
+	if err := e.send(0x10, black...); err != nil {
+		return err
+	}
+	if err := e.send(0x13, red...); err != nil {
+		return err
+	}
+	e.trace("transfer", &start)
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	e.trace("refresh", &start)
+	return nil
+}
+
+// sendFull sends a whole frame. The panel compares the old (0x10) and new
+// (0x13) frames; in the new frame a set bit is black.
+func (e *EPD) sendFull(buf []byte) error {
+	if err := e.send(0x10, buf...); err != nil {
+		return err
+	}
+	return e.send(0x13, invertBytes(buf)...)
+}
+
+// sendPartial sends the part of a frame within window, whose left and right
+// edges are on byte boundaries, along with the same part of the frame on
+// the panel, so only the pixels that differ are driven
+func (e *EPD) sendPartial(buf []byte, window image.Rectangle) error {
+	xe, ye := window.Max.X-1, window.Max.Y-1
+	if err := e.sendAll([]epdCommand{
+		{0x50, []byte{0xA9, 0x07}}, // VCOM and data interval: border floating
+		{0x91, nil},                // partial in
+		{0x90, []byte{ // partial window, inclusive
+			byte(window.Min.X >> 8), byte(window.Min.X), byte(xe >> 8), byte(xe),
+			byte(window.Min.Y >> 8), byte(window.Min.Y), byte(ye >> 8), byte(ye),
+			0x01, // scan inside the window only
+		}},
+	}); err != nil {
+		return err
+	}
+	if err := e.send(0x10, invertBytes(windowBytes(e.last, e.Width, window))...); err != nil {
+		return err
+	}
+	return e.send(0x13, invertBytes(windowBytes(buf, e.Width, window))...)
+}
+
+// windowBytes copies the rows of a packed frame within window, whose left
+// and right edges are on byte boundaries
+func windowBytes(buf []byte, width int, window image.Rectangle) []byte {
+	stride := (width + 7) / 8
+	from, to := window.Min.X/8, (window.Max.X+7)/8
+	out := make([]byte, 0, (to-from)*window.Dy())
+	for y := window.Min.Y; y < window.Max.Y; y++ {
+		out = append(out, buf[y*stride+from:y*stride+to]...)
+	}
+	return out
+}
+
+// invertBytes returns a copy of buf with every bit flipped
+func invertBytes(buf []byte) []byte {
+	out := make([]byte, len(buf))
+	for i, b := range buf {
+		out[i] = ^b
+	}
+	return out
+}
+
+// trace reports the time since *start to Trace and restarts the clock
+func (e *EPD) trace(step string, start *time.Time) {
+	now := time.Now()
+	if e.Trace != nil {
+		e.Trace(step, now.Sub(*start))
+	}
+	*start = now
+}
+
+// Clear blanks the panel to white, always with a quality refresh
+func (e *EPD) Clear() error {
+	mode := e.mode
+	e.mode = ModeQuality
+	defer func() { e.mode = mode }()
+	return e.Display(image.NewUniform(color.White))
+}
+
+// Close puts the panel into deep sleep if it was left powered off, and
+// releases the SPI device and GPIO lines
+func (e *EPD) Close() {
+	if e.ready {
+		e.deepSleep()
+	}
+	if e.spi != nil {
+		e.spi.Close()
+	}
+	for _, line := range []*GPIOOutput{e.rst, e.dc, e.cs, e.pwr} {
+		if line != nil {
+			line.Close()
+		}
+	}
+	if e.busy != nil {
+		e.busy.Close()
+	}
+}
+
+// SetMode selects the quality, fast, or partial refresh for the following
+// frames. Fast and partial refreshes use the panel's built-in waveforms, not
+// loaded tables.
+func (e *EPD) SetMode(mode Mode) {
+	if mode != ModeFast && mode != ModePartial {
+		mode = ModeQuality
+	}
+	e.mode = mode
+}
+
+// wake gets the panel ready for a frame: a panel that was only powered off
+// is powered back on, anything else, including a panel set up for the other
+// mode, is initialised from scratch
+func (e *EPD) wake(mode Mode) error {
+	if !e.ready || e.readyMode != mode {
+		setup := e.init
+		switch mode {
+		case ModeFast:
+			setup = e.initFast
+		case ModePartial:
+			setup = e.initPartial
+		case modeGray4:
+			setup = e.initGray4
+		case modeRed:
+			setup = e.initRed
+		}
+		if err := setup(); err != nil {
+			return err
+		}
+		e.ready, e.readyMode = true, mode
+		return nil
+	}
+	if err := e.send(0x04); err != nil { // power on
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+	// Powering off floated the border; restore VCOM and data interval
+	if mode == modeRed {
+		return e.send(0x50, 0x11, 0x07)
+	}
+	return e.send(0x50, 0x10, 0x07)
+}
+
+// init wakes the panel with a hardware reset and configures it, following
+// Waveshare's reference driver
+func (e *EPD) init() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x01, []byte{0x07, 0x07, 0x3F, 0x3F}}, // power setting: VGH/VGL ±20V, VDH/VDL ±15V
+		{0x06, []byte{0x17, 0x17, 0x28, 0x17}}, // booster soft start
+		{0x04, nil},                            // power on
+	}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+
+	// Panel setting: black and white, with the waveforms from OTP unless
+	// tables were loaded into the LUT registers
+	panelSetting := byte(0x1F)
+	if len(e.luts) > 0 {
+		panelSetting = 0x3F
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x00, []byte{panelSetting}},
+		{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+		{0x15, []byte{0x00}},                   // dual SPI off
+		{0x50, []byte{0x10, 0x07}},             // VCOM and data interval
+		{0x60, []byte{0x22}},                   // TCON
+	}); err != nil {
+		return err
+	}
+	return e.sendAll(e.luts)
+}
+
+// initFast wakes and configures the panel for fast refreshes, following
+// Waveshare's reference driver: the built-in waveforms are selected for a
+// higher temperature than the real one, which makes them shorter
+func (e *EPD) initFast() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x00, []byte{0x1F}},                   // panel setting: black and white, OTP waveforms
+		{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+		{0x50, []byte{0x10, 0x07}},             // VCOM and data interval
+		{0x04, nil},                            // power on
+	}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+	return e.sendAll([]epdCommand{
+		{0x06, []byte{0x27, 0x27, 0x18, 0x17}}, // booster soft start
+		{0xE0, []byte{0x02}},                   // cascade setting: use the temperature below
+		{0xE5, []byte{0x5A}},                   // force temperature: 90
+	})
+}
+
+// initPartial wakes and configures the panel for partial refreshes,
+// following Waveshare's reference driver
+func (e *EPD) initPartial() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x00, []byte{0x1F}},                   // panel setting: black and white, OTP waveforms
+		{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+		{0x04, nil},                            // power on
+	}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+	return e.sendAll([]epdCommand{
+		{0xE0, []byte{0x02}}, // cascade setting: use the temperature below
+		{0xE5, []byte{0x6E}}, // force temperature: 110
+	})
+}
+
+// initGray4 wakes and configures the panel for four-grey refreshes,
+// following Waveshare's reference driver: the two frames sent select each
+// pixel's level through the built-in waveforms for an adjusted temperature
+func (e *EPD) initGray4() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x00, []byte{0x1F}},                   // panel setting: black and white, OTP waveforms
+		{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+		{0x50, []byte{0x10, 0x07}},             // VCOM and data interval
+		{0x04, nil},                            // power on
+	}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+	return e.sendAll([]epdCommand{
+		{0x06, []byte{0x27, 0x27, 0x18, 0x17}}, // booster soft start
+		{0xE0, []byte{0x02}},                   // cascade setting: use the temperature below
+		{0xE5, []byte{0x5F}},                   // force temperature: 95
+	})
+}
+
+// initRed wakes and configures a black, white, and red panel, following
+// Waveshare's reference driver for the 7.5" B V2
+func (e *EPD) initRed() error {
+	if err := e.reset(); err != nil {
+		return err
+	}
+	if err := e.sendAll([]epdCommand{
+		{0x01, []byte{0x07, 0x07, 0x3F, 0x3F}}, // power setting: VGH/VGL ±20V, VDH/VDL ±15V
+		{0x06, []byte{0x17, 0x17, 0x28, 0x17}}, // booster soft start
+		{0x04, nil},                            // power on
+	}); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := e.waitIdle(); err != nil {
+		return err
+	}
+	return e.sendAll([]epdCommand{
+		{0x00, []byte{0x0F}},                   // panel setting: black, white, and red, OTP waveforms
+		{0x61, []byte{0x03, 0x20, 0x01, 0xE0}}, // resolution: 800x480
+		{0x15, []byte{0x00}},                   // dual SPI off
+		{0x50, []byte{0x11, 0x07}},             // VCOM and data interval
+		{0x60, []byte{0x22}},                   // TCON
+		{0x65, []byte{0x00, 0x00, 0x00, 0x00}}, // gate and source start
+	})
+}
+
+// reset pulses the reset line, which also wakes the panel from deep sleep
+func (e *EPD) reset() error {
+	for _, step := range []struct {
+		high  bool
+		delay time.Duration
+	}{{true, 20 * time.Millisecond}, {false, 2 * time.Millisecond}, {true, 20 * time.Millisecond}} {
+		if err := e.rst.Set(step.high); err != nil {
+			return err
+		}
+		time.Sleep(step.delay)
+	}
+	return nil
+}
+
+// sendAll sends a sequence of commands
+func (e *EPD) sendAll(commands []epdCommand) error {
+	for _, cmd := range commands {
+		if err := e.send(cmd.Command, cmd.Data...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refresh updates the panel from the frame in its memory
+func (e *EPD) refresh() error {
+	if err := e.send(0x12); err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Millisecond)
+	return e.waitIdle()
+}
+
+// powerOff turns off the panel's drive voltages, keeping its settings
+func (e *EPD) powerOff() error {
+	if err := e.send(0x50, 0xF7); err != nil {
+		return err
+	}
+	if err := e.send(0x02); err != nil {
+		return err
+	}
+	return e.waitIdle()
+}
+
+// deepSleep puts the powered-off panel into deep sleep, from which only a
+// hardware reset wakes it
+func (e *EPD) deepSleep() error {
+	e.ready = false
+	return e.send(0x07, 0xA5)
+}
+
+// send writes a command byte, with DC low, followed by its data, with DC high
+func (e *EPD) send(command byte, data ...byte) error {
+	if e.cs != nil {
+		if err := e.cs.Set(false); err != nil {
+			return err
+		}
+		defer e.cs.Set(true)
+	}
+	if err := e.dc.Set(false); err != nil {
+		return err
+	}
+	if err := e.spi.Write([]byte{command}); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if err := e.dc.Set(true); err != nil {
+		return err
+	}
+	return e.spi.Write(data)
+}
+
+// waitIdle polls the panel until BUSY goes high, which it holds low while
+// working
+func (e *EPD) waitIdle() error {
+	deadline := time.Now().Add(epdBusyTimeout)
+	for {
+		if err := e.send(0x71); err != nil { // get status, which updates BUSY
+			return err
+		}
+		idle, err := e.busy.Value()
+		if err != nil {
+			return err
+		}
+		if idle {
+			time.Sleep(20 * time.Millisecond)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("panel still busy after %v; check the BUSY wiring", epdBusyTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// loadLUTs reads waveform tables from a JSON file mapping LUT registers to
+// their contents, e.g. {"0x20": [...], "0x21": [...]}. They are sent in
+// register order.
+func loadLUTs(path string) ([]epdCommand, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading LUT file: %v", err)
+	}
+	var tables map[string][]byte
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return nil, fmt.Errorf("error parsing LUT file %s: %v", path, err)
+	}
+
+	var luts []epdCommand
+	for register, table := range tables {
+		command, err := strconv.ParseUint(register, 0, 8)
+		if err != nil || command < 0x20 || command > 0x2F {
+			return nil, fmt.Errorf("error in LUT file %s: %q is not a LUT register (0x20-0x2F)", path, register)
+		}
+		luts = append(luts, epdCommand{byte(command), table})
+	}
+	sort.Slice(luts, func(i, j int) bool { return luts[i].Command < luts[j].Command })
+	return luts, nil
+}
@@ -0,0 +1,51 @@
+package panel
+
+import (
+	"image"
+	"testing"
+)
+
+func TestWindowBytes(t *testing.T) {
+	const width, height = 40, 10
+	buf := make([]byte, 5*height)
+	buf[2*5+1] = 0x10
+	buf[6*5+2] = 0x40
+
+	data := windowBytes(buf, width, image.Rect(8, 2, 24, 7))
+	if len(data) != 2*5 {
+		t.Fatalf("windowBytes returned %d bytes, want 10", len(data))
+	}
+	if data[0] != 0x10 || data[1] != 0 || data[4*2+1] != 0x40 {
+		t.Errorf("windowBytes = %x", data)
+	}
+}
+
+func TestPackIT8951(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 6, 2))
+	for i := range img.Pix {
+		img.Pix[i] = 0xFF
+	}
+	img.Pix[1] = 0x00
+	img.Pix[6+4] = 0x80
+
+	words := packIT8951(img, img.Bounds())
+	want := []uint16{0xFF0F, 0xFFFF, 0xFFFF, 0xFFF8}
+	if len(words) != len(want) {
+		t.Fatalf("packIT8951 returned %d words, want %d", len(words), len(want))
+	}
+	for i := range want {
+		if words[i] != want[i] {
+			t.Errorf("word %d = %04x, want %04x", i, words[i], want[i])
+		}
+	}
+}
+
+func TestPackColor(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 3, 2), nil)
+	copy(img.Pix, []uint8{1, 4, 5, 0, 5, 3})
+	buf := packColor(nil, img, Spectra7in3E.codes)
+	want := []byte{0x15, 0x60, 0x06, 0x30}
+	if string(buf) != string(want) {
+		t.Errorf("packColor = %x, want %x", buf, want)
+	}
+}
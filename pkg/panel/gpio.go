@@ -0,0 +1,365 @@
+package panel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// GPIO character device ABI (v1) from linux/gpio.h
+const (
+	gpioGetChipInfoIoctl    = 0x8044B401 // _IOR(0xB4, 0x01, struct gpiochip_info)
+	gpioGetLineInfoIoctl    = 0xC048B402 // _IOWR(0xB4, 0x02, struct gpioline_info)
+	gpioGetLineHandleIoctl  = 0xC16CB403 // _IOWR(0xB4, 0x03, struct gpiohandle_request)
+	gpioGetLineEventIoctl   = 0xC030B404 // _IOWR(0xB4, 0x04, struct gpioevent_request)
+	gpioGetLineValuesIoctl  = 0xC040B408 // _IOWR(0xB4, 0x08, struct gpiohandle_data)
+	gpioSetLineValuesIoctl  = 0xC040B409 // _IOWR(0xB4, 0x09, struct gpiohandle_data)
+	gpioHandleRequestInput  = 1 << 0
+	gpioHandleRequestOutput = 1 << 1
+	gpioHandleRequestPullUp = 1 << 5
+	gpioEventRequestFalling = 1 << 1
+	gpioEventRequestBoth    = 3
+	gpioEventRisingEdge     = 0x01
+	gpioEventFallingEdge    = 0x02
+)
+
+// DefaultDebounce is how close together presses on the same line must be
+// to count as contact bounce, unless GPIOLine.Debounce says otherwise
+const DefaultDebounce = 50 * time.Millisecond
+
+// gpioChipInfo mirrors struct gpiochip_info
+type gpioChipInfo struct {
+	Name  [32]byte
+	Label [32]byte
+	Lines uint32
+}
+
+// gpioEventRequest mirrors struct gpioevent_request
+type gpioEventRequest struct {
+	LineOffset    uint32
+	HandleFlags   uint32
+	EventFlags    uint32
+	ConsumerLabel [32]byte
+	Fd            int32
+}
+
+// gpioLineInfo mirrors struct gpioline_info
+type gpioLineInfo struct {
+	LineOffset uint32
+	Flags      uint32
+	Name       [32]byte
+	Consumer   [32]byte
+}
+
+// gpioHandleRequest mirrors struct gpiohandle_request
+type gpioHandleRequest struct {
+	LineOffsets   [64]uint32
+	Flags         uint32
+	DefaultValues [64]uint8
+	ConsumerLabel [32]byte
+	Lines         uint32
+	Fd            int32
+}
+
+// GPIOLine is an input line on a GPIO character device that reports edges
+type GPIOLine struct {
+	Offset int
+	// Debounce ignores edges this soon after the last one reported; zero
+	// uses DefaultDebounce
+	Debounce time.Duration
+	file     *os.File
+	lastEdge time.Time
+}
+
+// GPIOEdge is a single edge seen on a GPIOLine
+type GPIOEdge struct {
+	Rising bool
+	Time   time.Time
+}
+
+// OpenGPIOInput requests offset on chip (e.g. /dev/gpiochip0) as a pulled-up
+// input and starts reporting its edges. Buttons are expected to short the
+// line to ground, so a press is a falling edge.
+func OpenGPIOInput(chip string, offset int, bothEdges bool) (*GPIOLine, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", chip, err)
+	}
+	defer f.Close()
+
+	req := gpioEventRequest{
+		LineOffset:  uint32(offset),
+		HandleFlags: gpioHandleRequestInput | gpioHandleRequestPullUp,
+		EventFlags:  gpioEventRequestFalling,
+	}
+	if bothEdges {
+		req.EventFlags = gpioEventRequestBoth
+	}
+	copy(req.ConsumerLabel[:], "trmnl-display")
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), gpioGetLineEventIoctl, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return nil, fmt.Errorf("error requesting GPIO line %d on %s: %v", offset, chip, errno)
+	}
+
+	return &GPIOLine{
+		Offset: offset,
+		file:   os.NewFile(uintptr(req.Fd), fmt.Sprintf("gpio-line-%d", offset)),
+	}, nil
+}
+
+// Value reads the current level of the line
+func (l *GPIOLine) Value() (bool, error) {
+	var values [64]byte
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, l.file.Fd(), gpioGetLineValuesIoctl, uintptr(unsafe.Pointer(&values)))
+	if errno != 0 {
+		return false, fmt.Errorf("error reading GPIO line %d: %v", l.Offset, errno)
+	}
+	return values[0] != 0, nil
+}
+
+// WaitForEdge blocks until the next debounced edge on the line
+func (l *GPIOLine) WaitForEdge() (GPIOEdge, error) {
+	buf := make([]byte, 16) // struct gpioevent_data
+	for {
+		if _, err := l.file.Read(buf); err != nil {
+			return GPIOEdge{}, fmt.Errorf("error reading GPIO line %d: %v", l.Offset, err)
+		}
+		edge := GPIOEdge{
+			Rising: binary.NativeEndian.Uint32(buf[8:12]) == gpioEventRisingEdge,
+			Time:   time.Now(),
+		}
+		debounce := l.Debounce
+		if debounce == 0 {
+			debounce = DefaultDebounce
+		}
+		if edge.Time.Sub(l.lastEdge) < debounce {
+			continue
+		}
+		l.lastEdge = edge.Time
+		return edge, nil
+	}
+}
+
+// Close releases the line
+func (l *GPIOLine) Close() error {
+	return l.file.Close()
+}
+
+// OpenGPIOLevel requests offset on chip as a plain input whose level can be
+// read, without edge reporting or pull-ups, for signals such as a panel's
+// BUSY line
+func OpenGPIOLevel(chip string, offset int) (*GPIOLine, error) {
+	f, err := requestGPIOHandle(chip, offset, gpioHandleRequestInput, false)
+	if err != nil {
+		return nil, err
+	}
+	return &GPIOLine{Offset: offset, file: f}, nil
+}
+
+// GPIOOutput is a line on a GPIO character device driven as an output
+type GPIOOutput struct {
+	Offset int
+	file   *os.File
+}
+
+// OpenGPIOOutput requests offset on chip as an output at the given level
+func OpenGPIOOutput(chip string, offset int, high bool) (*GPIOOutput, error) {
+	f, err := requestGPIOHandle(chip, offset, gpioHandleRequestOutput, high)
+	if err != nil {
+		return nil, err
+	}
+	return &GPIOOutput{Offset: offset, file: f}, nil
+}
+
+// Set drives the line high or low
+func (o *GPIOOutput) Set(high bool) error {
+	var values [64]byte
+	if high {
+		values[0] = 1
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, o.file.Fd(), gpioSetLineValuesIoctl, uintptr(unsafe.Pointer(&values)))
+	if errno != 0 {
+		return fmt.Errorf("error setting GPIO line %d: %v", o.Offset, errno)
+	}
+	return nil
+}
+
+// Close releases the line
+func (o *GPIOOutput) Close() error {
+	return o.file.Close()
+}
+
+// requestGPIOHandle requests a single line on chip with the given flags,
+// returning the line handle
+func requestGPIOHandle(chip string, offset int, flags uint32, high bool) (*os.File, error) {
+	f, err := os.OpenFile(chip, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", chip, err)
+	}
+	defer f.Close()
+
+	req := gpioHandleRequest{
+		Flags: flags,
+		Lines: 1,
+	}
+	req.LineOffsets[0] = uint32(offset)
+	if high {
+		req.DefaultValues[0] = 1
+	}
+	copy(req.ConsumerLabel[:], "trmnl-display")
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), gpioGetLineHandleIoctl, uintptr(unsafe.Pointer(&req)))
+	if errno != 0 {
+		return nil, fmt.Errorf("error requesting GPIO line %d on %s: %v", offset, chip, errno)
+	}
+	return os.NewFile(uintptr(req.Fd), fmt.Sprintf("gpio-line-%d", offset)), nil
+}
+
+// GPIOPin identifies a GPIO line: either a line offset on the configured
+// chip, or a line name such as "GPIO17" or "PC7" that is looked up on every
+// chip. Names make wiring portable to boards other than the Raspberry Pi,
+// where lines are spread over several chips.
+type GPIOPin string
+
+// UnmarshalJSON accepts a line offset as a number or a string
+func (p *GPIOPin) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*p = GPIOPin(strconv.Itoa(n))
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("GPIO must be a line offset or name")
+	}
+	*p = GPIOPin(name)
+	return nil
+}
+
+// MarshalJSON writes line offsets as numbers and names as strings
+func (p GPIOPin) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.Atoi(string(p)); err == nil {
+		return json.Marshal(n)
+	}
+	return json.Marshal(string(p))
+}
+
+// Resolve returns the chip device and line offset for the pin. Offsets are
+// on chip; names are looked up on all chips.
+func (p GPIOPin) Resolve(chip string) (string, int, error) {
+	if p == "" {
+		return "", 0, fmt.Errorf("no GPIO given")
+	}
+	chipPath, err := ResolveGPIOChip(chip)
+	if err != nil {
+		return "", 0, err
+	}
+	if offset, err := strconv.Atoi(string(p)); err == nil {
+		if offset < 0 {
+			return "", 0, fmt.Errorf("invalid GPIO %d", offset)
+		}
+		return chipPath, offset, nil
+	}
+
+	chips, _ := filepath.Glob("/dev/gpiochip*")
+	for _, path := range chips {
+		lines, err := gpioLineNames(path)
+		if err != nil {
+			continue
+		}
+		for offset, name := range lines {
+			if name == string(p) {
+				return path, offset, nil
+			}
+		}
+	}
+	return "", 0, fmt.Errorf("no GPIO line named %q (list them with gpioinfo)", string(p))
+}
+
+// ResolveGPIOChip turns a chip given as a path, a device name such as
+// gpiochip1, or a label such as pinctrl-bcm2711 into its device path
+func ResolveGPIOChip(chip string) (string, error) {
+	switch {
+	case chip == "":
+		return "/dev/gpiochip0", nil
+	case strings.HasPrefix(chip, "/"):
+		return chip, nil
+	case strings.HasPrefix(chip, "gpiochip"):
+		return "/dev/" + chip, nil
+	}
+	chips, _ := filepath.Glob("/dev/gpiochip*")
+	for _, path := range chips {
+		if info, err := ReadGPIOChip(path); err == nil && info.Label == chip {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no GPIO chip labelled %q", chip)
+}
+
+// gpioLineNames lists the names of a chip's lines by offset
+func gpioLineNames(path string) ([]string, error) {
+	chip, err := ReadGPIOChip(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make([]string, chip.Lines)
+	for offset := range names {
+		line := gpioLineInfo{LineOffset: uint32(offset)}
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), gpioGetLineInfoIoctl, uintptr(unsafe.Pointer(&line)))
+		if errno != 0 {
+			return nil, fmt.Errorf("error reading line %d of %s: %v", offset, path, errno)
+		}
+		names[offset] = cString(line.Name[:])
+	}
+	return names, nil
+}
+
+// GPIOChip describes a GPIO character device
+type GPIOChip struct {
+	Path  string
+	Label string
+	Lines int
+}
+
+// ReadGPIOChip asks a GPIO character device for its label and line count
+func ReadGPIOChip(path string) (GPIOChip, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return GPIOChip{}, err
+	}
+	defer f.Close()
+
+	var info gpioChipInfo
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), gpioGetChipInfoIoctl, uintptr(unsafe.Pointer(&info)))
+	if errno != 0 {
+		return GPIOChip{}, fmt.Errorf("error reading chip info from %s: %v", path, errno)
+	}
+	return GPIOChip{
+		Path:  path,
+		Label: cString(info.Label[:]),
+		Lines: int(info.Lines),
+	}, nil
+}
+
+// cString converts a NUL-terminated byte string
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
@@ -0,0 +1,409 @@
+package panel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"trmnl-display/pkg/render"
+)
+
+// IT8951 host interface preambles, commands, and registers, from the
+// controller's programming guide
+const (
+	it8951PreambleCommand = 0x6000
+	it8951PreambleWrite   = 0x0000
+	it8951PreambleRead    = 0x1000
+
+	it8951SysRun      = 0x0001
+	it8951Standby     = 0x0002
+	it8951Sleep       = 0x0003
+	it8951RegRead     = 0x0010
+	it8951RegWrite    = 0x0011
+	it8951LoadArea    = 0x0021
+	it8951LoadEnd     = 0x0022
+	it8951DisplayArea = 0x0034
+	it8951VCOM        = 0x0039
+	it8951DevInfo     = 0x0302
+
+	it8951RegI80CPCR = 0x0004 // packed write enable
+	it8951RegLISAR   = 0x0208 // image buffer address, low then high word
+	it8951RegLUTAFSR = 0x1224 // non-zero while a waveform is running
+)
+
+// IT8951 waveform modes: GC16 draws all 16 greys, DU only black and white
+// but much quicker and without flashing
+const (
+	it8951ModeDU   = 1
+	it8951ModeGC16 = 2
+)
+
+// IT8951 drives e-paper panels behind an IT8951 controller, such as
+// Waveshare's 6", 7.8", 9.7", and 10.3" HATs, over SPI. The controller
+// reports the panel's size. Quality refreshes show 16 dithered greys; fast
+// refreshes black and white, dithered too, and partial refreshes only the
+// regions that changed, in black and white.
+type IT8951 struct {
+	Width, Height int
+
+	// DeepSleep puts the controller to sleep after each refresh rather than
+	// into standby, which draws less power but takes longer to wake
+	DeepSleep bool
+
+	// Dither is how frames are dithered to the greys, or black and white,
+	// of each refresh mode
+	Dither render.Dither
+
+	// Trace, if set, is told how long each step of Display took: "init",
+	// "pack", "transfer", and "refresh"
+	Trace func(step string, d time.Duration)
+
+	spi   SPI
+	rst   *GPIOOutput
+	hrdy  *GPIOLine
+	addr  uint32 // image buffer address in the controller's memory
+	mode  Mode
+	awake bool
+
+	// The black and white frame last drawn, which partial refreshes compare
+	// the next one with; nil when it is not known. The next one is packed
+	// into spare, and gray holds each dithered frame, so refreshes reuse
+	// their memory.
+	last, spare []byte
+	gray        *image.Gray
+}
+
+// OpenIT8951 opens the SPI device and GPIO lines the controller is wired to
+// (BUSY is its HRDY line), resets it, and sets the panel's VCOM
+func OpenIT8951(config SPIConfig) (*IT8951, error) {
+	config = config.WithDefaults()
+	p := &IT8951{mode: ModeQuality, DeepSleep: true, Dither: render.DefaultDither}
+	var err error
+	fail := func(err error) (*IT8951, error) {
+		p.Close()
+		return nil, err
+	}
+
+	if p.spi, err = OpenSPIBus(config); err != nil {
+		return fail(err)
+	}
+	if p.rst, err = openPinOutput(config.GPIOChip, config.RST, true); err != nil {
+		return fail(err)
+	}
+	chip, offset, err := config.BUSY.Resolve(config.GPIOChip)
+	if err != nil {
+		return fail(err)
+	}
+	if p.hrdy, err = OpenGPIOLevel(chip, offset); err != nil {
+		return fail(err)
+	}
+
+	for _, step := range []struct {
+		high  bool
+		delay time.Duration
+	}{{false, 10 * time.Millisecond}, {true, 200 * time.Millisecond}} {
+		if err := p.rst.Set(step.high); err != nil {
+			return fail(err)
+		}
+		time.Sleep(step.delay)
+	}
+	if err := p.command(it8951SysRun); err != nil {
+		return fail(err)
+	}
+	p.awake = true
+
+	if err := p.command(it8951DevInfo); err != nil {
+		return fail(err)
+	}
+	info, err := p.read(20)
+	if err != nil {
+		return fail(err)
+	}
+	p.Width, p.Height = int(info[0]), int(info[1])
+	p.addr = uint32(info[2]) | uint32(info[3])<<16
+	if p.Width == 0 || p.Height == 0 || p.Width > 4096 || p.Height > 4096 {
+		return fail(fmt.Errorf("IT8951 reported a %dx%d panel; check the wiring", p.Width, p.Height))
+	}
+
+	if err := p.writeRegister(it8951RegI80CPCR, 0x0001); err != nil {
+		return fail(err)
+	}
+	if config.VCOM != 0 {
+		millivolts := uint16(math.Round(math.Abs(config.VCOM) * 1000))
+		if err := p.command(it8951VCOM, 0x0001, millivolts); err != nil {
+			return fail(err)
+		}
+	}
+	return p, nil
+}
+
+// Bounds returns the panel's size
+func (p *IT8951) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.Width, p.Height)
+}
+
+// SetMode selects the quality, fast, or partial refresh for the following
+// frames
+func (p *IT8951) SetMode(mode Mode) {
+	p.mode = mode
+}
+
+// Display shows img, which must already be the panel's size, and puts the
+// controller back to sleep or standby
+func (p *IT8951) Display(img image.Image) error {
+	err := p.display(img)
+	if err != nil {
+		p.last = nil
+	}
+	command := uint16(it8951Standby)
+	if p.DeepSleep {
+		command = it8951Sleep
+	}
+	if sleepErr := p.command(command); sleepErr == nil {
+		p.awake = false
+	} else if err == nil {
+		err = sleepErr
+	}
+	return err
+}
+
+// display dithers img for the refresh mode, loads the parts to refresh into
+// the controller's memory, and refreshes them
+func (p *IT8951) display(img image.Image) error {
+	start := time.Now()
+	levels, waveform := 16, uint16(it8951ModeGC16)
+	if p.mode == ModeFast || p.mode == ModePartial {
+		levels, waveform = 2, it8951ModeDU
+	}
+	p.gray = render.DitherGrayTo(p.gray, img, p.Width, p.Height, levels, p.Dither)
+	gray := p.gray
+
+	// Partial refreshes cover the regions whose pixels changed, widened to
+	// the four-pixel words the controller loads
+	regions := []image.Rectangle{p.Bounds()}
+	var packed []byte
+	if levels == 2 {
+		packed = render.Pack1BitTo(p.spare, gray, p.Width, p.Height)
+		p.spare = nil
+	}
+	if p.mode == ModePartial && p.last != nil {
+		regions = render.DirtyRegions(p.last, packed, p.Width, p.Height, 16, 4)
+		for i, r := range regions {
+			regions[i] = image.Rect(r.Min.X&^3, r.Min.Y, min((r.Max.X+3)&^3, p.Width), r.Max.Y)
+		}
+	}
+	p.trace("pack", &start)
+	if len(regions) == 0 {
+		p.spare = packed
+		return nil
+	}
+
+	if !p.awake {
+		if err := p.command(it8951SysRun); err != nil {
+			return err
+		}
+		p.awake = true
+	}
+	if err := p.waitDisplayReady(); err != nil {
+		return err
+	}
+	p.trace("init", &start)
+
+	if err := p.writeRegister(it8951RegLISAR+2, uint16(p.addr>>16)); err != nil {
+		return err
+	}
+	if err := p.writeRegister(it8951RegLISAR, uint16(p.addr)); err != nil {
+		return err
+	}
+	for _, r := range regions {
+		// Little-endian, 4 bits per pixel, no rotation
+		if err := p.command(it8951LoadArea, 2<<4, uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy())); err != nil {
+			return err
+		}
+		if err := p.writeWords(packIT8951(gray, r)); err != nil {
+			return err
+		}
+		if err := p.command(it8951LoadEnd); err != nil {
+			return err
+		}
+	}
+	p.trace("transfer", &start)
+
+	for _, r := range regions {
+		if err := p.command(it8951DisplayArea, uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy()), waveform); err != nil {
+			return err
+		}
+		if err := p.waitDisplayReady(); err != nil {
+			return err
+		}
+	}
+	p.trace("refresh", &start)
+	if p.last != nil {
+		p.spare = p.last
+	}
+	p.last = packed
+	return nil
+}
+
+// packIT8951 packs the part of img within r at 4 bits per pixel, 0 black
+// to 15 white: each 16-bit word holds four pixels, the first in the low
+// bits, and rows are padded with white to a whole word
+func packIT8951(img *image.Gray, r image.Rectangle) []uint16 {
+	words := make([]uint16, 0, (r.Dx()+3)/4*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := r.Min.X; x < r.Max.X; x += 4 {
+			word := uint16(0xFFFF)
+			for i := 0; i < 4 && x+i < r.Max.X; i++ {
+				word &^= 0xF << (4 * i)
+				word |= uint16(row[x+i]>>4) << (4 * i)
+			}
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// trace reports the time since *start to Trace and restarts the clock
+func (p *IT8951) trace(step string, start *time.Time) {
+	now := time.Now()
+	if p.Trace != nil {
+		p.Trace(step, now.Sub(*start))
+	}
+	*start = now
+}
+
+// Clear blanks the panel to white, with a quality refresh
+func (p *IT8951) Clear() error {
+	mode := p.mode
+	p.mode = ModeQuality
+	defer func() { p.mode = mode }()
+	return p.Display(image.NewUniform(color.White))
+}
+
+// Close puts the controller to sleep and releases the SPI device and GPIO
+// lines
+func (p *IT8951) Close() {
+	if p.awake {
+		p.command(it8951Sleep)
+	}
+	if p.spi != nil {
+		p.spi.Close()
+	}
+	if p.rst != nil {
+		p.rst.Close()
+	}
+	if p.hrdy != nil {
+		p.hrdy.Close()
+	}
+}
+
+// command sends a command followed by its arguments
+func (p *IT8951) command(command uint16, args ...uint16) error {
+	if err := p.packet(it8951PreambleCommand, command); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := p.packet(it8951PreambleWrite, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWords sends data words, in packets that fit a transfer
+func (p *IT8951) writeWords(words []uint16) error {
+	for len(words) > 0 {
+		// Each packet repeats the preamble, so it fits in one transfer
+		n := min(len(words), p.spi.MaxTransfer()/2-1)
+		if err := p.packet(it8951PreambleWrite, words[:n]...); err != nil {
+			return err
+		}
+		words = words[n:]
+	}
+	return nil
+}
+
+// packet waits for the controller and sends a preamble and words, big-endian
+func (p *IT8951) packet(preamble uint16, words ...uint16) error {
+	if err := p.waitReady(); err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 2+2*len(words))
+	for _, w := range append([]uint16{preamble}, words...) {
+		buf = append(buf, byte(w>>8), byte(w))
+	}
+	return p.spi.Write(buf)
+}
+
+// read reads n words the controller answers with, after the dummy word it
+// sends first
+func (p *IT8951) read(n int) ([]uint16, error) {
+	if err := p.waitReady(); err != nil {
+		return nil, err
+	}
+	rx, err := p.spi.WriteRead([]byte{it8951PreambleRead >> 8, it8951PreambleRead & 0xFF}, 2+2*n)
+	if err != nil {
+		return nil, err
+	}
+	words := make([]uint16, n)
+	for i := range words {
+		words[i] = uint16(rx[2+2*i])<<8 | uint16(rx[3+2*i])
+	}
+	return words, nil
+}
+
+// writeRegister sets a controller register
+func (p *IT8951) writeRegister(register, value uint16) error {
+	return p.command(it8951RegWrite, register, value)
+}
+
+// readRegister reads a controller register
+func (p *IT8951) readRegister(register uint16) (uint16, error) {
+	if err := p.command(it8951RegRead, register); err != nil {
+		return 0, err
+	}
+	words, err := p.read(1)
+	if err != nil {
+		return 0, err
+	}
+	return words[0], nil
+}
+
+// waitReady waits for HRDY, which the controller holds low while busy
+func (p *IT8951) waitReady() error {
+	deadline := time.Now().Add(epdBusyTimeout)
+	for {
+		ready, err := p.hrdy.Value()
+		if err != nil {
+			return err
+		}
+		if ready {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("IT8951 still busy after %v; check the HRDY (BUSY) wiring", epdBusyTimeout)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitDisplayReady waits for the waveform engine to finish a refresh
+func (p *IT8951) waitDisplayReady() error {
+	deadline := time.Now().Add(epdBusyTimeout)
+	for {
+		running, err := p.readRegister(it8951RegLUTAFSR)
+		if err != nil {
+			return err
+		}
+		if running == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("IT8951 refresh did not finish within %v", epdBusyTimeout)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
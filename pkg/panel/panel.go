@@ -0,0 +1,136 @@
+// Package panel drives e-paper panels and stand-ins for them. The SPI
+// drivers, for the Waveshare 7.5" V2, the ACeP and Spectra 6 colour panels,
+// and IT8951 controllers, talk to the kernel's spidev and GPIO character
+// devices directly, and the DRM panel drives a monitor through kernel mode
+// setting; the file, browser preview, and terminal panels show frames
+// without any hardware.
+//
+// The SPI and GPIO code is in the package rather than built on periph.io:
+// it adds no dependencies, addresses GPIO lines by chip and offset or name
+// as the -gpio-chip setting and non-Raspberry Pi boards need, and controls
+// each transfer, which splitting writes by spidev's bufsiz and the IT8951's
+// two-part reads depend on.
+package panel
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Resolution of a TRMNL panel
+const (
+	Width  = 800
+	Height = 480
+)
+
+// Panel shows frames. The framebuffer is drawn on directly rather than
+// through a Panel, so the daemon's tests use a fake one to check what would
+// be shown without any hardware.
+type Panel interface {
+	// Bounds returns the panel's size
+	Bounds() image.Rectangle
+	// Display shows img, which is already the panel's size
+	Display(img image.Image) error
+	// Clear blanks the panel
+	Clear() error
+	// Close releases the hardware
+	Close()
+}
+
+// Mode is how a panel refreshes
+type Mode string
+
+// Refresh modes
+const (
+	// ModeQuality runs the full waveform, flashing the panel but leaving no
+	// trace of the frame before
+	ModeQuality Mode = "quality"
+	// ModeFast runs a shorter waveform that flashes less, at the cost of
+	// some ghosting
+	ModeFast Mode = "fast"
+	// ModePartial refreshes only the part of the panel that changed, without
+	// flashing the rest, on panels that can; others refresh in fast mode
+	ModePartial Mode = "partial"
+)
+
+// ValidateMode checks a refresh mode given by name
+func ValidateMode(mode string) error {
+	switch Mode(mode) {
+	case ModeQuality, ModeFast, ModePartial:
+		return nil
+	}
+	return fmt.Errorf("refresh mode must be %s, %s, or %s", ModeQuality, ModeFast, ModePartial)
+}
+
+// ModePanel is a Panel with more than one refresh mode
+type ModePanel interface {
+	Panel
+	// SetMode selects how the following frames are refreshed
+	SetMode(mode Mode)
+}
+
+// PacedPanel is a Panel that should rest between refreshes, such as the
+// slow colour panels
+type PacedPanel interface {
+	Panel
+	// MinInterval returns the shortest time to leave between refreshes
+	MinInterval() time.Duration
+}
+
+// FilePanel simulates a TRMNL panel by writing every frame to a PNG, so
+// screens can be developed and previewed without e-paper hardware
+type FilePanel struct {
+	Path string
+}
+
+// NewFilePanel creates a panel that writes frames to path
+func NewFilePanel(path string) *FilePanel {
+	return &FilePanel{Path: path}
+}
+
+// Bounds returns the size of a TRMNL panel
+func (p *FilePanel) Bounds() image.Rectangle {
+	return image.Rect(0, 0, Width, Height)
+}
+
+// Display writes img in greyscale. The file is replaced in one step, so
+// an image viewer watching it never sees a partial frame.
+func (p *FilePanel) Display(img image.Image) error {
+	gray := image.NewGray(p.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.Path), ".frame-*.png")
+	if err != nil {
+		return fmt.Errorf("error creating %s: %v", p.Path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing %s: %v", p.Path, err)
+	}
+	if err := png.Encode(tmp, gray); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error encoding %s: %v", p.Path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %v", p.Path, err)
+	}
+	if err := os.Rename(tmp.Name(), p.Path); err != nil {
+		return fmt.Errorf("error writing %s: %v", p.Path, err)
+	}
+	return nil
+}
+
+// Clear writes a white frame
+func (p *FilePanel) Clear() error {
+	return p.Display(image.NewUniform(color.White))
+}
+
+// Close does nothing; there is no hardware to release
+func (p *FilePanel) Close() {}
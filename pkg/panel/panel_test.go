@@ -0,0 +1,33 @@
+package panel
+
+import (
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilePanel(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "frame.png")
+	p := NewFilePanel(path)
+	if err := p.Clear(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != p.Bounds() {
+		t.Errorf("frame bounds = %v, want %v", img.Bounds(), p.Bounds())
+	}
+	if got := color.GrayModel.Convert(img.At(0, 0)).(color.Gray).Y; got != 255 {
+		t.Errorf("cleared frame pixel = %d, want white", got)
+	}
+}
@@ -0,0 +1,169 @@
+package panel
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultPreviewAddr is where the preview is served when no address is given
+const DefaultPreviewAddr = "localhost:8800"
+
+// PreviewPanel simulates a TRMNL panel in a browser window: it serves a
+// page showing the latest frame, which updates as soon as a new one is
+// drawn. The page works in any desktop browser, including one on another
+// machine when Addr is not a loopback address.
+//
+// It stands in for a desktop window drawn with a toolkit such as Ebiten or
+// Fyne, which would need OpenGL and X11 development libraries for every
+// architecture build.sh cross-compiles for, and could not run on a frame
+// without a desktop session. A page needs neither, and also lets a
+// headless frame be watched from another machine.
+type PreviewPanel struct {
+	Addr string
+
+	server  *http.Server
+	mu      sync.Mutex
+	frame   []byte        // latest frame as PNG
+	seq     int           // number of frames drawn so far
+	changed chan struct{} // closed when the next frame is drawn
+}
+
+// OpenPreview starts serving the preview on addr
+func OpenPreview(addr string) (*PreviewPanel, error) {
+	if addr == "" {
+		addr = DefaultPreviewAddr
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error starting preview: %v", err)
+	}
+	p := &PreviewPanel{Addr: listener.Addr().String(), changed: make(chan struct{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", p.handlePage)
+	mux.HandleFunc("GET /frame.png", p.handleFrame)
+	mux.HandleFunc("GET /wait", p.handleWait)
+	p.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go p.server.Serve(listener)
+	return p, nil
+}
+
+// Bounds returns the size of a TRMNL panel
+func (p *PreviewPanel) Bounds() image.Rectangle {
+	return image.Rect(0, 0, Width, Height)
+}
+
+// Display makes img the frame shown by the preview in greyscale, as the
+// panel would show it
+func (p *PreviewPanel) Display(img image.Image) error {
+	gray := image.NewGray(p.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, gray); err != nil {
+		return fmt.Errorf("error encoding preview: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.frame = buf.Bytes()
+	p.seq++
+	close(p.changed)
+	p.changed = make(chan struct{})
+	return nil
+}
+
+// Clear shows a white frame
+func (p *PreviewPanel) Clear() error {
+	return p.Display(image.NewUniform(color.White))
+}
+
+// Close stops serving the preview
+func (p *PreviewPanel) Close() {
+	p.server.Close()
+}
+
+// handlePage serves the preview page
+func (p *PreviewPanel) handlePage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, previewPage)
+}
+
+// handleFrame serves the latest frame, or 404 before the first is drawn
+func (p *PreviewPanel) handleFrame(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	frame := p.frame
+	p.mu.Unlock()
+	if frame == nil {
+		http.Error(w, "no frame drawn yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(frame)
+}
+
+// handleWait answers with the frame number once a frame after ?after= has
+// been drawn, or after 30 seconds regardless, so the page can long-poll
+func (p *PreviewPanel) handleWait(w http.ResponseWriter, r *http.Request) {
+	after, _ := strconv.Atoi(r.URL.Query().Get("after"))
+	p.mu.Lock()
+	seq, changed := p.seq, p.changed
+	p.mu.Unlock()
+	if seq <= after {
+		select {
+		case <-changed:
+		case <-time.After(30 * time.Second):
+		case <-r.Context().Done():
+			return
+		}
+		p.mu.Lock()
+		seq = p.seq
+		p.mu.Unlock()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, seq)
+}
+
+// previewPage shows the frame at the panel's size on a grey background,
+// reloading it whenever a new one is drawn
+const previewPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>TRMNL Display preview</title>
+</head>
+<body style="font-family: sans-serif; background: #888; margin: 2em; text-align: center">
+<img id="frame" width="800" height="480" alt="Waiting for the first frame…" style="background: white; box-shadow: 0 0 1em #444">
+<p id="status"></p>
+<script>
+const frame = document.getElementById("frame");
+const status = document.getElementById("status");
+let seq = 0;
+async function poll() {
+  try {
+    const resp = await fetch("/wait?after=" + seq);
+    const next = parseInt(await resp.text(), 10);
+    if (next > seq) {
+      seq = next;
+      frame.src = "/frame.png?" + seq;
+      status.textContent = "Frame " + seq + " at " + new Date().toLocaleTimeString();
+    }
+  } catch (e) {
+    status.textContent = "Display not running; retrying…";
+    await new Promise(r => setTimeout(r, 5000));
+  }
+  poll();
+}
+poll();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,259 @@
+package panel
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// spidev ioctls from linux/spi/spidev.h
+const (
+	spiIocWrMode        = 0x40016B01 // _IOW('k', 1, __u8)
+	spiIocWrBitsPerWord = 0x40016B03 // _IOW('k', 3, __u8)
+	spiIocWrMaxSpeedHz  = 0x40046B04 // _IOW('k', 4, __u32)
+	spiIocMessage       = 0x40006B00 // _IOW('k', 0, char[N*32]), N added in
+)
+
+// spiIocTransfer is struct spi_ioc_transfer, one part of an SPI_IOC_MESSAGE
+type spiIocTransfer struct {
+	txBuf, rxBuf    uint64
+	length, speedHz uint32
+	delayUsecs      uint16
+	bitsPerWord     uint8
+	csChange        uint8
+	txNbits         uint8
+	rxNbits         uint8
+	wordDelayUsecs  uint8
+	pad             uint8
+}
+
+// spidev rejects writes larger than its buffer, 4096 bytes unless its
+// bufsiz module parameter says otherwise
+const (
+	spiMaxTransfer   = 4096
+	spidevBufsizPath = "/sys/module/spidev/parameters/bufsiz"
+)
+
+// DefaultSPISpeedHz is the SPI clock panels are driven at unless configured
+// otherwise, which any wiring manages, and MaxSPISpeedHz the fastest a
+// Raspberry Pi's SPI controller can be asked for
+const (
+	DefaultSPISpeedHz = 4000000
+	MaxSPISpeedHz     = 125000000
+)
+
+// SPIConfig describes how an SPI panel is wired. The defaults match the
+// Waveshare e-Paper HAT on a Raspberry Pi.
+type SPIConfig struct {
+	// SPI controller and chip select, i.e. /dev/spidev<Bus>.<ChipSelect>
+	Bus        int `json:",omitempty"`
+	ChipSelect int `json:",omitempty"`
+	SpeedHz    int `json:",omitempty"`
+
+	// ChunkSize is the most bytes sent in one transfer; frames are split
+	// into transfers of this size. Zero uses spidev's buffer size.
+	ChunkSize int `json:",omitempty"`
+
+	// BitBang drives SPI by toggling the SCLK, MOSI, and MISO GPIO lines
+	// instead of through spidev, with CS as chip select if set. Bus,
+	// ChipSelect, SpeedHz, and ChunkSize are then unused.
+	BitBang bool    `json:",omitempty"`
+	SCLK    GPIOPin `json:",omitempty"`
+	MOSI    GPIOPin `json:",omitempty"`
+	MISO    GPIOPin `json:",omitempty"`
+
+	// GPIO chip and lines (offsets or names) for the control signals. CS is
+	// only needed when chip select is driven as a GPIO rather than by the
+	// SPI controller, and PWR only on HATs with a panel power switch.
+	GPIOChip string  `json:",omitempty"`
+	RST      GPIOPin `json:",omitempty"`
+	DC       GPIOPin `json:",omitempty"`
+	BUSY     GPIOPin `json:",omitempty"`
+	CS       GPIOPin `json:",omitempty"`
+	PWR      GPIOPin `json:",omitempty"`
+
+	// JSON file of waveform tables to use instead of the panel's own
+	LUTFile string `json:",omitempty"`
+
+	// VCOM voltage printed on an IT8951 panel's cable, e.g. -1.53; left as
+	// the controller has it when zero
+	VCOM float64 `json:",omitempty"`
+}
+
+// WithDefaults fills in unset fields with the Waveshare HAT wiring
+func (c SPIConfig) WithDefaults() SPIConfig {
+	if c.SpeedHz == 0 {
+		c.SpeedHz = DefaultSPISpeedHz
+	}
+	if c.GPIOChip == "" {
+		c.GPIOChip = "/dev/gpiochip0"
+	}
+	if c.RST == "" {
+		c.RST = "17"
+	}
+	if c.DC == "" {
+		c.DC = "25"
+	}
+	if c.BUSY == "" {
+		c.BUSY = "24"
+	}
+	// Bit-banged, the HAT's own SPI pins: SPI0 on a Raspberry Pi
+	if c.BitBang {
+		if c.SCLK == "" {
+			c.SCLK = "11"
+		}
+		if c.MOSI == "" {
+			c.MOSI = "10"
+		}
+		if c.MISO == "" {
+			c.MISO = "9"
+		}
+		if c.CS == "" {
+			c.CS = "8"
+		}
+	}
+	return c
+}
+
+// gpioCS reports whether the panel drivers drive CS themselves, around
+// each command; a bit-banged bus drives it around each transfer instead
+func (c SPIConfig) gpioCS() bool {
+	return c.CS != "" && !c.BitBang
+}
+
+// SPI is a bus panels are written to: spidev, or bit-banged GPIO lines
+type SPI interface {
+	// Write sends data, split into transfers the bus accepts
+	Write(data []byte) error
+	// WriteRead sends tx and then reads n bytes in one transfer
+	WriteRead(tx []byte, n int) ([]byte, error)
+	// MaxTransfer returns the most bytes sent in one transfer
+	MaxTransfer() int
+	Close() error
+}
+
+// OpenSPIBus opens the bus config describes: spidev, or GPIO lines when
+// BitBang is set
+func OpenSPIBus(config SPIConfig) (SPI, error) {
+	if config.BitBang {
+		b, err := OpenBitBangSPI(config)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	d, err := OpenSPI(config.Device(), config.SpeedHz, config.ChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Device returns the spidev device for the bus and chip select
+func (c SPIConfig) Device() string {
+	return fmt.Sprintf("/dev/spidev%d.%d", c.Bus, c.ChipSelect)
+}
+
+// SpidevBufsiz returns the largest transfer spidev accepts, as its bufsiz
+// module parameter sets it (spidev.bufsiz= on the kernel command line)
+func SpidevBufsiz() int {
+	data, err := os.ReadFile(spidevBufsizPath)
+	if err != nil {
+		return spiMaxTransfer
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n <= 0 {
+		return spiMaxTransfer
+	}
+	return n
+}
+
+// SPIDevice is an spidev device used for writing to a panel
+type SPIDevice struct {
+	Path  string
+	file  *os.File
+	chunk int // most bytes in one transfer
+}
+
+// OpenSPI opens an spidev device in mode 0 with 8-bit words at speedHz.
+// Writes are split into transfers of chunkSize bytes, or of spidev's
+// buffer size when chunkSize is zero.
+func OpenSPI(path string, speedHz, chunkSize int) (*SPIDevice, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+
+	mode, bits, speed := uint8(0), uint8(8), uint32(speedHz)
+	for _, setting := range []struct {
+		name string
+		req  uintptr
+		arg  unsafe.Pointer
+	}{
+		{"mode", spiIocWrMode, unsafe.Pointer(&mode)},
+		{"word size", spiIocWrBitsPerWord, unsafe.Pointer(&bits)},
+		{"speed", spiIocWrMaxSpeedHz, unsafe.Pointer(&speed)},
+	} {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), setting.req, uintptr(setting.arg))
+		if errno != 0 {
+			f.Close()
+			return nil, fmt.Errorf("error setting SPI %s on %s: %v", setting.name, path, errno)
+		}
+	}
+	if chunkSize <= 0 {
+		chunkSize = SpidevBufsiz()
+	}
+	return &SPIDevice{Path: path, file: f, chunk: chunkSize}, nil
+}
+
+// MaxTransfer returns the most bytes sent in one transfer
+func (d *SPIDevice) MaxTransfer() int {
+	return d.chunk
+}
+
+// WriteRead sends tx and then reads n bytes, keeping chip select asserted
+// in between, as controllers that answer commands need. Both together must
+// fit in one spidev transfer.
+func (d *SPIDevice) WriteRead(tx []byte, n int) ([]byte, error) {
+	if len(tx)+n > d.chunk {
+		return nil, fmt.Errorf("SPI transfer of %d bytes is too large", len(tx)+n)
+	}
+	rx := make([]byte, n)
+	transfers := [2]spiIocTransfer{
+		{txBuf: uint64(uintptr(unsafe.Pointer(&tx[0]))), length: uint32(len(tx))},
+		{rxBuf: uint64(uintptr(unsafe.Pointer(&rx[0]))), length: uint32(n)},
+	}
+	req := spiIocMessage | uintptr(len(transfers)*int(unsafe.Sizeof(transfers[0])))<<16
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.file.Fd(), req, uintptr(unsafe.Pointer(&transfers[0])))
+	runtime.KeepAlive(tx)
+	runtime.KeepAlive(rx)
+	if errno != 0 {
+		return nil, fmt.Errorf("error reading from %s: %v", d.Path, errno)
+	}
+	return rx, nil
+}
+
+// Write sends data, split into transfers spidev accepts
+func (d *SPIDevice) Write(data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), d.chunk)
+		if _, err := d.file.Write(data[:n]); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return fmt.Errorf("error writing to %s: transfer of %d bytes is larger than spidev's buffer; set SPI.ChunkSize to %d or less", d.Path, n, SpidevBufsiz())
+			}
+			return fmt.Errorf("error writing to %s: %v", d.Path, err)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// Close closes the device
+func (d *SPIDevice) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,193 @@
+package panel
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Ways of drawing frames in a terminal
+const (
+	TermModeAuto   = ""
+	TermModeSixel  = "sixel"
+	TermModeBlocks = "blocks"
+)
+
+// Grey levels used for sixel output
+const sixelLevels = 16
+
+// TermPanel simulates a TRMNL panel in the terminal, so frames can be
+// checked over SSH. Sixel graphics show the frame at full resolution in
+// terminals that support them; elsewhere it is drawn with Unicode half
+// blocks, two pixels per character, scaled to the terminal's width.
+type TermPanel struct {
+	Sixel bool
+	out   io.Writer
+}
+
+// NewTermPanel creates a panel drawing on stdout in mode, guessing whether
+// the terminal supports sixel when mode is empty
+func NewTermPanel(mode string) *TermPanel {
+	sixel := mode == TermModeSixel
+	if mode == TermModeAuto {
+		sixel = terminalSupportsSixel()
+	}
+	return &TermPanel{Sixel: sixel, out: os.Stdout}
+}
+
+// terminalSupportsSixel guesses from the environment whether the terminal
+// draws sixel graphics. Asking the terminal would need it in raw mode,
+// which would fight with the log output sharing it.
+func terminalSupportsSixel() bool {
+	for _, name := range []string{os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")} {
+		name = strings.ToLower(name)
+		for _, known := range []string{"sixel", "mlterm", "foot", "wezterm", "contour", "yaft", "mintty"} {
+			if strings.Contains(name, known) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Bounds returns the size of a TRMNL panel
+func (p *TermPanel) Bounds() image.Rectangle {
+	return image.Rect(0, 0, Width, Height)
+}
+
+// Display draws img below the log output
+func (p *TermPanel) Display(img image.Image) error {
+	gray := image.NewGray(p.Bounds())
+	draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	out := bufio.NewWriter(p.out)
+	if p.Sixel {
+		writeSixel(out, gray)
+	} else {
+		cols, rows := terminalSize()
+		writeHalfBlocks(out, gray, cols, rows)
+	}
+	if err := out.Flush(); err != nil {
+		return fmt.Errorf("error writing to terminal: %v", err)
+	}
+	return nil
+}
+
+// Clear draws a white frame
+func (p *TermPanel) Clear() error {
+	return p.Display(image.NewUniform(color.White))
+}
+
+// Close does nothing; the last frame stays in the scrollback
+func (p *TermPanel) Close() {}
+
+// terminalSize returns stdout's size in characters, or 80x24 when it is
+// not a terminal
+func terminalSize() (cols, rows int) {
+	var size struct{ Rows, Cols, X, Y uint16 }
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdout.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size.Cols == 0 || size.Rows == 0 {
+		return 80, 24
+	}
+	return int(size.Cols), int(size.Rows)
+}
+
+// writeHalfBlocks draws img with upper half blocks, the top pixel in the
+// foreground colour and the bottom one in the background, scaled to fit in
+// cols by rows characters with a line left for the prompt
+func writeHalfBlocks(w *bufio.Writer, img *image.Gray, cols, rows int) {
+	bounds := img.Bounds()
+	width := cols
+	height := width * bounds.Dy() / bounds.Dx()
+	if maxHeight := (rows - 1) * 2; height > maxHeight {
+		height = maxHeight
+		width = height * bounds.Dx() / bounds.Dy()
+	}
+	height &^= 1
+	if width < 1 || height < 2 {
+		return
+	}
+
+	at := func(x, y int) uint8 {
+		return img.GrayAt(x*bounds.Dx()/width, y*bounds.Dy()/height).Y
+	}
+	for y := 0; y < height; y += 2 {
+		for x := 0; x < width; x++ {
+			top, bottom := at(x, y), at(x, y+1)
+			fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀", top, top, top, bottom, bottom, bottom)
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
+
+// writeSixel draws img as a sixel image in sixelLevels shades of grey
+func writeSixel(w *bufio.Writer, img *image.Gray) {
+	bounds := img.Bounds()
+	level := func(x, y int) int {
+		return int(img.GrayAt(x, y).Y) * (sixelLevels - 1) / 255
+	}
+
+	// Start the image with square pixels and define the palette, in
+	// percentages
+	fmt.Fprintf(w, "\x1bP0;1;0q\"1;1;%d;%d", bounds.Dx(), bounds.Dy())
+	for i := 0; i < sixelLevels; i++ {
+		v := i * 100 / (sixelLevels - 1)
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i, v, v, v)
+	}
+
+	// Each band is six rows; within it every shade is drawn in turn over
+	// the same columns, with runs of the same sixel compressed
+	row := make([]byte, bounds.Dx())
+	for top := 0; top < bounds.Dy(); top += 6 {
+		first := true
+		for shade := 0; shade < sixelLevels; shade++ {
+			used := false
+			for x := range row {
+				bits := byte(0)
+				for dy := 0; dy < 6 && top+dy < bounds.Dy(); dy++ {
+					if level(x, top+dy) == shade {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+				used = used || bits != 0
+			}
+			if !used {
+				continue
+			}
+			if !first {
+				w.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(w, "#%d", shade)
+			writeSixelRuns(w, row)
+		}
+		w.WriteByte('-')
+	}
+	w.WriteString("\x1b\\\n")
+}
+
+// writeSixelRuns writes a band's sixels, compressing repeats as !COUNT
+func writeSixelRuns(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		n := 1
+		for i+n < len(row) && row[i+n] == row[i] {
+			n++
+		}
+		if n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			for j := 0; j < n; j++ {
+				w.WriteByte(row[i])
+			}
+		}
+		i += n
+	}
+}
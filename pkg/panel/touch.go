@@ -0,0 +1,182 @@
+package panel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// Input event types and codes from linux/input-event-codes.h
+const (
+	evSyn            = 0x00
+	evKey            = 0x01
+	evAbs            = 0x03
+	synReport        = 0x00
+	btnTouch         = 0x14a
+	absX             = 0x00
+	absY             = 0x01
+	absMTPositionX   = 0x35
+	absMTPositionY   = 0x36
+	absMTTrackingID  = 0x39
+	evdevKeyBitBytes = (0x2ff + 7) / 8 // KEY_MAX
+)
+
+// evdev ioctls from linux/input.h
+const (
+	eviocgabs = 0x80184540 // _IOR('E', 0x40 + abs, struct input_absinfo)
+	eviocgbit = 0x80004520 // _IOC(_IOC_READ, 'E', 0x20 + ev, len)
+)
+
+// inputEvent is struct input_event, whose timestamp is a struct timeval
+// of native longs
+type inputEvent struct {
+	Time  syscall.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+// inputAbsInfo is struct input_absinfo
+type inputAbsInfo struct {
+	Value, Minimum, Maximum, Fuzz, Flat, Resolution int32
+}
+
+// TouchPoint is where a touch landed, from 0 to 1 across and down the
+// touch panel
+type TouchPoint struct {
+	X, Y float64
+}
+
+// Touch reads taps from a touchscreen through the kernel's evdev interface,
+// as the goodix driver provides for the GT1151 controller on Waveshare's
+// touch e-paper HATs
+type Touch struct {
+	Path string
+
+	file       *os.File
+	xMin, xMax int32
+	yMin, yMax int32
+	xCode      uint16
+	yCode      uint16
+	btnTouch   bool
+
+	// Last position reported, as evdev only sends the axes that changed,
+	// and whether a finger is down
+	x, y int32
+	down bool
+}
+
+// OpenTouch opens the touchscreen at path, a /dev/input/event device, or
+// the first one found when path is empty
+func OpenTouch(path string) (*Touch, error) {
+	if path == "" {
+		found, err := FindTouch()
+		if err != nil {
+			return nil, err
+		}
+		path = found
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	t := &Touch{Path: path, file: f, xCode: absX, yCode: absY, btnTouch: isTouchDevice(path)}
+	x, errX := t.absInfo(absX)
+	y, errY := t.absInfo(absY)
+	if errX != nil || errY != nil || x.Maximum <= x.Minimum || y.Maximum <= y.Minimum {
+		// Multi-touch only drivers report positions per contact
+		x, errX = t.absInfo(absMTPositionX)
+		y, errY = t.absInfo(absMTPositionY)
+		t.xCode, t.yCode = absMTPositionX, absMTPositionY
+	}
+	if errX != nil || errY != nil || x.Maximum <= x.Minimum || y.Maximum <= y.Minimum {
+		f.Close()
+		return nil, fmt.Errorf("%s does not report touch positions", path)
+	}
+	t.xMin, t.xMax = x.Minimum, x.Maximum
+	t.yMin, t.yMax = y.Minimum, y.Maximum
+	return t, nil
+}
+
+// FindTouch returns the first input device that reports touches
+func FindTouch() (string, error) {
+	paths, _ := filepath.Glob("/dev/input/event*")
+	sort.Strings(paths)
+	for _, path := range paths {
+		if isTouchDevice(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no touchscreen found in /dev/input (for a Waveshare touch HAT, load its driver with dtoverlay=goodix)")
+}
+
+// isTouchDevice reports whether the input device at path has BTN_TOUCH
+func isTouchDevice(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var bits [evdevKeyBitBytes]byte
+	req := uintptr(eviocgbit+evKey) | uintptr(len(bits))<<16
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(&bits[0])))
+	return errno == 0 && bits[btnTouch/8]&(1<<(btnTouch%8)) != 0
+}
+
+// absInfo reads the range of an absolute axis
+func (t *Touch) absInfo(code uint16) (inputAbsInfo, error) {
+	var info inputAbsInfo
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, t.file.Fd(), eviocgabs+uintptr(code), uintptr(unsafe.Pointer(&info)))
+	if errno != 0 {
+		return info, errno
+	}
+	return info, nil
+}
+
+// Next blocks until the next tap, reporting where the finger came down
+func (t *Touch) Next() (TouchPoint, error) {
+	buf := make([]byte, unsafe.Sizeof(inputEvent{}))
+	tapped := false
+	for {
+		if _, err := t.file.Read(buf); err != nil {
+			return TouchPoint{}, fmt.Errorf("error reading %s: %v", t.Path, err)
+		}
+		var ev inputEvent
+		if _, err := binary.Decode(buf, binary.NativeEndian, &ev); err != nil {
+			return TouchPoint{}, fmt.Errorf("error reading %s: %v", t.Path, err)
+		}
+		switch {
+		case ev.Type == evAbs && ev.Code == t.xCode:
+			t.x = ev.Value
+		case ev.Type == evAbs && ev.Code == t.yCode:
+			t.y = ev.Value
+		case ev.Type == evKey && ev.Code == btnTouch:
+			tapped = tapped || ev.Value == 1 && !t.down
+			t.down = ev.Value == 1
+		case ev.Type == evAbs && ev.Code == absMTTrackingID && !t.btnTouch:
+			// Without BTN_TOUCH, a contact starts with a tracking ID and
+			// ends with -1
+			tapped = tapped || ev.Value >= 0 && !t.down
+			t.down = ev.Value >= 0
+		case ev.Type == evSyn && ev.Code == synReport && tapped:
+			return t.point(t.x, t.y), nil
+		}
+	}
+}
+
+// point scales a raw position to the 0 to 1 range
+func (t *Touch) point(x, y int32) TouchPoint {
+	scale := func(v, lo, hi int32) float64 {
+		return min(max(float64(v-lo)/float64(hi-lo), 0), 1)
+	}
+	return TouchPoint{X: scale(x, t.xMin, t.xMax), Y: scale(y, t.yMin, t.yMax)}
+}
+
+// Close releases the device
+func (t *Touch) Close() error {
+	return t.file.Close()
+}
@@ -0,0 +1,48 @@
+package panel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestTouchNext(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	touch := &Touch{Path: "pipe", file: r, xCode: absX, yCode: absY, btnTouch: true, xMax: 1000, yMax: 500}
+
+	var events bytes.Buffer
+	for _, ev := range []inputEvent{
+		{Type: evAbs, Code: absX, Value: 250},
+		{Type: evAbs, Code: absY, Value: 400},
+		{Type: evKey, Code: btnTouch, Value: 1},
+		{Type: evSyn, Code: synReport},
+		{Type: evAbs, Code: absX, Value: 300}, // dragging, not a new tap
+		{Type: evSyn, Code: synReport},
+		{Type: evKey, Code: btnTouch, Value: 0},
+		{Type: evSyn, Code: synReport},
+		{Type: evAbs, Code: absY, Value: 100}, // x unchanged
+		{Type: evKey, Code: btnTouch, Value: 1},
+		{Type: evSyn, Code: synReport},
+	} {
+		binary.Write(&events, binary.NativeEndian, ev)
+	}
+	go func() {
+		w.Write(events.Bytes())
+		w.Close()
+	}()
+
+	for _, want := range []TouchPoint{{X: 0.25, Y: 0.8}, {X: 0.3, Y: 0.2}} {
+		got, err := touch.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("tap at %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package qr
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeVersion(t *testing.T) {
+	// Byte mode capacities at level M: 14 bytes fit version 1, 26 version 2,
+	// and 180 version 9, the largest supported
+	for _, tt := range []struct {
+		length int
+		size   int
+	}{
+		{1, 21},
+		{14, 21},
+		{15, 25},
+		{26, 25},
+		{27, 29},
+		{180, 53},
+	} {
+		q, err := Encode(strings.Repeat("a", tt.length))
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", tt.length, err)
+		}
+		if q.Size != tt.size {
+			t.Errorf("Encode(%d bytes) size = %d, want %d", tt.length, q.Size, tt.size)
+		}
+	}
+	if _, err := Encode(strings.Repeat("a", 181)); err == nil {
+		t.Error("Encode(181 bytes) succeeded, want an error")
+	}
+}
+
+func TestFormatBits(t *testing.T) {
+	// The level M format strings from the QR code specification, most
+	// significant bit first
+	want := []string{
+		"101010000010010",
+		"101000100100101",
+		"101111001111100",
+		"101101101001011",
+		"100010111111001",
+		"100000011001110",
+		"100111110010111",
+		"100101010100000",
+	}
+	for mask, bits := range want {
+		q := newQRCode(1)
+		q.drawFormatBits(mask)
+		// Read both copies back: around the top left finder pattern, and
+		// split between the top right and bottom left ones
+		var first, second [15]byte
+		read := func(dark bool) byte {
+			if dark {
+				return '1'
+			}
+			return '0'
+		}
+		for i := 0; i <= 5; i++ {
+			first[14-i] = read(q.Dark(8, i))
+		}
+		first[14-6] = read(q.Dark(8, 7))
+		first[14-7] = read(q.Dark(8, 8))
+		first[14-8] = read(q.Dark(7, 8))
+		for i := 9; i < 15; i++ {
+			first[14-i] = read(q.Dark(14-i, 8))
+		}
+		for i := 0; i < 8; i++ {
+			second[14-i] = read(q.Dark(q.Size-1-i, 8))
+		}
+		for i := 8; i < 15; i++ {
+			second[14-i] = read(q.Dark(8, q.Size-15+i))
+		}
+		if string(first[:]) != bits || string(second[:]) != bits {
+			t.Errorf("mask %d format bits = %s and %s, want %s", mask, first[:], second[:], bits)
+		}
+		if !q.Dark(8, q.Size-8) {
+			t.Errorf("mask %d: dark module is light", mask)
+		}
+	}
+}
+
+func TestAddECC(t *testing.T) {
+	// "HELLO WORLD" as a version 1-M symbol, the worked example commonly
+	// used to check QR encoders
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ecc := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	got := qrAddECC(data, 1)
+	if !bytes.Equal(got, append(append([]byte{}, data...), ecc...)) {
+		t.Errorf("qrAddECC = %v, want the data followed by %v", got, ecc)
+	}
+}
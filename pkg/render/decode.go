@@ -0,0 +1,334 @@
+// Package render turns the images a TRMNL server sends into frames for an
+// e-paper panel: decoding (including the 1-bit BMPs the standard library
+// cannot read), scaling, inverting, dithering, and packing for the panel.
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // Register JPEG decoder
+	_ "image/png"  // Register PNG decoder
+	"io"
+	"os"
+
+	_ "golang.org/x/image/bmp" // Register BMP decoder
+)
+
+// Files larger than this are not read into memory by the BMP decoder
+const maxFileBytes = 32 * 1024 * 1024
+
+// Images with more pixels than this are rejected whatever Options.MaxPixels
+// says, as decoding one would need gigabytes of memory
+const maxImagePixels = 1 << 26
+
+// Options controls decoding
+type Options struct {
+	// Reject images with more pixels than this; 0 for no limit beyond the
+	// 64 megapixels no image may exceed
+	MaxPixels int
+	// Receives progress messages, if set
+	Log func(format string, args ...interface{})
+}
+
+// logf passes a message to Log
+func (o Options) logf(format string, args ...interface{}) {
+	if o.Log != nil {
+		o.Log(format, args...)
+	}
+}
+
+// Decode reads and decodes the image at path, falling back to the custom BMP
+// decoder for BMP variants the standard library cannot handle
+func Decode(path string, options Options) (image.Image, error) {
+	// Open the image file
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening image file: %v", err)
+	}
+	defer file.Close()
+
+	options.logf("Reading image from %s\n", path)
+
+	// Get image format
+	format, err := imageFormat(file)
+	if err != nil {
+		return nil, fmt.Errorf("error determining image format: %v", err)
+	}
+	options.logf("Detected image format: %s\n", format)
+
+	// Reset file position after checking format
+	file.Seek(0, 0)
+
+	// Check the declared dimensions before allocating anything for the pixels
+	if err := checkImageSize(file, options.MaxPixels); err != nil {
+		return nil, err
+	}
+	file.Seek(0, 0)
+
+	var img image.Image
+	// Try standard decoding first
+	img, format, err = image.Decode(file)
+	// If standard decoding fails for BMP, try our custom decoder
+	if err != nil && format == "bmp" {
+		options.logf("Standard BMP decoder failed: %v\n", err)
+		options.logf("Trying custom BMP decoder...\n")
+		file.Seek(0, 0)
+		img, err = decodeBMP(file, options)
+		if err != nil {
+			return nil, fmt.Errorf("both standard and custom BMP decoders failed: %v", err)
+		}
+		options.logf("Successfully decoded image with custom BMP decoder\n")
+	} else if err != nil {
+		return nil, fmt.Errorf("error decoding image format '%s': %v", format, err)
+	} else {
+		options.logf("Successfully decoded image as %s\n", format)
+	}
+
+	return img, nil
+}
+
+// pixelLimit returns the most pixels an image may have given maxPixels
+func pixelLimit(maxPixels int) int64 {
+	if maxPixels <= 0 || maxPixels > maxImagePixels {
+		return maxImagePixels
+	}
+	return int64(maxPixels)
+}
+
+// checkImageSize reads the image header and rejects images with more than
+// maxPixels pixels. Headers the standard library cannot parse are let through
+// so the full decoders can report them; decodeBMP checks the BMPs it falls
+// back for itself.
+func checkImageSize(file *os.File, maxPixels int) error {
+	cfg, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil
+	}
+
+	limit := pixelLimit(maxPixels)
+	if int64(cfg.Width)*int64(cfg.Height) > limit {
+		return fmt.Errorf("%s image is %dx%d, exceeding the %d pixel limit", format, cfg.Width, cfg.Height, limit)
+	}
+	return nil
+}
+
+// decodeBMP attempts to decode a BMP file using a simplified approach
+// that can handle some BMP variants that the standard library cannot, including 1-bit BMPs.
+func decodeBMP(file *os.File, options Options) (image.Image, error) {
+	// Read the entire file
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error getting file info: %v", err)
+	}
+
+	fileSize := fileInfo.Size()
+	if fileSize > maxFileBytes {
+		return nil, fmt.Errorf("BMP file is too large (%d bytes)", fileSize)
+	}
+	data := make([]byte, fileSize)
+	_, err = io.ReadFull(file, data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading file: %v", err)
+	}
+
+	// Check BMP signature and that the headers we parse are present
+	if len(data) < 30 {
+		return nil, fmt.Errorf("BMP file is truncated")
+	}
+	if data[0] != 'B' || data[1] != 'M' {
+		return nil, fmt.Errorf("invalid BMP signature")
+	}
+
+	// Parse header information
+	dataOffset := int(uint32(data[10]) | uint32(data[11])<<8 | uint32(data[12])<<16 | uint32(data[13])<<24)
+	headerSize := int(uint32(data[14]) | uint32(data[15])<<8 | uint32(data[16])<<16 | uint32(data[17])<<24)
+	width := int(int32(uint32(data[18]) | uint32(data[19])<<8 | uint32(data[20])<<16 | uint32(data[21])<<24))
+	if width < 0 {
+		width = -width
+	}
+	height := int(int32(uint32(data[22]) | uint32(data[23])<<8 | uint32(data[24])<<16 | uint32(data[25])<<24))
+	isBottomUp := true
+	if height < 0 {
+		height = -height
+		isBottomUp = false
+	}
+	bitsPerPixel := int(uint16(data[28]) | uint16(data[29])<<8)
+	var numColors int
+	if headerSize >= 36 && len(data) > 49 {
+		numColors = int(uint32(data[46]) | uint32(data[47])<<8 | uint32(data[48])<<16 | uint32(data[49])<<24)
+	}
+	// The header can claim any palette size; an indexed image can use at
+	// most 1<<bitsPerPixel colours, and other depths have no palette
+	if bitsPerPixel <= 8 && (numColors <= 0 || numColors > 1<<uint(bitsPerPixel)) {
+		numColors = 1 << uint(bitsPerPixel)
+	} else if bitsPerPixel > 8 {
+		numColors = 0
+	}
+
+	if limit := pixelLimit(options.MaxPixels); int64(width)*int64(height) > limit {
+		return nil, fmt.Errorf("BMP image is %dx%d, exceeding the %d pixel limit", width, height, limit)
+	}
+
+	options.logf("BMP Info: width=%d, height=%d, bitsPerPixel=%d, dataOffset=%d, headerSize=%d, numColors=%d\n",
+		width, height, bitsPerPixel, dataOffset, headerSize, numColors)
+
+	// Calculate row padding (BMP rows are aligned to 4 bytes)
+	rowSize := ((width*bitsPerPixel + 31) / 32) * 4
+	// The header can claim any size, so check the pixels it describes are
+	// in the file before allocating for them. The last row's padding is
+	// sometimes left off.
+	if height > 0 {
+		lastRow := (int64(width)*int64(bitsPerPixel) + 7) / 8
+		if dataOffset > len(data) || int64(rowSize)*int64(height-1)+lastRow > int64(len(data)-dataOffset) {
+			return nil, fmt.Errorf("BMP image is %dx%d, but the file holds fewer pixels", width, height)
+		}
+	}
+
+	// Create a new RGBA image
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	// For 1-bit (and other indexed) BMPs, read the colour palette
+	var palette []color.RGBA
+	if bitsPerPixel == 1 || bitsPerPixel == 4 || bitsPerPixel == 8 {
+		paletteOffset := 14 + headerSize
+		if headerSize < 12 || paletteOffset+numColors*4 > len(data) {
+			return nil, fmt.Errorf("BMP palette runs past the end of the file")
+		}
+		palette = make([]color.RGBA, numColors)
+		for i := 0; i < numColors && paletteOffset+i*4+2 < len(data); i++ {
+			b := data[paletteOffset+i*4]
+			g := data[paletteOffset+i*4+1]
+			r := data[paletteOffset+i*4+2]
+			palette[i] = color.RGBA{r, g, b, 255}
+		}
+		if len(palette) < 2 {
+			// Default palette for 1-bit BMP: black and white
+			palette = []color.RGBA{
+				{0, 0, 0, 255},
+				{255, 255, 255, 255},
+			}
+		}
+
+		options.logf("Palette: %v\n", palette)
+	}
+
+	// Read pixel data
+	for y := 0; y < height; y++ {
+		srcY := y
+		if isBottomUp {
+			srcY = height - 1 - y
+		}
+
+		for x := 0; x < width; x++ {
+			var col color.RGBA
+
+			switch bitsPerPixel {
+			case 24, 32:
+				pos := dataOffset + srcY*rowSize + x*bitsPerPixel/8
+				if pos+3 > len(data) {
+					continue
+				}
+				b := data[pos]
+				g := data[pos+1]
+				r := data[pos+2]
+				a := uint8(255)
+				if bitsPerPixel == 32 && pos+3 < len(data) {
+					a = data[pos+3]
+				}
+				col = color.RGBA{r, g, b, a}
+			case 16:
+				pos := dataOffset + srcY*rowSize + x*2
+				if pos+1 >= len(data) {
+					continue
+				}
+				value := uint16(data[pos]) | uint16(data[pos+1])<<8
+				r := uint8((value>>11)&0x1F) << 3
+				g := uint8((value>>5)&0x3F) << 2
+				b := uint8(value&0x1F) << 3
+				col = color.RGBA{r, g, b, 255}
+			case 8:
+				pos := dataOffset + srcY*rowSize + x
+				if pos >= len(data) {
+					continue
+				}
+				index := data[pos]
+				if int(index) < len(palette) {
+					col = palette[index]
+				} else {
+					col = color.RGBA{0, 0, 0, 255}
+				}
+			case 4:
+				pos := dataOffset + srcY*rowSize + x/2
+				if pos >= len(data) {
+					continue
+				}
+				var index uint8
+				if x%2 == 0 {
+					index = (data[pos] >> 4) & 0x0F
+				} else {
+					index = data[pos] & 0x0F
+				}
+				if int(index) < len(palette) {
+					col = palette[index]
+				} else {
+					col = color.RGBA{0, 0, 0, 255}
+				}
+			case 1:
+				bytePos := dataOffset + srcY*rowSize + x/8
+				bitPos := 7 - (x % 8)
+				if bytePos >= len(data) {
+					continue
+				}
+				bit := (data[bytePos] >> bitPos) & 1
+				if int(bit) < len(palette) {
+					col = color.RGBA{palette[bit].R, palette[bit].G, palette[bit].B, 255}
+				} else {
+					if bit == 0 {
+						col = color.RGBA{0, 0, 0, 255}
+					} else {
+						col = color.RGBA{255, 255, 255, 255}
+					}
+				}
+			default:
+				return nil, fmt.Errorf("unsupported BMP bit depth: %d", bitsPerPixel)
+			}
+
+			// Use the standard Set method.
+			img.Set(x, y, col)
+		}
+	}
+
+	return img, nil
+}
+
+// imageFormat determines the image format based on its header.
+func imageFormat(file *os.File) (string, error) {
+	buffer := make([]byte, 512)
+	_, err := file.Read(buffer)
+	if err != nil {
+		return "", err
+	}
+
+	signatures := map[string][]byte{
+		"jpeg": {0xFF, 0xD8},
+		"png":  {0x89, 0x50, 0x4E, 0x47},
+		"gif":  {0x47, 0x49, 0x46},
+		"bmp":  {0x42, 0x4D},
+	}
+
+	for format, signature := range signatures {
+		match := true
+		for i, b := range signature {
+			if buffer[i] != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			return format, nil
+		}
+	}
+
+	return "unknown", nil
+}
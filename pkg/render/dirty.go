@@ -0,0 +1,93 @@
+package render
+
+import (
+	"image"
+	"sort"
+)
+
+// DirtyRegions compares two frames packed by Pack1Bit and returns the
+// rectangles holding every pixel that changed, with left and right edges on
+// byte boundaries. Changed rows are grouped into bands, and regions less
+// than gap pixels apart are merged, as each region costs a refresh of its
+// own. At most maxRegions are returned; beyond that the closest are merged
+// until they fit. Frames that are the same give no regions.
+func DirtyRegions(old, buf []byte, width, height, gap, maxRegions int) []image.Rectangle {
+	stride := (width + 7) / 8
+	var regions []image.Rectangle
+	var band image.Rectangle // changes in the rows since the last unchanged gap
+	lastChanged := -1
+	for y := 0; y < height; y++ {
+		row := y * stride
+		first, last := -1, -1
+		for i := 0; i < stride; i++ {
+			if old[row+i] != buf[row+i] {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		if lastChanged >= 0 && y-lastChanged > gap {
+			regions = append(regions, band)
+			band = image.Rectangle{}
+		}
+		band = band.Union(image.Rect(first*8, y, min((last+1)*8, stride*8), y+1))
+		lastChanged = y
+	}
+	if lastChanged >= 0 {
+		regions = append(regions, band)
+	}
+	return mergeRegions(regions, gap, max(1, maxRegions))
+}
+
+// mergeRegions merges regions that overlap or come within gap pixels of
+// each other, then the pairs whose union adds the least area until no more
+// than maxRegions are left
+func mergeRegions(regions []image.Rectangle, gap, maxRegions int) []image.Rectangle {
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(regions) && !merged; i++ {
+			for j := i + 1; j < len(regions); j++ {
+				if regions[i].Inset(-gap).Overlaps(regions[j]) {
+					regions[i] = regions[i].Union(regions[j])
+					regions = append(regions[:j], regions[j+1:]...)
+					merged = true
+					break
+				}
+			}
+		}
+	}
+	for len(regions) > maxRegions {
+		bestI, bestJ, bestCost := 0, 1, -1
+		for i := range regions {
+			for j := i + 1; j < len(regions); j++ {
+				cost := area(regions[i].Union(regions[j])) - area(regions[i]) - area(regions[j])
+				if bestCost < 0 || cost < bestCost {
+					bestI, bestJ, bestCost = i, j, cost
+				}
+			}
+		}
+		regions[bestI] = regions[bestI].Union(regions[bestJ])
+		regions = append(regions[:bestJ], regions[bestJ+1:]...)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Min.Y < regions[j].Min.Y })
+	return regions
+}
+
+// DirtyFraction returns the fraction of a width x height frame the regions
+// cover
+func DirtyFraction(regions []image.Rectangle, width, height int) float64 {
+	total := 0
+	for _, r := range regions {
+		total += area(r)
+	}
+	return float64(total) / float64(width*height)
+}
+
+// area returns the number of pixels in r
+func area(r image.Rectangle) int {
+	return r.Dx() * r.Dy()
+}
@@ -0,0 +1,142 @@
+package render
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Tap is one of the pixels a dithering kernel carries error to, DX to the
+// right and DY rows below the current one, and its share of the error
+type Tap struct {
+	DX, DY, Weight int
+}
+
+// Kernel is an error-diffusion kernel: each tap gets Weight/Divisor of a
+// pixel's error. Kernels whose weights add up to less than the divisor,
+// like Atkinson's, let the rest of the error go, which keeps highlights and
+// shadows clean at the cost of some detail.
+type Kernel struct {
+	Taps    []Tap
+	Divisor int
+}
+
+// Error-diffusion kernels
+var (
+	FloydSteinberg = Kernel{Divisor: 16, Taps: []Tap{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}}
+	Atkinson = Kernel{Divisor: 8, Taps: []Tap{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}}
+	Stucki = Kernel{Divisor: 42, Taps: []Tap{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+		{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+	}}
+	Sierra = Kernel{Divisor: 32, Taps: []Tap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}}
+)
+
+// Kernels are the kernels by the names ParseKernel accepts; "none" rounds
+// each pixel to the nearest level without carrying any error
+var Kernels = map[string]Kernel{
+	"floyd-steinberg": FloydSteinberg,
+	"atkinson":        Atkinson,
+	"stucki":          Stucki,
+	"sierra":          Sierra,
+	"none":            {},
+}
+
+// DefaultKernel names the kernel used when none is given
+const DefaultKernel = "floyd-steinberg"
+
+// ParseKernel looks up a kernel by name
+func ParseKernel(name string) (Kernel, error) {
+	if kernel, ok := Kernels[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return kernel, nil
+	}
+	names := make([]string, 0, len(Kernels))
+	for name := range Kernels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return Kernel{}, fmt.Errorf("unknown dithering kernel %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// Dither is how frames are dithered: the kernel, and whether alternate rows
+// are scanned right to left (serpentine scanning), which breaks up the
+// diagonal patterns scanning one way leaves
+type Dither struct {
+	Kernel     Kernel
+	Serpentine bool
+}
+
+// DefaultDither is Floyd-Steinberg, scanning every row left to right
+var DefaultDither = Dither{Kernel: FloydSteinberg}
+
+// quantizer sets a pixel given the error carried to it, for each of its
+// channels, and returns the error its choice of level leaves
+type quantizer func(x, y int, carried []int) []int
+
+// diffuse visits each pixel of a width x height frame in scanning order,
+// spreading the error each quantizer call leaves over the pixels the kernel
+// reaches. Each row depends on the error from those above, so frames are
+// dithered on one core; without a kernel there is no error to carry, and
+// bands of rows are quantized in parallel, each with a quantizer of its own
+// from newQuantizer.
+func (d Dither) diffuse(width, height, channels int, newQuantizer func() quantizer) {
+	if len(d.Kernel.Taps) == 0 {
+		bands(width, height, func(y0, y1 int) {
+			quantize := newQuantizer()
+			carried := make([]int, channels)
+			for y := y0; y < y1; y++ {
+				for x := 0; x < width; x++ {
+					quantize(x, y, carried)
+				}
+			}
+		})
+		return
+	}
+
+	quantize := newQuantizer()
+	// Error rows, from the current one down, with room for the kernel to
+	// reach past either edge
+	const pad = 2
+	stride := (width + 2*pad) * channels
+	rows := [3][]int{make([]int, stride), make([]int, stride), make([]int, stride)}
+	carried := make([]int, channels)
+	for y := 0; y < height; y++ {
+		dir := 1
+		if d.Serpentine && y%2 == 1 {
+			dir = -1
+		}
+		for i := 0; i < width; i++ {
+			x := i
+			if dir < 0 {
+				x = width - 1 - i
+			}
+			at := (x + pad) * channels
+			for c := range carried {
+				if d.Kernel.Divisor > 0 {
+					carried[c] = rows[0][at+c] / d.Kernel.Divisor
+				}
+			}
+			errs := quantize(x, y, carried)
+			for _, tap := range d.Kernel.Taps {
+				to := (x + tap.DX*dir + pad) * channels
+				for c, err := range errs {
+					rows[tap.DY][to+c] += err * tap.Weight
+				}
+			}
+		}
+		rows[0], rows[1], rows[2] = rows[1], rows[2], rows[0]
+		clear(rows[2])
+	}
+}
@@ -0,0 +1,178 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DitherGray4 converts the width x height area at the top left of img to
+// four grey levels (0, 85, 170, and 255), dithered, so photos and gradients
+// keep their shading on a four-grey panel
+func DitherGray4(img image.Image, width, height int, dither Dither) *image.Gray {
+	return DitherGray(img, width, height, 4, dither)
+}
+
+// DitherGray converts the width x height area at the top left of img to the
+// given number of evenly spaced grey levels, from black to white, with
+// error-diffusion dithering
+func DitherGray(img image.Image, width, height, levels int, dither Dither) *image.Gray {
+	return DitherGrayTo(nil, img, width, height, levels, dither)
+}
+
+// DitherGrayTo is DitherGray into out, which is reused when it is the right
+// size and allocated otherwise; it returns the dithered frame
+func DitherGrayTo(out *image.Gray, img image.Image, width, height, levels int, dither Dither) *image.Gray {
+	if out == nil || out.Rect != image.Rect(0, 0, width, height) {
+		out = image.NewGray(image.Rect(0, 0, width, height))
+	}
+	step := 255 / (levels - 1)
+	bounds := img.Bounds()
+	dither.diffuse(width, height, 1, func() quantizer {
+		// diffuse finishes each row before the next
+		row, rowY := make([]uint8, width), -1
+		errs := make([]int, 1)
+		return func(x, y int, carried []int) []int {
+			if y != rowY {
+				grayRow(img, bounds.Min.X, bounds.Min.Y+y, row)
+				rowY = y
+			}
+			value := int(row[x]) + carried[0]
+			level := min(max((value+step/2)/step, 0), levels-1)
+			out.Pix[y*out.Stride+x] = uint8(level * step)
+			errs[0] = value - level*step
+			return errs
+		}
+	})
+	return out
+}
+
+// PackGray4 packs the width x height area at the top left of a four-level
+// frame into the two bit planes four-grey e-paper controllers take, most
+// significant bit first with rows padded to a whole byte. Each pixel's bits
+// in the two planes select its level: white 00, light grey 01, dark grey 10,
+// and black 11.
+func PackGray4(img image.Image, width, height int) (plane1, plane2 []byte) {
+	stride := (width + 7) / 8
+	plane1 = make([]byte, stride*height)
+	plane2 = make([]byte, stride*height)
+	bounds := img.Bounds()
+	bands(width, height, func(y0, y1 int) {
+		row := make([]uint8, width)
+		for y := y0; y < y1; y++ {
+			grayRow(img, bounds.Min.X, bounds.Min.Y+y, row)
+			for x, gray := range row {
+				bit := byte(0x80 >> (x % 8))
+				i := y*stride + x/8
+				switch level := (int(gray) + 42) / 85; level {
+				case 0: // black
+					plane1[i] |= bit
+					plane2[i] |= bit
+				case 1: // dark grey
+					plane1[i] |= bit
+				case 2: // light grey
+					plane2[i] |= bit
+				}
+			}
+		}
+	})
+	return plane1, plane2
+}
+
+// Luma weights the red, green, and blue channels when converting colour to
+// grey; the weights add up to 1
+type Luma struct {
+	R, G, B float64
+
+	// Linear weighs the channels in linear light, decoding sRGB first and
+	// encoding the grey again, rather than weighing the gamma-encoded
+	// values. Saturated colours then come out lighter, closer to how bright
+	// they look.
+	Linear bool
+}
+
+// Named luma weights ParseLuma accepts. BT.601 is what image/color's
+// GrayModel, and so every driver by default, uses.
+var Lumas = map[string]Luma{
+	"bt601":   {R: 0.299, G: 0.587, B: 0.114},
+	"bt709":   {R: 0.2126, G: 0.7152, B: 0.0722},
+	"average": {R: 1.0 / 3, G: 1.0 / 3, B: 1.0 / 3},
+	"red":     {R: 1},
+	"green":   {G: 1},
+	"blue":    {B: 1},
+}
+
+// DefaultLuma names the weights used when none are given
+const DefaultLuma = "bt601"
+
+// ParseLuma parses named weights, such as bt709 or red, or three weights for
+// red, green, and blue separated by commas, which are scaled to add up to 1
+func ParseLuma(s string) (Luma, error) {
+	s = strings.TrimSpace(s)
+	if luma, ok := Lumas[strings.ToLower(s)]; ok {
+		return luma, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return Luma{}, fmt.Errorf("unknown luma %q, expected bt601, bt709, average, red, green, blue, or weights R,G,B", s)
+	}
+	var weights [3]float64
+	sum := 0.0
+	for i, part := range parts {
+		w, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || w < 0 {
+			return Luma{}, fmt.Errorf("invalid luma weight %q", part)
+		}
+		weights[i] = w
+		sum += w
+	}
+	if sum == 0 {
+		return Luma{}, fmt.Errorf("luma weights %q are all zero", s)
+	}
+	return Luma{R: weights[0] / sum, G: weights[1] / sum, B: weights[2] / sum}, nil
+}
+
+// ToGray converts img to grey with the given channel weights
+func ToGray(img image.Image, luma Luma) *image.Gray {
+	bounds := img.Bounds()
+	out := image.NewGray(bounds)
+	bands(bounds.Dx(), bounds.Dy(), func(y0, y1 int) {
+		for y := bounds.Min.Y + y0; y < bounds.Min.Y+y1; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				r, g, b, _ := img.At(x, y).RGBA()
+				var gray float64
+				if luma.Linear {
+					linear := luma.R*srgbToLinear[r>>8] + luma.G*srgbToLinear[g>>8] + luma.B*srgbToLinear[b>>8]
+					gray = linearToSRGB(linear) * 255
+				} else {
+					gray = (luma.R*float64(r) + luma.G*float64(g) + luma.B*float64(b)) / 0x101
+				}
+				out.Pix[out.PixOffset(x, y)] = uint8(min(gray+0.5, 255))
+			}
+		}
+	})
+	return out
+}
+
+// srgbToLinear decodes each 8-bit sRGB value to linear light, from 0 to 1
+var srgbToLinear = func() (table [256]float64) {
+	for i := range table {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			table[i] = v / 12.92
+		} else {
+			table[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return table
+}()
+
+// linearToSRGB encodes linear light, from 0 to 1, as sRGB
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
@@ -0,0 +1,44 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// DarkFraction returns the fraction of pixels darker than mid-grey, sampling
+// every fourth pixel in each direction
+func DarkFraction(img image.Image) float64 {
+	bounds := img.Bounds()
+	var dark, total int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += 4 {
+		for x := bounds.Min.X; x < bounds.Max.X; x += 4 {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			if gray.Y < 128 {
+				dark++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(dark) / float64(total)
+}
+
+// Invert returns a colour-inverted copy of img
+func Invert(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	inverted := image.NewRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			inverted.Set(x, y, color.RGBA64{
+				R: uint16(a - r),
+				G: uint16(a - g),
+				B: uint16(a - b),
+				A: uint16(a),
+			})
+		}
+	}
+	return inverted
+}
@@ -0,0 +1,95 @@
+package render
+
+import (
+	"fmt"
+	"image"
+)
+
+// Auto-levels modes
+const (
+	// LevelsStretch stretches the range of brightness in the frame, less the
+	// darkest and lightest LevelsClip of pixels, to run from black to white
+	LevelsStretch = "stretch"
+	// LevelsEqualize spreads brightness so each level is about as common as
+	// any other (histogram equalisation), which brings out detail in flat
+	// photos but exaggerates noise
+	LevelsEqualize = "equalize"
+)
+
+// LevelsClip is the fraction of pixels at either end of the histogram that
+// LevelsStretch lets go to pure black or white, so a few stray pixels do
+// not hold the range open
+const LevelsClip = 0.005
+
+// ValidateLevels checks an auto-levels mode; empty turns it off
+func ValidateLevels(mode string) error {
+	switch mode {
+	case "", LevelsStretch, LevelsEqualize:
+		return nil
+	}
+	return fmt.Errorf("unknown auto-levels mode %q, expected %s or %s", mode, LevelsStretch, LevelsEqualize)
+}
+
+// AutoLevels returns a copy of img with its brightness remapped by mode, so
+// washed-out photos and screenshots keep their contrast when converted to
+// black and white. The mapping comes from the brightness histogram and is
+// applied to each channel alike. Frames of a single brightness are returned
+// as they are.
+func AutoLevels(img *image.RGBA, mode string) *image.RGBA {
+	var hist [256]int
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(bounds.Min.X, y):]
+		for x := 0; x < bounds.Dx(); x++ {
+			p := row[4*x:]
+			hist[(19595*int(p[0])+38470*int(p[1])+7471*int(p[2])+1<<15)>>16]++
+		}
+	}
+	total := bounds.Dx() * bounds.Dy()
+
+	var lut [256]uint8
+	switch mode {
+	case LevelsStretch:
+		clip := int(float64(total) * LevelsClip)
+		lo, hi := 0, 255
+		for count := 0; lo < 255 && count+hist[lo] <= clip; lo++ {
+			count += hist[lo]
+		}
+		for count := 0; hi > 0 && count+hist[hi] <= clip; hi-- {
+			count += hist[hi]
+		}
+		if hi <= lo {
+			return img
+		}
+		for v := range lut {
+			lut[v] = uint8(min(max((v-lo)*255/(hi-lo), 0), 255))
+		}
+	case LevelsEqualize:
+		// Map each level to the fraction of pixels at or below it, leaving
+		// the darkest level in the frame black
+		cdf, first := 0, -1
+		for v := range hist {
+			if first < 0 && hist[v] > 0 {
+				first = hist[v]
+			}
+			cdf += hist[v]
+			if total > first {
+				lut[v] = uint8(max(cdf-first, 0) * 255 / (total - first))
+			}
+		}
+		if total <= first {
+			return img
+		}
+	default:
+		return img
+	}
+
+	out := image.NewRGBA(bounds)
+	for i := 0; i < len(img.Pix); i += 4 {
+		out.Pix[i] = lut[img.Pix[i]]
+		out.Pix[i+1] = lut[img.Pix[i+1]]
+		out.Pix[i+2] = lut[img.Pix[i+2]]
+		out.Pix[i+3] = img.Pix[i+3]
+	}
+	return out
+}
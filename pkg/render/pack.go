@@ -0,0 +1,49 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// Pack1Bit converts the width x height area at the top left of img to one
+// bit per pixel, most significant bit first, with rows padded to a whole
+// byte. Pixels at mid-grey or lighter set their bit, so a set bit is white,
+// the order e-paper controllers expect for a frame.
+func Pack1Bit(img image.Image, width, height int) []byte {
+	return Pack1BitTo(nil, img, width, height)
+}
+
+// Pack1BitTo is Pack1Bit into buf, which is reused when it is big enough
+// and allocated otherwise; it returns the packed frame
+func Pack1BitTo(buf []byte, img image.Image, width, height int) []byte {
+	stride := (width + 7) / 8
+	buf = resize(buf, stride*height)
+	bounds := img.Bounds()
+	bands(width, height, func(y0, y1 int) {
+		row := make([]uint8, width)
+		for y := y0; y < y1; y++ {
+			grayRow(img, bounds.Min.X, bounds.Min.Y+y, row)
+			out := buf[y*stride : (y+1)*stride]
+			for x, gray := range row {
+				if gray >= 128 {
+					out[x/8] |= 0x80 >> (x % 8)
+				}
+			}
+		}
+	})
+	return buf
+}
+
+// Unpack1Bit is the reverse of Pack1Bit, for checking packed frames
+func Unpack1Bit(buf []byte, width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	stride := (width + 7) / 8
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if buf[y*stride+x/8]&(0x80>>(x%8)) != 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,74 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// DitherPalette converts the width x height area at the top left of img to
+// the colours of palette with error-diffusion dithering, so a colour panel
+// with only a few inks can still show photos. Each pixel takes the palette
+// colour closest to it in RGB.
+func DitherPalette(img image.Image, width, height int, palette color.Palette, dither Dither) *image.Paletted {
+	return DitherPaletteTo(nil, img, width, height, palette, dither)
+}
+
+// DitherPaletteTo is DitherPalette into out, which is reused when it is the
+// right size and allocated otherwise; it returns the dithered frame
+func DitherPaletteTo(out *image.Paletted, img image.Image, width, height int, palette color.Palette, dither Dither) *image.Paletted {
+	if out == nil || out.Rect != image.Rect(0, 0, width, height) {
+		out = image.NewPaletted(image.Rect(0, 0, width, height), palette)
+	}
+	out.Palette = palette
+	inks := make([][3]int, len(palette))
+	for i, c := range palette {
+		r, g, b, _ := c.RGBA()
+		inks[i] = [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+	}
+	bounds := img.Bounds()
+	dither.diffuse(width, height, 3, func() quantizer {
+		errs := make([]int, 3)
+		return func(x, y int, carried []int) []int {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			// Clamped, as colours beyond the palette's would otherwise carry
+			// ever more error along
+			value := [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+			for c := range value {
+				value[c] = min(max(value[c]+carried[c], 0), 255)
+			}
+			best, bestDist := 0, -1
+			for i, ink := range inks {
+				dist := 0
+				for c := range ink {
+					d := value[c] - ink[c]
+					dist += d * d
+				}
+				if bestDist < 0 || dist < bestDist {
+					best, bestDist = i, dist
+				}
+			}
+			out.Pix[y*out.Stride+x] = uint8(best)
+			for c := range value {
+				errs[c] = value[c] - inks[best][c]
+			}
+			return errs
+		}
+	})
+	return out
+}
+
+// MixPalette blends two palettes of the same length colour by colour, from
+// all of a at 0 to all of b at 1
+func MixPalette(a, b color.Palette, t float64) color.Palette {
+	t = min(max(t, 0), 1)
+	mixed := make(color.Palette, len(a))
+	for i := range a {
+		r1, g1, b1, _ := a[i].RGBA()
+		r2, g2, b2, _ := b[i].RGBA()
+		mix := func(x, y uint32) uint8 {
+			return uint8((float64(x>>8)*(1-t) + float64(y>>8)*t) + 0.5)
+		}
+		mixed[i] = color.RGBA{mix(r1, r2), mix(g1, g2), mix(b1, b2), 0xFF}
+	}
+	return mixed
+}
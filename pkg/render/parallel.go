@@ -0,0 +1,31 @@
+package render
+
+import (
+	"runtime"
+	"sync"
+)
+
+// minBandPixels is the fewest pixels worth handing to a goroutine of their
+// own; smaller frames are converted in one go
+const minBandPixels = 32 * 1024
+
+// bands splits the rows 0 to height of a frame width pixels wide into about
+// one band per CPU and calls fn for each in a goroutine of its own,
+// returning once all are done. fn must only write to its own rows.
+func bands(width, height int, fn func(y0, y1 int)) {
+	n := min(runtime.GOMAXPROCS(0), width*height/minBandPixels, height)
+	if n <= 1 {
+		fn(0, height)
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		y0, y1 := height*i/n, height*(i+1)/n
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(y0, y1)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"image"
+	"image/color"
+)
+
+// grayRow fills row with the grey values of the len(row) pixels of img from
+// (x0, y) rightwards, as color.GrayModel would convert them. The images
+// scaling and decoding produce are read straight from their Pix slices,
+// which is several times quicker than going through At for every pixel.
+func grayRow(img image.Image, x0, y int, row []uint8) {
+	if !image.Rect(x0, y, x0+len(row), y+1).In(img.Bounds()) {
+		grayRowAt(img, x0, y, row)
+		return
+	}
+	switch m := img.(type) {
+	case *image.Gray:
+		copy(row, m.Pix[m.PixOffset(x0, y):])
+	case *image.RGBA:
+		p := m.Pix[m.PixOffset(x0, y):]
+		for x := range row {
+			row[x] = lumaBT601(uint32(p[4*x])*0x101, uint32(p[4*x+1])*0x101, uint32(p[4*x+2])*0x101)
+		}
+	case *image.NRGBA:
+		p := m.Pix[m.PixOffset(x0, y):]
+		for x := range row {
+			// Premultiplied as NRGBA's RGBA method does
+			a := uint32(p[4*x+3])
+			r := uint32(p[4*x]) * 0x101 * a / 0xFF
+			g := uint32(p[4*x+1]) * 0x101 * a / 0xFF
+			b := uint32(p[4*x+2]) * 0x101 * a / 0xFF
+			row[x] = lumaBT601(r, g, b)
+		}
+	default:
+		grayRowAt(img, x0, y, row)
+	}
+}
+
+// grayRowAt is grayRow for any image, through At
+func grayRowAt(img image.Image, x0, y int, row []uint8) {
+	for x := range row {
+		row[x] = color.GrayModel.Convert(img.At(x0+x, y)).(color.Gray).Y
+	}
+}
+
+// lumaBT601 converts 16-bit red, green, and blue to an 8-bit grey exactly
+// as color.GrayModel does
+func lumaBT601(r, g, b uint32) uint8 {
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
+
+// resize returns buf cleared and cut to n bytes, or a new buffer if buf is
+// too small
+func resize(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	buf = buf[:n]
+	clear(buf)
+	return buf
+}
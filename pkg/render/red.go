@@ -0,0 +1,140 @@
+package render
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// RedRule decides which pixels a black, white, and red panel shows in red:
+// those whose hue lies between HueFrom and HueTo degrees (wrapping past 360,
+// so 330-30 is the reds either side of 0), with at least MinSaturation and
+// MinValue, both from 0 to 1. Other pixels are drawn in black and white.
+type RedRule struct {
+	HueFrom, HueTo float64
+	MinSaturation  float64
+	MinValue       float64
+}
+
+// RedRules are the named rules ParseRedRule accepts
+var RedRules = map[string]RedRule{
+	// Reds, from crimson to red-orange
+	"red": {HueFrom: 330, HueTo: 30, MinSaturation: 0.4, MinValue: 0.25},
+	// Reds, oranges, pinks, and magentas, for dashboards whose accents are
+	// any warm colour
+	"warm": {HueFrom: 280, HueTo: 60, MinSaturation: 0.3, MinValue: 0.25},
+	// Any clearly coloured pixel, whatever its hue
+	"color": {HueFrom: 0, HueTo: 360, MinSaturation: 0.3, MinValue: 0.2},
+}
+
+// DefaultRedRule is the rule used when none is given
+const DefaultRedRule = "red"
+
+// ParseRedRule parses a named rule, such as "red", or thresholds like
+// "hue=330-30,saturation=0.4,value=0.25"; thresholds left out keep the
+// values of the red rule
+func ParseRedRule(s string) (RedRule, error) {
+	s = strings.TrimSpace(s)
+	if rule, ok := RedRules[strings.ToLower(s)]; ok {
+		return rule, nil
+	}
+	if !strings.Contains(s, "=") {
+		return RedRule{}, fmt.Errorf("unknown red rule %q, expected red, warm, color, or thresholds like hue=330-30,saturation=0.4,value=0.25", s)
+	}
+
+	rule := RedRules[DefaultRedRule]
+	for _, part := range strings.Split(s, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(key) {
+		case "hue":
+			from, to, ok := strings.Cut(value, "-")
+			hueFrom, err1 := strconv.ParseFloat(from, 64)
+			hueTo, err2 := strconv.ParseFloat(to, 64)
+			if !ok || err1 != nil || err2 != nil || hueFrom < 0 || hueFrom > 360 || hueTo < 0 || hueTo > 360 {
+				return RedRule{}, fmt.Errorf("invalid hue range %q in red rule, expected degrees like 330-30", value)
+			}
+			rule.HueFrom, rule.HueTo = hueFrom, hueTo
+		case "saturation", "value":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil || f < 0 || f > 1 {
+				return RedRule{}, fmt.Errorf("invalid %s %q in red rule, expected 0 to 1", key, value)
+			}
+			if key == "value" {
+				rule.MinValue = f
+			} else {
+				rule.MinSaturation = f
+			}
+		default:
+			return RedRule{}, fmt.Errorf("unknown threshold %q in red rule, expected hue, saturation, or value", key)
+		}
+	}
+	return rule, nil
+}
+
+// IsRed reports whether the rule draws c in red
+func (r RedRule) IsRed(c color.Color) bool {
+	hue, saturation, value := hsv(c)
+	if saturation < r.MinSaturation || value < r.MinValue {
+		return false
+	}
+	if r.HueFrom <= r.HueTo {
+		return hue >= r.HueFrom && hue <= r.HueTo
+	}
+	return hue >= r.HueFrom || hue <= r.HueTo
+}
+
+// hsv returns c's hue in degrees and its saturation and value from 0 to 1
+func hsv(c color.Color) (hue, saturation, value float64) {
+	r16, g16, b16, _ := c.RGBA()
+	r, g, b := float64(r16)/0xFFFF, float64(g16)/0xFFFF, float64(b16)/0xFFFF
+	hi, lo := max(r, g, b), min(r, g, b)
+	if hi == 0 {
+		return 0, 0, 0
+	}
+	value, saturation = hi, (hi-lo)/hi
+	if hi == lo {
+		return 0, 0, value
+	}
+	switch hi {
+	case r:
+		hue = 60 * (g - b) / (hi - lo)
+	case g:
+		hue = 60 * (2 + (b-r)/(hi-lo))
+	default:
+		hue = 60 * (4 + (r-g)/(hi-lo))
+	}
+	if hue < 0 {
+		hue += 360
+	}
+	return hue, saturation, value
+}
+
+// PackRed converts the width x height area at the top left of img to the
+// two planes a black, white, and red panel takes, packed like Pack1Bit: a
+// black and white plane where a set bit is white, and a red plane where a
+// set bit is red. Pixels the rule picks are red, and white in the first
+// plane; the rest are black or white as Pack1Bit makes them.
+func PackRed(img image.Image, width, height int, rule RedRule) (black, red []byte) {
+	stride := (width + 7) / 8
+	black = make([]byte, stride*height)
+	red = make([]byte, stride*height)
+	bounds := img.Bounds()
+	bands(width, height, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < width; x++ {
+				c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+				bit := byte(0x80 >> (x % 8))
+				i := y*stride + x/8
+				if rule.IsRed(c) {
+					red[i] |= bit
+					black[i] |= bit
+				} else if color.GrayModel.Convert(c).(color.Gray).Y >= 128 {
+					black[i] |= bit
+				}
+			}
+		}
+	})
+	return black, red
+}
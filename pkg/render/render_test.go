@@ -0,0 +1,622 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// checkGolden compares img with testdata/name, or rewrites it with -update
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if *updateGolden {
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	defer f.Close()
+	want, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want.Bounds() != img.Bounds() {
+		t.Fatalf("%s: bounds = %v, want %v", name, img.Bounds(), want.Bounds())
+	}
+	diff := 0
+	for y := want.Bounds().Min.Y; y < want.Bounds().Max.Y; y++ {
+		for x := want.Bounds().Min.X; x < want.Bounds().Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)) != color.GrayModel.Convert(want.At(x, y)) {
+				diff++
+			}
+		}
+	}
+	if diff > 0 {
+		t.Errorf("%s: %d pixels differ from the golden file (run go test -update if the change is intended)", name, diff)
+	}
+}
+
+// oneBitBMP encodes a 1-bit BMP, the format the TRMNL server sends, with
+// black as palette entry 0 and white as 1
+func oneBitBMP(img *image.Gray) []byte {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	rowSize := (width + 31) / 32 * 4
+	dataOffset := 14 + 40 + 8
+
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("BM")
+	le(uint32(dataOffset + rowSize*height))
+	le(uint32(0))
+	le(uint32(dataOffset))
+	le(uint32(40))
+	le(int32(width))
+	le(int32(height))
+	le(uint16(1))
+	le(uint16(1))
+	le(uint32(0))
+	le(uint32(rowSize * height))
+	le(int32(2835))
+	le(int32(2835))
+	le(uint32(2))
+	le(uint32(2))
+	buf.Write([]byte{0, 0, 0, 0, 255, 255, 255, 0})
+	for y := height - 1; y >= 0; y-- {
+		row := make([]byte, rowSize)
+		for x := 0; x < width; x++ {
+			if img.GrayAt(x, y).Y >= 128 {
+				row[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		buf.Write(row)
+	}
+	return buf.Bytes()
+}
+
+// testScreen is a 400x240 screen with a grey ramp across the top half and
+// a white checkerboard of 10px squares on black below
+func testScreen() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 400, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 400; x++ {
+			v := uint8(x * 255 / 399)
+			if y >= 120 {
+				v = 0
+				if (x/10+y/10)%2 == 0 {
+					v = 255
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+// Size of the panel frames are packed for
+var panelBounds = image.Rect(0, 0, 800, 480)
+
+// packFrame scales img to the panel, packs it, and unpacks the result, so
+// it can be compared with a golden image
+func packFrame(img image.Image) *image.Gray {
+	packed := Pack1Bit(Scale(img, panelBounds), panelBounds.Dx(), panelBounds.Dy())
+	return Unpack1Bit(packed, panelBounds.Dx(), panelBounds.Dy())
+}
+
+func TestPackGolden(t *testing.T) {
+	checkGolden(t, "pack-ramp.png", packFrame(testScreen()))
+}
+
+func TestBMPPipelineGolden(t *testing.T) {
+	// Checkerboard only, which survives the 1-bit round trip unchanged
+	screen := testScreen()
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 400; x++ {
+			screen.SetGray(x, y, color.Gray{Y: uint8(255 * ((x / 40) % 2))})
+		}
+	}
+	path := filepath.Join(t.TempDir(), "display.bmp")
+	if err := os.WriteFile(path, oneBitBMP(screen), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		golden   string
+		darkMode bool
+	}{
+		{"bmp-pipeline.png", false},
+		{"bmp-pipeline-dark.png", true},
+	} {
+		t.Run(tt.golden, func(t *testing.T) {
+			img, err := Decode(path, Options{MaxPixels: 1 << 24})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.darkMode {
+				img = Invert(img)
+			}
+			checkGolden(t, tt.golden, packFrame(img))
+		})
+	}
+}
+
+func TestDecodeBMPBadPalette(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 2))
+	img.SetGray(3, 0, color.Gray{Y: 255})
+
+	// A header claiming 2^32-1 colours is read as the two a 1-bit image has
+	huge := oneBitBMP(img)
+	binary.LittleEndian.PutUint32(huge[46:], 0xFFFFFFFF)
+	// A palette cut off by the end of the file is an error
+	truncated := oneBitBMP(img)[:14+40+4]
+
+	for _, tt := range []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"huge colour count", huge, false},
+		{"truncated palette", truncated, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "display.bmp")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			got, err := decodeBMP(file, Options{})
+			if tt.wantErr {
+				if err == nil {
+					t.Error("decoded a BMP whose palette runs past the end of the file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if y := color.GrayModel.Convert(got.At(3, 0)).(color.Gray).Y; y != 255 {
+				t.Errorf("pixel (3, 0) = %d, want 255", y)
+			}
+		})
+	}
+}
+
+func TestDecodeBMPOversized(t *testing.T) {
+	// A header claiming 65535x65535 pixels with none behind it is rejected
+	// before anything is allocated for them, even with no -max-pixels
+	img := image.NewGray(image.Rect(0, 0, 16, 2))
+	data := oneBitBMP(img)
+	binary.LittleEndian.PutUint32(data[18:], 65535)
+	binary.LittleEndian.PutUint32(data[22:], 65535)
+	path := filepath.Join(t.TempDir(), "display.bmp")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decode(path, Options{}); err == nil {
+		t.Error("Decode accepted a 65535x65535 BMP header in a tiny file")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := decodeBMP(file, Options{}); err == nil {
+		t.Error("decodeBMP accepted a 65535x65535 BMP header in a tiny file")
+	}
+
+	// The header alone is enough to reject an image over the ceiling
+	binary.LittleEndian.PutUint32(data[18:], 1<<14)
+	binary.LittleEndian.PutUint32(data[22:], 1<<14)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file.Seek(0, 0)
+	if _, err := decodeBMP(file, Options{}); err == nil || !strings.Contains(err.Error(), "pixel limit") {
+		t.Errorf("error = %v, want the pixel limit", err)
+	}
+}
+
+func TestPackThreshold(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 1))
+	for x, v := range []uint8{0, 127, 128, 255, 255, 0, 200, 50} {
+		img.SetGray(x, 0, color.Gray{Y: v})
+	}
+	if got := Pack1Bit(img, 8, 1); got[0] != 0b00111010 {
+		t.Errorf("pack = %08b, want 00111010 (set bits for 128 and up)", got[0])
+	}
+}
+
+func TestDirtyRegions(t *testing.T) {
+	const width, height = 64, 100
+	stride := width / 8
+	old := make([]byte, stride*height)
+	buf := bytes.Clone(old)
+	if got := DirtyRegions(old, buf, width, height, 8, 4); len(got) != 0 {
+		t.Errorf("regions of identical frames = %v, want none", got)
+	}
+
+	// A change at the top, two close together in the middle that merge,
+	// and one at the bottom
+	buf[2*stride+1] = 0x01
+	buf[50*stride+0] = 0x80
+	buf[53*stride+3] = 0x80
+	buf[90*stride+7] = 0x01
+	want := []image.Rectangle{
+		image.Rect(8, 2, 16, 3),
+		image.Rect(0, 50, 32, 54),
+		image.Rect(56, 90, 64, 91),
+	}
+	got := DirtyRegions(old, buf, width, height, 8, 4)
+	if len(got) != len(want) {
+		t.Fatalf("DirtyRegions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("region %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+
+	// Limited to two regions, the top one is merged with the middle one,
+	// which adds less area than merging with the bottom
+	got = DirtyRegions(old, buf, width, height, 8, 2)
+	if len(got) != 2 || got[0] != image.Rect(0, 2, 32, 54) {
+		t.Errorf("DirtyRegions limited to 2 = %v", got)
+	}
+	if f := DirtyFraction(got, width, height); f <= 0 || f >= 1 {
+		t.Errorf("DirtyFraction = %v", f)
+	}
+}
+
+func TestPackGray4(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 8, 1))
+	for x, v := range []uint8{255, 170, 85, 0, 250, 160, 90, 10} {
+		img.SetGray(x, 0, color.Gray{Y: v})
+	}
+	plane1, plane2 := PackGray4(img, 8, 1)
+	if plane1[0] != 0b00110011 || plane2[0] != 0b01010101 {
+		t.Errorf("planes = %08b %08b, want 00110011 01010101", plane1[0], plane2[0])
+	}
+}
+
+func TestDitherGray4(t *testing.T) {
+	// A flat mid-grey between two levels dithers to a mix of them whose
+	// average stays close to the original
+	img := image.NewUniform(color.Gray{Y: 128})
+	out := DitherGray4(img, 32, 32, DefaultDither)
+	sum := 0
+	for _, v := range out.Pix {
+		if v != 85 && v != 170 {
+			t.Fatalf("dithered level %d, want 85 or 170", v)
+		}
+		sum += int(v)
+	}
+	if avg := sum / len(out.Pix); avg < 120 || avg > 136 {
+		t.Errorf("average of dithered grey = %d, want about 128", avg)
+	}
+}
+
+func TestDitherGray16(t *testing.T) {
+	// Levels a 16-grey panel can show exactly are left alone
+	img := image.NewGray(image.Rect(0, 0, 16, 1))
+	for x := 0; x < 16; x++ {
+		img.SetGray(x, 0, color.Gray{Y: uint8(x * 17)})
+	}
+	out := DitherGray(img, 16, 1, 16, DefaultDither)
+	if !bytes.Equal(out.Pix, img.Pix) {
+		t.Errorf("DitherGray changed exact levels: %v", out.Pix)
+	}
+}
+
+func TestDitherPalette(t *testing.T) {
+	palette := color.Palette{
+		color.RGBA{0x00, 0x00, 0x00, 0xFF}, // black
+		color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, // white
+		color.RGBA{0x00, 0xFF, 0x00, 0xFF}, // green
+		color.RGBA{0x00, 0x00, 0xFF, 0xFF}, // blue
+		color.RGBA{0xFF, 0x00, 0x00, 0xFF}, // red
+		color.RGBA{0xFF, 0xFF, 0x00, 0xFF}, // yellow
+		color.RGBA{0xFF, 0x80, 0x00, 0xFF}, // orange
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0xFF, 0x00, 0x00, 0xFF})
+	}
+	img.Set(0, 0, color.RGBA{0x00, 0x00, 0xF0, 0xFF})
+	img.Set(7, 7, color.White)
+
+	out := DitherPalette(img, 8, 8, palette, DefaultDither)
+	if got := out.ColorIndexAt(0, 0); got != 3 {
+		t.Errorf("blue pixel = colour %d, want 3", got)
+	}
+	if got := out.ColorIndexAt(4, 4); got != 4 {
+		t.Errorf("red pixel = colour %d, want 4", got)
+	}
+
+	// Orange comes out as orange, or red and yellow mixed, never as a
+	// colour far from it
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []byte{0xFF, 0xA0, 0x00, 0xFF})
+	}
+	for _, index := range DitherPalette(img, 8, 8, palette, DefaultDither).Pix {
+		if index != 4 && index != 5 && index != 6 {
+			t.Fatalf("orange dithered to colour %d", index)
+		}
+	}
+}
+
+func TestMixPalette(t *testing.T) {
+	a := color.Palette{color.RGBA{0x00, 0x40, 0xFF, 0xFF}}
+	b := color.Palette{color.RGBA{0xFF, 0x40, 0x00, 0xFF}}
+	if got := MixPalette(a, b, 0.5)[0]; got != (color.RGBA{0x80, 0x40, 0x80, 0xFF}) {
+		t.Errorf("halfway = %v", got)
+	}
+	if got := MixPalette(a, b, 2)[0]; got != b[0] {
+		t.Errorf("beyond 1 = %v, want %v", got, b[0])
+	}
+}
+
+func TestRedRule(t *testing.T) {
+	red := RedRules["red"]
+	tests := []struct {
+		c    color.RGBA
+		want bool
+	}{
+		{color.RGBA{0xE0, 0x20, 0x20, 0xFF}, true},
+		{color.RGBA{0xE0, 0x10, 0x60, 0xFF}, true},  // crimson, hue past 330
+		{color.RGBA{0xFF, 0xA0, 0x00, 0xFF}, false}, // orange
+		{color.RGBA{0x30, 0x08, 0x08, 0xFF}, false}, // too dark
+		{color.RGBA{0xC0, 0xA0, 0xA0, 0xFF}, false}, // too grey
+		{color.RGBA{0x80, 0x80, 0x80, 0xFF}, false},
+	}
+	for _, tt := range tests {
+		if got := red.IsRed(tt.c); got != tt.want {
+			t.Errorf("IsRed(%v) = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+	if !RedRules["warm"].IsRed(color.RGBA{0xFF, 0xA0, 0x00, 0xFF}) {
+		t.Errorf("the warm rule left orange out")
+	}
+
+	rule, err := ParseRedRule("hue=200-260, saturation=0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule != (RedRule{HueFrom: 200, HueTo: 260, MinSaturation: 0.5, MinValue: red.MinValue}) {
+		t.Errorf("ParseRedRule = %+v", rule)
+	}
+	for _, s := range []string{"blue", "hue=400-10", "saturation=2", "tint=0.5"} {
+		if _, err := ParseRedRule(s); err == nil {
+			t.Errorf("ParseRedRule(%q) succeeded", s)
+		}
+	}
+}
+
+func TestPackRed(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 1))
+	img.Set(0, 0, color.White)
+	img.Set(1, 0, color.RGBA{0xE0, 0x20, 0x20, 0xFF})
+	img.Set(2, 0, color.Black)
+	black, red := PackRed(img, 8, 1, RedRules["red"])
+	// Pixels left unset are transparent black
+	if black[0] != 0xC0 || red[0] != 0x40 {
+		t.Errorf("PackRed = %08b, %08b; want 11000000, 01000000", black[0], red[0])
+	}
+}
+
+func TestToGrayLuma(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	img.Set(0, 0, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+	img.Set(1, 0, color.RGBA{0x00, 0x00, 0xFF, 0xFF})
+
+	if gray := ToGray(img, Lumas["red"]); gray.Pix[0] != 0xFF || gray.Pix[1] != 0 {
+		t.Errorf("red channel = %v, want [255 0]", gray.Pix)
+	}
+	// The default weights match image/color's conversion
+	gray := ToGray(img, Lumas[DefaultLuma])
+	for x := 0; x < 2; x++ {
+		want := color.GrayModel.Convert(img.At(x, 0)).(color.Gray).Y
+		if d := int(gray.Pix[x]) - int(want); d < -1 || d > 1 {
+			t.Errorf("bt601 pixel %d = %d, want %d", x, gray.Pix[x], want)
+		}
+	}
+
+	luma, err := ParseLuma("2, 1, 1")
+	if err != nil || luma != (Luma{R: 0.5, G: 0.25, B: 0.25}) {
+		t.Errorf("ParseLuma = %+v, %v", luma, err)
+	}
+	for _, s := range []string{"bt2020", "1,2", "0,0,0", "1,-1,1"} {
+		if _, err := ParseLuma(s); err == nil {
+			t.Errorf("ParseLuma(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSharpen(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 5, 1))
+	for x, v := range []uint8{100, 100, 100, 200, 200} {
+		img.Set(x, 0, color.RGBA{v, v, v, 0xFF})
+	}
+	out := Sharpen(img, 1)
+	// Either side of the edge moves away from it; flat areas stay put
+	if out.Pix[0] != 100 || out.Pix[2*4] >= 100 || out.Pix[3*4] <= 200 || out.Pix[4*4+3] != 0xFF {
+		t.Errorf("Sharpen = %v", out.Pix)
+	}
+}
+
+func TestAutoLevels(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := 0; i < len(img.Pix); i += 4 {
+		v := uint8(150 + (i/4)%2*50) // a washed-out frame, 150 and 200
+		copy(img.Pix[i:], []uint8{v, v, v, 0xFF})
+	}
+	for _, mode := range []string{LevelsStretch, LevelsEqualize} {
+		out := AutoLevels(img, mode)
+		if out.Pix[0] != 0 || out.Pix[4] != 255 {
+			t.Errorf("%s: levels = %d, %d; want 0, 255", mode, out.Pix[0], out.Pix[4])
+		}
+	}
+
+	flat := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for _, mode := range []string{LevelsStretch, LevelsEqualize} {
+		if out := AutoLevels(flat, mode); out != flat {
+			t.Errorf("%s changed a flat frame", mode)
+		}
+	}
+	if ValidateLevels("curves") == nil {
+		t.Errorf("ValidateLevels accepted an unknown mode")
+	}
+}
+
+func TestToGrayLinear(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+	img.Set(1, 0, color.RGBA{0x80, 0x80, 0x80, 0xFF})
+	img.Set(2, 0, color.White)
+
+	luma := Lumas["bt709"]
+	gamma := ToGray(img, luma)
+	luma.Linear = true
+	linear := ToGray(img, luma)
+	// Pure red is 21% of white in linear light, about 127 once encoded,
+	// against 54 weighing the encoded values
+	if linear.Pix[0] < 120 || linear.Pix[0] > 130 || gamma.Pix[0] > 60 {
+		t.Errorf("red = %d linear, %d gamma-encoded", linear.Pix[0], gamma.Pix[0])
+	}
+	// Greys and white are unchanged
+	if linear.Pix[1] != 0x80 || linear.Pix[2] != 0xFF {
+		t.Errorf("grey = %d, white = %d", linear.Pix[1], linear.Pix[2])
+	}
+}
+
+func TestDitherKernels(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	for name, kernel := range Kernels {
+		for _, serpentine := range []bool{false, true} {
+			out := DitherGray(img, 32, 32, 2, Dither{Kernel: kernel, Serpentine: serpentine})
+			white := 0
+			for _, v := range out.Pix {
+				if v == 0xFF {
+					white++
+				}
+			}
+			// Mid-grey comes out about half white, except without
+			// diffusion, where it all rounds up
+			want := len(out.Pix) / 2
+			if name == "none" {
+				want = len(out.Pix)
+			}
+			if d := white - want; d < -len(out.Pix)/20 || d > len(out.Pix)/20 {
+				t.Errorf("%s (serpentine %v): %d of %d pixels white, want about %d", name, serpentine, white, len(out.Pix), want)
+			}
+		}
+	}
+	if _, err := ParseKernel("bayer"); err == nil {
+		t.Errorf("ParseKernel accepted an unknown kernel")
+	}
+}
+
+func TestGrayRowMatchesGrayModel(t *testing.T) {
+	rect := image.Rect(3, 2, 40, 9)
+	imgs := []image.Image{image.NewGray(rect), image.NewRGBA(rect), image.NewNRGBA(rect), image.NewRGBA64(rect)}
+	for _, img := range imgs {
+		set := img.(interface{ Set(x, y int, c color.Color) })
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				set.Set(x, y, color.NRGBA{uint8(x * 37), uint8(y * 91), uint8(x * y * 13), uint8(255 - x*5)})
+			}
+		}
+		row := make([]uint8, rect.Dx())
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			grayRow(img, rect.Min.X, y, row)
+			for x, got := range row {
+				if want := color.GrayModel.Convert(img.At(rect.Min.X+x, y)).(color.Gray).Y; got != want {
+					t.Fatalf("%T at (%d,%d): gray %d, want %d", img, rect.Min.X+x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkPack1Bit(b *testing.B) {
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 480))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	for i := 0; i < b.N; i++ {
+		Pack1Bit(img, 800, 480)
+	}
+}
+
+func TestPackReusesBuffer(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 2))
+	img.Pix[3] = 0xFF
+	buf := make([]byte, 8)
+	for i := range buf {
+		buf[i] = 0xAA
+	}
+	got := Pack1BitTo(buf, img, 16, 2)
+	if &got[0] != &buf[0] {
+		t.Errorf("Pack1BitTo allocated though the buffer was big enough")
+	}
+	if want := Pack1Bit(img, 16, 2); !bytes.Equal(got, want) {
+		t.Errorf("Pack1BitTo = %x, want %x", got, want)
+	}
+}
+
+func TestBandsMatchOneCore(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 800, 480))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7 / 3)
+	}
+	covered := make([]int, 480)
+	bands(800, 480, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			covered[y]++
+		}
+	})
+	for y, n := range covered {
+		if n != 1 {
+			t.Fatalf("row %d converted %d times, want once", y, n)
+		}
+	}
+
+	packed := Pack1Bit(img, 800, 480)
+	dithered := DitherGray(img, 800, 480, 4, Dither{Kernel: Kernels["none"]})
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 800; x++ {
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+			if set := packed[y*100+x/8]&(0x80>>(x%8)) != 0; set != (gray >= 128) {
+				t.Fatalf("pixel (%d,%d) of grey %d packed as %v", x, y, gray, set)
+			}
+			if want := uint8((int(gray) + 42) / 85 * 85); dithered.Pix[y*800+x] != want {
+				t.Fatalf("pixel (%d,%d) of grey %d dithered to %d, want %d", x, y, gray, dithered.Pix[y*800+x], want)
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package render
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// ScaleRect maps r from src coordinates to dst coordinates, rounding
+// outwards so the result covers every affected pixel
+func ScaleRect(r, src, dst image.Rectangle) image.Rectangle {
+	if src.Empty() {
+		return dst
+	}
+	return image.Rect(
+		dst.Min.X+(r.Min.X-src.Min.X)*dst.Dx()/src.Dx(),
+		dst.Min.Y+(r.Min.Y-src.Min.Y)*dst.Dy()/src.Dy(),
+		dst.Min.X+((r.Max.X-src.Min.X)*dst.Dx()+src.Dx()-1)/src.Dx(),
+		dst.Min.Y+((r.Max.Y-src.Min.Y)*dst.Dy()+src.Dy()-1)/src.Dy(),
+	)
+}
+
+// Scale scales img to fill targetRect with nearest-neighbour sampling, which
+// keeps 1-bit images crisp
+func Scale(img image.Image, targetRect image.Rectangle) *image.RGBA {
+	scaledImg := image.NewRGBA(targetRect)
+	ScaleTo(scaledImg, img)
+	return scaledImg
+}
+
+// ScaleTo is Scale into an existing frame, which img fills whatever it held
+// before, so a frame of the panel's size can be reused for every refresh
+func ScaleTo(dst *image.RGBA, img image.Image) {
+	draw.NearestNeighbor.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+}
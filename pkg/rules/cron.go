@@ -0,0 +1,200 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a standard five-field cron expression, minute hour
+// day-of-month month day-of-week, for example
+//
+//	*/5 9-17 * * mon-fri
+//	0 * * * *
+//
+// Fields take *, numbers, ranges (a-b), steps (*/n, a-b/n), and lists
+// separated by commas; months and weekdays may also be given by their first
+// three letters. As in cron, when both day fields are restricted a day
+// matching either one is enough.
+type CronSchedule struct {
+	Text string
+
+	minute, hour, dom, month, dow uint64 // bit n set when n matches
+	domAny, dowAny                bool
+}
+
+// cronField describes the values one cron field accepts
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names for min, min+1, ...
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// cronMacros are the shorthand expressions cron accepts
+var cronMacros = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// ParseCron parses a cron expression
+func ParseCron(text string) (*CronSchedule, error) {
+	text = strings.TrimSpace(text)
+	expr := text
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule %q, expected minute hour day-of-month month day-of-week", text)
+	}
+
+	s := &CronSchedule{Text: text}
+	bits := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		value, err := cronFields[i].parse(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", text, err)
+		}
+		*bits[i] = value
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parse parses one field into a bit set of the values it matches
+func (f cronField) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		rangeStr, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepStr, f.name)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rangeStr != "*" {
+			loStr, hiStr, isRange := strings.Cut(rangeStr, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// As in cron, a/n runs from a to the end of the field
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q in %s", rangeStr, f.name)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a single number or name in the field
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q, expected %d-%d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// dayMatches reports whether the schedule runs on t's day
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<t.Weekday()) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t the schedule matches, or the zero
+// time if there is none within a few years (e.g. 30 February)
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<t.Month()) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ParseSchedule parses cron expressions separated by semicolons
+func ParseSchedule(text string) ([]*CronSchedule, error) {
+	var schedule []*CronSchedule
+	for _, expr := range strings.Split(text, ";") {
+		if strings.TrimSpace(expr) == "" {
+			continue
+		}
+		s, err := ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, s)
+	}
+	return schedule, nil
+}
+
+// UntilScheduled returns how long until the first of the schedules next
+// matches after now, or 0 if none ever does
+func UntilScheduled(schedule []*CronSchedule, now time.Time) time.Duration {
+	var next time.Time
+	for _, s := range schedule {
+		if t := s.Next(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(now)
+}
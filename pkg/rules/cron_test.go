@@ -0,0 +1,62 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	tests := []struct {
+		expr, after, want string
+	}{
+		{"*/5 9-17 * * mon-fri", "2025-06-20 10:02", "2025-06-20 10:05"},
+		{"*/5 9-17 * * mon-fri", "2025-06-20 17:55", "2025-06-23 09:00"}, // Friday evening to Monday
+		{"0 * * * *", "2025-06-20 10:00", "2025-06-20 11:00"},
+		{"@daily", "2025-12-31 23:59", "2026-01-01 00:00"},
+		{"30 6 1,15 * *", "2025-06-02 08:00", "2025-06-15 06:30"},
+		{"0 12 1 * sun", "2025-06-02 08:00", "2025-06-08 12:00"}, // either day field
+		{"0 0 * feb 7", "2025-06-02 08:00", "2026-02-01 00:00"},  // 7 is Sunday
+		{"15-45/15 8 * * *", "2025-06-02 08:40", "2025-06-02 08:45"},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseCron(%q): %v", tt.expr, err)
+		}
+		after, _ := time.ParseInLocation("2006-01-02 15:04", tt.after, time.UTC)
+		if got := s.Next(after).Format("2006-01-02 15:04"); got != tt.want {
+			t.Errorf("%q after %s = %s, want %s", tt.expr, tt.after, got, tt.want)
+		}
+	}
+
+	s, _ := ParseCron("0 0 30 feb *")
+	if next := s.Next(time.Now()); !next.IsZero() {
+		t.Errorf("30 February matched %s", next)
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * * funday", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("ParseCron(%q) succeeded", expr)
+		}
+	}
+}
+
+func TestUntilScheduled(t *testing.T) {
+	schedule, err := ParseSchedule("*/5 9-17 * * *; 0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 20, 20, 10, 0, 0, time.UTC)
+	if got := UntilScheduled(schedule, now); got != 50*time.Minute {
+		t.Errorf("UntilScheduled in the evening = %s, want 50m", got)
+	}
+	now = time.Date(2025, 6, 20, 10, 1, 30, 0, time.UTC)
+	if got := UntilScheduled(schedule, now); got != 3*time.Minute+30*time.Second {
+		t.Errorf("UntilScheduled during the day = %s, want 3m30s", got)
+	}
+	if got := UntilScheduled(nil, now); got != 0 {
+		t.Errorf("UntilScheduled without a schedule = %s, want 0", got)
+	}
+}
@@ -0,0 +1,411 @@
+// Package rules decides what the frame shows and when: the content rules
+// ("when weekday 07:00-09:00 show transit"), cron refresh schedules, and the
+// sunrise and sunset times that night and day rules follow.
+package rules
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/panel"
+)
+
+// Rule is one line of the content rules, for example
+//
+//	when weekday 07:00-09:00 show transit
+//	when battery <20% interval 2h
+//	when offline show clock
+//	when 08:00-20:00 show clock mode fast
+//
+// A rule applies when all of its conditions hold. Rules are evaluated in
+// order each cycle and the first matching rule to set a given action wins.
+type Rule struct {
+	Text string
+
+	// Conditions
+	Days    map[time.Weekday]bool
+	Window  *TimeWindow
+	Battery *BatteryCondition
+	Online  *bool
+	Night   *bool // between sunset and sunrise at -location
+	Dim     *bool // the -light-sensor reads below -light-threshold
+
+	// Actions
+	Show     string
+	Interval time.Duration
+	Dark     *bool
+	Quiet    bool
+	Mode     string // panel refresh mode
+}
+
+// BatteryCondition compares the battery charge against a percentage
+type BatteryCondition struct {
+	Below   bool
+	Percent int
+}
+
+// State is what rules are evaluated against
+type State struct {
+	Now        time.Time
+	Online     bool
+	HasBattery bool
+	Battery    int
+	HasSun     bool // Night is known because a location is set
+	Night      bool
+	HasLight   bool // Dim is known because a light sensor is read
+	Dim        bool
+}
+
+// Decision is the combined outcome of all matching rules
+type Decision struct {
+	Show     string
+	Interval time.Duration
+	Dark     *bool
+	Quiet    bool
+	Mode     string
+}
+
+// TimeWindow is a daily time range such as 06:30-09:00. Windows whose end is
+// before their start wrap past midnight.
+type TimeWindow struct {
+	Start int // minutes after midnight
+	End   int
+}
+
+var weekdayNames = map[string][]time.Weekday{
+	"daily":   {time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday},
+	"weekday": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend": {time.Saturday, time.Sunday},
+	"mon":     {time.Monday},
+	"tue":     {time.Tuesday},
+	"wed":     {time.Wednesday},
+	"thu":     {time.Thursday},
+	"fri":     {time.Friday},
+	"sat":     {time.Saturday},
+	"sun":     {time.Sunday},
+}
+
+// ParseList parses rules separated by newlines or semicolons. Blank lines
+// and lines starting with # are ignored.
+func ParseList(text string) ([]Rule, error) {
+	var rules []Rule
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == ';' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// LoadFile reads and parses a rules file
+func LoadFile(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rules file: %v", err)
+	}
+	return ParseList(string(data))
+}
+
+// Parse parses a single "when <conditions> <actions>" rule
+func Parse(text string) (Rule, error) {
+	rule := Rule{Text: text}
+	tokens := strings.Fields(text)
+	if len(tokens) == 0 || tokens[0] != "when" {
+		return rule, fmt.Errorf("rule %q must start with \"when\"", text)
+	}
+
+	i := 1
+	next := func() (string, error) {
+		if i >= len(tokens) {
+			return "", fmt.Errorf("rule %q is incomplete", text)
+		}
+		i++
+		return tokens[i-1], nil
+	}
+
+	hasAction := false
+	for i < len(tokens) {
+		token, _ := next()
+		switch {
+		// Conditions
+		case token == "always":
+		case token == "online" || token == "offline":
+			online := token == "online"
+			rule.Online = &online
+		case token == "night" || token == "day":
+			night := token == "night"
+			rule.Night = &night
+		case token == "dim" || token == "bright":
+			dim := token == "dim"
+			rule.Dim = &dim
+		case token == "battery":
+			comparison, err := next()
+			if err != nil {
+				return rule, err
+			}
+			// Accept both "battery <20%" and "battery < 20%"
+			if comparison == "<" || comparison == ">" {
+				value, err := next()
+				if err != nil {
+					return rule, err
+				}
+				comparison += value
+			}
+			condition, err := parseBatteryCondition(comparison)
+			if err != nil {
+				return rule, fmt.Errorf("rule %q: %v", text, err)
+			}
+			rule.Battery = condition
+		case strings.Contains(token, ":"):
+			window, err := ParseTimeWindow(token)
+			if err != nil {
+				return rule, fmt.Errorf("rule %q: %v", text, err)
+			}
+			rule.Window = window
+		case isDayList(token):
+			if rule.Days == nil {
+				rule.Days = make(map[time.Weekday]bool)
+			}
+			for _, name := range strings.Split(token, ",") {
+				for _, day := range weekdayNames[name] {
+					rule.Days[day] = true
+				}
+			}
+
+		// Actions
+		case token == "show":
+			screen, err := next()
+			if err != nil {
+				return rule, err
+			}
+			rule.Show = screen
+			hasAction = true
+		case token == "interval":
+			value, err := next()
+			if err != nil {
+				return rule, err
+			}
+			interval, err := time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return rule, fmt.Errorf("rule %q: invalid interval %q", text, value)
+			}
+			rule.Interval = interval
+			hasAction = true
+		case token == "dark":
+			value, err := next()
+			if err != nil {
+				return rule, err
+			}
+			if value != "on" && value != "off" {
+				return rule, fmt.Errorf("rule %q: dark must be on or off", text)
+			}
+			dark := value == "on"
+			rule.Dark = &dark
+			hasAction = true
+		case token == "quiet":
+			rule.Quiet = true
+			hasAction = true
+		case token == "mode":
+			value, err := next()
+			if err != nil {
+				return rule, err
+			}
+			if err := panel.ValidateMode(value); err != nil {
+				return rule, fmt.Errorf("rule %q: %v", text, err)
+			}
+			rule.Mode = value
+			hasAction = true
+
+		default:
+			return rule, fmt.Errorf("rule %q: unknown word %q", text, token)
+		}
+	}
+
+	if !hasAction {
+		return rule, fmt.Errorf("rule %q has no action (show, interval, dark, quiet, or mode)", text)
+	}
+	return rule, nil
+}
+
+// isDayList reports whether token is a comma-separated list of day names
+func isDayList(token string) bool {
+	for _, name := range strings.Split(token, ",") {
+		if _, ok := weekdayNames[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseDays parses a comma-separated list of day names, such as "weekday"
+// or "mon,wed,fri", into the days it covers
+func ParseDays(list string) (map[time.Weekday]bool, error) {
+	if !isDayList(list) {
+		return nil, fmt.Errorf("invalid days %q", list)
+	}
+	days := make(map[time.Weekday]bool)
+	for _, name := range strings.Split(list, ",") {
+		for _, day := range weekdayNames[name] {
+			days[day] = true
+		}
+	}
+	return days, nil
+}
+
+// parseBatteryCondition parses "<20%" or ">50%"
+func parseBatteryCondition(s string) (*BatteryCondition, error) {
+	if len(s) < 2 || (s[0] != '<' && s[0] != '>') {
+		return nil, fmt.Errorf("invalid battery condition %q, expected <N%% or >N%%", s)
+	}
+	percent, err := strconv.Atoi(strings.TrimSuffix(s[1:], "%"))
+	if err != nil || percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("invalid battery percentage %q", s[1:])
+	}
+	return &BatteryCondition{Below: s[0] == '<', Percent: percent}, nil
+}
+
+// Matches reports whether all of the rule's conditions hold
+func (r *Rule) Matches(state State) bool {
+	if r.Days != nil && !r.Days[state.Now.Weekday()] {
+		return false
+	}
+	if r.Window != nil && !r.Window.Contains(state.Now) {
+		return false
+	}
+	if r.Online != nil && *r.Online != state.Online {
+		return false
+	}
+	if r.Night != nil && (!state.HasSun || *r.Night != state.Night) {
+		return false
+	}
+	if r.Dim != nil && (!state.HasLight || *r.Dim != state.Dim) {
+		return false
+	}
+	if r.Battery != nil {
+		if !state.HasBattery {
+			return false
+		}
+		if r.Battery.Below && state.Battery >= r.Battery.Percent {
+			return false
+		}
+		if !r.Battery.Below && state.Battery <= r.Battery.Percent {
+			return false
+		}
+	}
+	return true
+}
+
+// Evaluate combines the actions of all matching rules
+func Evaluate(rules []Rule, state State) Decision {
+	var decision Decision
+	for _, rule := range rules {
+		if !rule.Matches(state) {
+			continue
+		}
+		if decision.Show == "" {
+			decision.Show = rule.Show
+		}
+		if decision.Interval == 0 {
+			decision.Interval = rule.Interval
+		}
+		if decision.Dark == nil {
+			decision.Dark = rule.Dark
+		}
+		if decision.Mode == "" {
+			decision.Mode = rule.Mode
+		}
+		decision.Quiet = decision.Quiet || rule.Quiet
+	}
+	return decision
+}
+
+// Location is where the frame is, in degrees with east positive, for night
+// and day rules
+type Location struct {
+	Latitude, Longitude float64
+}
+
+// UntilNextChange returns how long until any rule's time window starts or
+// ends, or the sun rises or sets at location for a day or night rule, so a
+// refresh can be brought forward to honour it. It returns 0 when no rule
+// depends on the time of day. Day and night rules are left out when
+// location is nil.
+func UntilNextChange(rules []Rule, location *Location, now time.Time) time.Duration {
+	var soonest time.Duration
+	for _, rule := range rules {
+		if rule.Night != nil && location != nil {
+			if d := UntilSunChange(now, location.Latitude, location.Longitude); d > 0 && (soonest == 0 || d < soonest) {
+				soonest = d
+			}
+		}
+		if rule.Window == nil {
+			continue
+		}
+		for _, edge := range []int{rule.Window.Start, rule.Window.End} {
+			if d := untilClockTime(edge, now); soonest == 0 || d < soonest {
+				soonest = d
+			}
+		}
+	}
+	return soonest
+}
+
+// untilClockTime returns how long until the next time the clock reads minute
+// (minutes after midnight)
+func untilClockTime(minute int, now time.Time) time.Duration {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	at := midnight.Add(time.Duration(minute) * time.Minute)
+	if !at.After(now) {
+		at = at.AddDate(0, 0, 1)
+	}
+	return at.Sub(now)
+}
+
+// ParseTimeWindow parses an HH:MM-HH:MM range
+func ParseTimeWindow(s string) (*TimeWindow, error) {
+	startStr, endStr, found := strings.Cut(s, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", s)
+	}
+	start, err := ParseClockTime(startStr)
+	if err != nil {
+		return nil, err
+	}
+	end, err := ParseClockTime(endStr)
+	if err != nil {
+		return nil, err
+	}
+	return &TimeWindow{Start: start, End: end}, nil
+}
+
+// ParseClockTime parses HH:MM into minutes after midnight
+func ParseClockTime(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t falls inside the window
+func (w *TimeWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// String formats the window as HH:MM-HH:MM
+func (w *TimeWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
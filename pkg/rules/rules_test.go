@@ -0,0 +1,75 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	list, err := ParseList(`
+# Mornings on weekdays, the clock when offline
+when weekday 07:00-09:00 show transit interval 5m
+when offline show clock
+when battery <20% interval 2h dark on
+when always show clock mode fast`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monday := time.Date(2025, 6, 23, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		state State
+		want  Decision
+	}{
+		{"first show wins", State{Now: monday, Online: true}, Decision{Show: "transit", Interval: 5 * time.Minute, Mode: "fast"}},
+		{"window closed", State{Now: monday.Add(2 * time.Hour), Online: true}, Decision{Show: "clock", Mode: "fast"}},
+		{"weekend", State{Now: monday.AddDate(0, 0, -1), Online: false}, Decision{Show: "clock", Mode: "fast"}},
+		{"unknown battery", State{Now: monday.Add(2 * time.Hour), Online: true, Battery: 5}, Decision{Show: "clock", Mode: "fast"}},
+		{"low battery", State{Now: monday.Add(2 * time.Hour), Online: true, HasBattery: true, Battery: 5}, Decision{Show: "clock", Interval: 2 * time.Hour, Mode: "fast"}},
+	}
+	for _, tt := range tests {
+		got := Evaluate(list, tt.state)
+		dark := got.Dark
+		got.Dark = nil
+		if got != tt.want {
+			t.Errorf("%s: Evaluate = %+v, want %+v", tt.name, got, tt.want)
+		}
+		if wantDark := tt.want.Interval == 2*time.Hour; (dark != nil && *dark) != wantDark {
+			t.Errorf("%s: dark = %v, want %v", tt.name, dark != nil, wantDark)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"show clock",
+		"when weekday",
+		"when 07:00 show clock",
+		"when battery <120% quiet",
+		"when always dark maybe",
+		"when always mode slow",
+		"when sometimes show clock",
+	} {
+		if _, err := Parse(text); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestUntilNextChange(t *testing.T) {
+	list, err := ParseList("when 22:00-06:30 quiet; when night dark on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 6, 21, 12, 0, 0, 0, time.UTC)
+	// Without a location only the window counts
+	if got := UntilNextChange(list, nil, now); got != 10*time.Hour {
+		t.Errorf("UntilNextChange without a location = %s, want 10h", got)
+	}
+	// Sunset in London comes first
+	london := &Location{Latitude: 51.51, Longitude: -0.13}
+	if got := UntilNextChange(list, london, now); got <= 8*time.Hour || got >= 9*time.Hour {
+		t.Errorf("UntilNextChange in London = %s, want sunset, a little over 8h away", got)
+	}
+}
@@ -0,0 +1,67 @@
+package rules
+
+import (
+	"math"
+	"time"
+)
+
+// SunTimes returns sunrise and sunset on the local day of t at the given
+// latitude and longitude (degrees, east positive), using the sunrise
+// equation, which is good to a minute or two. Where the sun does not rise or
+// set that day, rise and set are zero and polarDay says which it is.
+func SunTimes(t time.Time, lat, lon float64) (rise, set time.Time, polarDay bool) {
+	const j2000 = 2451545.0
+	rad := math.Pi / 180
+
+	// Days since 2000-01-01 12:00 UTC; longitude then moves this to the
+	// local solar noon of the same calendar day
+	noon := time.Date(t.Year(), t.Month(), t.Day(), 12, 0, 0, 0, time.UTC)
+	n := math.Round(float64(noon.Unix())/86400 + 2440587.5 - j2000)
+
+	// Mean solar time, anomaly, and the equation of the centre
+	jStar := n + 0.0009 - lon/360
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	c := 1.9148*math.Sin(m*rad) + 0.0200*math.Sin(2*m*rad) + 0.0003*math.Sin(3*m*rad)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := j2000 + jStar + 0.0053*math.Sin(m*rad) - 0.0069*math.Sin(2*lambda*rad)
+
+	// Hour angle at which the sun's upper edge, refracted, meets the horizon
+	sinDecl := math.Sin(lambda*rad) * math.Sin(23.4397*rad)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosOmega := (math.Sin(-0.833*rad) - math.Sin(lat*rad)*sinDecl) / (math.Cos(lat*rad) * cosDecl)
+	if cosOmega > 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	if cosOmega < -1 {
+		return time.Time{}, time.Time{}, true
+	}
+	omega := math.Acos(cosOmega) / rad
+
+	fromJulian := func(j float64) time.Time {
+		return time.Unix(int64(math.Round((j-2440587.5)*86400)), 0).In(t.Location())
+	}
+	return fromJulian(transit - omega/360), fromJulian(transit + omega/360), false
+}
+
+// IsNight reports whether the sun is down at t
+func IsNight(t time.Time, lat, lon float64) bool {
+	rise, set, polarDay := SunTimes(t, lat, lon)
+	if rise.IsZero() {
+		return !polarDay
+	}
+	return t.Before(rise) || !t.Before(set)
+}
+
+// UntilSunChange returns how long until the next sunrise or sunset after t,
+// or 0 if there is none in the next two days
+func UntilSunChange(t time.Time, lat, lon float64) time.Duration {
+	for day := 0; day < 3; day++ {
+		rise, set, _ := SunTimes(t.AddDate(0, 0, day), lat, lon)
+		for _, edge := range []time.Time{rise, set} {
+			if !edge.IsZero() && edge.After(t) {
+				return edge.Sub(t)
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+package rules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunTimes(t *testing.T) {
+	tests := []struct {
+		zone      string
+		lat, lon  float64
+		date      string
+		rise, set string // local, from published tables
+	}{
+		{"Europe/London", 51.51, -0.13, "2025-06-21", "04:43", "21:21"},
+		{"Europe/London", 51.51, -0.13, "2025-12-21", "08:04", "15:53"},
+		{"America/Los_Angeles", 34.05, -118.24, "2025-06-21", "05:42", "20:08"},
+		{"Australia/Sydney", -33.87, 151.21, "2025-06-21", "07:00", "16:54"},
+	}
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Skipf("no time zone data: %v", err)
+		}
+		day, _ := time.ParseInLocation("2006-01-02 15:04", tt.date+" 12:00", loc)
+		rise, set, _ := SunTimes(day, tt.lat, tt.lon)
+		for _, c := range []struct {
+			name string
+			got  time.Time
+			want string
+		}{{"sunrise", rise, tt.rise}, {"sunset", set, tt.set}} {
+			want, _ := time.ParseInLocation("2006-01-02 15:04", tt.date+" "+c.want, loc)
+			if d := c.got.Sub(want); d < -3*time.Minute || d > 3*time.Minute {
+				t.Errorf("%s %s %s = %s, want about %s", tt.zone, tt.date, c.name, c.got.Format("2006-01-02 15:04"), c.want)
+			}
+		}
+		if !IsNight(rise.Add(-time.Minute), tt.lat, tt.lon) || IsNight(rise.Add(time.Minute), tt.lat, tt.lon) {
+			t.Errorf("%s %s: IsNight wrong around sunrise", tt.zone, tt.date)
+		}
+		if d := UntilSunChange(day, tt.lat, tt.lon); d != set.Sub(day) {
+			t.Errorf("%s %s: UntilSunChange at noon = %s, want time to sunset %s", tt.zone, tt.date, d, set.Sub(day))
+		}
+	}
+}
+
+func TestSunTimesPolar(t *testing.T) {
+	// Tromsø has midnight sun in June and polar night in December
+	summer := time.Date(2025, 6, 21, 12, 0, 0, 0, time.UTC)
+	if rise, _, polarDay := SunTimes(summer, 69.65, 18.96); !rise.IsZero() || !polarDay || IsNight(summer, 69.65, 18.96) {
+		t.Error("expected midnight sun in Tromsø in June")
+	}
+	winter := time.Date(2025, 12, 21, 12, 0, 0, 0, time.UTC)
+	if rise, _, polarDay := SunTimes(winter, 69.65, 18.96); !rise.IsZero() || polarDay || !IsNight(winter, 69.65, 18.96) {
+		t.Error("expected polar night in Tromsø in December")
+	}
+}
@@ -0,0 +1,69 @@
+// Package sensor reads the sensors a frame can carry: ambient light sensors
+// (BH1750 and TSL2561) and battery monitors (INA219) over I2C, and PiSugar
+// batteries through their server. I2C devices are reached through the
+// kernel's i2c-dev interface, /dev/i2c-N, with no other drivers.
+package sensor
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// i2cSlave is the i2c-dev ioctl selecting the address of the device to talk
+// to, from linux/i2c-dev.h
+const i2cSlave = 0x0703
+
+// I2CDevice is one device on an I2C bus
+type I2CDevice struct {
+	Bus  int
+	Addr uint16
+	file *os.File
+}
+
+// OpenI2C opens the device at addr on /dev/i2c-<bus>
+func OpenI2C(bus int, addr uint16) (*I2CDevice, error) {
+	path := fmt.Sprintf("/dev/i2c-%d", bus)
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%s not found (enable I2C with dtparam=i2c_arm=on)", path)
+		}
+		return nil, fmt.Errorf("error opening %s: %v", path, err)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(addr)); errno != 0 {
+		f.Close()
+		return nil, fmt.Errorf("error selecting I2C address %#02x on %s: %v", addr, path, errno)
+	}
+	return &I2CDevice{Bus: bus, Addr: addr, file: f}, nil
+}
+
+// Write sends data to the device
+func (d *I2CDevice) Write(data ...byte) error {
+	if _, err := d.file.Write(data); err != nil {
+		return fmt.Errorf("error writing to I2C device %#02x: %v", d.Addr, err)
+	}
+	return nil
+}
+
+// Read reads n bytes from the device
+func (d *I2CDevice) Read(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := d.file.Read(buf); err != nil {
+		return nil, fmt.Errorf("error reading from I2C device %#02x: %v", d.Addr, err)
+	}
+	return buf, nil
+}
+
+// ReadReg writes reg and reads n bytes back
+func (d *I2CDevice) ReadReg(reg byte, n int) ([]byte, error) {
+	if err := d.Write(reg); err != nil {
+		return nil, err
+	}
+	return d.Read(n)
+}
+
+// Close releases the device
+func (d *I2CDevice) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,95 @@
+package sensor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultINA219Addr is the INA219's address with both address pins low.
+// Waveshare's UPS HATs set theirs to 0x42 or 0x43.
+const DefaultINA219Addr = 0x40
+
+// INA219 registers
+const (
+	ina219Shunt = 0x01
+	ina219Bus   = 0x02
+)
+
+// INA219 is a TI INA219 current and power monitor across a battery, as on
+// Waveshare's UPS HATs, read at its power-on configuration
+type INA219 struct {
+	dev *I2CDevice
+}
+
+// ParseINA219 parses BUS[:ADDR], either part of which may be empty for the
+// defaults: bus 1 and DefaultINA219Addr
+func ParseINA219(s string) (bus int, addr uint16, err error) {
+	var parts []string
+	if s != "" {
+		parts = strings.Split(s, ":")
+	}
+	if len(parts) > 2 {
+		return 0, 0, fmt.Errorf("invalid INA219 %q, expected BUS[:ADDR]", s)
+	}
+	return parseBusAddr(parts, 1, DefaultINA219Addr)
+}
+
+// OpenINA219 opens the INA219 at addr on /dev/i2c-<bus>
+func OpenINA219(bus int, addr uint16) (*INA219, error) {
+	dev, err := OpenI2C(bus, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &INA219{dev: dev}
+	if _, err := s.BusVoltage(); err != nil {
+		dev.Close()
+		return nil, fmt.Errorf("INA219 not responding: %v", err)
+	}
+	return s, nil
+}
+
+// BusVoltage returns the voltage on the load side of the shunt, that of the
+// battery
+func (s *INA219) BusVoltage() (float64, error) {
+	data, err := s.dev.ReadReg(ina219Bus, 2)
+	if err != nil {
+		return 0, err
+	}
+	// The top 13 bits count 4mV steps
+	return float64((uint16(data[0])<<8|uint16(data[1]))>>3) * 0.004, nil
+}
+
+// ShuntVoltage returns the voltage across the shunt, which is positive while
+// current flows from the supply side to the load side
+func (s *INA219) ShuntVoltage() (float64, error) {
+	data, err := s.dev.ReadReg(ina219Shunt, 2)
+	if err != nil {
+		return 0, err
+	}
+	// Signed, in 10µV steps
+	return float64(int16(uint16(data[0])<<8|uint16(data[1]))) * 0.00001, nil
+}
+
+// Close releases the device
+func (s *INA219) Close() error {
+	return s.dev.Close()
+}
+
+// lipoCurve is the resting voltage of a single lithium polymer cell at each
+// tenth of its charge, from empty to full
+var lipoCurve = [11]float64{3.27, 3.61, 3.69, 3.71, 3.73, 3.75, 3.77, 3.79, 3.80, 3.82, 4.20}
+
+// LiPoPercent estimates the charge of a single lithium polymer cell from its
+// voltage. Under load, or while charging, the estimate is rough.
+func LiPoPercent(volts float64) int {
+	if volts <= lipoCurve[0] {
+		return 0
+	}
+	for i := 1; i < len(lipoCurve); i++ {
+		if volts < lipoCurve[i] {
+			fraction := (volts - lipoCurve[i-1]) / (lipoCurve[i] - lipoCurve[i-1])
+			return int((float64(i-1) + fraction) * 10)
+		}
+	}
+	return 100
+}
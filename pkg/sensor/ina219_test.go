@@ -0,0 +1,44 @@
+package sensor
+
+import "testing"
+
+func TestParseINA219(t *testing.T) {
+	tests := []struct {
+		in   string
+		bus  int
+		addr uint16
+	}{
+		{"", 1, DefaultINA219Addr},
+		{"0", 0, DefaultINA219Addr},
+		{"1:0x42", 1, 0x42},
+	}
+	for _, tt := range tests {
+		bus, addr, err := ParseINA219(tt.in)
+		if err != nil || bus != tt.bus || addr != tt.addr {
+			t.Errorf("ParseINA219(%q) = %d, %#x, %v, want %d, %#x", tt.in, bus, addr, err, tt.bus, tt.addr)
+		}
+	}
+	if _, _, err := ParseINA219("1:0x42:3"); err == nil {
+		t.Errorf("ParseINA219 accepted three parts")
+	}
+}
+
+func TestLiPoPercent(t *testing.T) {
+	tests := []struct {
+		volts float64
+		want  int
+	}{
+		{3.0, 0},
+		{3.27, 0},
+		{3.70, 25},
+		{3.80, 80},
+		{4.01, 95},
+		{4.20, 100},
+		{4.35, 100},
+	}
+	for _, tt := range tests {
+		if got := LiPoPercent(tt.volts); got != tt.want {
+			t.Errorf("LiPoPercent(%v) = %d, want %d", tt.volts, got, tt.want)
+		}
+	}
+}
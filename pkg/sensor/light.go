@@ -0,0 +1,205 @@
+package sensor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LightSensor measures ambient light
+type LightSensor interface {
+	// Lux returns the illuminance
+	Lux() (float64, error)
+	// Close releases the device
+	Close() error
+}
+
+// Light sensor models, and the I2C addresses they answer on by default
+const (
+	BH1750  = "bh1750"
+	TSL2561 = "tsl2561"
+
+	bh1750Addr  = 0x23
+	tsl2561Addr = 0x39
+)
+
+// LightConfig says which light sensor to read and where it is
+type LightConfig struct {
+	Model string
+	Bus   int
+	Addr  uint16
+}
+
+// ParseLightConfig parses MODEL[:BUS[:ADDR]], such as bh1750, or
+// tsl2561:1:0x49. The bus defaults to 1, the one on a Raspberry Pi's
+// header, and the address to the model's usual one.
+func ParseLightConfig(s string) (LightConfig, error) {
+	parts := strings.Split(s, ":")
+	config := LightConfig{Model: strings.ToLower(parts[0]), Bus: 1}
+	switch config.Model {
+	case BH1750:
+		config.Addr = bh1750Addr
+	case TSL2561:
+		config.Addr = tsl2561Addr
+	default:
+		return config, fmt.Errorf("unknown light sensor %q, expected %s or %s", parts[0], BH1750, TSL2561)
+	}
+	if len(parts) > 3 {
+		return config, fmt.Errorf("invalid light sensor %q, expected MODEL[:BUS[:ADDR]]", s)
+	}
+	var err error
+	config.Bus, config.Addr, err = parseBusAddr(parts[1:], config.Bus, config.Addr)
+	return config, err
+}
+
+// parseBusAddr parses the optional BUS and ADDR parts of a sensor setting,
+// returning bus and addr for those left out
+func parseBusAddr(parts []string, bus int, addr uint16) (int, uint16, error) {
+	if len(parts) > 0 {
+		n, err := strconv.Atoi(parts[0])
+		if err != nil || n < 0 {
+			return bus, addr, fmt.Errorf("invalid I2C bus %q", parts[0])
+		}
+		bus = n
+	}
+	if len(parts) > 1 {
+		n, err := strconv.ParseUint(parts[1], 0, 7)
+		if err != nil {
+			return bus, addr, fmt.Errorf("invalid I2C address %q", parts[1])
+		}
+		addr = uint16(n)
+	}
+	return bus, addr, nil
+}
+
+// OpenLightSensor opens and powers up the sensor config describes
+func OpenLightSensor(config LightConfig) (LightSensor, error) {
+	dev, err := OpenI2C(config.Bus, config.Addr)
+	if err != nil {
+		return nil, err
+	}
+	var sensor LightSensor
+	switch config.Model {
+	case BH1750:
+		sensor, err = newBH1750(dev)
+	case TSL2561:
+		sensor, err = newTSL2561(dev)
+	default:
+		err = fmt.Errorf("unknown light sensor %q", config.Model)
+	}
+	if err != nil {
+		dev.Close()
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// bh1750 is a ROHM BH1750 measuring continuously at 1 lux resolution
+type bh1750 struct {
+	dev *I2CDevice
+}
+
+// BH1750 instructions
+const (
+	bh1750PowerOn        = 0x01
+	bh1750ContinuousHRes = 0x10
+)
+
+func newBH1750(dev *I2CDevice) (*bh1750, error) {
+	if err := dev.Write(bh1750PowerOn); err != nil {
+		return nil, fmt.Errorf("BH1750 not responding: %v", err)
+	}
+	if err := dev.Write(bh1750ContinuousHRes); err != nil {
+		return nil, err
+	}
+	// The first measurement takes up to 180ms
+	time.Sleep(180 * time.Millisecond)
+	return &bh1750{dev: dev}, nil
+}
+
+// Lux reads the latest measurement
+func (s *bh1750) Lux() (float64, error) {
+	data, err := s.dev.Read(2)
+	if err != nil {
+		return 0, err
+	}
+	return float64(uint16(data[0])<<8|uint16(data[1])) / 1.2, nil
+}
+
+// Close releases the device
+func (s *bh1750) Close() error {
+	return s.dev.Close()
+}
+
+// tsl2561 is a TAOS TSL2561 integrating for 402ms at 1x gain
+type tsl2561 struct {
+	dev *I2CDevice
+}
+
+// TSL2561 registers, addressed with the command bit set, and the word bit
+// for reading both bytes of a channel
+const (
+	tsl2561Command = 0x80
+	tsl2561Word    = 0x20
+	tsl2561Control = 0x00
+	tsl2561Timing  = 0x01
+	tsl2561Data0   = 0x0C
+	tsl2561Data1   = 0x0E
+	tsl2561PowerOn = 0x03
+	tsl2561Int402  = 0x02
+)
+
+func newTSL2561(dev *I2CDevice) (*tsl2561, error) {
+	if err := dev.Write(tsl2561Command|tsl2561Control, tsl2561PowerOn); err != nil {
+		return nil, fmt.Errorf("TSL2561 not responding: %v", err)
+	}
+	if err := dev.Write(tsl2561Command|tsl2561Timing, tsl2561Int402); err != nil {
+		return nil, err
+	}
+	// Wait for the first integration to finish
+	time.Sleep(410 * time.Millisecond)
+	return &tsl2561{dev: dev}, nil
+}
+
+// Lux reads both channels, broadband and infrared, and combines them
+func (s *tsl2561) Lux() (float64, error) {
+	var channels [2]float64
+	for i, reg := range []byte{tsl2561Data0, tsl2561Data1} {
+		data, err := s.dev.ReadReg(tsl2561Command|tsl2561Word|reg, 2)
+		if err != nil {
+			return 0, err
+		}
+		channels[i] = float64(uint16(data[1])<<8 | uint16(data[0]))
+	}
+	return tsl2561Lux(channels[0], channels[1]), nil
+}
+
+// tsl2561Lux is the datasheet's empirical formula for the T, FN, and CL
+// packages, from channel counts at 402ms and 1x gain; it assumes 16x gain,
+// so the counts are scaled up to match
+func tsl2561Lux(ch0, ch1 float64) float64 {
+	if ch0 == 0 {
+		return 0
+	}
+	ch0, ch1 = ch0*16, ch1*16
+	var lux float64
+	switch ratio := ch1 / ch0; {
+	case ratio <= 0.5:
+		lux = 0.0304*ch0 - 0.062*ch0*math.Pow(ratio, 1.4)
+	case ratio <= 0.61:
+		lux = 0.0224*ch0 - 0.031*ch1
+	case ratio <= 0.80:
+		lux = 0.0128*ch0 - 0.0153*ch1
+	case ratio <= 1.30:
+		lux = 0.00146*ch0 - 0.00112*ch1
+	}
+	return max(lux, 0)
+}
+
+// Close powers the sensor down and releases it
+func (s *tsl2561) Close() error {
+	s.dev.Write(tsl2561Command|tsl2561Control, 0)
+	return s.dev.Close()
+}
@@ -0,0 +1,46 @@
+package sensor
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLightConfig(t *testing.T) {
+	tests := []struct {
+		in   string
+		want LightConfig
+	}{
+		{"bh1750", LightConfig{Model: BH1750, Bus: 1, Addr: 0x23}},
+		{"TSL2561:0", LightConfig{Model: TSL2561, Bus: 0, Addr: 0x39}},
+		{"tsl2561:1:0x49", LightConfig{Model: TSL2561, Bus: 1, Addr: 0x49}},
+	}
+	for _, tt := range tests {
+		got, err := ParseLightConfig(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLightConfig(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "veml7700", "bh1750:x", "bh1750:1:0x80", "bh1750:1:2:3"} {
+		if _, err := ParseLightConfig(in); err == nil {
+			t.Errorf("ParseLightConfig(%q) succeeded", in)
+		}
+	}
+}
+
+func TestTSL2561Lux(t *testing.T) {
+	tests := []struct {
+		ch0, ch1 float64
+		want     float64
+	}{
+		{0, 0, 0},
+		{1000, 0, 486.4},
+		{1000, 550, 85.6}, // 0.0224*16000 - 0.031*8800
+		{1000, 2000, 0},   // infrared only
+		{100, 70, 3.344},  // 0.0128*1600 - 0.0153*1120
+	}
+	for _, tt := range tests {
+		if got := tsl2561Lux(tt.ch0, tt.ch1); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("tsl2561Lux(%v, %v) = %v, want %v", tt.ch0, tt.ch1, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package sensor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPiSugarAddr is where pisugar-server takes commands
+const DefaultPiSugarAddr = "127.0.0.1:8423"
+
+// PiSugar reads a PiSugar battery through pisugar-server, which owns the
+// board's I2C connection
+type PiSugar struct {
+	Addr string // DefaultPiSugarAddr if empty
+}
+
+// PiSugarStatus is a PiSugar battery reading
+type PiSugarStatus struct {
+	Percent  float64
+	Voltage  float64
+	Charging bool
+}
+
+// Status asks the server for the battery's charge, voltage, and whether it
+// is charging
+func (p *PiSugar) Status() (PiSugarStatus, error) {
+	var status PiSugarStatus
+	addr := p.Addr
+	if addr == "" {
+		addr = DefaultPiSugarAddr
+	}
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return status, fmt.Errorf("error connecting to pisugar-server: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	replies := bufio.NewReader(conn)
+	get := func(name string) (string, error) {
+		if _, err := fmt.Fprintf(conn, "get %s\n", name); err != nil {
+			return "", fmt.Errorf("error querying pisugar-server: %v", err)
+		}
+		line, err := replies.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading from pisugar-server: %v", err)
+		}
+		return parsePiSugarReply(name, line)
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{{"battery", &status.Percent}, {"battery_v", &status.Voltage}} {
+		reply, err := get(field.name)
+		if err != nil {
+			return status, err
+		}
+		if *field.value, err = strconv.ParseFloat(reply, 64); err != nil {
+			return status, fmt.Errorf("invalid %s from pisugar-server: %q", field.name, reply)
+		}
+	}
+	reply, err := get("battery_charging")
+	if err != nil {
+		return status, err
+	}
+	status.Charging = reply == "true"
+	return status, nil
+}
+
+// parsePiSugarReply returns the value in a "name: value" reply
+func parsePiSugarReply(name, line string) (string, error) {
+	key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+	if !found || key != name {
+		return "", fmt.Errorf("unexpected reply from pisugar-server to get %s: %q", name, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(value), nil
+}
@@ -0,0 +1,50 @@
+package sensor
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+)
+
+// fakePiSugar answers get commands as pisugar-server does
+func fakePiSugar(t *testing.T, values map[string]string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				var name string
+				fmt.Sscanf(scanner.Text(), "get %s", &name)
+				fmt.Fprintf(conn, "%s: %s\n", name, values[name])
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestPiSugarStatus(t *testing.T) {
+	addr := fakePiSugar(t, map[string]string{"battery": "84.5", "battery_v": "4.02", "battery_charging": "true"})
+	status, err := (&PiSugar{Addr: addr}).Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (PiSugarStatus{Percent: 84.5, Voltage: 4.02, Charging: true}); status != want {
+		t.Errorf("Status() = %+v, want %+v", status, want)
+	}
+
+	addr = fakePiSugar(t, map[string]string{"battery": "unknown"})
+	if _, err := (&PiSugar{Addr: addr}).Status(); err == nil {
+		t.Errorf("Status() accepted an invalid reading")
+	}
+}
@@ -0,0 +1,279 @@
+// Package sources fetches what the morning briefing shows besides the time:
+// the weather from Open-Meteo, today's events from an iCalendar file, and
+// headlines from RSS and Atom feeds.
+package sources
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"trmnl-display/pkg/api"
+)
+
+// Client fetches sources over HTTP
+type Client struct {
+	UserAgent string
+	HTTP      api.HTTPClient // http.DefaultClient if nil
+}
+
+// WeatherReport is today's weather at the configured location
+type WeatherReport struct {
+	Temperature    float64
+	Condition      string
+	High           float64
+	Low            float64
+	PrecipChance   int
+	TemperatureUOM string
+}
+
+// AgendaEvent is a single calendar entry
+type AgendaEvent struct {
+	Start   time.Time
+	AllDay  bool
+	Summary string
+}
+
+// openMeteoResponse is the subset of the Open-Meteo forecast response we use
+type openMeteoResponse struct {
+	Current struct {
+		Temperature float64 `json:"temperature_2m"`
+		WeatherCode int     `json:"weather_code"`
+	} `json:"current"`
+	CurrentUnits struct {
+		Temperature string `json:"temperature_2m"`
+	} `json:"current_units"`
+	Daily struct {
+		High         []float64 `json:"temperature_2m_max"`
+		Low          []float64 `json:"temperature_2m_min"`
+		PrecipChance []int     `json:"precipitation_probability_max"`
+	} `json:"daily"`
+}
+
+// Weather gets the current conditions and today's forecast from Open-Meteo
+func (c *Client) Weather(ctx context.Context, latitude, longitude float64) (*WeatherReport, error) {
+	query := url.Values{}
+	query.Set("latitude", fmt.Sprintf("%.4f", latitude))
+	query.Set("longitude", fmt.Sprintf("%.4f", longitude))
+	query.Set("current", "temperature_2m,weather_code")
+	query.Set("daily", "temperature_2m_max,temperature_2m_min,precipitation_probability_max")
+	query.Set("timezone", "auto")
+	query.Set("forecast_days", "1")
+
+	body, err := c.get(ctx, "https://api.open-meteo.com/v1/forecast?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var forecast openMeteoResponse
+	if err := json.NewDecoder(body).Decode(&forecast); err != nil {
+		return nil, fmt.Errorf("error parsing weather: %v", err)
+	}
+
+	report := &WeatherReport{
+		Temperature:    forecast.Current.Temperature,
+		Condition:      weatherCondition(forecast.Current.WeatherCode),
+		TemperatureUOM: forecast.CurrentUnits.Temperature,
+	}
+	if len(forecast.Daily.High) > 0 && len(forecast.Daily.Low) > 0 {
+		report.High = forecast.Daily.High[0]
+		report.Low = forecast.Daily.Low[0]
+	}
+	if len(forecast.Daily.PrecipChance) > 0 {
+		report.PrecipChance = forecast.Daily.PrecipChance[0]
+	}
+	return report, nil
+}
+
+// weatherCondition describes a WMO weather interpretation code
+func weatherCondition(code int) string {
+	switch {
+	case code == 0:
+		return "Clear sky"
+	case code <= 2:
+		return "Partly cloudy"
+	case code == 3:
+		return "Overcast"
+	case code <= 48:
+		return "Fog"
+	case code <= 57:
+		return "Drizzle"
+	case code <= 67:
+		return "Rain"
+	case code <= 77:
+		return "Snow"
+	case code <= 82:
+		return "Rain showers"
+	case code <= 86:
+		return "Snow showers"
+	default:
+		return "Thunderstorm"
+	}
+}
+
+// LoadAgenda reads the events occurring on day from an iCalendar file.
+// Recurring events are only listed on their first occurrence.
+func LoadAgenda(path string, day time.Time) ([]AgendaEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening calendar: %v", err)
+	}
+	defer file.Close()
+
+	var events []AgendaEvent
+	var current *AgendaEvent
+	for _, line := range unfoldICSLines(file) {
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		params := ""
+		if i := strings.Index(name, ";"); i >= 0 {
+			name, params = name[:i], name[i+1:]
+		}
+
+		switch name {
+		case "BEGIN":
+			if value == "VEVENT" {
+				current = &AgendaEvent{}
+			}
+		case "END":
+			if value == "VEVENT" && current != nil {
+				events = append(events, *current)
+				current = nil
+			}
+		case "SUMMARY":
+			if current != nil {
+				current.Summary = strings.NewReplacer(`\,`, ",", `\;`, ";", `\n`, " ").Replace(value)
+			}
+		case "DTSTART":
+			if current != nil {
+				current.Start, current.AllDay = parseICSTime(value, params)
+			}
+		}
+	}
+
+	y, m, d := day.Date()
+	var today []AgendaEvent
+	for _, event := range events {
+		ey, em, ed := event.Start.In(day.Location()).Date()
+		if ey == y && em == m && ed == d {
+			today = append(today, event)
+		}
+	}
+	sort.Slice(today, func(i, j int) bool {
+		return today[i].Start.Before(today[j].Start)
+	})
+	return today, nil
+}
+
+// unfoldICSLines joins iCalendar continuation lines
+func unfoldICSLines(r io.Reader) []string {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseICSTime parses a DTSTART value, honouring TZID and all-day dates
+func parseICSTime(value, params string) (time.Time, bool) {
+	loc := time.Local
+	for _, param := range strings.Split(params, ";") {
+		if tzid, ok := strings.CutPrefix(param, "TZID="); ok {
+			if l, err := time.LoadLocation(tzid); err == nil {
+				loc = l
+			}
+		}
+	}
+
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, false
+	}
+	if t, err := time.ParseInLocation("20060102", value, time.Local); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// feed covers both RSS 2.0 and Atom documents
+type feed struct {
+	Channel struct {
+		Items []struct {
+			Title string `xml:"title"`
+		} `xml:"item"`
+	} `xml:"channel"`
+	Entries []struct {
+		Title string `xml:"title"`
+	} `xml:"entry"`
+}
+
+// Headlines returns up to limit item titles from an RSS or Atom feed
+func (c *Client) Headlines(ctx context.Context, feedURL string, limit int) ([]string, error) {
+	body, err := c.get(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var f feed
+	if err := xml.NewDecoder(body).Decode(&f); err != nil {
+		return nil, fmt.Errorf("error parsing feed: %v", err)
+	}
+
+	var headlines []string
+	for _, item := range f.Channel.Items {
+		headlines = append(headlines, strings.TrimSpace(item.Title))
+	}
+	for _, entry := range f.Entries {
+		headlines = append(headlines, strings.TrimSpace(entry.Title))
+	}
+	if len(headlines) > limit {
+		headlines = headlines[:limit]
+	}
+	return headlines, nil
+}
+
+// get performs a GET for a source and returns the response body
+func (c *Client) get(ctx context.Context, sourceURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	if c.UserAgent != "" {
+		req.Header.Add("User-Agent", c.UserAgent)
+	}
+
+	client := c.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %v", sourceURL, err)
+	}
+	if resp.StatusCode != 200 {
+		api.CloseResponse(resp)
+		return nil, fmt.Errorf("error fetching %s: status code %d", sourceURL, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
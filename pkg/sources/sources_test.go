@@ -0,0 +1,104 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeHTTPClient answers every request with body
+type fakeHTTPClient struct {
+	body     string
+	requests []*http.Request
+}
+
+func (c *fakeHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.requests = append(c.requests, req)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(c.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestWeather(t *testing.T) {
+	fake := &fakeHTTPClient{body: `{
+		"current": {"temperature_2m": 17.4, "weather_code": 61},
+		"current_units": {"temperature_2m": "°C"},
+		"daily": {"temperature_2m_max": [21.2], "temperature_2m_min": [11.8], "precipitation_probability_max": [70]}
+	}`}
+	client := &Client{UserAgent: "test/1", HTTP: fake}
+	report, err := client.Weather(context.Background(), 51.5, -0.13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WeatherReport{Temperature: 17.4, Condition: "Rain", High: 21.2, Low: 11.8, PrecipChance: 70, TemperatureUOM: "°C"}
+	if *report != want {
+		t.Errorf("Weather = %+v, want %+v", *report, want)
+	}
+	req := fake.requests[0]
+	if got := req.URL.Query().Get("latitude"); got != "51.5000" {
+		t.Errorf("latitude = %q, want 51.5000", got)
+	}
+	if got := req.Header.Get("User-Agent"); got != "test/1" {
+		t.Errorf("User-Agent = %q, want test/1", got)
+	}
+}
+
+func TestHeadlines(t *testing.T) {
+	fake := &fakeHTTPClient{body: `<feed xmlns="http://www.w3.org/2005/Atom">
+<entry><title> First </title></entry>
+<entry><title>Second</title></entry>
+<entry><title>Third</title></entry>
+</feed>`}
+	headlines, err := (&Client{HTTP: fake}).Headlines(context.Background(), "https://news.test/atom", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(headlines, "|") != "First|Second" {
+		t.Errorf("Headlines = %q, want the first two titles", headlines)
+	}
+}
+
+func TestLoadAgenda(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "calendar.ics")
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"DTSTART:20250623T150000",
+		"SUMMARY:Dentist\\, Main St",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART;VALUE=DATE:20250623",
+		"SUMMARY:Bank holi",
+		" day",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"DTSTART:20250624T090000",
+		"SUMMARY:Tomorrow",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	if err := os.WriteFile(path, []byte(ics), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := LoadAgenda(path, time.Date(2025, 6, 23, 8, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("LoadAgenda = %+v, want today's two events", events)
+	}
+	if !events[0].AllDay || events[0].Summary != "Bank holiday" {
+		t.Errorf("first event = %+v, want the all-day bank holiday", events[0])
+	}
+	if events[1].AllDay || events[1].Summary != "Dentist, Main St" || events[1].Start.Hour() != 15 {
+		t.Errorf("second event = %+v, want the dentist at 15:00", events[1])
+	}
+}